@@ -0,0 +1,55 @@
+// Command fake-weather-providers serves fake versions of the upstream weather provider APIs, see package fakeprovider.
+//
+// Example usage, with weather-api-standalone:
+//
+//	go run ./cmd/fake-weather-providers -addr localhost:9090 -keys fake-key &
+//	APP_OPENWEATHER_API_KEY=fake-key APP_OPENWEATHER_BASE_URL=http://localhost:9090 \
+//		APP_WEATHERSTACK_API_KEY=fake-key APP_WEATHERSTACK_BASE_URL=http://localhost:9090 \
+//		go run ./cmd/weather-api-standalone
+package main
+
+import (
+	"flag"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/internal/fakeprovider"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func main() {
+	var (
+		server fakeprovider.Server
+		addr   = flag.String(`addr`, `localhost:9090`, `listen address`)
+		keys   = flag.String(`keys`, ``, `comma separated list of accepted api keys, any key will be accepted if empty`)
+		script = flag.String(`script`, ``, `optional path to a JSON script, see fakeprovider.Server.LoadScript`)
+		seed   = flag.Int64(`seed`, 1, `seed used to inject faults`)
+	)
+	flag.DurationVar(&server.Latency, `latency`, 0, `latency added to every response`)
+	flag.Float64Var(&server.ErrorRate, `error-rate`, 0, `probability of responding with a server error`)
+	flag.Float64Var(&server.RateLimitRate, `rate-limit-rate`, 0, `probability of responding with a rate limit error`)
+	flag.Float64Var(&server.MalformedRate, `malformed-rate`, 0, `probability of responding with malformed JSON`)
+	flag.Parse()
+
+	if *keys != `` {
+		server.Keys = strings.Split(*keys, `,`)
+	}
+	server.Rand = rand.New(rand.NewSource(*seed))
+	if *script != `` {
+		b, err := os.ReadFile(*script)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := server.LoadScript(b); err != nil {
+			log.Fatalf(`invalid script: %v`, err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+
+	log.Printf(`serving fake weather providers on %s`, *addr)
+	log.Fatal(http.ListenAndServe(*addr, router))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fullstorydev/grpchan/inprocgrpc"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	geocodeapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geocode"
+	metnoapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/metno"
+	omapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openmeteo"
+	owapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openweather"
+	wsapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/weatherstack"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/fakeprovider"
+	"github.com/joeycumines/mx51-weather-api/internal/weather"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestIntegration exercises the full request path, including the upstream HTTP requests, against fake providers.
+func TestIntegration(t *testing.T) {
+	t.Parallel()
+
+	fake := fakeprovider.Server{Keys: []string{`good-key`}}
+	fakeRouter := chi.NewRouter()
+	fakeRouter.Route(`/`, fake.Register)
+	fakeServer := httptest.NewServer(fakeRouter)
+	defer fakeServer.Close()
+
+	var conn inprocgrpc.Channel
+	openweather.RegisterOpenweatherServer(&conn, &owapi.Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `bad-key`}, apikey.Key{Value: `good-key`}),
+		BaseURL: fakeServer.URL,
+		Client:  fakeServer.Client(),
+	})
+	weatherstack.RegisterWeatherstackServer(&conn, &wsapi.Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `good-key`}),
+		BaseURL: fakeServer.URL,
+		Client:  fakeServer.Client(),
+	})
+
+	omServer := &omapi.Server{
+		BaseURL:          fakeServer.URL,
+		GeocodingBaseURL: fakeServer.URL,
+		Client:           fakeServer.Client(),
+	}
+	openmeteo.RegisterOpenMeteoServer(&conn, omServer)
+	metno.RegisterMetnoServer(&conn, &metnoapi.Server{
+		Geocoder: omServer,
+		BaseURL:  fakeServer.URL,
+		Client:   fakeServer.Client(),
+	})
+	geocode.RegisterGeocodeServer(&conn, &geocodeapi.Server{
+		BaseURL: fakeServer.URL,
+		Client:  fakeServer.Client(),
+	})
+
+	server := weather.Server{
+		MaxAge:       time.Second * 3,
+		TimeNow:      time.Now,
+		Openweather:  openweather.NewOpenweatherClient(&conn),
+		Weatherstack: weatherstack.NewWeatherstackClient(&conn),
+		OpenMeteo:    openmeteo.NewOpenMeteoClient(&conn),
+		Metno:        metno.NewMetnoClient(&conn),
+		Geocode:      geocode.NewGeocodeClient(&conn),
+	}
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type Body struct {
+		WindSpeed          float64 `json:"wind_speed"`
+		TemperatureDegrees float64 `json:"temperature_degrees"`
+		Location           *struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"location"`
+	}
+
+	get := func(t *testing.T, city string) (int, Body) {
+		res, err := ts.Client().Get(ts.URL + `/v1/weather?city=` + city)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body Body
+		if res.StatusCode == http.StatusOK {
+			if err := json.Unmarshal(b, &body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode, body
+	}
+
+	expect := func(t *testing.T, body Body, reading fakeprovider.Reading, tolerance float64) {
+		if math.Abs(body.WindSpeed-reading.WindSpeed) > tolerance ||
+			math.Abs(body.TemperatureDegrees-reading.TemperatureDegrees) > tolerance {
+			t.Errorf(`unexpected body %+v for reading %+v`, body, reading)
+		}
+		// note: all queries are resolved by the fake geocoding api
+		if body.Location == nil || body.Location.ID != fmt.Sprintf(`geonames:%d`, fakeprovider.DeterministicID(body.Location.Name)) {
+			t.Errorf(`unexpected location: %+v`, body.Location)
+		}
+	}
+
+	// note: not parallel, as rate limiting takes keys out of rotation
+
+	t.Run(`weatherstack`, func(t *testing.T) {
+		statusCode, body := get(t, `sydney`)
+		if statusCode != http.StatusOK {
+			t.Fatal(statusCode)
+		}
+		reading, _ := fakeprovider.DeterministicReading(fakeprovider.Weatherstack, `sydney`)
+		expect(t, body, reading, 0.5)
+	})
+
+	t.Run(`openweather fallback`, func(t *testing.T) {
+		fake.Script(fakeprovider.Weatherstack, `brisbane`, fakeprovider.Step{Fault: fakeprovider.FaultMalformed})
+		statusCode, body := get(t, `brisbane`)
+		if statusCode != http.StatusOK {
+			t.Fatal(statusCode)
+		}
+		reading, _ := fakeprovider.DeterministicReading(fakeprovider.Openweather, `brisbane`)
+		expect(t, body, reading, 0.05)
+	})
+
+	t.Run(`openmeteo fallback`, func(t *testing.T) {
+		fake.Script(fakeprovider.Weatherstack, `darwin`, fakeprovider.Step{Fault: fakeprovider.FaultNotFound})
+		fake.Script(fakeprovider.Openweather, `darwin`, fakeprovider.Step{Fault: fakeprovider.FaultError})
+		statusCode, body := get(t, `darwin`)
+		if statusCode != http.StatusOK {
+			t.Fatal(statusCode)
+		}
+		reading, _ := fakeprovider.DeterministicReading(fakeprovider.OpenMeteo, `darwin`)
+		expect(t, body, reading, 0.05)
+	})
+
+	t.Run(`metno fallback`, func(t *testing.T) {
+		fake.Script(fakeprovider.Weatherstack, `hobart`, fakeprovider.Step{Fault: fakeprovider.FaultNotFound})
+		fake.Script(fakeprovider.Openweather, `hobart`, fakeprovider.Step{Fault: fakeprovider.FaultError})
+		fake.Script(fakeprovider.OpenMeteo, `hobart`, fakeprovider.Step{Fault: fakeprovider.FaultRateLimit})
+		statusCode, body := get(t, `hobart`)
+		if statusCode != http.StatusOK {
+			t.Fatal(statusCode)
+		}
+		reading, _ := fakeprovider.DeterministicReading(fakeprovider.Metno, `hobart`)
+		expect(t, body, reading, 0.2)
+	})
+
+	t.Run(`unavailable`, func(t *testing.T) {
+		fake.Script(fakeprovider.Weatherstack, `perth`, fakeprovider.Step{Fault: fakeprovider.FaultRateLimit})
+		fake.Script(fakeprovider.Openweather, `perth`, fakeprovider.Step{Fault: fakeprovider.FaultError})
+		fake.Script(fakeprovider.OpenMeteo, `perth`, fakeprovider.Step{Fault: fakeprovider.FaultMalformed})
+		fake.Script(fakeprovider.Metno, `perth`, fakeprovider.Step{Fault: fakeprovider.FaultError})
+		if statusCode, _ := get(t, `perth`); statusCode != http.StatusServiceUnavailable {
+			t.Error(statusCode)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+// Package apikey implements a pool of upstream API keys, supporting weighted round-robin selection, temporary removal
+// of rejected keys from rotation, runtime rotation, and per-key usage accounting.
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Pool is a set of API keys for a single upstream provider, safe for concurrent use.
+	// The zero value is an empty pool, see also NewPool and Pool.Set.
+	Pool struct {
+		// Cooldown is how long a rejected key is taken out of rotation, defaults to DefaultCooldown.
+		Cooldown time.Duration
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+
+		mu   sync.Mutex
+		keys []*entry
+	}
+
+	// Key is a single API key, with a relative weight, used to distribute requests between keys.
+	Key struct {
+		Value string `json:"key"`
+		// Weight must be positive, zero will be treated as 1.
+		Weight int `json:"weight,omitempty"`
+	}
+
+	// Usage models the (redacted) state of a key within a Pool.
+	Usage struct {
+		ID            string     `json:"id"`
+		Key           string     `json:"key"`
+		Weight        int        `json:"weight"`
+		Requests      uint64     `json:"requests"`
+		Failures      uint64     `json:"failures"`
+		Rejections    uint64     `json:"rejections"`
+		LastUsed      *time.Time `json:"last_used,omitempty"`
+		DisabledUntil *time.Time `json:"disabled_until,omitempty"`
+	}
+
+	entry struct {
+		key     Key
+		current int
+		usage   Usage
+	}
+)
+
+const (
+	// DefaultCooldown is the default value for Pool.Cooldown.
+	DefaultCooldown = time.Minute
+)
+
+var (
+	// ErrNoKeys indicates that the pool has no keys in rotation.
+	ErrNoKeys = errors.New(`apikey: no keys available`)
+)
+
+// NewPool initialises a new pool with the given keys.
+func NewPool(keys ...Key) *Pool {
+	var x Pool
+	x.Set(keys...)
+	return &x
+}
+
+// ParseKeys parses a comma separated list of keys, each optionally suffixed with `:<weight>`, e.g. `key1,key2:3`.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, v := range strings.Split(s, `,`) {
+		v = strings.TrimSpace(v)
+		if v == `` {
+			continue
+		}
+		key := Key{Value: v, Weight: 1}
+		if i := strings.LastIndexByte(v, ':'); i != -1 {
+			weight, err := strconv.Atoi(v[i+1:])
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf(`apikey: invalid weight for key %s`, Redact(v[:i]))
+			}
+			key.Value, key.Weight = v[:i], weight
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Redact returns a representation of the key safe for display.
+func Redact(key string) string {
+	const visible = 4
+	if len(key) <= visible*2 {
+		return strings.Repeat(`*`, len(key))
+	}
+	return strings.Repeat(`*`, len(key)-visible) + key[len(key)-visible:]
+}
+
+// Set replaces the keys in the pool, retaining the usage of any keys that remain.
+func (x *Pool) Set(keys ...Key) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	existing := make(map[string]*entry, len(x.keys))
+	for _, e := range x.keys {
+		existing[e.key.Value] = e
+	}
+	entries := make([]*entry, 0, len(keys))
+	for _, key := range keys {
+		if key.Weight <= 0 {
+			key.Weight = 1
+		}
+		e := existing[key.Value]
+		if e == nil {
+			e = &entry{usage: Usage{ID: keyID(key.Value), Key: Redact(key.Value)}}
+		} else {
+			delete(existing, key.Value)
+		}
+		e.key = key
+		e.usage.Weight = key.Weight
+		entries = append(entries, e)
+	}
+	x.keys = entries
+}
+
+// Len returns the number of keys in the pool, including those out of rotation.
+func (x *Pool) Len() int {
+	if x == nil {
+		return 0
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.keys)
+}
+
+// Next selects the next key, using smooth weighted round-robin, skipping any keys currently out of rotation.
+// ErrNoKeys will be returned if no keys are available.
+func (x *Pool) Next() (string, error) {
+	if x == nil {
+		return ``, ErrNoKeys
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.timeNow()
+	var (
+		total int
+		best  *entry
+	)
+	for _, e := range x.keys {
+		if e.usage.DisabledUntil != nil {
+			if now.Before(*e.usage.DisabledUntil) {
+				continue
+			}
+			e.usage.DisabledUntil = nil
+		}
+		e.current += e.key.Weight
+		total += e.key.Weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	if best == nil {
+		return ``, ErrNoKeys
+	}
+	best.current -= total
+	best.usage.Requests++
+	best.usage.LastUsed = &now
+	return best.key.Value, nil
+}
+
+// Reject takes the key out of rotation for the configured cooldown, e.g. after an HTTP 401 or 429 response.
+func (x *Pool) Reject(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e := x.find(key); e != nil {
+		until := x.timeNow().Add(x.cooldown())
+		e.usage.Rejections++
+		e.usage.DisabledUntil = &until
+	}
+}
+
+// Fail records a failed request that was not attributable to the key itself.
+func (x *Pool) Fail(key string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if e := x.find(key); e != nil {
+		e.usage.Failures++
+	}
+}
+
+// Usage returns a snapshot of the usage of each key, in pool order.
+func (x *Pool) Usage() []Usage {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	usage := make([]Usage, len(x.keys))
+	for i, e := range x.keys {
+		usage[i] = e.usage
+	}
+	return usage
+}
+
+// Values returns the (unredacted) value of each key, in pool order, e.g. so they may be redacted from logs.
+func (x *Pool) Values() []string {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	values := make([]string, len(x.keys))
+	for i, e := range x.keys {
+		values[i] = e.key.Value
+	}
+	return values
+}
+
+func (x *Pool) find(key string) *entry {
+	for _, e := range x.keys {
+		if e.key.Value == key {
+			return e
+		}
+	}
+	return nil
+}
+
+func (x *Pool) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
+
+func (x *Pool) cooldown() time.Duration {
+	if x.Cooldown > 0 {
+		return x.Cooldown
+	}
+	return DefaultCooldown
+}
+
+func keyID(key string) string {
+	b := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(b[:4])
+}
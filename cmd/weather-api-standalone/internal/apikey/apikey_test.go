@@ -0,0 +1,134 @@
+package apikey
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name string
+		in   string
+		out  []Key
+		err  string
+	}{
+		{name: `empty`},
+		{name: `single`, in: `abc`, out: []Key{{Value: `abc`, Weight: 1}}},
+		{name: `weighted`, in: ` abc:3, def ,`, out: []Key{{Value: `abc`, Weight: 3}, {Value: `def`, Weight: 1}}},
+		{name: `invalid weight`, in: `abcdefghijkl:x`, err: `apikey: invalid weight for key ********ijkl`},
+		{name: `zero weight`, in: `abc:0`, err: `apikey: invalid weight for key ***`},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			out, err := ParseKeys(tc.in)
+			if (err == nil) != (tc.err == ``) || (err != nil && err.Error() != tc.err) {
+				t.Errorf(`unexpected error: %v`, err)
+			}
+			if !reflect.DeepEqual(out, tc.out) {
+				t.Errorf(`unexpected keys: %+v`, out)
+			}
+		})
+	}
+}
+
+func TestPool_Next_weighted(t *testing.T) {
+	t.Parallel()
+	pool := NewPool(Key{Value: `a`, Weight: 3}, Key{Value: `b`}, Key{Value: `c`, Weight: 1})
+	var order string
+	for i := 0; i < 10; i++ {
+		key, err := pool.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		order += key
+	}
+	// smooth weighted round-robin interleaves the heavier key
+	if order != `abacaabaca` {
+		t.Errorf(`unexpected order: %s`, order)
+	}
+	usage := pool.Usage()
+	if usage[0].Requests != 6 || usage[1].Requests != 2 || usage[2].Requests != 2 {
+		t.Errorf(`unexpected usage: %+v`, usage)
+	}
+}
+
+func TestPool_Reject(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(0, 0)
+	pool := NewPool(Key{Value: `a`}, Key{Value: `b`})
+	pool.Cooldown = time.Second
+	pool.TimeNow = func() time.Time { return now }
+
+	pool.Reject(`a`)
+	for i := 0; i < 3; i++ {
+		if key, err := pool.Next(); err != nil || key != `b` {
+			t.Fatal(key, err)
+		}
+	}
+
+	pool.Reject(`b`)
+	if key, err := pool.Next(); err != ErrNoKeys {
+		t.Fatal(key, err)
+	}
+
+	now = now.Add(time.Second)
+	if key, err := pool.Next(); err != nil || key == `` {
+		t.Fatal(key, err)
+	}
+	for _, usage := range pool.Usage() {
+		if usage.Rejections != 1 || usage.DisabledUntil != nil {
+			t.Errorf(`unexpected usage: %+v`, usage)
+		}
+	}
+}
+
+func TestPool_Set(t *testing.T) {
+	t.Parallel()
+	pool := NewPool(Key{Value: `a`}, Key{Value: `b`})
+	for i := 0; i < 4; i++ {
+		if _, err := pool.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Set(Key{Value: `b`, Weight: 2}, Key{Value: `c`})
+	usage := pool.Usage()
+	if len(usage) != 2 ||
+		usage[0].Key != `*` || usage[0].Weight != 2 || usage[0].Requests != 2 ||
+		usage[1].Key != `*` || usage[1].Weight != 1 || usage[1].Requests != 0 {
+		t.Errorf(`unexpected usage: %+v`, usage)
+	}
+	if usage[0].ID == usage[1].ID {
+		t.Errorf(`expected distinct ids: %+v`, usage)
+	}
+}
+
+func TestPool_nil(t *testing.T) {
+	t.Parallel()
+	var pool *Pool
+	if pool.Len() != 0 {
+		t.Error(pool.Len())
+	}
+	if _, err := pool.Next(); err != ErrNoKeys {
+		t.Error(err)
+	}
+	if v := pool.Values(); v != nil {
+		t.Error(v)
+	}
+}
+
+func TestHandler_Secrets(t *testing.T) {
+	t.Parallel()
+	secrets := Handler{
+		`a`: NewPool(Key{Value: `key1`}, Key{Value: `key2`, Weight: 2}),
+		`b`: NewPool(Key{Value: `key3`}),
+		`c`: nil,
+	}.Secrets()
+	sort.Strings(secrets)
+	if !reflect.DeepEqual(secrets, []string{`key1`, `key2`, `key3`}) {
+		t.Errorf(`unexpected secrets: %q`, secrets)
+	}
+}
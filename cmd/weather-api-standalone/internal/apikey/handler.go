@@ -0,0 +1,88 @@
+package apikey
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"sort"
+)
+
+type (
+	// Handler exposes the usage of, and allows rotation of, pools of keys, keyed by provider name.
+	// See also Register.
+	Handler map[string]*Pool
+
+	setKeysRequest struct {
+		Keys []Key `json:"keys"`
+	}
+)
+
+// Register wires up the handler.
+func (x Handler) Register(r chi.Router) {
+	r.Get(`/admin/v1/apikeys`, x.listUsage)
+	r.Get(`/admin/v1/apikeys/{provider}`, x.getUsage)
+	r.Put(`/admin/v1/apikeys/{provider}`, x.setKeys)
+}
+
+// Secrets returns the values of every key, of every pool, see logging.Redactor.
+func (x Handler) Secrets() []string {
+	var secrets []string
+	for _, pool := range x {
+		secrets = append(secrets, pool.Values()...)
+	}
+	return secrets
+}
+
+func (x Handler) listUsage(w http.ResponseWriter, r *http.Request) {
+	providers := make([]string, 0, len(x))
+	for provider := range x {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	res := make(map[string][]Usage, len(providers))
+	for _, provider := range providers {
+		res[provider] = x[provider].Usage()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (x Handler) getUsage(w http.ResponseWriter, r *http.Request) {
+	pool := x[chi.URLParam(r, `provider`)]
+	if pool == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, pool.Usage())
+}
+
+func (x Handler) setKeys(w http.ResponseWriter, r *http.Request) {
+	pool := x[chi.URLParam(r, `provider`)]
+	if pool == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var req setKeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `invalid request body`, http.StatusBadRequest)
+		return
+	}
+	for _, key := range req.Keys {
+		if key.Value == `` || key.Weight < 0 {
+			http.Error(w, `invalid key`, http.StatusBadRequest)
+			return
+		}
+	}
+	pool.Set(req.Keys...)
+	writeJSON(w, http.StatusOK, pool.Usage())
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,238 @@
+// Package cache implements the in-memory cache used by the provider implementations, optionally bounded by size
+// (evicting the least recently used entries) and age.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Cache is a map of keys to values, safe for concurrent use. The zero value is an empty cache, and a Cache must
+	// not be copied after first use.
+	//
+	// Bounds are provided per call, such that they may be configured using the (exported) fields of the owner.
+	Cache[K comparable, V any] struct {
+		mu      sync.Mutex
+		entries map[K]*list.Element
+		// order is the recency of use, the front being the most recent
+		order   list.List
+		stats   Stats
+		timeNow func() time.Time
+	}
+
+	// Stats are the counters of a Cache, see Cache.Stats.
+	Stats struct {
+		// Entries is the number of entries, including any that have expired, but not yet been removed.
+		Entries int
+		Hits    uint64
+		Misses  uint64
+		// Expired is the number of entries removed on access, as they exceeded the ttl.
+		Expired uint64
+		// Evicted is the number of entries removed as the cache exceeded the size.
+		Evicted uint64
+		// Invalidated is the number of entries removed via Delete or DeleteFunc.
+		Invalidated uint64
+	}
+
+	// Entry is a snapshot of a cached entry, see Admin.
+	Entry struct {
+		Key    string
+		Value  any
+		Stored time.Time
+	}
+
+	// Admin exposes a cache for inspection and invalidation, by string keys, e.g. via an admin api, see NewAdmin.
+	Admin interface {
+		// Entries returns every entry with the key prefix, the most recently used first.
+		Entries(prefix string) []Entry
+		// Entry returns the entry for the key, without affecting its recency.
+		Entry(key string) (Entry, bool)
+		// Invalidate removes the entry for the key, or (if prefix is true) every entry with the key prefix,
+		// returning the number removed.
+		Invalidate(key string, prefix bool) int
+		// Refresh fetches the value for the key from upstream, replacing the cached value.
+		Refresh(ctx context.Context, key string) (Entry, error)
+		Stats() Stats
+	}
+
+	entry[K comparable, V any] struct {
+		key    K
+		value  V
+		stored time.Time
+	}
+
+	admin[K comparable, V any] struct {
+		cache   *Cache[K, V]
+		format  func(key K) string
+		refresh func(ctx context.Context, key string) (V, error)
+	}
+)
+
+var (
+	// compile time assertions
+
+	_ Admin = (*admin[string, any])(nil)
+)
+
+// NewAdmin adapts the cache, using format to convert keys to strings, and refresh to fetch (and store) the value of
+// a key, e.g. by calling the owner, bypassing the cache.
+func NewAdmin[K comparable, V any](cache *Cache[K, V], format func(key K) string, refresh func(ctx context.Context, key string) (V, error)) Admin {
+	return &admin[K, V]{cache: cache, format: format, refresh: refresh}
+}
+
+// Get returns the value for the key, if present, and (if ttl is positive) stored less than ttl ago. Expired entries
+// are removed.
+func (x *Cache[K, V]) Get(key K, ttl time.Duration) (value V, ok bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	elem := x.entries[key]
+	if elem == nil {
+		x.stats.Misses++
+		return value, false
+	}
+	e := elem.Value.(*entry[K, V])
+	if ttl > 0 && x.now().Sub(e.stored) >= ttl {
+		x.order.Remove(elem)
+		delete(x.entries, key)
+		x.stats.Misses++
+		x.stats.Expired++
+		return value, false
+	}
+	x.order.MoveToFront(elem)
+	x.stats.Hits++
+	return e.value, true
+}
+
+// Put stores the value for the key, then (if size is positive) evicts the least recently used entries, until there
+// are at most size.
+func (x *Cache[K, V]) Put(key K, value V, size int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if elem := x.entries[key]; elem != nil {
+		e := elem.Value.(*entry[K, V])
+		e.value, e.stored = value, x.now()
+		x.order.MoveToFront(elem)
+	} else {
+		if x.entries == nil {
+			x.entries = make(map[K]*list.Element)
+		}
+		x.entries[key] = x.order.PushFront(&entry[K, V]{key: key, value: value, stored: x.now()})
+	}
+	for size > 0 && x.order.Len() > size {
+		elem := x.order.Back()
+		x.order.Remove(elem)
+		delete(x.entries, elem.Value.(*entry[K, V]).key)
+		x.stats.Evicted++
+	}
+}
+
+// Delete removes the entry for the key, returning true if it was present.
+func (x *Cache[K, V]) Delete(key K) bool {
+	return x.DeleteFunc(func(k K) bool { return k == key }) != 0
+}
+
+// DeleteFunc removes every entry for which fn returns true, returning the number removed. The cache is locked while
+// fn is called.
+func (x *Cache[K, V]) DeleteFunc(fn func(key K) bool) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var n int
+	for elem := x.order.Front(); elem != nil; {
+		next := elem.Next()
+		if key := elem.Value.(*entry[K, V]).key; fn(key) {
+			x.order.Remove(elem)
+			delete(x.entries, key)
+			n++
+		}
+		elem = next
+	}
+	x.stats.Invalidated += uint64(n)
+	return n
+}
+
+// Range calls fn with a snapshot of each entry, the most recently used first, until it returns false. Neither the
+// recency, nor the expiry, of the entries are affected.
+func (x *Cache[K, V]) Range(fn func(key K, value V, stored time.Time) bool) {
+	x.mu.Lock()
+	entries := make([]entry[K, V], 0, x.order.Len())
+	for elem := x.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, *elem.Value.(*entry[K, V]))
+	}
+	x.mu.Unlock()
+	for _, e := range entries {
+		if !fn(e.key, e.value, e.stored) {
+			return
+		}
+	}
+}
+
+// Stats returns a snapshot of the counters.
+func (x *Cache[K, V]) Stats() Stats {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	stats := x.stats
+	stats.Entries = x.order.Len()
+	return stats
+}
+
+// Len returns the number of entries, including any that have expired, but not yet been removed.
+func (x *Cache[K, V]) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.order.Len()
+}
+
+func (x *Cache[K, V]) now() time.Time {
+	if x.timeNow != nil {
+		return x.timeNow()
+	}
+	return time.Now()
+}
+
+func (x *admin[K, V]) Entries(prefix string) []Entry {
+	var entries []Entry
+	x.cache.Range(func(key K, value V, stored time.Time) bool {
+		if k := x.format(key); strings.HasPrefix(k, prefix) {
+			entries = append(entries, Entry{Key: k, Value: value, Stored: stored})
+		}
+		return true
+	})
+	return entries
+}
+
+func (x *admin[K, V]) Entry(key string) (res Entry, ok bool) {
+	x.cache.Range(func(k K, value V, stored time.Time) bool {
+		if x.format(k) == key {
+			res, ok = Entry{Key: key, Value: value, Stored: stored}, true
+		}
+		return !ok
+	})
+	return
+}
+
+func (x *admin[K, V]) Invalidate(key string, prefix bool) int {
+	return x.cache.DeleteFunc(func(k K) bool {
+		if prefix {
+			return strings.HasPrefix(x.format(k), key)
+		}
+		return x.format(k) == key
+	})
+}
+
+func (x *admin[K, V]) Refresh(ctx context.Context, key string) (Entry, error) {
+	value, err := x.refresh(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+	if res, ok := x.Entry(key); ok {
+		return res, nil
+	}
+	// note: the value may not have been stored, e.g. if the cache is bounded to zero entries
+	return Entry{Key: key, Value: value}, nil
+}
+
+func (x *admin[K, V]) Stats() Stats { return x.cache.Stats() }
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache_size(t *testing.T) {
+	t.Parallel()
+
+	var c Cache[string, int]
+	if _, ok := c.Get(`a`, 0); ok {
+		t.Error(`expected miss`)
+	}
+
+	c.Put(`a`, 1, 2)
+	c.Put(`b`, 2, 2)
+	// a is now the most recently used
+	if v, ok := c.Get(`a`, 0); !ok || v != 1 {
+		t.Errorf(`unexpected value: %d %v`, v, ok)
+	}
+	c.Put(`c`, 3, 2)
+	if _, ok := c.Get(`b`, 0); ok {
+		t.Error(`expected b to be evicted`)
+	}
+	if v, ok := c.Get(`c`, 0); !ok || v != 3 || c.Len() != 2 {
+		t.Errorf(`unexpected value: %d %v %d`, v, ok, c.Len())
+	}
+
+	// replacing doesn't grow the cache
+	c.Put(`c`, 4, 2)
+	if v, ok := c.Get(`c`, 0); !ok || v != 4 || c.Len() != 2 {
+		t.Errorf(`unexpected value: %d %v %d`, v, ok, c.Len())
+	}
+
+	// unbounded
+	for i := 0; i < 10; i++ {
+		c.Put(string(rune('d'+i)), i, 0)
+	}
+	if v := c.Len(); v != 12 {
+		t.Errorf(`unexpected len: %d`, v)
+	}
+}
+
+func TestCache_ttl(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1666000000, 0)
+	c := Cache[string, int]{timeNow: func() time.Time { return now }}
+
+	c.Put(`a`, 1, 0)
+	now = now.Add(time.Minute)
+	c.Put(`b`, 2, 0)
+	now = now.Add(time.Second * 30)
+
+	if v, ok := c.Get(`a`, 0); !ok || v != 1 {
+		t.Errorf(`unexpected value: %d %v`, v, ok)
+	}
+	if _, ok := c.Get(`a`, time.Minute); ok {
+		t.Error(`expected a to have expired`)
+	}
+	// expired entries are removed
+	if _, ok := c.Get(`a`, 0); ok || c.Len() != 1 {
+		t.Errorf(`unexpected len: %d`, c.Len())
+	}
+	if v, ok := c.Get(`b`, time.Minute); !ok || v != 2 {
+		t.Errorf(`unexpected value: %d %v`, v, ok)
+	}
+}
+
+func TestCache_stats(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1666000000, 0)
+	c := Cache[string, int]{timeNow: func() time.Time { return now }}
+	c.Get(`a`, 0)
+	c.Put(`a`, 1, 2)
+	c.Put(`b`, 2, 2)
+	c.Put(`c`, 3, 2)
+	c.Get(`c`, 0)
+	now = now.Add(time.Minute)
+	c.Get(`b`, time.Second)
+	c.Put(`ab`, 4, 0)
+	c.Put(`ac`, 5, 0)
+	if n := c.DeleteFunc(func(key string) bool { return key[0] == 'a' }); n != 2 {
+		t.Errorf(`unexpected count: %d`, n)
+	}
+	if c.Delete(`a`) || !c.Delete(`c`) {
+		t.Error(`unexpected delete`)
+	}
+	if v := c.Stats(); v != (Stats{Hits: 1, Misses: 2, Expired: 1, Evicted: 1, Invalidated: 3}) {
+		t.Errorf(`unexpected stats: %+v`, v)
+	}
+}
+
+func TestNewAdmin(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1666000000, 0)
+	c := Cache[int, string]{timeNow: func() time.Time { return now }}
+	format := func(key int) string { return `n:` + strconv.Itoa(key) }
+	admin := NewAdmin(&c, format, func(ctx context.Context, key string) (string, error) {
+		n, err := strconv.Atoi(strings.TrimPrefix(key, `n:`))
+		if err != nil {
+			return ``, err
+		}
+		c.Put(n, `refreshed`, 0)
+		return `refreshed`, nil
+	})
+	for _, n := range []int{1, 12, 2, 13} {
+		c.Put(n, strconv.Itoa(n), 0)
+		now = now.Add(time.Second)
+	}
+
+	var keys []string
+	for _, e := range admin.Entries(`n:1`) {
+		keys = append(keys, e.Key)
+	}
+	if v := strings.Join(keys, `,`); v != `n:13,n:12,n:1` {
+		t.Errorf(`unexpected keys: %s`, v)
+	}
+	if v, ok := admin.Entry(`n:12`); !ok || v.Value != `12` || !v.Stored.Equal(time.Unix(1666000001, 0)) {
+		t.Errorf(`unexpected entry: %+v %v`, v, ok)
+	}
+	if _, ok := admin.Entry(`n:3`); ok {
+		t.Error(`expected no entry`)
+	}
+	// recency is unaffected
+	if v := admin.Entries(``); len(v) != 4 || v[0].Key != `n:13` {
+		t.Errorf(`unexpected entries: %+v`, v)
+	}
+
+	if v, err := admin.Refresh(context.Background(), `n:2`); err != nil || v.Value != `refreshed` || !v.Stored.Equal(now) {
+		t.Errorf(`unexpected entry: %+v %v`, v, err)
+	}
+	if _, err := admin.Refresh(context.Background(), `nope`); err == nil {
+		t.Error(`expected an error`)
+	}
+
+	if n := admin.Invalidate(`n:1`, true); n != 3 {
+		t.Errorf(`unexpected count: %d`, n)
+	}
+	if n := admin.Invalidate(`n:2`, false); n != 1 {
+		t.Errorf(`unexpected count: %d`, n)
+	}
+	if v := admin.Stats(); v.Entries != 0 || v.Invalidated != 4 {
+		t.Errorf(`unexpected stats: %+v`, v)
+	}
+}
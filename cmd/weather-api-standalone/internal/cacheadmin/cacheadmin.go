@@ -0,0 +1,236 @@
+// Package cacheadmin implements the admin api of the provider caches, served over gRPC, and HTTP, see Server.
+package cacheadmin
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/joeycumines/mx51-weather-api/cacheadmin"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// Server implements cacheadmin.CacheAdminServer, for the caches of each provider, which are either local (see
+	// Caches), or served standalone, in which case calls are forwarded (see Remotes). See also Register, which
+	// serves the same api over HTTP.
+	//
+	// Every call must present the Token, see UnaryServerInterceptor.
+	Server struct {
+		unimplementedServer
+
+		// Token is the bearer token required by every call, which is also presented to each of the Remotes. If
+		// empty, every call is rejected.
+		Token string
+
+		// Caches are the local caches, keyed by provider.
+		Caches map[string]cache.Admin
+
+		// Remotes are the providers served standalone, keyed by provider, to which calls are forwarded.
+		Remotes map[string]cacheadmin.CacheAdminClient
+
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+	}
+
+	unimplementedServer = cacheadmin.UnimplementedCacheAdminServer
+)
+
+const (
+	// AuthorizationMetadata is the gRPC metadata carrying the bearer token, equivalent to the HTTP header.
+	AuthorizationMetadata = `authorization`
+
+	bearerPrefix = `Bearer `
+)
+
+var (
+	// compile time assertions
+
+	_ cacheadmin.CacheAdminServer = (*Server)(nil)
+	_ grpc.UnaryServerInterceptor = (*Server)(nil).UnaryServerInterceptor
+)
+
+// UnaryServerInterceptor rejects calls that don't present the Token, as a bearer token, via the authorization
+// metadata.
+func (x *Server) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var authorization string
+	if v := md.Get(AuthorizationMetadata); len(v) != 0 {
+		authorization = v[0]
+	}
+	if !x.authorized(authorization) {
+		return nil, status.Error(codes.Unauthenticated, `cacheadmin: invalid or missing bearer token`)
+	}
+	return handler(ctx, req)
+}
+
+func (x *Server) ListEntries(ctx context.Context, req *cacheadmin.ListEntriesRequest) (*cacheadmin.ListEntriesResponse, error) {
+	if remote := x.Remotes[req.GetProvider()]; remote != nil {
+		return remote.ListEntries(x.outgoing(ctx), req)
+	}
+	c, err := x.cache(req.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+	now := x.timeNow()
+	res := cacheadmin.ListEntriesResponse{Entries: []*cacheadmin.Entry{}}
+	for _, e := range c.Entries(req.GetPrefix()) {
+		entry, err := newEntry(req.GetProvider(), e, now)
+		if err != nil {
+			return nil, err
+		}
+		// note: the values are omitted, for brevity
+		entry.Value = nil
+		res.Entries = append(res.Entries, entry)
+	}
+	return &res, nil
+}
+
+func (x *Server) GetEntry(ctx context.Context, req *cacheadmin.GetEntryRequest) (*cacheadmin.Entry, error) {
+	if remote := x.Remotes[req.GetProvider()]; remote != nil {
+		return remote.GetEntry(x.outgoing(ctx), req)
+	}
+	c, err := x.cache(req.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetKey() == `` {
+		return nil, status.Error(codes.InvalidArgument, `cacheadmin: key required`)
+	}
+	e, ok := c.Entry(req.GetKey())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, `cacheadmin: entry not found: %q`, req.GetKey())
+	}
+	return newEntry(req.GetProvider(), e, x.timeNow())
+}
+
+func (x *Server) InvalidateEntries(ctx context.Context, req *cacheadmin.InvalidateEntriesRequest) (*cacheadmin.InvalidateEntriesResponse, error) {
+	if remote := x.Remotes[req.GetProvider()]; remote != nil {
+		return remote.InvalidateEntries(x.outgoing(ctx), req)
+	}
+	c, err := x.cache(req.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	switch target := req.GetTarget().(type) {
+	case *cacheadmin.InvalidateEntriesRequest_Key:
+		if target.Key == `` {
+			return nil, status.Error(codes.InvalidArgument, `cacheadmin: key required`)
+		}
+		count = c.Invalidate(target.Key, false)
+	case *cacheadmin.InvalidateEntriesRequest_Prefix:
+		count = c.Invalidate(target.Prefix, true)
+	default:
+		return nil, status.Error(codes.InvalidArgument, `cacheadmin: key or prefix required`)
+	}
+	return &cacheadmin.InvalidateEntriesResponse{Count: int64(count)}, nil
+}
+
+func (x *Server) RefreshEntry(ctx context.Context, req *cacheadmin.RefreshEntryRequest) (*cacheadmin.Entry, error) {
+	if remote := x.Remotes[req.GetProvider()]; remote != nil {
+		return remote.RefreshEntry(x.outgoing(ctx), req)
+	}
+	c, err := x.cache(req.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetKey() == `` {
+		return nil, status.Error(codes.InvalidArgument, `cacheadmin: key required`)
+	}
+	e, err := c.Refresh(ctx, req.GetKey())
+	if err != nil {
+		return nil, err
+	}
+	return newEntry(req.GetProvider(), e, x.timeNow())
+}
+
+func (x *Server) GetStats(ctx context.Context, req *cacheadmin.GetStatsRequest) (*cacheadmin.Stats, error) {
+	if remote := x.Remotes[req.GetProvider()]; remote != nil {
+		return remote.GetStats(x.outgoing(ctx), req)
+	}
+	c, err := x.cache(req.GetProvider())
+	if err != nil {
+		return nil, err
+	}
+	stats := c.Stats()
+	return &cacheadmin.Stats{
+		Provider:    req.GetProvider(),
+		Entries:     int64(stats.Entries),
+		Hits:        stats.Hits,
+		Misses:      stats.Misses,
+		Expired:     stats.Expired,
+		Evicted:     stats.Evicted,
+		Invalidated: stats.Invalidated,
+	}, nil
+}
+
+// Providers returns the name of every provider, local or remote, sorted.
+func (x *Server) Providers() []string {
+	providers := make([]string, 0, len(x.Caches)+len(x.Remotes))
+	for provider := range x.Caches {
+		providers = append(providers, provider)
+	}
+	for provider := range x.Remotes {
+		if _, ok := x.Caches[provider]; !ok {
+			providers = append(providers, provider)
+		}
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+func (x *Server) cache(provider string) (cache.Admin, error) {
+	if c := x.Caches[provider]; c != nil {
+		return c, nil
+	}
+	return nil, status.Errorf(codes.NotFound, `cacheadmin: unknown provider: %q`, provider)
+}
+
+// authorized returns true if the authorization (header or metadata) presents the Token, as a bearer token
+func (x *Server) authorized(authorization string) bool {
+	token, ok := strings.CutPrefix(authorization, bearerPrefix)
+	return ok && x.Token != `` && subtle.ConstantTimeCompare([]byte(token), []byte(x.Token)) == 1
+}
+
+// outgoing presents the Token to a remote provider
+func (x *Server) outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, AuthorizationMetadata, bearerPrefix+x.Token)
+}
+
+func (x *Server) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
+
+func newEntry(provider string, e cache.Entry, now time.Time) (*cacheadmin.Entry, error) {
+	res := cacheadmin.Entry{
+		Provider: provider,
+		Key:      e.Key,
+	}
+	if !e.Stored.IsZero() {
+		res.StoreTime = timestamppb.New(e.Stored)
+	}
+	if v, ok := e.Value.(interface{ GetReadTime() *timestamppb.Timestamp }); ok && v.GetReadTime() != nil {
+		res.ReadTime = v.GetReadTime()
+		res.Age = durationpb.New(now.Sub(res.ReadTime.AsTime()))
+	}
+	if v, ok := e.Value.(proto.Message); ok {
+		var err error
+		if res.Value, err = anypb.New(v); err != nil {
+			return nil, status.Errorf(codes.Internal, `cacheadmin: %v`, err)
+		}
+	}
+	return &res, nil
+}
@@ -0,0 +1,238 @@
+package cacheadmin
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fullstorydev/grpchan"
+	"github.com/fullstorydev/grpchan/inprocgrpc"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/cacheadmin"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCache returns a cache of openweather responses, keyed by query, and the number of refreshes
+func newCache(now time.Time) (*cache.Cache[string, *openweather.Weather], cache.Admin, *int) {
+	var (
+		c       cache.Cache[string, *openweather.Weather]
+		refresh int
+	)
+	admin := cache.NewAdmin(&c, func(key string) string { return `query:` + key }, func(ctx context.Context, key string) (*openweather.Weather, error) {
+		query, ok := strings.CutPrefix(key, `query:`)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, `invalid cache key`)
+		}
+		refresh++
+		res := &openweather.Weather{ReadTime: timestamppb.New(now), Temp: 30}
+		c.Put(query, res, 0)
+		return res, nil
+	})
+	return &c, admin, &refresh
+}
+
+func TestServer_Register(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	c, admin, refresh := newCache(now)
+	c.Put(`sydney`, &openweather.Weather{ReadTime: timestamppb.New(now.Add(-time.Minute)), Temp: 21.5}, 0)
+	c.Put(`melbourne`, &openweather.Weather{ReadTime: timestamppb.New(now.Add(-time.Second)), Temp: 12}, 0)
+	c.Put(`perth`, &openweather.Weather{ReadTime: timestamppb.New(now), Temp: 25}, 0)
+
+	server := Server{
+		Token:   `token1`,
+		Caches:  map[string]cache.Admin{`openweather`: admin},
+		TimeNow: func() time.Time { return now },
+	}
+	router := chi.NewRouter()
+	router.Group(server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	do := func(method, path, token string, v any) int {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != `` {
+			req.Header.Set(`Authorization`, `Bearer `+token)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	for _, token := range [...]string{``, `token2`} {
+		var res map[string]any
+		if code := do(http.MethodGet, `/admin/v1/caches`, token, &res); code != http.StatusUnauthorized || res[`code`] != float64(codes.Unauthenticated) {
+			t.Errorf(`unexpected response: %d %v`, code, res)
+		}
+	}
+
+	var entries struct {
+		Entries []struct {
+			Key      string          `json:"key"`
+			ReadTime string          `json:"readTime"`
+			Age      string          `json:"age"`
+			Value    json.RawMessage `json:"value"`
+		} `json:"entries"`
+	}
+	if code := do(http.MethodGet, `/admin/v1/caches/openweather/entries?prefix=query:`, `token1`, &entries); code != http.StatusOK ||
+		len(entries.Entries) != 3 ||
+		entries.Entries[0].Key != `query:perth` ||
+		entries.Entries[2].Key != `query:sydney` ||
+		entries.Entries[2].Age != `60s` ||
+		entries.Entries[2].ReadTime != `2022-10-31T01:21:01Z` ||
+		entries.Entries[2].Value != nil {
+		t.Errorf(`unexpected entries: %d %+v`, code, entries)
+	}
+
+	var entry struct {
+		Provider string `json:"provider"`
+		Key      string `json:"key"`
+		Age      string `json:"age"`
+		Value    struct {
+			Type string  `json:"@type"`
+			Temp float64 `json:"temp"`
+		} `json:"value"`
+	}
+	if code := do(http.MethodGet, `/admin/v1/caches/openweather/entry?key=query:melbourne`, `token1`, &entry); code != http.StatusOK ||
+		entry.Provider != `openweather` ||
+		entry.Age != `1s` ||
+		entry.Value.Type != `type.googleapis.com/weather.openweather.v1.Weather` ||
+		entry.Value.Temp != 12 {
+		t.Errorf(`unexpected entry: %d %+v`, code, entry)
+	}
+	for _, path := range [...]string{`/admin/v1/caches/openweather/entry?key=query:hobart`, `/admin/v1/caches/weatherstack/entries`} {
+		if code := do(http.MethodGet, path, `token1`, nil); code != http.StatusNotFound {
+			t.Errorf(`%s: unexpected status code: %d`, path, code)
+		}
+	}
+	if code := do(http.MethodGet, `/admin/v1/caches/openweather/entry`, `token1`, nil); code != http.StatusBadRequest {
+		t.Errorf(`unexpected status code: %d`, code)
+	}
+
+	if code := do(http.MethodPost, `/admin/v1/caches/openweather/entry/refresh?key=query:sydney`, `token1`, &entry); code != http.StatusOK ||
+		*refresh != 1 ||
+		entry.Key != `query:sydney` ||
+		entry.Age != `0s` ||
+		entry.Value.Temp != 30 {
+		t.Errorf(`unexpected entry: %d %+v`, code, entry)
+	}
+	if code := do(http.MethodPost, `/admin/v1/caches/openweather/entry/refresh?key=sydney`, `token1`, nil); code != http.StatusBadRequest {
+		t.Errorf(`unexpected status code: %d`, code)
+	}
+
+	var invalidated struct {
+		Count string `json:"count"`
+	}
+	for _, tc := range [...]struct {
+		query string
+		code  int
+		count string
+	}{
+		{``, http.StatusBadRequest, ``},
+		{`?key=query:melbourne`, http.StatusOK, `1`},
+		{`?key=query:melbourne`, http.StatusOK, ``},
+		{`?prefix=query:p`, http.StatusOK, `1`},
+	} {
+		invalidated.Count = ``
+		if code := do(http.MethodDelete, `/admin/v1/caches/openweather/entries`+tc.query, `token1`, &invalidated); code != tc.code || invalidated.Count != tc.count {
+			t.Errorf(`%s: unexpected response: %d %+v`, tc.query, code, invalidated)
+		}
+	}
+
+	var stats []map[string]any
+	if code := do(http.MethodGet, `/admin/v1/caches`, `token1`, &stats); code != http.StatusOK ||
+		len(stats) != 1 ||
+		stats[0][`provider`] != `openweather` ||
+		stats[0][`entries`] != `1` ||
+		stats[0][`invalidated`] != `2` {
+		t.Errorf(`unexpected stats: %d %v`, code, stats)
+	}
+}
+
+func TestServer_remote(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	c, admin, _ := newCache(now)
+	c.Put(`sydney`, &openweather.Weather{ReadTime: timestamppb.New(now), Temp: 21.5}, 0)
+
+	// the provider, served standalone
+	remote := &Server{
+		Token:  `token1`,
+		Caches: map[string]cache.Admin{`openweather`: admin},
+	}
+	var conn inprocgrpc.Channel
+	cacheadmin.RegisterCacheAdminServer(grpchan.WithInterceptor(&conn, remote.UnaryServerInterceptor, nil), remote)
+	client := cacheadmin.NewCacheAdminClient(&conn)
+
+	if _, err := client.GetStats(context.Background(), &cacheadmin.GetStatsRequest{Provider: `openweather`}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+
+	for _, token := range [...]string{`token1`, `token2`} {
+		server := Server{
+			Token:   token,
+			Remotes: map[string]cacheadmin.CacheAdminClient{`openweather`: client},
+		}
+		if v := server.Providers(); len(v) != 1 || v[0] != `openweather` {
+			t.Errorf(`unexpected providers: %v`, v)
+		}
+		res, err := server.GetEntry(context.Background(), &cacheadmin.GetEntryRequest{Provider: `openweather`, Key: `query:sydney`})
+		if token != remote.Token {
+			if status.Code(err) != codes.Unauthenticated {
+				t.Errorf(`unexpected error: %v`, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var value openweather.Weather
+		if err := res.GetValue().UnmarshalTo(&value); err != nil || value.GetTemp() != 21.5 || !res.GetReadTime().AsTime().Equal(now) {
+			t.Errorf(`unexpected entry: %v %v`, res, err)
+		}
+		if _, err := server.GetStats(context.Background(), &cacheadmin.GetStatsRequest{Provider: `weatherstack`}); status.Code(err) != codes.NotFound {
+			t.Errorf(`unexpected error: %v`, err)
+		}
+	}
+}
+
+func TestServer_authorized(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		token         string
+		authorization string
+		ok            bool
+	}{
+		{`token1`, `Bearer token1`, true},
+		{`token1`, `bearer token1`, false},
+		{`token1`, `token1`, false},
+		{`token1`, `Bearer token12`, false},
+		{``, `Bearer `, false},
+		{``, ``, false},
+	} {
+		if ok := (&Server{Token: tc.token}).authorized(tc.authorization); ok != tc.ok {
+			t.Errorf(`%q %q: unexpected result: %v`, tc.token, tc.authorization, ok)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package cacheadmin
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/cacheadmin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+)
+
+// Register wires up the HTTP equivalent of the gRPC api, requiring the Token, as a bearer token, via the
+// Authorization header. Entries are identified using the `key` (or `prefix`) query parameter, e.g.
+// `DELETE /admin/v1/caches/openweather/entries?prefix=position:r3`.
+func (x *Server) Register(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(x.Middleware)
+		r.Get(`/admin/v1/caches`, x.listStats)
+		r.Get(`/admin/v1/caches/{provider}`, x.getStats)
+		r.Get(`/admin/v1/caches/{provider}/entries`, x.listEntries)
+		r.Delete(`/admin/v1/caches/{provider}/entries`, x.invalidateEntries)
+		r.Get(`/admin/v1/caches/{provider}/entry`, x.getEntry)
+		r.Post(`/admin/v1/caches/{provider}/entry/refresh`, x.refreshEntry)
+	})
+}
+
+// Middleware rejects requests that don't present the Token, as a bearer token, via the Authorization header, e.g. to
+// protect the other admin endpoints, using the same token.
+func (x *Server) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !x.authorized(r.Header.Get(`Authorization`)) {
+			w.Header().Set(`WWW-Authenticate`, `Bearer`)
+			writeError(w, status.Error(codes.Unauthenticated, `cacheadmin: invalid or missing bearer token`))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (x *Server) listStats(w http.ResponseWriter, r *http.Request) {
+	res := []json.RawMessage{}
+	for _, provider := range x.Providers() {
+		stats, err := x.GetStats(r.Context(), &cacheadmin.GetStatsRequest{Provider: provider})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		b, err := protojson.Marshal(stats)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res = append(res, b)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (x *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	res, err := x.GetStats(r.Context(), &cacheadmin.GetStatsRequest{Provider: chi.URLParam(r, `provider`)})
+	writeProtoJSON(w, res, err)
+}
+
+func (x *Server) listEntries(w http.ResponseWriter, r *http.Request) {
+	res, err := x.ListEntries(r.Context(), &cacheadmin.ListEntriesRequest{
+		Provider: chi.URLParam(r, `provider`),
+		Prefix:   r.URL.Query().Get(`prefix`),
+	})
+	writeProtoJSON(w, res, err)
+}
+
+func (x *Server) invalidateEntries(w http.ResponseWriter, r *http.Request) {
+	req := cacheadmin.InvalidateEntriesRequest{Provider: chi.URLParam(r, `provider`)}
+	// note: an empty prefix invalidates every entry, but must be explicit
+	params := r.URL.Query()
+	switch {
+	case params.Has(`key`):
+		req.Target = &cacheadmin.InvalidateEntriesRequest_Key{Key: params.Get(`key`)}
+	case params.Has(`prefix`):
+		req.Target = &cacheadmin.InvalidateEntriesRequest_Prefix{Prefix: params.Get(`prefix`)}
+	}
+	res, err := x.InvalidateEntries(r.Context(), &req)
+	writeProtoJSON(w, res, err)
+}
+
+func (x *Server) getEntry(w http.ResponseWriter, r *http.Request) {
+	res, err := x.GetEntry(r.Context(), &cacheadmin.GetEntryRequest{
+		Provider: chi.URLParam(r, `provider`),
+		Key:      r.URL.Query().Get(`key`),
+	})
+	writeProtoJSON(w, res, err)
+}
+
+func (x *Server) refreshEntry(w http.ResponseWriter, r *http.Request) {
+	res, err := x.RefreshEntry(r.Context(), &cacheadmin.RefreshEntryRequest{
+		Provider: chi.URLParam(r, `provider`),
+		Key:      r.URL.Query().Get(`key`),
+	})
+	writeProtoJSON(w, res, err)
+}
+
+func writeProtoJSON(w http.ResponseWriter, msg proto.Message, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// note: compacts the (intentionally unstable) protojson output
+	writeJSON(w, http.StatusOK, json.RawMessage(b))
+}
+
+// writeError writes a google.rpc.Status, with the status code corresponding to the gRPC code
+func writeError(w http.ResponseWriter, err error) {
+	sts, _ := status.FromError(err)
+	var statusCode int
+	switch sts.Code() {
+	case codes.InvalidArgument:
+		statusCode = http.StatusBadRequest
+	case codes.Unauthenticated:
+		statusCode = http.StatusUnauthorized
+	case codes.NotFound:
+		statusCode = http.StatusNotFound
+	case codes.Unimplemented:
+		statusCode = http.StatusNotImplemented
+	case codes.Unavailable:
+		statusCode = http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		statusCode = http.StatusGatewayTimeout
+	default:
+		statusCode = http.StatusInternalServerError
+	}
+	b, err := protojson.Marshal(sts.Proto())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, statusCode, json.RawMessage(b))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
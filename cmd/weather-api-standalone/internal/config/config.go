@@ -0,0 +1,754 @@
+// Package config models the configuration of weather-api-standalone, loaded from (in order of increasing
+// precedence) Default, a YAML file, environment variables, then flags.
+//
+// Each field is tagged with its YAML key, and optionally its environment variable, and flag usage. Flag names are
+// the dot separated path of YAML keys, with underscores replaced by hyphens, e.g.
+// `--providers.openweather.base-url`. The environment variables of nested structs are prefixed by the env tag of
+// the parent, if any. Fields tagged `reload:"true"` may be changed at runtime, see Reloader.
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	geocodeapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geocode"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geohash"
+	metnoapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/metno"
+	omapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openmeteo"
+	owapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openweather"
+	wsapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/weatherstack"
+	"github.com/joeycumines/mx51-weather-api/internal/alerts"
+	"github.com/joeycumines/mx51-weather-api/internal/clientauth"
+	"github.com/joeycumines/mx51-weather-api/internal/divergence"
+	"github.com/joeycumines/mx51-weather-api/internal/health"
+	"github.com/joeycumines/mx51-weather-api/internal/history"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	"github.com/joeycumines/mx51-weather-api/internal/weather"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Config is the configuration of weather-api-standalone.
+	Config struct {
+		Addr               string     `yaml:"addr" env:"APP_ADDR" usage:"listen address of the public api"`
+		AdminAddr          string     `yaml:"admin_addr" env:"APP_ADMIN_ADDR" usage:"listen address of the admin api"`
+		AdminToken         Secret     `yaml:"admin_token" env:"APP_ADMIN_TOKEN" usage:"bearer token required by the config, cache, api key, and client admin apis, which are disabled if unset"`
+		ShutdownTimeout    Duration   `yaml:"shutdown_timeout" env:"APP_SHUTDOWN_TIMEOUT" usage:"deadline for draining in-flight requests, on shutdown"`
+		MaxAge             Duration   `yaml:"max_age" env:"APP_MAX_AGE" reload:"true" usage:"max age of readings"`
+		Priority           []string   `yaml:"priority" env:"APP_PROVIDER_PRIORITY" reload:"true" usage:"comma separated provider priority"`
+		AmbiguityThreshold float64    `yaml:"ambiguity_threshold" env:"APP_AMBIGUITY_THRESHOLD" reload:"true" usage:"min population ratio of ambiguous locations"`
+		RoutingFile        string     `yaml:"routing_file" env:"APP_ROUTING_FILE" usage:"path to the JSON routing rules"`
+		Providers          Providers  `yaml:"providers"`
+		Geocoding          Geocoding  `yaml:"geocoding"`
+		Consensus          Consensus  `yaml:"consensus"`
+		Divergence         Divergence `yaml:"divergence"`
+		History            History    `yaml:"history"`
+		Alerts             Alerts     `yaml:"alerts"`
+		Clients            Clients    `yaml:"clients"`
+		GRPC               GRPC       `yaml:"grpc"`
+		Tracing            Tracing    `yaml:"tracing"`
+		Log                Log        `yaml:"log"`
+		Health             Health     `yaml:"health"`
+	}
+
+	// Providers configures the provider implementations.
+	Providers struct {
+		RecordDir        string        `yaml:"record_dir" env:"APP_PROVIDER_RECORD_DIR" usage:"directory upstream requests are recorded to"`
+		ReplayDir        string        `yaml:"replay_dir" env:"APP_PROVIDER_REPLAY_DIR" usage:"directory upstream requests are replayed from"`
+		GeohashPrecision int           `yaml:"geohash_precision" env:"APP_GEOHASH_PRECISION" usage:"geohash precision positions are cached by"`
+		Openweather      KeyedProvider `yaml:"openweather" env:"APP_OPENWEATHER"`
+		Weatherstack     KeyedProvider `yaml:"weatherstack" env:"APP_WEATHERSTACK"`
+		OpenMeteo        OpenMeteo     `yaml:"openmeteo" env:"APP_OPENMETEO"`
+		Metno            Metno         `yaml:"metno" env:"APP_METNO"`
+	}
+
+	// Upstream configures the upstream api of a provider.
+	Upstream struct {
+		BaseURL   string   `yaml:"base_url" env:"_BASE_URL" usage:"base url of the upstream api"`
+		Timeout   Duration `yaml:"timeout" env:"_TIMEOUT" reload:"true" usage:"timeout of each upstream call"`
+		Wait      Duration `yaml:"wait" env:"_WAIT" reload:"true" usage:"wait prior to each upstream call, merging concurrent requests"`
+		RateLimit Duration `yaml:"rate_limit" env:"_RATE_LIMIT" reload:"true" usage:"min interval between upstream calls, per location"`
+		CacheSize int      `yaml:"cache_size" env:"_CACHE_SIZE" reload:"true" usage:"max cached responses, unbounded if 0"`
+		CacheTTL  Duration `yaml:"cache_ttl" env:"_CACHE_TTL" reload:"true" usage:"max age of cached responses, unbounded if 0"`
+	}
+
+	// KeyedProvider configures a provider requiring api keys, it is disabled if there are none, unless it is served
+	// standalone, at Address.
+	KeyedProvider struct {
+		Address  string  `yaml:"address" env:"_ADDRESS" usage:"gRPC target of the standalone provider, e.g. dns:///openweather:9090, served in-process if empty"`
+		APIKeys  APIKeys `yaml:"api_keys" env:"_API_KEY" reload:"true" usage:"comma separated api keys, see apikey.ParseKeys"`
+		Upstream `yaml:",inline"`
+	}
+
+	// OpenMeteo configures the open-meteo provider.
+	OpenMeteo struct {
+		GeocodingBaseURL   string   `yaml:"geocoding_base_url" env:"_GEOCODING_BASE_URL" usage:"base url of the upstream geocoding api"`
+		GeocodingCacheSize int      `yaml:"geocoding_cache_size" env:"_GEOCODING_CACHE_SIZE" usage:"max cached geocoding results"`
+		GeocodingCacheTTL  Duration `yaml:"geocoding_cache_ttl" env:"_GEOCODING_CACHE_TTL" usage:"max age of cached geocoding results"`
+		Upstream           `yaml:",inline"`
+	}
+
+	// Metno configures the met.no provider.
+	Metno struct {
+		UserAgent string `yaml:"user_agent" env:"_USER_AGENT" usage:"user agent identifying the application"`
+		Upstream  `yaml:",inline"`
+	}
+
+	// Geocoding configures the geocoding service.
+	Geocoding struct {
+		BaseURL             string   `yaml:"base_url" env:"APP_GEOCODING_BASE_URL" usage:"base url of the upstream geocoding api, defaults to providers.openmeteo.geocoding_base_url"`
+		Timeout             Duration `yaml:"timeout" env:"APP_GEOCODING_TIMEOUT" usage:"timeout of each upstream call"`
+		CacheSize           int      `yaml:"cache_size" env:"APP_GEOCODING_CACHE_SIZE" usage:"max cached searches, and locations"`
+		CacheTTL            Duration `yaml:"cache_ttl" env:"APP_GEOCODING_CACHE_TTL" usage:"max age of cached searches, and locations"`
+		GazetteerFile       string   `yaml:"gazetteer_file" env:"APP_GAZETTEER_FILE" usage:"path to a GeoNames dump, replacing the upstream api"`
+		GazetteerAdmin1File string   `yaml:"gazetteer_admin1_file" env:"APP_GAZETTEER_ADMIN1_FILE" usage:"path to the GeoNames admin1 codes"`
+	}
+
+	// Consensus configures the consensus mode.
+	Consensus struct {
+		Weights                 string  `yaml:"weights" env:"APP_CONSENSUS_WEIGHTS" reload:"true" usage:"comma separated provider weights, e.g. weatherstack:2"`
+		MaxTemperatureDeviation float64 `yaml:"max_temperature_deviation" env:"APP_CONSENSUS_MAX_TEMPERATURE_DEVIATION" reload:"true" usage:"max deviation from the median temperature"`
+		MaxWindSpeedDeviation   float64 `yaml:"max_wind_speed_deviation" env:"APP_CONSENSUS_MAX_WIND_SPEED_DEVIATION" reload:"true" usage:"max deviation from the median wind speed"`
+	}
+
+	// Divergence configures the tracking of disagreement between providers.
+	Divergence struct {
+		TemperatureThreshold float64  `yaml:"temperature_threshold" env:"APP_DIVERGENCE_TEMPERATURE_THRESHOLD" usage:"mean temperature delta at which a provider diverges"`
+		WindSpeedThreshold   float64  `yaml:"wind_speed_threshold" env:"APP_DIVERGENCE_WIND_SPEED_THRESHOLD" usage:"mean wind speed delta at which a provider diverges"`
+		WebhookURL           Secret   `yaml:"webhook_url" env:"APP_DIVERGENCE_WEBHOOK_URL" usage:"url called when a provider diverges, which may contain a token"`
+		SampleInterval       Duration `yaml:"sample_interval" env:"APP_DIVERGENCE_SAMPLE_INTERVAL" usage:"min interval between sampling every provider, per requested location, which costs upstream quota, negative disables"`
+		MaxLocations         int      `yaml:"max_locations" env:"APP_DIVERGENCE_MAX_LOCATIONS" usage:"max tracked locations, evicting the least recently used"`
+	}
+
+	// History configures the persistence of readings.
+	History struct {
+		Dir       string   `yaml:"dir" env:"APP_HISTORY_DIR" usage:"directory readings are persisted to, disabled if empty"`
+		Retention Duration `yaml:"retention" env:"APP_HISTORY_RETENTION" usage:"min period readings are kept for"`
+	}
+
+	// Alerts configures alert subscriptions.
+	Alerts struct {
+		File     string   `yaml:"file" env:"APP_ALERTS_FILE" usage:"path subscriptions are persisted to, disabled if empty"`
+		Interval Duration `yaml:"interval" env:"APP_ALERTS_INTERVAL" usage:"interval subscribed locations are refreshed"`
+	}
+
+	// Clients configures the authentication, and quotas, of the clients of the public api, see clientauth.
+	Clients struct {
+		File              string   `yaml:"file" env:"APP_CLIENTS_FILE" usage:"path to the JSON client api keys and plans, the public api is open if empty"`
+		UsageRetention    int      `yaml:"usage_retention" env:"APP_CLIENTS_USAGE_RETENTION" usage:"days of client usage reported"`
+		UsageFile         string   `yaml:"usage_file" env:"APP_CLIENTS_USAGE_FILE" usage:"path client usage is persisted to, defaults to the clients file, with a .usage.json suffix"`
+		UsageSaveInterval Duration `yaml:"usage_save_interval" env:"APP_CLIENTS_USAGE_SAVE_INTERVAL" usage:"interval client usage is persisted"`
+	}
+
+	// GRPC configures the transport between the api and standalone providers, see rpc.Config.
+	GRPC struct {
+		Addr       string `yaml:"addr" env:"APP_GRPC_ADDR" usage:"listen address of a standalone provider"`
+		Insecure   bool   `yaml:"insecure" env:"APP_GRPC_INSECURE" usage:"disable TLS"`
+		CertFile   string `yaml:"cert_file" env:"APP_GRPC_CERT_FILE" usage:"PEM certificate, served by a standalone provider, or presented to it (mTLS)"`
+		KeyFile    string `yaml:"key_file" env:"APP_GRPC_KEY_FILE" usage:"PEM private key of the certificate"`
+		CAFile     string `yaml:"ca_file" env:"APP_GRPC_CA_FILE" usage:"PEM CA certificates, verifying standalone providers, or their clients (mTLS)"`
+		ServerName string `yaml:"server_name" env:"APP_GRPC_SERVER_NAME" usage:"server name verified by the api, defaults to the host of the address"`
+	}
+
+	// Tracing configures OpenTelemetry tracing, see tracing.Config.
+	Tracing struct {
+		Exporter    string  `yaml:"exporter" env:"APP_TRACING_EXPORTER" usage:"otlp, stdout, or none"`
+		Endpoint    string  `yaml:"endpoint" env:"APP_TRACING_ENDPOINT" usage:"OTLP gRPC collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT, or localhost:4317"`
+		Insecure    bool    `yaml:"insecure" env:"APP_TRACING_INSECURE" usage:"disable TLS to the OTLP collector"`
+		SampleRatio float64 `yaml:"sample_ratio" env:"APP_TRACING_SAMPLE_RATIO" usage:"fraction of traces sampled, unless continued from a parent"`
+	}
+
+	// Log configures structured logging, see logging.Config.
+	Log struct {
+		Level  string `yaml:"level" env:"APP_LOG_LEVEL" reload:"true" usage:"min level logged, i.e. debug, info, warn, or error"`
+		Format string `yaml:"format" env:"APP_LOG_FORMAT" usage:"text, or json"`
+	}
+
+	// Health configures the readiness of each provider, see health.Checker.
+	Health struct {
+		Window           Duration `yaml:"window" env:"APP_HEALTH_WINDOW" usage:"how recent the outcome of a provider call must be to affect its readiness"`
+		FailureThreshold int      `yaml:"failure_threshold" env:"APP_HEALTH_FAILURE_THRESHOLD" usage:"consecutive failures at which a provider is not ready"`
+		ProbeCity        string   `yaml:"probe_city" env:"APP_HEALTH_PROBE_CITY" usage:"city providers are probed with, while idle or failing, which costs upstream quota, disabled if empty"`
+		ProbeInterval    Duration `yaml:"probe_interval" env:"APP_HEALTH_PROBE_INTERVAL" usage:"interval providers are probed at, at most half of health.window"`
+		GracePeriod      Duration `yaml:"grace_period" env:"APP_HEALTH_GRACE_PERIOD" usage:"period after start that providers are ready, until called, if not probed"`
+	}
+
+	// Options are the flags controlling how the config is loaded, rather than the config itself.
+	Options struct {
+		// File is the path to the YAML config file, set via --config or APP_CONFIG_FILE.
+		File string
+		// PrintConfig indicates the (redacted) config should be printed, instead of running, set via
+		// --print-config.
+		PrintConfig bool
+	}
+
+	// Duration is a time.Duration that (un)marshals as a string, e.g. "150ms".
+	Duration time.Duration
+
+	// APIKeys is a comma separated list of api keys, see apikey.ParseKeys, which are redacted when marshaled.
+	APIKeys string
+
+	// Secret is a string, e.g. a token, which is redacted when marshaled.
+	Secret string
+
+	// field is a (leaf) config field, see walk
+	field struct {
+		value  reflect.Value
+		path   string
+		env    string
+		usage  string
+		reload bool
+	}
+
+	// flagValue records the raw flag, which is applied after the config file and environment variables
+	flagValue struct {
+		field *field
+		raw   *string
+	}
+)
+
+var (
+	// compile time assertions
+
+	_ encoding.TextMarshaler   = Duration(0)
+	_ encoding.TextUnmarshaler = (*Duration)(nil)
+	_ encoding.TextMarshaler   = APIKeys(``)
+	_ encoding.TextUnmarshaler = (*APIKeys)(nil)
+	_ encoding.TextMarshaler   = Secret(``)
+	_ encoding.TextUnmarshaler = (*Secret)(nil)
+	_ flag.Value               = flagValue{}
+)
+
+// Default returns the default config, including the defaults of each provider.
+func Default() *Config {
+	upstream := func(baseURL string, timeout, wait, rateLimit time.Duration) Upstream {
+		return Upstream{
+			BaseURL:   baseURL,
+			Timeout:   Duration(timeout),
+			Wait:      Duration(wait),
+			RateLimit: Duration(rateLimit),
+		}
+	}
+	priority := make([]string, len(weather.DefaultPriority))
+	for i, provider := range weather.DefaultPriority {
+		priority[i] = string(provider)
+	}
+	return &Config{
+		Addr:               `:8080`,
+		AdminAddr:          `localhost:8081`,
+		ShutdownTimeout:    Duration(time.Second * 30),
+		MaxAge:             Duration(time.Second * 3),
+		Priority:           priority,
+		AmbiguityThreshold: weather.DefaultAmbiguityThreshold,
+		Providers: Providers{
+			GeohashPrecision: geohash.DefaultPrecision,
+			Openweather: KeyedProvider{
+				Upstream: upstream(owapi.DefaultBaseURL, owapi.DefaultTimeout, owapi.DefaultWait, owapi.DefaultRateLimit),
+			},
+			Weatherstack: KeyedProvider{
+				Upstream: upstream(wsapi.DefaultBaseURL, wsapi.DefaultTimeout, wsapi.DefaultWait, wsapi.DefaultRateLimit),
+			},
+			OpenMeteo: OpenMeteo{
+				GeocodingBaseURL:   omapi.DefaultGeocodingBaseURL,
+				GeocodingCacheSize: omapi.DefaultGeocodingCacheSize,
+				GeocodingCacheTTL:  Duration(omapi.DefaultGeocodingCacheTTL),
+				Upstream:           upstream(omapi.DefaultBaseURL, omapi.DefaultTimeout, omapi.DefaultWait, omapi.DefaultRateLimit),
+			},
+			Metno: Metno{
+				UserAgent: metnoapi.DefaultUserAgent,
+				Upstream:  upstream(metnoapi.DefaultBaseURL, metnoapi.DefaultTimeout, metnoapi.DefaultWait, metnoapi.DefaultRateLimit),
+			},
+		},
+		Geocoding: Geocoding{
+			Timeout:   Duration(geocodeapi.DefaultTimeout),
+			CacheSize: geocodeapi.DefaultCacheSize,
+			CacheTTL:  Duration(geocodeapi.DefaultCacheTTL),
+		},
+		Consensus: Consensus{
+			MaxTemperatureDeviation: weather.DefaultMaxTemperatureDeviation,
+			MaxWindSpeedDeviation:   weather.DefaultMaxWindSpeedDeviation,
+		},
+		Divergence: Divergence{
+			TemperatureThreshold: divergence.DefaultTemperatureThreshold,
+			WindSpeedThreshold:   divergence.DefaultWindSpeedThreshold,
+			SampleInterval:       Duration(divergence.DefaultSampleInterval),
+			MaxLocations:         divergence.DefaultMaxLocations,
+		},
+		History: History{
+			Retention: Duration(history.DefaultRetention),
+		},
+		Alerts: Alerts{
+			Interval: Duration(alerts.DefaultInterval),
+		},
+		Clients: Clients{
+			UsageRetention:    clientauth.DefaultUsageRetention,
+			UsageSaveInterval: Duration(clientauth.DefaultSaveInterval),
+		},
+		GRPC: GRPC{
+			Addr: `:9090`,
+		},
+		Tracing: Tracing{
+			Exporter:    tracing.ExporterNone,
+			SampleRatio: 1,
+		},
+		Log: Log{
+			Level:  `info`,
+			Format: logging.FormatText,
+		},
+		Health: Health{
+			Window:           Duration(health.DefaultWindow),
+			FailureThreshold: health.DefaultFailureThreshold,
+			ProbeInterval:    Duration(health.DefaultProbeInterval),
+			GracePeriod:      Duration(health.DefaultGracePeriod),
+		},
+	}
+}
+
+// Load parses the flags from args (excluding the program name), then loads and validates the config. The lookupEnv
+// function is typically os.LookupEnv. The returned error wraps flag.ErrHelp, if help was requested.
+func Load(name string, args []string, lookupEnv func(key string) (string, bool)) (*Config, Options, error) {
+	config := Default()
+	fields := walk(reflect.ValueOf(config).Elem(), ``, ``)
+
+	var options Options
+	options.File, _ = lookupEnv(`APP_CONFIG_FILE`)
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&options.File, `config`, options.File, `path to the YAML config file (env APP_CONFIG_FILE)`)
+	flags.BoolVar(&options.PrintConfig, `print-config`, false, `print the (redacted) config, then exit`)
+	var flagValues []flagValue
+	for i := range fields {
+		v := flagValue{field: &fields[i], raw: new(string)}
+		usage := v.field.usage
+		if v.field.env != `` {
+			usage += ` (env ` + v.field.env + `)`
+		}
+		flags.Var(v, v.field.flagName(), usage)
+		flagValues = append(flagValues, v)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, options, err
+	}
+	if flags.NArg() != 0 {
+		return nil, options, fmt.Errorf(`config: unexpected arguments: %q`, flags.Args())
+	}
+
+	if options.File != `` {
+		b, err := os.ReadFile(options.File)
+		if err != nil {
+			return nil, options, fmt.Errorf(`config: %w`, err)
+		}
+		if err := Unmarshal(b, config); err != nil {
+			return nil, options, fmt.Errorf(`config: invalid file %s: %w`, options.File, err)
+		}
+	}
+
+	for _, field := range fields {
+		if field.env == `` {
+			continue
+		}
+		// note: empty values are treated as unset
+		if v, ok := lookupEnv(field.env); ok && v != `` {
+			if err := field.set(v); err != nil {
+				return nil, options, fmt.Errorf(`config: invalid %s: %w`, field.env, err)
+			}
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if v, ok := f.Value.(flagValue); ok && err == nil {
+			if e := v.field.set(*v.raw); e != nil {
+				err = fmt.Errorf(`config: invalid flag --%s: %w`, f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, options, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, options, err
+	}
+
+	return config, options, nil
+}
+
+// Unmarshal decodes YAML into the config, unknown fields are an error.
+func Unmarshal(b []byte, config *Config) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Write encodes the config as YAML, with any secrets redacted.
+func (x *Config) Write(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(x); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Validate returns an error describing every invalid field, if any.
+func (x *Config) Validate() error {
+	var problems []string
+	check := func(path string, err error) {
+		if err != nil {
+			problems = append(problems, path+`: `+err.Error())
+		}
+	}
+
+	check(`addr`, validateAddr(x.Addr))
+	check(`admin_addr`, validateAddr(x.AdminAddr))
+	check(`shutdown_timeout`, validatePositive(x.ShutdownTimeout))
+	check(`max_age`, validatePositive(x.MaxAge))
+	if _, err := weather.ParsePriority(strings.Join(x.Priority, `,`)); err != nil {
+		check(`priority`, err)
+	}
+	if x.AmbiguityThreshold < 0 {
+		check(`ambiguity_threshold`, errors.New(`must not be negative`))
+	}
+
+	if x.Providers.RecordDir != `` && x.Providers.ReplayDir != `` {
+		check(`providers.replay_dir`, errors.New(`mutually exclusive with providers.record_dir`))
+	}
+	if x.Providers.GeohashPrecision < 0 || x.Providers.GeohashPrecision > geohash.MaxPrecision {
+		check(`providers.geohash_precision`, fmt.Errorf(`must be between 0 and %d`, geohash.MaxPrecision))
+	}
+	for _, keyed := range [...]struct {
+		path     string
+		provider *KeyedProvider
+	}{
+		{`providers.openweather`, &x.Providers.Openweather},
+		{`providers.weatherstack`, &x.Providers.Weatherstack},
+	} {
+		if _, err := apikey.ParseKeys(string(keyed.provider.APIKeys)); err != nil {
+			check(keyed.path+`.api_keys`, err)
+		}
+	}
+	for _, upstream := range [...]struct {
+		path     string
+		upstream *Upstream
+	}{
+		{`providers.openweather`, &x.Providers.Openweather.Upstream},
+		{`providers.weatherstack`, &x.Providers.Weatherstack.Upstream},
+		{`providers.openmeteo`, &x.Providers.OpenMeteo.Upstream},
+		{`providers.metno`, &x.Providers.Metno.Upstream},
+	} {
+		problems = append(problems, upstream.upstream.validate(upstream.path)...)
+	}
+	check(`providers.openmeteo.geocoding_base_url`, validateURL(x.Providers.OpenMeteo.GeocodingBaseURL))
+	if x.Providers.OpenMeteo.GeocodingCacheSize <= 0 {
+		check(`providers.openmeteo.geocoding_cache_size`, errors.New(`must be positive`))
+	}
+	check(`providers.openmeteo.geocoding_cache_ttl`, validatePositive(x.Providers.OpenMeteo.GeocodingCacheTTL))
+
+	check(`geocoding.base_url`, validateURL(x.Geocoding.BaseURL))
+	check(`geocoding.timeout`, validatePositive(x.Geocoding.Timeout))
+	if x.Geocoding.CacheSize <= 0 {
+		check(`geocoding.cache_size`, errors.New(`must be positive`))
+	}
+	check(`geocoding.cache_ttl`, validatePositive(x.Geocoding.CacheTTL))
+	if x.Geocoding.GazetteerAdmin1File != `` && x.Geocoding.GazetteerFile == `` {
+		check(`geocoding.gazetteer_admin1_file`, errors.New(`requires geocoding.gazetteer_file`))
+	}
+
+	if _, err := weather.ParseWeights(x.Consensus.Weights); err != nil {
+		check(`consensus.weights`, err)
+	}
+	if x.Consensus.MaxTemperatureDeviation < 0 {
+		check(`consensus.max_temperature_deviation`, errors.New(`must not be negative`))
+	}
+	if x.Consensus.MaxWindSpeedDeviation < 0 {
+		check(`consensus.max_wind_speed_deviation`, errors.New(`must not be negative`))
+	}
+
+	if x.Divergence.TemperatureThreshold < 0 {
+		check(`divergence.temperature_threshold`, errors.New(`must not be negative`))
+	}
+	if x.Divergence.WindSpeedThreshold < 0 {
+		check(`divergence.wind_speed_threshold`, errors.New(`must not be negative`))
+	}
+	if err := validateURL(string(x.Divergence.WebhookURL)); err != nil {
+		// note: the error would include the url, which is a secret
+		check(`divergence.webhook_url`, errors.New(`must be an absolute http(s) url`))
+	}
+	if x.Divergence.SampleInterval == 0 {
+		check(`divergence.sample_interval`, errors.New(`must not be zero`))
+	}
+	if x.Divergence.MaxLocations <= 0 {
+		check(`divergence.max_locations`, errors.New(`must be positive`))
+	}
+
+	check(`history.retention`, validateNonNegative(x.History.Retention))
+	check(`alerts.interval`, validateNonNegative(x.Alerts.Interval))
+	if x.Clients.UsageRetention <= 0 {
+		check(`clients.usage_retention`, errors.New(`must be positive`))
+	}
+	check(`clients.usage_save_interval`, validatePositive(x.Clients.UsageSaveInterval))
+
+	check(`grpc.addr`, validateAddr(x.GRPC.Addr))
+	if (x.GRPC.CertFile == ``) != (x.GRPC.KeyFile == ``) {
+		check(`grpc.key_file`, errors.New(`must be set with grpc.cert_file`))
+	}
+
+	switch x.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		check(`tracing.exporter`, fmt.Errorf(`must be one of %s, %s, or %s`, tracing.ExporterOTLP, tracing.ExporterStdout, tracing.ExporterNone))
+	}
+	if x.Tracing.SampleRatio <= 0 || x.Tracing.SampleRatio > 1 {
+		check(`tracing.sample_ratio`, errors.New(`must be greater than 0, and at most 1`))
+	}
+
+	if _, err := logging.ParseLevel(x.Log.Level); err != nil {
+		check(`log.level`, errors.New(`must be one of debug, info, warn, or error`))
+	}
+	switch x.Log.Format {
+	case logging.FormatText, logging.FormatJSON:
+	default:
+		check(`log.format`, fmt.Errorf(`must be one of %s, or %s`, logging.FormatText, logging.FormatJSON))
+	}
+
+	check(`health.window`, validatePositive(x.Health.Window))
+	if x.Health.FailureThreshold <= 0 {
+		check(`health.failure_threshold`, errors.New(`must be positive`))
+	}
+	if err := validatePositive(x.Health.ProbeInterval); err != nil {
+		check(`health.probe_interval`, err)
+	} else if x.Health.ProbeInterval > x.Health.Window/2 {
+		// note: otherwise, providers may become unknown, between probes
+		check(`health.probe_interval`, errors.New(`must be at most half of health.window`))
+	}
+	check(`health.grace_period`, validatePositive(x.Health.GracePeriod))
+
+	if len(problems) != 0 {
+		return fmt.Errorf("config: invalid:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (x *Upstream) validate(path string) (problems []string) {
+	for _, v := range [...]struct {
+		name string
+		err  error
+	}{
+		{`base_url`, validateURL(x.BaseURL)},
+		{`timeout`, validatePositive(x.Timeout)},
+		{`wait`, validatePositive(x.Wait)},
+		{`rate_limit`, validatePositive(x.RateLimit)},
+		{`cache_ttl`, validateNonNegative(x.CacheTTL)},
+	} {
+		if v.err != nil {
+			problems = append(problems, path+`.`+v.name+`: `+v.err.Error())
+		}
+	}
+	if x.CacheSize < 0 {
+		problems = append(problems, path+`.cache_size: must not be negative`)
+	}
+	return problems
+}
+
+// Std returns the value as a time.Duration.
+func (x Duration) Std() time.Duration { return time.Duration(x) }
+
+func (x Duration) MarshalText() ([]byte, error) { return []byte(time.Duration(x).String()), nil }
+
+func (x *Duration) UnmarshalText(b []byte) error {
+	d, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*x = Duration(d)
+	return nil
+}
+
+// Keys parses the api keys.
+func (x APIKeys) Keys() ([]apikey.Key, error) { return apikey.ParseKeys(string(x)) }
+
+// String redacts each key, see apikey.Redact.
+func (x APIKeys) String() string {
+	keys, err := x.Keys()
+	if err != nil {
+		return apikey.Redact(string(x))
+	}
+	redacted := make([]string, len(keys))
+	for i, key := range keys {
+		redacted[i] = apikey.Redact(key.Value)
+		if key.Weight != 1 {
+			redacted[i] += `:` + strconv.Itoa(key.Weight)
+		}
+	}
+	return strings.Join(redacted, `,`)
+}
+
+func (x APIKeys) MarshalText() ([]byte, error) { return []byte(x.String()), nil }
+
+func (x *APIKeys) UnmarshalText(b []byte) error {
+	*x = APIKeys(b)
+	return nil
+}
+
+// String redacts the secret, see apikey.Redact.
+func (x Secret) String() string { return apikey.Redact(string(x)) }
+
+func (x Secret) MarshalText() ([]byte, error) { return []byte(x.String()), nil }
+
+func (x *Secret) UnmarshalText(b []byte) error {
+	*x = Secret(b)
+	return nil
+}
+
+func (x flagValue) String() string {
+	if x.raw == nil {
+		return ``
+	}
+	return *x.raw
+}
+
+func (x flagValue) Set(s string) error {
+	// note: validated early, for a better error message, but applied after the file and env
+	if err := x.field.set(s); err != nil {
+		return err
+	}
+	*x.raw = s
+	return nil
+}
+
+func (x flagValue) IsBoolFlag() bool {
+	return x.field != nil && x.field.value.Kind() == reflect.Bool
+}
+
+func (x *field) flagName() string {
+	return strings.ReplaceAll(x.path, `_`, `-`)
+}
+
+// set parses the value into the field, see also Unmarshal, which handles the same types
+func (x *field) set(s string) error {
+	if v, ok := x.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return v.UnmarshalText([]byte(s))
+	}
+	switch x.value.Kind() {
+	case reflect.String:
+		x.value.SetString(s)
+	case reflect.Int:
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		x.value.SetInt(int64(v))
+	case reflect.Float64:
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return err
+		}
+		x.value.SetFloat(v)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		x.value.SetBool(v)
+	case reflect.Slice:
+		var values []string
+		for _, v := range strings.Split(s, `,`) {
+			if v = strings.TrimSpace(v); v != `` {
+				values = append(values, v)
+			}
+		}
+		x.value.Set(reflect.ValueOf(values))
+	default:
+		panic(fmt.Errorf(`config: unsupported field %s of type %s`, x.path, x.value.Type()))
+	}
+	return nil
+}
+
+// walk returns the leaf fields of the (struct) value, in order
+func walk(value reflect.Value, path, env string) (fields []field) {
+	for i := 0; i < value.NumField(); i++ {
+		t := value.Type().Field(i)
+		name, _, _ := strings.Cut(t.Tag.Get(`yaml`), `,`)
+		if name == `-` {
+			continue
+		}
+		fieldPath := path
+		if !t.Anonymous {
+			if fieldPath != `` {
+				fieldPath += `.`
+			}
+			fieldPath += name
+		}
+		fieldEnv := t.Tag.Get(`env`)
+		if fieldEnv != `` {
+			fieldEnv = env + fieldEnv
+		} else if t.Anonymous {
+			fieldEnv = env
+		}
+		if v := value.Field(i); v.Kind() == reflect.Struct {
+			fields = append(fields, walk(v, fieldPath, fieldEnv)...)
+			continue
+		}
+		fields = append(fields, field{
+			value:  value.Field(i),
+			path:   fieldPath,
+			env:    fieldEnv,
+			usage:  t.Tag.Get(`usage`),
+			reload: t.Tag.Get(`reload`) == `true`,
+		})
+	}
+	return fields
+}
+
+func validateAddr(addr string) error {
+	if _, port, err := net.SplitHostPort(addr); err != nil {
+		return err
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf(`invalid port %q`, port)
+	}
+	return nil
+}
+
+// validateURL allows empty values
+func validateURL(s string) error {
+	if s == `` {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != `http` && u.Scheme != `https`) || u.Host == `` {
+		return fmt.Errorf(`must be an absolute http(s) url, got %q`, s)
+	}
+	return nil
+}
+
+func validatePositive(d Duration) error {
+	if d <= 0 {
+		return errors.New(`must be positive`)
+	}
+	return nil
+}
+
+func validateNonNegative(d Duration) error {
+	if d < 0 {
+		return errors.New(`must not be negative`)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lookupEnv(env map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), `config.yaml`)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_defaults(t *testing.T) {
+	t.Parallel()
+
+	config, options, err := Load(`test`, nil, lookupEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options != (Options{}) {
+		t.Errorf(`unexpected options: %+v`, options)
+	}
+	if !reflect.DeepEqual(config, Default()) {
+		t.Errorf(`unexpected config: %+v`, config)
+	}
+	if config.Addr != `:8080` || config.MaxAge.Std() != time.Second*3 || config.Providers.Openweather.Wait.Std() != time.Millisecond*100 {
+		t.Errorf(`unexpected config: %+v`, config)
+	}
+}
+
+func TestLoad_precedence(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, `
+addr: ":9000"
+admin_addr: "localhost:9001"
+max_age: 10s
+priority: [openmeteo, metno]
+providers:
+  openweather:
+    api_keys: file-key
+    base_url: http://file
+    timeout: 5s
+    cache_size: 100
+  metno:
+    user_agent: file-agent
+`)
+
+	config, options, err := Load(`test`, []string{
+		`--config`, path,
+		`--providers.openweather.base-url=http://flag`,
+		`--max-age`, `20s`,
+	}, lookupEnv(map[string]string{
+		`APP_OPENWEATHER_BASE_URL`: `http://env`,
+		`APP_OPENWEATHER_TIMEOUT`:  `7s`,
+		`APP_METNO_CACHE_TTL`:      `1m`,
+		`APP_PROVIDER_PRIORITY`:    `metno, openmeteo`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.File != path || options.PrintConfig {
+		t.Errorf(`unexpected options: %+v`, options)
+	}
+
+	expected := Default()
+	expected.Addr = `:9000`
+	expected.AdminAddr = `localhost:9001`
+	expected.MaxAge = Duration(time.Second * 20)
+	expected.Priority = []string{`metno`, `openmeteo`}
+	expected.Providers.Openweather.APIKeys = `file-key`
+	expected.Providers.Openweather.BaseURL = `http://flag`
+	expected.Providers.Openweather.Timeout = Duration(time.Second * 7)
+	expected.Providers.Openweather.CacheSize = 100
+	expected.Providers.Metno.UserAgent = `file-agent`
+	expected.Providers.Metno.CacheTTL = Duration(time.Minute)
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("unexpected config:\n%+v\nexpected:\n%+v", config, expected)
+	}
+}
+
+func TestLoad_configFileEnv(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "history:\n  dir: /tmp/history\n")
+	config, options, err := Load(`test`, []string{`--print-config`}, lookupEnv(map[string]string{`APP_CONFIG_FILE`: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.File != path || !options.PrintConfig || config.History.Dir != `/tmp/history` {
+		t.Errorf(`unexpected result: %+v %+v`, options, config)
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		{
+			name: `unknown field`,
+			file: "providers:\n  openweather:\n    base_uri: http://x\n",
+			err:  `field base_uri not found`,
+		},
+		{
+			name: `invalid env`,
+			env:  map[string]string{`APP_WEATHERSTACK_CACHE_SIZE`: `lots`},
+			err:  `config: invalid APP_WEATHERSTACK_CACHE_SIZE`,
+		},
+		{
+			name: `invalid flag`,
+			args: []string{`--alerts.interval=often`},
+			err:  `invalid value "often" for flag -alerts.interval`,
+		},
+		{
+			name: `unknown flag`,
+			args: []string{`--nope`},
+			err:  `flag provided but not defined: -nope`,
+		},
+		{
+			name: `arguments`,
+			args: []string{`serve`},
+			err:  `config: unexpected arguments: ["serve"]`,
+		},
+		{
+			name: `validation`,
+			file: "addr: nope\npriority: [openweather, nope]\nproviders:\n  openmeteo:\n    timeout: 0s\n    base_url: ftp://x\n",
+			args: []string{`--tracing.sample-ratio=2`, `--log.level=verbose`, `--health.failure-threshold=0`, `--clients.usage-retention=0`},
+			env:  map[string]string{`APP_OPENWEATHER_API_KEY`: `key:0`, `APP_GEOHASH_PRECISION`: `13`, `APP_GRPC_CERT_FILE`: `cert.pem`, `APP_TRACING_EXPORTER`: `jaeger`, `APP_LOG_FORMAT`: `xml`},
+			err: "config: invalid:\n" +
+				"  addr: address nope: missing port in address\n" +
+				"  priority: unknown provider \"nope\"\n" +
+				"  providers.geohash_precision: must be between 0 and 12\n" +
+				"  providers.openweather.api_keys: apikey: invalid weight for key ***\n" +
+				"  providers.openmeteo.base_url: must be an absolute http(s) url, got \"ftp://x\"\n" +
+				"  providers.openmeteo.timeout: must be positive\n" +
+				"  clients.usage_retention: must be positive\n" +
+				"  grpc.key_file: must be set with grpc.cert_file\n" +
+				"  tracing.exporter: must be one of otlp, stdout, or none\n" +
+				"  tracing.sample_ratio: must be greater than 0, and at most 1\n" +
+				"  log.level: must be one of debug, info, warn, or error\n" +
+				"  log.format: must be one of text, or json\n" +
+				"  health.failure_threshold: must be positive",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			args := tc.args
+			if tc.file != `` {
+				args = append([]string{`--config`, writeFile(t, tc.file)}, args...)
+			}
+			_, _, err := Load(`test`, args, lookupEnv(tc.env))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("unexpected error: %v\nexpected: %s", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLoad_help(t *testing.T) {
+	t.Parallel()
+	if _, _, err := Load(`test`, []string{`-h`}, lookupEnv(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
+
+func TestConfig_Write(t *testing.T) {
+	t.Parallel()
+
+	config := Default()
+	config.Providers.Openweather.APIKeys = `abcdefghijkl,short:3`
+	config.AdminToken = `s3cr3t-admin-token`
+	config.Divergence.WebhookURL = `https://hooks.example.com/services/s3cr3t-hook-token`
+	var b strings.Builder
+	if err := config.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if s := b.String(); strings.Contains(s, `abcdefgh`) ||
+		strings.Contains(s, `short`) ||
+		!strings.Contains(s, `api_keys: '********ijkl,*****:3'`) ||
+		strings.Contains(s, `s3cr3t`) ||
+		!strings.Contains(s, `admin_token: '**************oken'`) ||
+		strings.Contains(s, `example.com`) ||
+		!strings.Contains(s, `webhook_url: '************************************************oken'`) ||
+		!strings.Contains(s, `max_age: 3s`) ||
+		!strings.Contains(s, `rate_limit: 500ms`) {
+		t.Errorf("unexpected output:\n%s", s)
+	}
+
+	// the output (excluding secrets) round trips
+	config.Providers.Openweather.APIKeys = ``
+	config.AdminToken = ``
+	config.Divergence.WebhookURL = ``
+	b.Reset()
+	if err := config.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Config)
+	if err := Unmarshal([]byte(b.String()), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, config) {
+		t.Errorf("unexpected config:\n%+v\nexpected:\n%+v", decoded, config)
+	}
+}
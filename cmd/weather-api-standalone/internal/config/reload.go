@@ -0,0 +1,134 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Reloader holds the current config, which may be replaced at runtime, e.g. on SIGHUP, safe for concurrent use.
+	// Only fields tagged `reload:"true"` may change, see Config.RestartRequired.
+	// See also NewReloader, Run, and Register.
+	Reloader struct {
+		load    func() (*Config, error)
+		apply   func(prev, next *Config) error
+		mu      sync.Mutex
+		current atomic.Pointer[Config]
+	}
+)
+
+// NewReloader initialises a reloader from the current (already applied) config. The load function is typically a
+// call to Load, with the original args. The apply function replaces the running settings, and must not have applied
+// any of the next config, if it returns an error.
+func NewReloader(current *Config, load func() (*Config, error), apply func(prev, next *Config) error) *Reloader {
+	x := &Reloader{load: load, apply: apply}
+	x.current.Store(current)
+	return x
+}
+
+// Reload loads, then applies, the next config. The current config is retained if an error occurs, including if any
+// field that requires a restart was changed.
+func (x *Reloader) Reload() (*Config, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	next, err := x.load()
+	if err != nil {
+		return nil, err
+	}
+	prev := x.current.Load()
+	if paths := prev.RestartRequired(next); len(paths) != 0 {
+		return nil, fmt.Errorf(`config: restart required to change: %s`, strings.Join(paths, `, `))
+	}
+	if err := x.apply(prev, next); err != nil {
+		return nil, fmt.Errorf(`config: %w`, err)
+	}
+	x.current.Store(next)
+	return next, nil
+}
+
+// Current returns the current config, which must not be modified.
+func (x *Reloader) Current() *Config {
+	return x.current.Load()
+}
+
+// Run reloads the config on each signal received, logging the outcome, until the context is done.
+func (x *Reloader) Run(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			if _, err := x.Reload(); err != nil {
+				slog.Error(`config reload failed, retaining the current config`, `source`, sig.String(), `error`, err)
+			} else {
+				slog.Info(`config reloaded`, `source`, sig.String())
+			}
+		}
+	}
+}
+
+// Register wires up the admin endpoints, to view the (redacted) config, and reload it.
+func (x *Reloader) Register(r chi.Router) {
+	r.Get(`/admin/v1/config`, x.getConfig)
+	r.Post(`/admin/v1/config/reload`, x.reload)
+}
+
+func (x *Reloader) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeYAML(w, x.Current())
+}
+
+func (x *Reloader) reload(w http.ResponseWriter, r *http.Request) {
+	config, err := x.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), `config reloaded`, `source`, `admin api`)
+	writeYAML(w, config)
+}
+
+// RestartRequired returns the paths of the fields that differ in next, which may not be changed at runtime, i.e.
+// those not tagged `reload:"true"`. Enabling or disabling a provider, by adding or removing all of its api keys,
+// also requires a restart.
+func (x *Config) RestartRequired(next *Config) (paths []string) {
+	prevFields := walk(reflect.ValueOf(x).Elem(), ``, ``)
+	nextFields := walk(reflect.ValueOf(next).Elem(), ``, ``)
+	for i, field := range prevFields {
+		if !field.reload && !reflect.DeepEqual(field.value.Interface(), nextFields[i].value.Interface()) {
+			paths = append(paths, field.path)
+		}
+	}
+	for _, keyed := range [...]struct {
+		path       string
+		prev, next APIKeys
+	}{
+		{`providers.openweather.api_keys`, x.Providers.Openweather.APIKeys, next.Providers.Openweather.APIKeys},
+		{`providers.weatherstack.api_keys`, x.Providers.Weatherstack.APIKeys, next.Providers.Weatherstack.APIKeys},
+	} {
+		prevKeys, _ := keyed.prev.Keys()
+		nextKeys, _ := keyed.next.Keys()
+		if (len(prevKeys) == 0) != (len(nextKeys) == 0) {
+			paths = append(paths, keyed.path)
+		}
+	}
+	return paths
+}
+
+func writeYAML(w http.ResponseWriter, config *Config) {
+	var b bytes.Buffer
+	if err := config.Write(&b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/yaml`)
+	_, _ = w.Write(b.Bytes())
+}
@@ -0,0 +1,187 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_RestartRequired(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name   string
+		modify func(config *Config)
+		paths  []string
+	}{
+		{
+			name:   `unchanged`,
+			modify: func(config *Config) {},
+		},
+		{
+			name: `reloadable`,
+			modify: func(config *Config) {
+				config.MaxAge = Duration(time.Minute)
+				config.Priority = []string{`metno`}
+				config.AmbiguityThreshold = 0.5
+				config.Providers.Openweather.APIKeys = `other-key`
+				config.Providers.Metno.Timeout = Duration(time.Second)
+				config.Providers.OpenMeteo.CacheSize = 10
+				config.Consensus.Weights = `metno:2`
+			},
+		},
+		{
+			name: `restart required`,
+			modify: func(config *Config) {
+				config.Addr = `:9000`
+				config.MaxAge = Duration(time.Minute)
+				config.Providers.Metno.BaseURL = `http://other`
+				config.History.Dir = `/tmp/history`
+			},
+			paths: []string{`addr`, `providers.metno.base_url`, `history.dir`},
+		},
+		{
+			name: `providers enabled and disabled`,
+			modify: func(config *Config) {
+				config.Providers.Openweather.APIKeys = ``
+				config.Providers.Weatherstack.APIKeys = `key`
+			},
+			paths: []string{`providers.openweather.api_keys`, `providers.weatherstack.api_keys`},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			prev := Default()
+			prev.Providers.Openweather.APIKeys = `key`
+			next := Default()
+			next.Providers.Openweather.APIKeys = `key`
+			tc.modify(next)
+			if paths := prev.RestartRequired(next); !reflect.DeepEqual(paths, tc.paths) {
+				t.Errorf(`unexpected paths: %q`, paths)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	t.Parallel()
+
+	path := writeFile(t, "max_age: 5s\n")
+	load := func() (*Config, error) {
+		config, _, err := Load(`test`, []string{`--config`, path}, lookupEnv(nil))
+		return config, err
+	}
+	current, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		applied  []*Config
+		applyErr error
+	)
+	reloader := NewReloader(current, load, func(prev, next *Config) error {
+		expected := current
+		if len(applied) != 0 {
+			expected = applied[len(applied)-1]
+		}
+		if prev != expected {
+			t.Error(`unexpected prev`)
+		}
+		if applyErr != nil {
+			return applyErr
+		}
+		applied = append(applied, next)
+		return nil
+	})
+
+	router := chi.NewRouter()
+	router.Group(reloader.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	request := func(method, path string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(b)
+	}
+	update := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status, body := request(http.MethodGet, `/admin/v1/config`); status != http.StatusOK || !strings.Contains(body, `max_age: 5s`) {
+		t.Errorf(`unexpected response: %d %s`, status, body)
+	}
+
+	// valid
+	update("max_age: 10s\nproviders:\n  metno:\n    cache_ttl: 1m\n")
+	if status, body := request(http.MethodPost, `/admin/v1/config/reload`); status != http.StatusOK || !strings.Contains(body, `max_age: 10s`) {
+		t.Errorf(`unexpected response: %d %s`, status, body)
+	}
+	if len(applied) != 1 || reloader.Current() != applied[0] || applied[0].Providers.Metno.CacheTTL != Duration(time.Minute) {
+		t.Errorf(`unexpected applied: %v`, applied)
+	}
+
+	// invalid, restart required, or rejected by apply
+	for _, tc := range [...]struct {
+		content  string
+		applyErr error
+		err      string
+	}{
+		{content: "max_age: 0s\n", err: "config: invalid:\n  max_age: must be positive\n"},
+		{content: "max_age: [\n", err: `config: invalid file ` + path},
+		{content: "max_age: 20s\naddr: \":9000\"\n", err: "config: restart required to change: addr\n"},
+		{content: "max_age: 20s\n", applyErr: errors.New(`nope`), err: "config: nope\n"},
+	} {
+		update(tc.content)
+		applyErr = tc.applyErr
+		if status, body := request(http.MethodPost, `/admin/v1/config/reload`); status != http.StatusBadRequest || !strings.HasPrefix(body, tc.err) {
+			t.Errorf(`unexpected response: %d %s`, status, body)
+		}
+		if len(applied) != 1 || reloader.Current() != applied[0] {
+			t.Errorf(`unexpected applied: %v`, applied)
+		}
+	}
+	applyErr = nil
+
+	if status, body := request(http.MethodGet, `/admin/v1/config`); status != http.StatusOK || !strings.Contains(body, `max_age: 10s`) {
+		t.Errorf(`unexpected response: %d %s`, status, body)
+	}
+
+	// via a signal
+	update("max_age: 30s\n")
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer close(done)
+		reloader.Run(ctx, signals)
+	}()
+	signals <- os.Interrupt
+	cancel()
+	<-done
+	if len(applied) != 2 || reloader.Current().MaxAge != Duration(time.Second*30) {
+		t.Errorf(`unexpected applied: %v`, applied)
+	}
+}
@@ -0,0 +1,351 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/gazetteer"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Server implements geocode.GeocodeServer, using https://geocoding-api.open-meteo.com/v1, which is backed by
+	// GeoNames, and doesn't require an API key, or an (offline) gazetteer.Index, if configured.
+	Server struct {
+		unimplementedServer
+
+		// Gazetteer serves all requests, instead of the upstream API, if set. It also enables GetNearestLocation.
+		Gazetteer *gazetteer.Index
+
+		// BaseURL is the upstream geocoding API, defaults to DefaultBaseURL.
+		BaseURL string
+
+		// Client is used to make upstream requests, defaults to http.DefaultClient.
+		// See also httpclient.Config, which supports configuring transport timeouts and TLS.
+		Client *http.Client
+
+		// Timeout bounds each upstream call, defaults to DefaultTimeout.
+		Timeout time.Duration
+
+		// CacheSize bounds the number of cached searches, and (separately) locations, evicting the least recently
+		// used, defaults to DefaultCacheSize.
+		CacheSize int
+
+		// CacheTTL bounds the age of cached searches and locations, defaults to DefaultCacheTTL.
+		CacheTTL time.Duration
+
+		// searches and locations cache upstream results, which are assumed to be stable
+		// note: searches without any matches aren't cached, as the query is arbitrary (client provided) text
+		searches  cache.Cache[searchKey, []*locationpb.Location]
+		locations cache.Cache[string, *locationpb.Location]
+	}
+
+	searchKey struct {
+		query       string
+		countryCode string
+		maxResults  int32
+	}
+
+	// result models a single upstream location
+	result struct {
+		ID          int64    `json:"id"`
+		Name        string   `json:"name"`
+		Latitude    *float64 `json:"latitude"`
+		Longitude   *float64 `json:"longitude"`
+		CountryCode string   `json:"country_code"`
+		Timezone    string   `json:"timezone"`
+		Admin1      string   `json:"admin1"`
+		Population  int64    `json:"population"`
+	}
+
+	unimplementedServer = geocode.UnimplementedGeocodeServer
+)
+
+const (
+	// DefaultBaseURL is the default value for Server.BaseURL.
+	DefaultBaseURL = `https://geocoding-api.open-meteo.com`
+
+	// DefaultTimeout is the default value for Server.Timeout.
+	DefaultTimeout = time.Minute
+
+	// DefaultCacheSize is the default value for Server.CacheSize.
+	DefaultCacheSize = 10000
+
+	// DefaultCacheTTL is the default value for Server.CacheTTL.
+	DefaultCacheTTL = time.Hour * 24
+
+	// DefaultMaxResults is used if geocode.SearchLocationsRequest.MaxResults is unset.
+	DefaultMaxResults = 10
+
+	// MaxResults is the maximum geocode.SearchLocationsRequest.MaxResults.
+	MaxResults = 100
+
+	// IDPrefix is the prefix of all location ids, which are GeoNames ids.
+	IDPrefix = `geonames:`
+
+	provider = `geocode`
+)
+
+var (
+	// compile time assertions
+
+	_ geocode.GeocodeServer = (*Server)(nil)
+)
+
+func (x *Server) SearchLocations(ctx context.Context, req *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+	key, err := newSearchKey(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if x.Gazetteer != nil {
+		places := x.Gazetteer.Search(key.query, key.countryCode, int(key.maxResults))
+		locations := make([]*locationpb.Location, len(places))
+		for i, place := range places {
+			locations[i] = placeLocation(place)
+		}
+		return &geocode.SearchLocationsResponse{Locations: locations}, nil
+	}
+
+	if locations, ok := x.searches.Get(key, x.cacheTTL()); ok {
+		return &geocode.SearchLocationsResponse{Locations: locations}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, x.timeout())
+	defer cancel()
+
+	u := fmt.Sprintf(
+		`%s/v1/search?format=json&language=en&count=%d&name=%s`,
+		x.baseURL(),
+		key.maxResults,
+		url.QueryEscape(key.query),
+	)
+	if key.countryCode != `` {
+		u += `&countryCode=` + url.QueryEscape(key.countryCode)
+	}
+
+	slog.DebugContext(ctx, `geocode request`, `request`, logging.Proto(req))
+	var body struct {
+		Results []*result `json:"results"`
+	}
+	if err := x.getJSON(ctx, u, &body); err != nil {
+		slog.WarnContext(ctx, `geocode request failed`, `error`, err)
+		return nil, err
+	}
+
+	// note: the results field is omitted if there were no matches
+	locations := make([]*locationpb.Location, 0, len(body.Results))
+	for i, v := range body.Results {
+		location, err := v.location()
+		if err != nil {
+			return nil, httpclient.DecodeError(provider, fmt.Errorf(`results[%d]: %w`, i, err))
+		}
+		// note: the upstream country filter is best effort
+		if key.countryCode != `` && location.GetCountryCode() != key.countryCode {
+			continue
+		}
+		locations = append(locations, location)
+	}
+	slog.DebugContext(ctx, `geocode response`, `locations`, len(locations))
+
+	if len(locations) != 0 {
+		x.searches.Put(key, locations, x.cacheSize())
+		for _, location := range locations {
+			x.locations.Put(location.GetId(), location, x.cacheSize())
+		}
+	}
+
+	return &geocode.SearchLocationsResponse{Locations: locations}, nil
+}
+
+func (x *Server) GetLocation(ctx context.Context, req *geocode.GetLocationRequest) (*locationpb.Location, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	if x.Gazetteer != nil {
+		place, ok := x.Gazetteer.Get(id)
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, `geocode: location %q not found`, req.GetId())
+		}
+		return placeLocation(place), nil
+	}
+
+	if location, ok := x.locations.Get(req.GetId(), x.cacheTTL()); ok {
+		return location, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, x.timeout())
+	defer cancel()
+
+	slog.DebugContext(ctx, `geocode request`, `request`, logging.Proto(req))
+	var body result
+	if err := x.getJSON(ctx, fmt.Sprintf(`%s/v1/get?format=json&language=en&id=%d`, x.baseURL(), id), &body); err != nil {
+		slog.WarnContext(ctx, `geocode request failed`, `error`, err)
+		// note: the upstream api responds with 400 for unknown ids
+		if code := status.Code(err); code == codes.NotFound || code == codes.InvalidArgument {
+			return nil, status.Errorf(codes.NotFound, `geocode: location %q not found`, req.GetId())
+		}
+		return nil, err
+	}
+	location, err := body.location()
+	if err != nil {
+		return nil, httpclient.DecodeError(provider, err)
+	}
+
+	x.locations.Put(location.GetId(), location, x.cacheSize())
+
+	return location, nil
+}
+
+func (x *Server) GetNearestLocation(ctx context.Context, req *geocode.GetNearestLocationRequest) (*locationpb.Location, error) {
+	if x.Gazetteer == nil {
+		return nil, status.Error(codes.Unimplemented, `geocode: nearest location requires a gazetteer`)
+	}
+	position := req.GetPosition()
+	if position == nil ||
+		math.Abs(position.GetLatitude()) > 90 ||
+		math.Abs(position.GetLongitude()) > 180 {
+		return nil, status.Error(codes.InvalidArgument, `geocode: invalid position`)
+	}
+	place, _, ok := x.Gazetteer.Nearest(position.GetLatitude(), position.GetLongitude())
+	if !ok {
+		return nil, status.Error(codes.NotFound, `geocode: no locations`)
+	}
+	return placeLocation(place), nil
+}
+
+func placeLocation(place *gazetteer.Place) *locationpb.Location {
+	return &locationpb.Location{
+		Id:          IDPrefix + strconv.FormatInt(place.ID, 10),
+		Name:        place.Name,
+		Position:    &latlngpb.LatLng{Latitude: place.Latitude, Longitude: place.Longitude},
+		CountryCode: place.CountryCode,
+		TimeZone:    place.TimeZone,
+		Admin1:      place.Admin1,
+		Population:  place.Population,
+	}
+}
+
+func (x *result) location() (*locationpb.Location, error) {
+	if x.ID <= 0 {
+		return nil, fmt.Errorf(`missing "id"`)
+	}
+	if x.Latitude == nil || x.Longitude == nil {
+		return nil, fmt.Errorf(`missing "latitude" or "longitude"`)
+	}
+	return &locationpb.Location{
+		Id:          IDPrefix + strconv.FormatInt(x.ID, 10),
+		Name:        x.Name,
+		Position:    &latlngpb.LatLng{Latitude: *x.Latitude, Longitude: *x.Longitude},
+		CountryCode: strings.ToUpper(x.CountryCode),
+		TimeZone:    x.Timezone,
+		Admin1:      x.Admin1,
+		Population:  x.Population,
+	}, nil
+}
+
+func (x *Server) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := x.client().Do(req)
+	if err != nil {
+		return httpclient.TransportError(provider, err)
+	}
+	defer res.Body.Close()
+	defer io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return httpclient.StatusError(provider, res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return httpclient.DecodeError(provider, err)
+	}
+
+	return nil
+}
+
+func (x *Server) baseURL() string {
+	if x.BaseURL != `` {
+		return strings.TrimSuffix(x.BaseURL, `/`)
+	}
+	return DefaultBaseURL
+}
+
+func (x *Server) client() *http.Client {
+	if x.Client != nil {
+		return x.Client
+	}
+	return http.DefaultClient
+}
+
+func (x *Server) timeout() time.Duration {
+	if x.Timeout > 0 {
+		return x.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (x *Server) cacheSize() int {
+	if x.CacheSize > 0 {
+		return x.CacheSize
+	}
+	return DefaultCacheSize
+}
+
+func (x *Server) cacheTTL() time.Duration {
+	if x.CacheTTL > 0 {
+		return x.CacheTTL
+	}
+	return DefaultCacheTTL
+}
+
+func newSearchKey(req *geocode.SearchLocationsRequest) (searchKey, error) {
+	key := searchKey{
+		query:       strings.ToLower(strings.Join(strings.Fields(req.GetQuery()), ` `)),
+		countryCode: strings.ToUpper(strings.TrimSpace(req.GetCountryCode())),
+		maxResults:  req.GetMaxResults(),
+	}
+	if key.query == `` {
+		return searchKey{}, status.Error(codes.InvalidArgument, `geocode: query required`)
+	}
+	if key.countryCode != `` && len(key.countryCode) != 2 {
+		return searchKey{}, status.Errorf(codes.InvalidArgument, `geocode: invalid country code %q`, req.GetCountryCode())
+	}
+	switch {
+	case key.maxResults < 0 || key.maxResults > MaxResults:
+		return searchKey{}, status.Errorf(codes.InvalidArgument, `geocode: max results must be between 0 and %d`, MaxResults)
+	case key.maxResults == 0:
+		key.maxResults = DefaultMaxResults
+	}
+	return key, nil
+}
+
+func parseID(id string) (int64, error) {
+	if strings.HasPrefix(id, IDPrefix) {
+		if n, err := strconv.ParseInt(strings.TrimPrefix(id, IDPrefix), 10, 64); err == nil && n > 0 {
+			return n, nil
+		}
+	}
+	return 0, status.Errorf(codes.InvalidArgument, `geocode: invalid location id %q`, id)
+}
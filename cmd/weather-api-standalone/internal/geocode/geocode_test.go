@@ -0,0 +1,230 @@
+package geocode
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/fakeprovider"
+	"github.com/joeycumines/mx51-weather-api/internal/gazetteer"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func newFakeServer(t *testing.T, fake *fakeprovider.Server) (*Server, *atomic.Int32) {
+	var requests atomic.Int32
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Route(`/`, fake.Register)
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return &Server{BaseURL: ts.URL, Client: ts.Client()}, &requests
+}
+
+func TestServer_SearchLocations(t *testing.T) {
+	t.Parallel()
+
+	fake := fakeprovider.Server{Readings: func(provider fakeprovider.Provider, query string) (fakeprovider.Reading, bool) {
+		return fakeprovider.Reading{}, query != `nowhere`
+	}}
+	server, requests := newFakeServer(t, &fake)
+
+	res, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: `sydney`, CountryCode: `au`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetLocations()) != 1 {
+		t.Fatalf(`unexpected response: %v`, res)
+	}
+	location := res.GetLocations()[0]
+	if location.GetId() != fmt.Sprintf(`geonames:%d`, fakeprovider.DeterministicID(`sydney`)) ||
+		location.GetName() != `sydney` ||
+		location.GetCountryCode() != `AU` ||
+		location.GetTimeZone() == `` ||
+		location.GetPosition() == nil {
+		t.Errorf(`unexpected location: %v`, location)
+	}
+
+	// searches are normalized and cached
+	if res, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: ` Sydney `, CountryCode: `AU`}); err != nil || len(res.GetLocations()) != 1 {
+		t.Errorf(`unexpected response: %v %v`, res, err)
+	}
+	if v := requests.Load(); v != 1 {
+		t.Errorf(`unexpected requests: %d`, v)
+	}
+
+	// as are locations, by id
+	if v, err := server.GetLocation(context.Background(), &geocode.GetLocationRequest{Id: location.GetId()}); err != nil || v != location {
+		t.Errorf(`unexpected location: %v %v`, v, err)
+	}
+	if v := requests.Load(); v != 1 {
+		t.Errorf(`unexpected requests: %d`, v)
+	}
+
+	// searches without matches aren't cached
+	for i := 0; i < 2; i++ {
+		if res, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: `nowhere`}); err != nil || res.GetLocations() == nil || len(res.GetLocations()) != 0 {
+			t.Errorf(`unexpected response: %v %v`, res, err)
+		}
+	}
+	if v := requests.Load(); v != 3 {
+		t.Errorf(`unexpected requests: %d`, v)
+	}
+
+	for _, req := range [...]*geocode.SearchLocationsRequest{
+		{},
+		{Query: ` `},
+		{Query: `sydney`, CountryCode: `AUS`},
+		{Query: `sydney`, MaxResults: -1},
+		{Query: `sydney`, MaxResults: MaxResults + 1},
+	} {
+		if _, err := server.SearchLocations(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+			t.Errorf(`unexpected error for %v: %v`, req, err)
+		}
+	}
+}
+
+func TestServer_SearchLocations_cacheSize(t *testing.T) {
+	t.Parallel()
+
+	var fake fakeprovider.Server
+	server, requests := newFakeServer(t, &fake)
+	server.CacheSize = 1
+
+	for _, query := range [...]string{`sydney`, `sydney`, `perth`, `sydney`} {
+		if _, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: query}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := requests.Load(); v != 3 {
+		t.Errorf(`unexpected requests: %d`, v)
+	}
+	if v := server.searches.Len(); v != 1 {
+		t.Errorf(`unexpected searches: %d`, v)
+	}
+	if v := server.locations.Len(); v != 1 {
+		t.Errorf(`unexpected locations: %d`, v)
+	}
+}
+
+func TestServer_SearchLocations_countryCode(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get(`countryCode`); v != `AU` {
+			t.Errorf(`unexpected country code: %s`, v)
+		}
+		_, _ = w.Write([]byte(`{"results":[` +
+			`{"id":2147714,"name":"Sydney","latitude":-33.86785,"longitude":151.20732,"country_code":"AU","timezone":"Australia/Sydney","admin1":"New South Wales","population":4627345},` +
+			`{"id":6354908,"name":"Sydney","latitude":46.1351,"longitude":-60.1831,"country_code":"CA","timezone":"America/Glace_Bay","admin1":"Nova Scotia"}` +
+			`]}`))
+	}))
+	defer ts.Close()
+
+	server := Server{BaseURL: ts.URL, Client: ts.Client()}
+	res, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: `sydney`, CountryCode: `AU`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetLocations()) != 1 ||
+		res.GetLocations()[0].GetId() != `geonames:2147714` ||
+		res.GetLocations()[0].GetAdmin1() != `New South Wales` ||
+		res.GetLocations()[0].GetPopulation() != 4627345 ||
+		res.GetLocations()[0].GetPosition().GetLatitude() != -33.86785 {
+		t.Errorf(`unexpected response: %v`, res)
+	}
+}
+
+func TestServer_GetLocation(t *testing.T) {
+	t.Parallel()
+
+	var fake fakeprovider.Server
+	server, requests := newFakeServer(t, &fake)
+
+	// the fake only knows about ids it has previously returned
+	res, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: `perth`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := res.GetLocations()[0].GetId()
+
+	// uncached
+	server = &Server{BaseURL: server.BaseURL, Client: server.Client}
+	for i := 0; i < 2; i++ {
+		location, err := server.GetLocation(context.Background(), &geocode.GetLocationRequest{Id: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if location.GetId() != id || location.GetName() != `perth` {
+			t.Errorf(`unexpected location: %v`, location)
+		}
+	}
+	if v := requests.Load(); v != 2 {
+		t.Errorf(`unexpected requests: %d`, v)
+	}
+
+	if _, err := server.GetLocation(context.Background(), &geocode.GetLocationRequest{Id: `geonames:1`}); status.Code(err) != codes.NotFound {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+
+	for _, id := range [...]string{``, `2147714`, `geonames:`, `geonames:abc`, `geonames:-1`, `osm:2147714`} {
+		if _, err := server.GetLocation(context.Background(), &geocode.GetLocationRequest{Id: id}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf(`unexpected error for %q: %v`, id, err)
+		}
+	}
+}
+
+func TestServer_gazetteer(t *testing.T) {
+	t.Parallel()
+
+	index, err := gazetteer.Load(strings.NewReader(
+		"2147714\tSydney\tSydney\tSYD\t-33.86785\t151.20732\tP\tPPLA\tAU\t\t02\t\t\t\t4627345\t\t58\tAustralia/Sydney\t2020-05-15\n"+
+			"6354908\tSydney\tSydney\t\t46.1351\t-60.1831\tP\tPPL\tCA\t\t07\t\t\t\t105968\t\t23\tAmerica/Glace_Bay\t2019-09-05\n",
+	), strings.NewReader("AU.02\tNew South Wales\tNew South Wales\t2155400\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// note: the upstream api isn't used
+	server := Server{Gazetteer: index, BaseURL: `http://invalid`}
+
+	res, err := server.SearchLocations(context.Background(), &geocode.SearchLocationsRequest{Query: `SYDNEY`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetLocations()) != 2 ||
+		res.GetLocations()[0].GetId() != `geonames:2147714` ||
+		res.GetLocations()[0].GetAdmin1() != `New South Wales` ||
+		res.GetLocations()[0].GetTimeZone() != `Australia/Sydney` ||
+		res.GetLocations()[1].GetCountryCode() != `CA` ||
+		res.GetLocations()[1].GetPopulation() != 105968 {
+		t.Errorf(`unexpected response: %v`, res)
+	}
+
+	if location, err := server.GetLocation(context.Background(), &geocode.GetLocationRequest{Id: `geonames:6354908`}); err != nil || location.GetName() != `Sydney` || location.GetCountryCode() != `CA` {
+		t.Errorf(`unexpected location: %v %v`, location, err)
+	}
+	if _, err := server.GetLocation(context.Background(), &geocode.GetLocationRequest{Id: `geonames:1`}); status.Code(err) != codes.NotFound {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+
+	if location, err := server.GetNearestLocation(context.Background(), &geocode.GetNearestLocationRequest{Position: &latlngpb.LatLng{Latitude: -33.8150, Longitude: 151.0011}}); err != nil || location.GetId() != `geonames:2147714` {
+		t.Errorf(`unexpected location: %v %v`, location, err)
+	}
+	if _, err := server.GetNearestLocation(context.Background(), &geocode.GetNearestLocationRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+	if _, err := new(Server).GetNearestLocation(context.Background(), &geocode.GetNearestLocationRequest{Position: &latlngpb.LatLng{}}); status.Code(err) != codes.Unimplemented {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
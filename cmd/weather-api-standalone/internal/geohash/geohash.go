@@ -0,0 +1,130 @@
+// Package geohash implements geohash (https://en.wikipedia.org/wiki/Geohash) encoding, used to snap positions to a
+// grid, such that nearby positions may share cached readings.
+package geohash
+
+import (
+	"fmt"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"strings"
+)
+
+type (
+	// Cell is the bounding box of a geohash.
+	Cell struct {
+		MinLatitude, MaxLatitude   float64
+		MinLongitude, MaxLongitude float64
+	}
+)
+
+const (
+	// DefaultPrecision is used if the precision is not positive, a cell is approximately 4.9km by 4.9km (at the
+	// equator).
+	DefaultPrecision = 5
+
+	// MaxPrecision is the maximum precision, a cell is approximately 3.7cm by 1.9cm.
+	MaxPrecision = 12
+
+	// IDPrefix is the prefix of the weather.type.Location id, for cells.
+	IDPrefix = `geohash:`
+
+	alphabet = `0123456789bcdefghjkmnpqrstuvwxyz`
+)
+
+// Encode returns the geohash of the position, with the given precision (length), which defaults to
+// DefaultPrecision, and is capped at MaxPrecision.
+func Encode(latitude, longitude float64, precision int) string {
+	precision = normalizePrecision(precision)
+	cell := Cell{MinLatitude: -90, MaxLatitude: 90, MinLongitude: -180, MaxLongitude: 180}
+	var b strings.Builder
+	b.Grow(precision)
+	var (
+		bits, ch int
+		even     = true
+	)
+	for b.Len() < precision {
+		if even {
+			if mid := (cell.MinLongitude + cell.MaxLongitude) / 2; longitude >= mid {
+				ch |= 1 << (4 - bits)
+				cell.MinLongitude = mid
+			} else {
+				cell.MaxLongitude = mid
+			}
+		} else {
+			if mid := (cell.MinLatitude + cell.MaxLatitude) / 2; latitude >= mid {
+				ch |= 1 << (4 - bits)
+				cell.MinLatitude = mid
+			} else {
+				cell.MaxLatitude = mid
+			}
+		}
+		even = !even
+		if bits++; bits == 5 {
+			b.WriteByte(alphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return b.String()
+}
+
+// Decode returns the cell for a geohash.
+func Decode(hash string) (Cell, error) {
+	if hash == `` || len(hash) > MaxPrecision {
+		return Cell{}, fmt.Errorf(`geohash: invalid length %q`, hash)
+	}
+	cell := Cell{MinLatitude: -90, MaxLatitude: 90, MinLongitude: -180, MaxLongitude: 180}
+	even := true
+	for _, r := range strings.ToLower(hash) {
+		ch := strings.IndexRune(alphabet, r)
+		if ch < 0 {
+			return Cell{}, fmt.Errorf(`geohash: invalid character %q`, r)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := ch&(1<<bit) != 0
+			if even {
+				if mid := (cell.MinLongitude + cell.MaxLongitude) / 2; set {
+					cell.MinLongitude = mid
+				} else {
+					cell.MaxLongitude = mid
+				}
+			} else {
+				if mid := (cell.MinLatitude + cell.MaxLatitude) / 2; set {
+					cell.MinLatitude = mid
+				} else {
+					cell.MaxLatitude = mid
+				}
+			}
+			even = !even
+		}
+	}
+	return cell, nil
+}
+
+// Center returns the centroid of the cell.
+func (x Cell) Center() (latitude, longitude float64) {
+	return (x.MinLatitude + x.MaxLatitude) / 2, (x.MinLongitude + x.MaxLongitude) / 2
+}
+
+// Snap returns the location of the cell containing the position, i.e. identified by the geohash, and positioned at
+// the centroid. See also Encode.
+func Snap(position *latlngpb.LatLng, precision int) *locationpb.Location {
+	hash := Encode(position.GetLatitude(), position.GetLongitude(), precision)
+	// note: the hash is always valid
+	cell, _ := Decode(hash)
+	latitude, longitude := cell.Center()
+	return &locationpb.Location{
+		Id:       IDPrefix + hash,
+		Position: &latlngpb.LatLng{Latitude: latitude, Longitude: longitude},
+	}
+}
+
+func normalizePrecision(precision int) int {
+	switch {
+	case precision <= 0:
+		return DefaultPrecision
+	case precision > MaxPrecision:
+		return MaxPrecision
+	default:
+		return precision
+	}
+}
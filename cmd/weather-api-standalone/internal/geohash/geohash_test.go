@@ -0,0 +1,74 @@
+package geohash
+
+import (
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"math"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		latitude  float64
+		longitude float64
+		precision int
+		hash      string
+	}{
+		{57.64911, 10.40744, 11, `u4pruydqqvj`},
+		{-33.8688, 151.2093, 0, `r3gx2`},
+		{-33.8688, 151.2093, 7, `r3gx2f7`},
+		{42.6, -5.6, 5, `ezs42`},
+		{-90, -180, 1, `0`},
+		{90, 180, 1, `z`},
+		{0, 0, 100, `s00000000000`},
+	} {
+		if v := Encode(tc.latitude, tc.longitude, tc.precision); v != tc.hash {
+			t.Errorf(`unexpected hash for %f,%f: %s`, tc.latitude, tc.longitude, v)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	cell, err := Decode(`ezs42`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat, lng := cell.Center(); math.Abs(lat-42.60498046875) > 1e-9 || math.Abs(lng-(-5.60302734375)) > 1e-9 {
+		t.Errorf(`unexpected center: %f,%f`, lat, lng)
+	}
+	if cell.MaxLatitude-cell.MinLatitude != 180.0/(1<<12) || cell.MaxLongitude-cell.MinLongitude != 360.0/(1<<13) {
+		t.Errorf(`unexpected cell: %+v`, cell)
+	}
+
+	if v, err := Decode(`EZS42`); err != nil || v != cell {
+		t.Errorf(`unexpected cell: %+v %v`, v, err)
+	}
+
+	for _, hash := range [...]string{``, `ezs4a`, `0123456789bcd`} {
+		if _, err := Decode(hash); err == nil {
+			t.Errorf(`expected error for %q`, hash)
+		}
+	}
+}
+
+func TestSnap(t *testing.T) {
+	t.Parallel()
+
+	// nearby positions share the cell
+	a := Snap(&latlngpb.LatLng{Latitude: -33.8688, Longitude: 151.2093}, 5)
+	b := Snap(&latlngpb.LatLng{Latitude: -33.8701, Longitude: 151.2070}, 5)
+	if a.GetId() != `geohash:r3gx2` || a.GetId() != b.GetId() || a.GetPosition().GetLatitude() != b.GetPosition().GetLatitude() || a.GetPosition().GetLongitude() != b.GetPosition().GetLongitude() {
+		t.Errorf(`unexpected locations: %v %v`, a, b)
+	}
+
+	// the centroid snaps to the same cell
+	if v := Snap(a.GetPosition(), 5); v.GetId() != a.GetId() {
+		t.Errorf(`unexpected location: %v`, v)
+	}
+
+	if v := Snap(&latlngpb.LatLng{Latitude: -33.8688, Longitude: 151.2093}, 6); v.GetId() == a.GetId() {
+		t.Errorf(`unexpected location: %v`, v)
+	}
+}
@@ -0,0 +1,200 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Fixture is a recorded request/response pair, see Recorder and Replayer.
+	Fixture struct {
+		Request  FixtureRequest  `json:"request"`
+		Response FixtureResponse `json:"response"`
+	}
+
+	// FixtureRequest identifies the request of a Fixture.
+	FixtureRequest struct {
+		Method string `json:"method"`
+		// URL has any credentials redacted, see RedactURL.
+		URL string `json:"url"`
+	}
+
+	// FixtureResponse is the recorded response of a Fixture.
+	FixtureResponse struct {
+		StatusCode int         `json:"status_code"`
+		Header     http.Header `json:"header,omitempty"`
+		// Body is stored as a string, rather than raw JSON, as it may be malformed.
+		Body string `json:"body"`
+	}
+
+	// Recorder is a http.RoundTripper that writes each request/response pair to a fixture file, within Dir.
+	// Fixtures are keyed on the method and redacted URL, with subsequent requests overwriting any existing fixture.
+	Recorder struct {
+		Dir string
+		// Transport defaults to http.DefaultTransport.
+		Transport http.RoundTripper
+	}
+
+	// Replayer is a http.RoundTripper that serves responses from fixture files within Dir, as written by Recorder.
+	// Requests without a matching fixture will fail.
+	Replayer struct {
+		Dir string
+	}
+)
+
+const (
+	redacted = `REDACTED`
+)
+
+var (
+	// RedactParams are the query parameters that will be redacted from fixtures and logs, see also
+	// logging.SecretParams.
+	RedactParams = logging.SecretParams
+
+	// ErrNoFixture indicates that Replayer has no fixture for a request.
+	ErrNoFixture = errors.New(`httpclient: no fixture for request`)
+
+	unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+	// compile time assertions
+
+	_ http.RoundTripper = (*Recorder)(nil)
+	_ http.RoundTripper = (*Replayer)(nil)
+)
+
+// RedactURL returns the URL, with the values of any RedactParams replaced.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ``
+	}
+	v := *u
+	v.User = nil
+	if v.RawQuery != `` {
+		query := v.Query()
+		for _, param := range RedactParams {
+			if _, ok := query[param]; ok {
+				query.Set(param, redacted)
+			}
+		}
+		v.RawQuery = query.Encode()
+	}
+	return v.String()
+}
+
+// FixturePath returns the path of the fixture file, for a given request, within dir.
+func FixturePath(dir string, req *http.Request) string {
+	redactedURL := RedactURL(req.URL)
+	sum := sha256.Sum256([]byte(req.Method + ` ` + redactedURL))
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(req.URL.Host+req.URL.Path, `_`), `_`)
+	return filepath.Join(dir, fmt.Sprintf(`%s_%s_%s.json`, strings.ToLower(req.Method), name, hex.EncodeToString(sum[:6])))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (x *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := x.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	header := res.Header.Clone()
+	header.Del(`Set-Cookie`)
+	if err := writeFixture(FixturePath(x.Dir, req), &Fixture{
+		Request: FixtureRequest{
+			Method: req.Method,
+			URL:    RedactURL(req.URL),
+		},
+		Response: FixtureResponse{
+			StatusCode: res.StatusCode,
+			Header:     header,
+			Body:       string(body),
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (x *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+
+	fixture, err := readFixture(FixturePath(x.Dir, req))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNoFixture
+		}
+		return nil, err
+	}
+
+	header := fixture.Response.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set(`Content-Length`, strconv.Itoa(len(fixture.Response.Body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf(`%d %s`, fixture.Response.StatusCode, http.StatusText(fixture.Response.StatusCode)),
+		StatusCode:    fixture.Response.StatusCode,
+		Proto:         `HTTP/1.1`,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(fixture.Response.Body)),
+		ContentLength: int64(len(fixture.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func writeFixture(path string, fixture *Fixture) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent(``, `  `)
+	if err := enc.Encode(fixture); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+func readFixture(path string) (*Fixture, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(b, &fixture); err != nil {
+		return nil, fmt.Errorf(`httpclient: invalid fixture %s: %w`, path, err)
+	}
+	return &fixture, nil
+}
@@ -0,0 +1,125 @@
+// Package httpclient provides configuration and error handling shared by the upstream provider backends.
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type (
+	// Config models the transport settings for an upstream provider, see Config.NewClient.
+	// Zero values use the defaults of http.DefaultTransport.
+	Config struct {
+		// Timeout is the overall timeout for each request, including reading the body.
+		Timeout               time.Duration
+		DialTimeout           time.Duration
+		TLSHandshakeTimeout   time.Duration
+		ResponseHeaderTimeout time.Duration
+		IdleConnTimeout       time.Duration
+		// CAFile is an optional PEM file, replacing the system root CAs.
+		CAFile             string
+		InsecureSkipVerify bool
+		// RecordDir enables recording of fixtures, see Recorder.
+		RecordDir string
+		// ReplayDir enables replaying of fixtures, see Replayer, and is mutually exclusive with RecordDir.
+		ReplayDir string
+	}
+)
+
+// NewClient builds a new client using the config. Each request is traced, see Tracer.
+func (x Config) NewClient() (*http.Client, error) {
+	if x.ReplayDir != `` {
+		if x.RecordDir != `` {
+			return nil, errors.New(`httpclient: record and replay are mutually exclusive`)
+		}
+		return &http.Client{
+			Transport: &Tracer{Transport: &Replayer{Dir: x.ReplayDir}},
+			Timeout:   x.Timeout,
+		}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if x.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: x.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if x.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = x.TLSHandshakeTimeout
+	}
+	if x.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = x.ResponseHeaderTimeout
+	}
+	if x.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = x.IdleConnTimeout
+	}
+	if x.CAFile != `` || x.InsecureSkipVerify {
+		tlsConfig := &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: x.InsecureSkipVerify,
+		}
+		if x.CAFile != `` {
+			b, err := os.ReadFile(x.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf(`httpclient: no certificates found in %s`, x.CAFile)
+			}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	var roundTripper http.RoundTripper = transport
+	if x.RecordDir != `` {
+		roundTripper = &Recorder{Dir: x.RecordDir, Transport: roundTripper}
+	}
+
+	return &http.Client{
+		Transport: &Tracer{Transport: roundTripper},
+		Timeout:   x.Timeout,
+	}, nil
+}
+
+// StatusError maps an unexpected upstream HTTP status code to a gRPC status error.
+func StatusError(provider string, statusCode int) error {
+	var code codes.Code
+	switch {
+	case statusCode == http.StatusNotFound:
+		code = codes.NotFound
+	case statusCode == http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case statusCode == http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case statusCode >= 500:
+		code = codes.Unavailable
+	default:
+		code = codes.Internal
+	}
+	return status.Errorf(code, `%s: unexpected status code %d`, provider, statusCode)
+}
+
+// TransportError maps an error from http.Client.Do to a gRPC status error.
+// Note that the underlying error is not included in the message, as it may include the request URL.
+func TransportError(provider string, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Errorf(codes.Canceled, `%s: request canceled`, provider)
+	case errors.Is(err, context.DeadlineExceeded), os.IsTimeout(err):
+		return status.Errorf(codes.DeadlineExceeded, `%s: request timed out`, provider)
+	default:
+		return status.Errorf(codes.Unavailable, `%s: request failed`, provider)
+	}
+}
+
+// DecodeError maps an error decoding or validating an upstream response to a gRPC status error.
+func DecodeError(provider string, err error) error {
+	return status.Errorf(codes.Internal, `%s: invalid response: %v`, provider, err)
+}
@@ -0,0 +1,61 @@
+package httpclient
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfig_NewClient_timeout(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	client, err := Config{Timeout: time.Millisecond * 50}.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Get(ts.URL + `/?appid=secret`)
+	if err == nil {
+		t.Fatal(`expected error`)
+	}
+	err = TransportError(`test`, err)
+	if sts := status.Convert(err); sts.Code() != codes.DeadlineExceeded || sts.Message() != `test: request timed out` {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
+
+func TestConfig_NewClient_caFile(t *testing.T) {
+	t.Parallel()
+	if _, err := (Config{CAFile: `testdata/nonexistent.pem`}).NewClient(); err == nil {
+		t.Error(`expected error`)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	t.Parallel()
+	for statusCode, code := range map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusForbidden:           codes.Internal,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusTooManyRequests:     codes.ResourceExhausted,
+		http.StatusInternalServerError: codes.Unavailable,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+	} {
+		if err := StatusError(`test`, statusCode); status.Code(err) != code {
+			t.Errorf(`unexpected error for %d: %v`, statusCode, err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package httpclient
+
+import (
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+type (
+	// Tracer is a http.RoundTripper that starts a client span for each request, ending once the response headers
+	// are received, and propagates the trace context to the upstream. Unlike otelhttp.Transport, the URL is recorded
+	// with any credentials redacted, see RedactURL.
+	Tracer struct {
+		// Transport defaults to http.DefaultTransport.
+		Transport http.RoundTripper
+	}
+)
+
+var (
+	tracer = otel.Tracer(`github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient`)
+
+	// compile time assertions
+
+	_ http.RoundTripper = (*Tracer)(nil)
+)
+
+// RoundTrip implements http.RoundTripper.
+func (x *Tracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := x.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	ctx, span := tracer.Start(req.Context(), `HTTP `+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(req.Method),
+			semconv.HTTPURLKey.String(RedactURL(req.URL)),
+			semconv.NetPeerNameKey.String(req.URL.Hostname()),
+		),
+	)
+	defer span.End()
+
+	// note: the request must not be modified, per http.RoundTripper
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		// note: the error may include the URL, see TransportError
+		tracing.SetError(span, TransportError(req.URL.Hostname(), err))
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(res.StatusCode))
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(res.StatusCode, trace.SpanKindClient))
+	return res, nil
+}
@@ -0,0 +1,119 @@
+package httpclient
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recorder records every span, as the global tracer provider, which may only be set once (per test binary)
+var recorder = func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}()
+
+func TestTracer_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/missing` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get(`traceparent`)))
+	}))
+	defer ts.Close()
+
+	ctx, parent := otel.Tracer(`test`).Start(context.Background(), `TestTracer_RoundTrip`)
+	client := &http.Client{Transport: &Tracer{}}
+	for _, path := range [...]string{`/ok?appid=secret`, `/missing`} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		_, _ = io.Copy(&b, res.Body)
+		_ = res.Body.Close()
+		if req.Header.Get(`traceparent`) != `` {
+			t.Error(`expected the request to be unmodified`)
+		}
+		if res.StatusCode == http.StatusOK && !strings.HasPrefix(b.String(), `00-`+parent.SpanContext().TraceID().String()+`-`) {
+			t.Errorf(`expected the trace context to be propagated: %q`, b.String())
+		}
+	}
+	parent.End()
+
+	var children []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == parent.SpanContext().SpanID() {
+			children = append(children, span)
+		}
+	}
+	if len(children) != 2 {
+		t.Fatalf(`unexpected spans: %+v`, children)
+	}
+	for i, tc := range [...]struct {
+		url    string
+		code   int
+		status codes.Code
+	}{
+		{ts.URL + `/ok?appid=REDACTED`, http.StatusOK, codes.Unset},
+		{ts.URL + `/missing`, http.StatusNotFound, codes.Error},
+	} {
+		span := children[i]
+		attributes := make(map[string]any)
+		for _, kv := range span.Attributes() {
+			attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		if span.Name() != `HTTP GET` ||
+			attributes[string(semconv.HTTPURLKey)] != tc.url ||
+			attributes[string(semconv.HTTPStatusCodeKey)] != int64(tc.code) ||
+			span.Status().Code != tc.status {
+			t.Errorf(`unexpected span: %s %v %v`, span.Name(), attributes, span.Status())
+		}
+	}
+}
+
+func TestTracer_RoundTrip_error(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	ctx, parent := otel.Tracer(`test`).Start(context.Background(), `TestTracer_RoundTrip_error`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+`/?appid=secret`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&http.Client{Transport: &Tracer{}}).Do(req); err == nil {
+		t.Fatal(`expected error`)
+	}
+	parent.End()
+
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		if span.Status().Code != codes.Error || strings.Contains(span.Status().Description, `secret`) ||
+			!strings.HasSuffix(span.Status().Description, `request failed`) {
+			t.Errorf(`unexpected status: %v`, span.Status())
+		}
+		return
+	}
+	t.Error(`expected a span`)
+}
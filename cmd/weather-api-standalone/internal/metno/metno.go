@@ -0,0 +1,439 @@
+package metno
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/joeycumines/go-bigbuff"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geohash"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/metrics"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Server implements metno.MetnoServer, note that the upstream API doesn't require an API key, but it does
+	// require an identifying User-Agent, and conditional requests, respecting the Expires header.
+	//
+	// See https://api.met.no/doc/TermsOfService.
+	Server struct {
+		unimplementedServer
+
+		// Geocoder resolves queries to locations, and is required.
+		Geocoder Geocoder
+
+		// UserAgent identifies the application to the upstream API, defaults to DefaultUserAgent.
+		UserAgent string
+
+		// BaseURL is the upstream API, defaults to DefaultBaseURL.
+		BaseURL string
+
+		// Client is used to make upstream requests, defaults to http.DefaultClient.
+		// See also httpclient.Config, which supports configuring transport timeouts and TLS.
+		Client *http.Client
+
+		// Timeout bounds each upstream call (including geocoding), defaults to DefaultTimeout.
+		Timeout time.Duration
+
+		// Precision is the geohash precision positions are snapped to, such that nearby positions share the cache
+		// (and upstream requests), defaults to geohash.DefaultPrecision. See also geohash.Snap.
+		Precision int
+
+		// Wait is the "long poll" prior to each upstream call, merging concurrent requests (per key), defaults to
+		// DefaultWait.
+		Wait time.Duration
+
+		// RateLimit is the minimum interval between upstream calls (per key), defaults to DefaultRateLimit.
+		RateLimit time.Duration
+
+		// CacheSize bounds the number of cached responses, evicting the least recently used, unbounded if not
+		// positive.
+		CacheSize int
+
+		// CacheTTL bounds the age of cached responses, unbounded if not positive.
+		CacheTTL time.Duration
+
+		// Metrics records the rate limiting of upstream calls, optional. Cache and coalescing outcomes are attached
+		// to the context, see metrics.Metrics.UnaryServerInterceptor.
+		Metrics *metrics.Metrics
+
+		excl  bigbuff.Exclusive
+		cache cache.Cache[cacheKey, *cacheValue]
+		// ctx bounds all upstream calls, which may outlive the request that initiated them, see Close
+		ctx    context.Context
+		cancel context.CancelFunc
+		once   sync.Once
+		// reconfigured overrides the corresponding fields, if set, see Reconfigure
+		reconfigured atomic.Pointer[Options]
+	}
+
+	// Options are the fields of Server that may be replaced at runtime, see Server.Reconfigure.
+	Options struct {
+		Timeout   time.Duration
+		Wait      time.Duration
+		RateLimit time.Duration
+		CacheSize int
+		CacheTTL  time.Duration
+	}
+
+	// Geocoder resolves a query to a location, e.g. the openmeteo implementation.
+	Geocoder interface {
+		Geocode(ctx context.Context, query string) (*locationpb.Location, error)
+	}
+
+	// cacheKey uses the position, if set, such that queries resolving to the same location share the cache
+	cacheKey struct {
+		query    string
+		position string
+	}
+
+	cacheValue struct {
+		res *metno.CurrentWeather
+		// lastModified is the raw Last-Modified header, used for conditional requests
+		lastModified string
+	}
+
+	unimplementedServer = metno.UnimplementedMetnoServer
+)
+
+const (
+	// DefaultBaseURL is the default value for Server.BaseURL.
+	DefaultBaseURL = `https://api.met.no`
+
+	// DefaultUserAgent is the default value for Server.UserAgent.
+	DefaultUserAgent = `mx51-weather-api github.com/joeycumines/mx51-weather-api`
+
+	// DefaultTimeout is the default value for Server.Timeout.
+	DefaultTimeout = time.Minute
+
+	// DefaultWait is the default value for Server.Wait.
+	DefaultWait = time.Millisecond * 100
+
+	// DefaultRateLimit is the default value for Server.RateLimit.
+	DefaultRateLimit = time.Millisecond * 500
+
+	provider = `metno`
+)
+
+var (
+	tracer = otel.Tracer(`github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/metno`)
+
+	// compile time assertions
+
+	_ metno.MetnoServer = (*Server)(nil)
+)
+
+// Close cancels any in-flight upstream calls, and causes subsequent calls to fail. Requests already waiting on an
+// upstream call will fail, unless their own context is done first.
+func (x *Server) Close() {
+	x.context()
+	x.cancel()
+}
+
+// Reconfigure atomically replaces the options, which otherwise default to the corresponding fields of the Server.
+// Cached responses are retained, though they are subject to the new bounds, and in-flight upstream calls are
+// unaffected.
+func (x *Server) Reconfigure(options Options) {
+	x.reconfigured.Store(&options)
+}
+
+func (x *Server) GetCurrentWeather(ctx context.Context, req *metno.GetCurrentWeatherRequest) (*metno.CurrentWeather, error) {
+	key := newCacheKey(req, x.Precision)
+
+	// fast path
+	_, span := tracer.Start(ctx, `metno.cache`)
+	cached, ok := x.cache.Get(key, x.options().CacheTTL)
+	metrics.ObserveCache(ctx, ok, cached.valid(req))
+	span.SetAttributes(tracing.CacheOutcome(ok, cached.valid(req)))
+	span.End()
+	if cached.valid(req) {
+		return cached.res, nil
+	}
+
+	// summary:
+	// - locked on the key
+	// - "long poll" (default 100ms) prior to starting
+	// - merge multiple concurrent calls (per key)
+	// - rate limit (per key, default 500ms)
+	// - conditional requests, if previously cached
+	//
+	// See also the openweather and weatherstack implementations, which this mirrors.
+	// note: the span includes the wait, and any upstream call, which may be shared with other requests
+	ctx, span = tracer.Start(ctx, `metno.exclusive`)
+	defer span.End()
+	// note: called is only set if this call's work function was the one called, i.e. it wasn't coalesced
+	var called bool
+	callCtx := x.context()
+	ch := x.excl.CallWithOptions(
+		bigbuff.ExclusiveKey(key),
+		bigbuff.ExclusiveWait(x.wait()),
+		bigbuff.ExclusiveRateLimit(callCtx, x.rateLimit()),
+		x.Metrics.ExclusiveRateLimit(metno.Metno_ServiceDesc.ServiceName),
+		bigbuff.ExclusiveValue(func() (any, error) {
+			called = true
+			cached, _ := x.cache.Get(key, x.options().CacheTTL)
+			// note: the cache may have been refreshed by a previous call
+			if cached.valid(req) {
+				return cached.res, nil
+			}
+
+			slog.DebugContext(ctx, `metno request`, `request`, logging.Proto(req))
+			// note: the upstream call is traced as part of the request that initiated it, but isn't bound by it
+			upstreamCtx, upstreamSpan := tracer.Start(trace.ContextWithSpan(callCtx, span), `metno.upstream`)
+			defer upstreamSpan.End()
+			value, err := x.getCurrentWeather(upstreamCtx, req, cached)
+			tracing.SetError(upstreamSpan, err)
+			if err == nil {
+				x.cache.Put(key, value, x.options().CacheSize)
+			}
+			if err != nil {
+				slog.WarnContext(ctx, `metno request failed`, `error`, err)
+			} else {
+				slog.DebugContext(ctx, `metno response`, `response`, logging.Proto(value.res))
+			}
+			if err != nil {
+				return nil, err
+			}
+			return value.res, nil
+		}),
+	)
+
+	select {
+	case <-ctx.Done():
+		tracing.SetError(span, ctx.Err())
+		return nil, status.FromContextError(ctx.Err()).Err()
+
+	case v := <-ch:
+		if !called {
+			metrics.ObserveCoalesced(ctx)
+		}
+		span.SetAttributes(tracing.AttrCoalesced.Bool(!called))
+		tracing.SetError(span, v.Error)
+		if v.Error != nil {
+			return nil, v.Error
+		}
+		return v.Result.(*metno.CurrentWeather), nil
+	}
+}
+
+func (x *Server) getCurrentWeather(ctx context.Context, request *metno.GetCurrentWeatherRequest, cached *cacheValue) (*cacheValue, error) {
+	ctx, cancel := context.WithTimeout(ctx, x.timeout())
+	defer cancel()
+
+	// note: the position (if resolved) takes precedence over the query, and is snapped to the geohash cell
+	var location *locationpb.Location
+	if position := request.GetPosition(); position != nil {
+		location = geohash.Snap(position, x.Precision)
+	} else {
+		if x.Geocoder == nil {
+			return nil, status.Error(codes.FailedPrecondition, `metno: no geocoder configured`)
+		}
+		var err error
+		if location, err = x.Geocoder.Geocode(ctx, request.GetQuery()); err != nil {
+			return nil, err
+		}
+	}
+
+	// note: the upstream terms of service require at most 4 decimal places
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(
+		`%s/weatherapi/locationforecast/2.0/compact?lat=%.4f&lon=%.4f`,
+		x.baseURL(),
+		location.GetPosition().GetLatitude(),
+		location.GetPosition().GetLongitude(),
+	), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(`User-Agent`, x.userAgent())
+	if cached != nil && cached.lastModified != `` {
+		req.Header.Set(`If-Modified-Since`, cached.lastModified)
+	}
+
+	res, err := x.client().Do(req)
+	if err != nil {
+		return nil, httpclient.TransportError(provider, err)
+	}
+	defer res.Body.Close()
+	defer io.Copy(io.Discard, res.Body)
+
+	now := time.Now()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNonAuthoritativeInfo:
+		// the product is deprecated, but still functional
+		slog.WarnContext(ctx, `metno deprecated product`, `path`, req.URL.Path)
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, httpclient.StatusError(provider, res.StatusCode)
+		}
+		// the cached data is still current, but the expiry (may have) changed
+		value := *cached
+		value.res = proto.Clone(cached.res).(*metno.CurrentWeather)
+		value.res.ExpireTime = parseTimeHeader(res.Header, `Expires`)
+		if v := res.Header.Get(`Last-Modified`); v != `` {
+			value.lastModified = v
+		}
+		return &value, nil
+	default:
+		return nil, httpclient.StatusError(provider, res.StatusCode)
+	}
+
+	var body struct {
+		Properties struct {
+			Timeseries []struct {
+				Data struct {
+					Instant struct {
+						Details struct {
+							AirTemperature *float64 `json:"air_temperature"`
+							WindSpeed      *float64 `json:"wind_speed"`
+						} `json:"details"`
+					} `json:"instant"`
+				} `json:"data"`
+			} `json:"timeseries"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, httpclient.DecodeError(provider, err)
+	}
+	if len(body.Properties.Timeseries) == 0 {
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "properties.timeseries"`))
+	}
+
+	// note: the first instant is the current (or most recent) hour
+	current := body.Properties.Timeseries[0]
+	if current.Data.Instant.Details.AirTemperature == nil {
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "data.instant.details.air_temperature"`))
+	}
+	if current.Data.Instant.Details.WindSpeed == nil {
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "data.instant.details.wind_speed"`))
+	}
+
+	// the read time is the time the upstream data was last modified, falling back to the time of the request
+	readTime := parseTimeHeader(res.Header, `Last-Modified`)
+	if readTime == nil {
+		readTime = timestamppb.New(now)
+	}
+
+	return &cacheValue{
+		res: &metno.CurrentWeather{
+			ReadTime:       readTime,
+			ExpireTime:     parseTimeHeader(res.Header, `Expires`),
+			Location:       location,
+			AirTemperature: *current.Data.Instant.Details.AirTemperature,
+			WindSpeed:      *current.Data.Instant.Details.WindSpeed,
+		},
+		lastModified: res.Header.Get(`Last-Modified`),
+	}, nil
+}
+
+func (x *Server) baseURL() string {
+	if x.BaseURL != `` {
+		return strings.TrimSuffix(x.BaseURL, `/`)
+	}
+	return DefaultBaseURL
+}
+
+func (x *Server) userAgent() string {
+	if x.UserAgent != `` {
+		return x.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+func (x *Server) client() *http.Client {
+	if x.Client != nil {
+		return x.Client
+	}
+	return http.DefaultClient
+}
+
+func (x *Server) timeout() time.Duration {
+	if v := x.options().Timeout; v > 0 {
+		return v
+	}
+	return DefaultTimeout
+}
+
+func (x *Server) context() context.Context {
+	x.once.Do(func() { x.ctx, x.cancel = context.WithCancel(context.Background()) })
+	return x.ctx
+}
+
+// options returns the current options, see Reconfigure
+func (x *Server) options() *Options {
+	if v := x.reconfigured.Load(); v != nil {
+		return v
+	}
+	return &Options{
+		Timeout:   x.Timeout,
+		Wait:      x.Wait,
+		RateLimit: x.RateLimit,
+		CacheSize: x.CacheSize,
+		CacheTTL:  x.CacheTTL,
+	}
+}
+
+func (x *Server) wait() time.Duration {
+	if v := x.options().Wait; v > 0 {
+		return v
+	}
+	return DefaultWait
+}
+
+func (x *Server) rateLimit() time.Duration {
+	if v := x.options().RateLimit; v > 0 {
+		return v
+	}
+	return DefaultRateLimit
+}
+
+// valid returns true if the cached value may be used to satisfy the request, i.e. it hasn't expired, or it satisfies
+// the min read time
+func (x *cacheValue) valid(req *metno.GetCurrentWeatherRequest) bool {
+	if x == nil {
+		return false
+	}
+	if x.res.GetExpireTime() != nil && time.Now().Before(x.res.GetExpireTime().AsTime()) {
+		return true
+	}
+	return req.GetMinReadTime() == nil ||
+		(x.res.GetReadTime() != nil && !x.res.GetReadTime().AsTime().Before(req.GetMinReadTime().AsTime()))
+}
+
+func parseTimeHeader(header http.Header, key string) *timestamppb.Timestamp {
+	t, err := http.ParseTime(header.Get(key))
+	if err != nil {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func newCacheKey(req *metno.GetCurrentWeatherRequest, precision int) cacheKey {
+	if position := req.GetPosition(); position != nil {
+		return cacheKey{
+			position: geohash.Encode(position.GetLatitude(), position.GetLongitude(), precision),
+		}
+	}
+	return cacheKey{
+		query: req.GetQuery(),
+	}
+}
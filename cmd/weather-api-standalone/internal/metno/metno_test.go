@@ -0,0 +1,268 @@
+package metno
+
+import (
+	"context"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geohash"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	mockGeocoder func(ctx context.Context, query string) (*locationpb.Location, error)
+
+	// fakeUpstream serves the recorded payload, emulating the upstream cache headers
+	fakeUpstream struct {
+		mu           sync.Mutex
+		requests     int
+		notModified  int
+		lastModified time.Time
+		expires      time.Time
+		statusCode   int
+		body         []byte
+	}
+)
+
+func (f mockGeocoder) Geocode(ctx context.Context, query string) (*locationpb.Location, error) {
+	return f(ctx, query)
+}
+
+func (x *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.requests++
+	if r.URL.Path != `/weatherapi/locationforecast/2.0/compact` ||
+		r.URL.Query().Get(`lat`) != `-33.8688` ||
+		r.URL.Query().Get(`lon`) != `151.2093` {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get(`User-Agent`) != DefaultUserAgent {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !x.lastModified.IsZero() {
+		w.Header().Set(`Last-Modified`, x.lastModified.UTC().Format(http.TimeFormat))
+	}
+	if !x.expires.IsZero() {
+		w.Header().Set(`Expires`, x.expires.UTC().Format(http.TimeFormat))
+	}
+	if v, err := http.ParseTime(r.Header.Get(`If-Modified-Since`)); err == nil && !x.lastModified.After(v) {
+		x.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	if x.statusCode != 0 {
+		w.WriteHeader(x.statusCode)
+	}
+	_, _ = w.Write(x.body)
+}
+
+func newFakeUpstream(t *testing.T) (*fakeUpstream, *Server) {
+	body, err := os.ReadFile(`testdata/compact.json`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &fakeUpstream{
+		lastModified: time.Now().Add(-time.Minute * 20).Truncate(time.Second),
+		expires:      time.Now().Add(time.Minute * 10),
+		body:         body,
+	}
+	ts := httptest.NewServer(upstream)
+	t.Cleanup(ts.Close)
+	return upstream, &Server{
+		Geocoder: mockGeocoder(func(ctx context.Context, query string) (*locationpb.Location, error) {
+			if query != `sydney` {
+				return nil, status.Error(codes.NotFound, `openmeteo: no location found for query`)
+			}
+			return &locationpb.Location{Name: `Sydney`, Position: &latlngpb.LatLng{Latitude: -33.86882, Longitude: 151.20929}}, nil
+		}),
+		BaseURL: ts.URL,
+		Client:  ts.Client(),
+		Timeout: time.Second * 5,
+	}
+}
+
+func TestServer_GetCurrentWeather(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name       string
+		query      string
+		statusCode int
+		body       string
+		res        *metno.CurrentWeather
+		code       codes.Code
+		message    string
+	}{
+		{
+			name:  `success`,
+			query: `sydney`,
+			res:   &metno.CurrentWeather{AirTemperature: 21.3, WindSpeed: 5.6},
+		},
+		{
+			name:    `not found`,
+			query:   `atlantis`,
+			code:    codes.NotFound,
+			message: `openmeteo: no location found for query`,
+		},
+		{
+			name:       `deprecated`,
+			query:      `sydney`,
+			statusCode: http.StatusNonAuthoritativeInfo,
+			res:        &metno.CurrentWeather{AirTemperature: 21.3, WindSpeed: 5.6},
+		},
+		{
+			name:    `malformed`,
+			query:   `sydney`,
+			body:    `{"properties":{"timeseries":`,
+			code:    codes.Internal,
+			message: `metno: invalid response: unexpected EOF`,
+		},
+		{
+			name:    `empty timeseries`,
+			query:   `sydney`,
+			body:    `{"properties":{"timeseries":[]}}`,
+			code:    codes.Internal,
+			message: `metno: invalid response: missing "properties.timeseries"`,
+		},
+		{
+			name:    `missing wind speed`,
+			query:   `sydney`,
+			body:    `{"properties":{"timeseries":[{"data":{"instant":{"details":{"air_temperature":1}}}}]}}`,
+			code:    codes.Internal,
+			message: `metno: invalid response: missing "data.instant.details.wind_speed"`,
+		},
+		{
+			name:       `throttled`,
+			query:      `sydney`,
+			statusCode: http.StatusTooManyRequests,
+			code:       codes.ResourceExhausted,
+			message:    `metno: unexpected status code 429`,
+		},
+		{
+			name:       `server error`,
+			query:      `sydney`,
+			statusCode: http.StatusInternalServerError,
+			code:       codes.Unavailable,
+			message:    `metno: unexpected status code 500`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			upstream, server := newFakeUpstream(t)
+			upstream.statusCode = tc.statusCode
+			if tc.body != `` {
+				upstream.body = []byte(tc.body)
+			}
+
+			res, err := server.GetCurrentWeather(context.Background(), &metno.GetCurrentWeatherRequest{Query: tc.query})
+			if tc.res != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.GetAirTemperature() != tc.res.GetAirTemperature() || res.GetWindSpeed() != tc.res.GetWindSpeed() {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+				if !res.GetReadTime().AsTime().Equal(upstream.lastModified.Truncate(time.Second)) ||
+					!res.GetExpireTime().AsTime().Equal(upstream.expires.Truncate(time.Second)) {
+					t.Errorf(`unexpected times: %v`, res)
+				}
+				if res.GetLocation().GetName() != `Sydney` {
+					t.Errorf(`unexpected location: %v`, res.GetLocation())
+				}
+			} else {
+				if res != nil {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+				if sts := status.Convert(err); sts.Code() != tc.code || sts.Message() != tc.message {
+					t.Errorf(`unexpected error: %v`, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_GetCurrentWeather_conditional(t *testing.T) {
+	t.Parallel()
+
+	upstream, server := newFakeUpstream(t)
+	// already expired
+	upstream.expires = time.Now().Add(-time.Minute)
+
+	call := func(minReadTime time.Time) *metno.CurrentWeather {
+		res, err := server.GetCurrentWeather(context.Background(), &metno.GetCurrentWeatherRequest{Query: `sydney`, MinReadTime: timestamppb.New(minReadTime)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	requests := func() int {
+		upstream.mu.Lock()
+		defer upstream.mu.Unlock()
+		return upstream.requests
+	}
+	notModified := func() int {
+		upstream.mu.Lock()
+		defer upstream.mu.Unlock()
+		return upstream.notModified
+	}
+
+	res1 := call(time.Now().Add(-time.Hour))
+	if requests() != 1 {
+		t.Fatal(requests())
+	}
+
+	// expired and stale, so a conditional request is made, extending the expiry
+	upstream.mu.Lock()
+	upstream.expires = time.Now().Add(time.Hour)
+	upstream.mu.Unlock()
+	res2 := call(time.Now())
+	if requests() != 2 || notModified() != 1 {
+		t.Fatal(requests(), notModified())
+	}
+	if res2 == res1 ||
+		res2.GetAirTemperature() != res1.GetAirTemperature() ||
+		!res2.GetReadTime().AsTime().Equal(res1.GetReadTime().AsTime()) ||
+		!res2.GetExpireTime().AsTime().After(time.Now()) {
+		t.Errorf(`unexpected response: %v`, res2)
+	}
+
+	// not yet expired, so the min read time is ignored
+	if res3 := call(time.Now()); res3 != res2 || requests() != 2 {
+		t.Errorf(`expected cached response, got %v after %d requests`, res3, requests())
+	}
+}
+
+func TestServer_GetCurrentWeather_position(t *testing.T) {
+	t.Parallel()
+
+	upstream, server := newFakeUpstream(t)
+	// note: the geocoder isn't required
+	server.Geocoder = nil
+	// note: the fake upstream expects the (exact) position of sydney
+	server.Precision = geohash.MaxPrecision
+
+	res, err := server.GetCurrentWeather(context.Background(), &metno.GetCurrentWeatherRequest{
+		Query:    `anything`,
+		Position: &latlngpb.LatLng{Latitude: -33.86882, Longitude: 151.20929},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetAirTemperature() == 0 || upstream.requests != 1 || !strings.HasPrefix(res.GetLocation().GetId(), geohash.IDPrefix) {
+		t.Errorf(`unexpected response: %v`, res)
+	}
+}
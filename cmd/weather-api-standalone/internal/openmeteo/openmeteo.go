@@ -0,0 +1,438 @@
+package openmeteo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/joeycumines/go-bigbuff"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geohash"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/metrics"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Server implements openmeteo.OpenMeteoServer, note that the upstream API doesn't require an API key.
+	Server struct {
+		unimplementedServer
+
+		// BaseURL is the upstream forecast API, defaults to DefaultBaseURL.
+		BaseURL string
+
+		// GeocodingBaseURL is the upstream geocoding API, defaults to DefaultGeocodingBaseURL.
+		GeocodingBaseURL string
+
+		// Client is used to make upstream requests, defaults to http.DefaultClient.
+		// See also httpclient.Config, which supports configuring transport timeouts and TLS.
+		Client *http.Client
+
+		// Timeout bounds each upstream call (including geocoding), defaults to DefaultTimeout.
+		Timeout time.Duration
+
+		// Precision is the geohash precision positions are snapped to, such that nearby positions share the cache
+		// (and upstream requests), defaults to geohash.DefaultPrecision. See also geohash.Snap.
+		Precision int
+
+		// Wait is the "long poll" prior to each upstream call, merging concurrent requests (per key), defaults to
+		// DefaultWait.
+		Wait time.Duration
+
+		// RateLimit is the minimum interval between upstream calls (per key), defaults to DefaultRateLimit.
+		RateLimit time.Duration
+
+		// CacheSize bounds the number of cached responses, evicting the least recently used, unbounded if not
+		// positive.
+		CacheSize int
+
+		// CacheTTL bounds the age of cached responses, unbounded if not positive.
+		CacheTTL time.Duration
+
+		// GeocodingCacheSize bounds the number of cached geocoding results, evicting the least recently used,
+		// defaults to DefaultGeocodingCacheSize.
+		GeocodingCacheSize int
+
+		// GeocodingCacheTTL bounds the age of cached geocoding results, defaults to DefaultGeocodingCacheTTL.
+		GeocodingCacheTTL time.Duration
+
+		// Metrics records the rate limiting of upstream calls, optional. Cache and coalescing outcomes are attached
+		// to the context, see metrics.Metrics.UnaryServerInterceptor.
+		Metrics *metrics.Metrics
+
+		excl  bigbuff.Exclusive
+		cache cache.Cache[cacheKey, *cacheValue]
+		// ctx bounds all upstream calls, which may outlive the request that initiated them, see Close
+		ctx    context.Context
+		cancel context.CancelFunc
+		once   sync.Once
+		// reconfigured overrides the corresponding fields, if set, see Reconfigure
+		reconfigured atomic.Pointer[Options]
+
+		// locations caches geocoding results, which are assumed to be stable
+		// note: queries without any matches aren't cached, as the query is arbitrary (client provided) text
+		locations cache.Cache[string, *locationpb.Location]
+	}
+
+	// Options are the fields of Server that may be replaced at runtime, see Server.Reconfigure.
+	Options struct {
+		Timeout   time.Duration
+		Wait      time.Duration
+		RateLimit time.Duration
+		CacheSize int
+		CacheTTL  time.Duration
+	}
+
+	// cacheKey uses the position, if set, such that queries resolving to the same location share the cache
+	cacheKey struct {
+		query    string
+		position string
+	}
+
+	cacheValue = openmeteo.CurrentWeather
+
+	unimplementedServer = openmeteo.UnimplementedOpenMeteoServer
+)
+
+const (
+	// DefaultBaseURL is the default value for Server.BaseURL.
+	DefaultBaseURL = `https://api.open-meteo.com`
+
+	// DefaultGeocodingBaseURL is the default value for Server.GeocodingBaseURL.
+	DefaultGeocodingBaseURL = `https://geocoding-api.open-meteo.com`
+
+	// DefaultTimeout is the default value for Server.Timeout.
+	DefaultTimeout = time.Minute
+
+	// DefaultWait is the default value for Server.Wait.
+	DefaultWait = time.Millisecond * 100
+
+	// DefaultRateLimit is the default value for Server.RateLimit.
+	DefaultRateLimit = time.Millisecond * 500
+
+	// DefaultGeocodingCacheSize is the default value for Server.GeocodingCacheSize.
+	DefaultGeocodingCacheSize = 10000
+
+	// DefaultGeocodingCacheTTL is the default value for Server.GeocodingCacheTTL.
+	DefaultGeocodingCacheTTL = time.Hour * 24
+
+	provider = `openmeteo`
+)
+
+var (
+	tracer = otel.Tracer(`github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openmeteo`)
+
+	// compile time assertions
+
+	_ openmeteo.OpenMeteoServer = (*Server)(nil)
+)
+
+// Close cancels any in-flight upstream calls, and causes subsequent calls to fail. Requests already waiting on an
+// upstream call will fail, unless their own context is done first.
+func (x *Server) Close() {
+	x.context()
+	x.cancel()
+}
+
+// Reconfigure atomically replaces the options, which otherwise default to the corresponding fields of the Server.
+// Cached responses are retained, though they are subject to the new bounds, and in-flight upstream calls are
+// unaffected.
+func (x *Server) Reconfigure(options Options) {
+	x.reconfigured.Store(&options)
+}
+
+func (x *Server) GetCurrentWeather(ctx context.Context, req *openmeteo.GetCurrentWeatherRequest) (*openmeteo.CurrentWeather, error) {
+	key := newCacheKey(req, x.Precision)
+
+	// fast path
+	_, span := tracer.Start(ctx, `openmeteo.cache`)
+	res, ok := x.cache.Get(key, x.options().CacheTTL)
+	fresh := ok && (req.GetMinReadTime() == nil ||
+		(res.GetReadTime() != nil && !res.GetReadTime().AsTime().Before(req.GetMinReadTime().AsTime())))
+	metrics.ObserveCache(ctx, ok, fresh)
+	span.SetAttributes(tracing.CacheOutcome(ok, fresh))
+	span.End()
+	if fresh {
+		return res, nil
+	}
+
+	// summary:
+	// - locked on the key
+	// - "long poll" (default 100ms) prior to starting
+	// - merge multiple concurrent calls (per key)
+	// - rate limit (per key, default 500ms)
+	//
+	// See also the openweather and weatherstack implementations, which this mirrors.
+	// note: the span includes the wait, and any upstream call, which may be shared with other requests
+	ctx, span = tracer.Start(ctx, `openmeteo.exclusive`)
+	defer span.End()
+	// note: called is only set if this call's work function was the one called, i.e. it wasn't coalesced
+	var called bool
+	callCtx := x.context()
+	ch := x.excl.CallWithOptions(
+		bigbuff.ExclusiveKey(key),
+		bigbuff.ExclusiveWait(x.wait()),
+		bigbuff.ExclusiveRateLimit(callCtx, x.rateLimit()),
+		x.Metrics.ExclusiveRateLimit(openmeteo.OpenMeteo_ServiceDesc.ServiceName),
+		bigbuff.ExclusiveValue(func() (any, error) {
+			called = true
+			slog.DebugContext(ctx, `openmeteo request`, `request`, logging.Proto(req))
+			// note: the upstream call is traced as part of the request that initiated it, but isn't bound by it
+			upstreamCtx, upstreamSpan := tracer.Start(trace.ContextWithSpan(callCtx, span), `openmeteo.upstream`)
+			defer upstreamSpan.End()
+			res, err := x.getCurrentWeather(upstreamCtx, req)
+			tracing.SetError(upstreamSpan, err)
+			if err == nil {
+				x.cache.Put(key, res, x.options().CacheSize)
+			}
+			if err != nil {
+				slog.WarnContext(ctx, `openmeteo request failed`, `error`, err)
+			} else {
+				slog.DebugContext(ctx, `openmeteo response`, `response`, logging.Proto(res))
+			}
+			return res, err
+		}),
+	)
+
+	select {
+	case <-ctx.Done():
+		tracing.SetError(span, ctx.Err())
+		return nil, status.FromContextError(ctx.Err()).Err()
+
+	case v := <-ch:
+		if !called {
+			metrics.ObserveCoalesced(ctx)
+		}
+		span.SetAttributes(tracing.AttrCoalesced.Bool(!called))
+		tracing.SetError(span, v.Error)
+		if v.Error != nil {
+			return nil, v.Error
+		}
+		return v.Result.(*openmeteo.CurrentWeather), nil
+	}
+}
+
+func (x *Server) getCurrentWeather(ctx context.Context, request *openmeteo.GetCurrentWeatherRequest) (*openmeteo.CurrentWeather, error) {
+	ctx, cancel := context.WithTimeout(ctx, x.timeout())
+	defer cancel()
+
+	// note: the position (if resolved) takes precedence over the query, and is snapped to the geohash cell
+	var location *locationpb.Location
+	if position := request.GetPosition(); position != nil {
+		location = geohash.Snap(position, x.Precision)
+	} else {
+		var err error
+		if location, err = x.getLocation(ctx, request.GetQuery()); err != nil {
+			return nil, err
+		}
+	}
+
+	var body struct {
+		CurrentWeather struct {
+			Temperature *float64 `json:"temperature"`
+			WindSpeed   *float64 `json:"windspeed"`
+		} `json:"current_weather"`
+	}
+	readTime := time.Now()
+	if err := x.getJSON(ctx, fmt.Sprintf(
+		`%s/v1/forecast?current_weather=true&temperature_unit=celsius&windspeed_unit=kmh&latitude=%s&longitude=%s`,
+		x.baseURL(),
+		formatCoordinate(location.GetPosition().GetLatitude()),
+		formatCoordinate(location.GetPosition().GetLongitude()),
+	), &body); err != nil {
+		return nil, err
+	}
+	if body.CurrentWeather.Temperature == nil {
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "current_weather.temperature"`))
+	}
+	if body.CurrentWeather.WindSpeed == nil {
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "current_weather.windspeed"`))
+	}
+
+	return &openmeteo.CurrentWeather{
+		ReadTime:    timestamppb.New(readTime),
+		Location:    location,
+		Temperature: *body.CurrentWeather.Temperature,
+		WindSpeed:   *body.CurrentWeather.WindSpeed,
+	}, nil
+}
+
+// Geocode resolves the query to the most relevant location, using (and caching the results of) the geocoding API.
+// It may be used to resolve locations for other providers.
+func (x *Server) Geocode(ctx context.Context, query string) (*locationpb.Location, error) {
+	ctx, cancel := context.WithTimeout(ctx, x.timeout())
+	defer cancel()
+	return x.getLocation(ctx, query)
+}
+
+// getLocation resolves the query to the most relevant location, using the geocoding API
+func (x *Server) getLocation(ctx context.Context, query string) (*locationpb.Location, error) {
+	if location, ok := x.locations.Get(query, x.geocodingCacheTTL()); ok {
+		return location, nil
+	}
+
+	var body struct {
+		Results []struct {
+			Name      string   `json:"name"`
+			Latitude  *float64 `json:"latitude"`
+			Longitude *float64 `json:"longitude"`
+		} `json:"results"`
+	}
+	if err := x.getJSON(ctx, fmt.Sprintf(
+		`%s/v1/search?count=1&format=json&name=%s`,
+		x.geocodingBaseURL(),
+		url.QueryEscape(query),
+	), &body); err != nil {
+		return nil, err
+	}
+	// note: the results field is omitted if there were no matches
+	if len(body.Results) == 0 {
+		return nil, status.Errorf(codes.NotFound, `openmeteo: no location found for query`)
+	}
+	if body.Results[0].Latitude == nil || body.Results[0].Longitude == nil {
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "results[0].latitude" or "results[0].longitude"`))
+	}
+
+	location := &locationpb.Location{
+		Name: body.Results[0].Name,
+		Position: &latlngpb.LatLng{
+			Latitude:  *body.Results[0].Latitude,
+			Longitude: *body.Results[0].Longitude,
+		},
+	}
+
+	x.locations.Put(query, location, x.geocodingCacheSize())
+
+	return location, nil
+}
+
+func (x *Server) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := x.client().Do(req)
+	if err != nil {
+		return httpclient.TransportError(provider, err)
+	}
+	defer res.Body.Close()
+	defer io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return httpclient.StatusError(provider, res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return httpclient.DecodeError(provider, err)
+	}
+
+	return nil
+}
+
+func (x *Server) baseURL() string {
+	if x.BaseURL != `` {
+		return strings.TrimSuffix(x.BaseURL, `/`)
+	}
+	return DefaultBaseURL
+}
+
+func (x *Server) geocodingBaseURL() string {
+	if x.GeocodingBaseURL != `` {
+		return strings.TrimSuffix(x.GeocodingBaseURL, `/`)
+	}
+	return DefaultGeocodingBaseURL
+}
+
+func (x *Server) client() *http.Client {
+	if x.Client != nil {
+		return x.Client
+	}
+	return http.DefaultClient
+}
+
+func (x *Server) timeout() time.Duration {
+	if v := x.options().Timeout; v > 0 {
+		return v
+	}
+	return DefaultTimeout
+}
+
+func (x *Server) geocodingCacheSize() int {
+	if x.GeocodingCacheSize > 0 {
+		return x.GeocodingCacheSize
+	}
+	return DefaultGeocodingCacheSize
+}
+
+func (x *Server) geocodingCacheTTL() time.Duration {
+	if x.GeocodingCacheTTL > 0 {
+		return x.GeocodingCacheTTL
+	}
+	return DefaultGeocodingCacheTTL
+}
+
+func (x *Server) context() context.Context {
+	x.once.Do(func() { x.ctx, x.cancel = context.WithCancel(context.Background()) })
+	return x.ctx
+}
+
+// options returns the current options, see Reconfigure
+func (x *Server) options() *Options {
+	if v := x.reconfigured.Load(); v != nil {
+		return v
+	}
+	return &Options{
+		Timeout:   x.Timeout,
+		Wait:      x.Wait,
+		RateLimit: x.RateLimit,
+		CacheSize: x.CacheSize,
+		CacheTTL:  x.CacheTTL,
+	}
+}
+
+func (x *Server) wait() time.Duration {
+	if v := x.options().Wait; v > 0 {
+		return v
+	}
+	return DefaultWait
+}
+
+func (x *Server) rateLimit() time.Duration {
+	if v := x.options().RateLimit; v > 0 {
+		return v
+	}
+	return DefaultRateLimit
+}
+
+func formatCoordinate(v float64) string {
+	return fmt.Sprintf(`%.4f`, v)
+}
+
+func newCacheKey(req *openmeteo.GetCurrentWeatherRequest, precision int) cacheKey {
+	if position := req.GetPosition(); position != nil {
+		return cacheKey{
+			position: geohash.Encode(position.GetLatitude(), position.GetLongitude(), precision),
+		}
+	}
+	return cacheKey{
+		query: req.GetQuery(),
+	}
+}
@@ -0,0 +1,216 @@
+package openmeteo
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/internal/fakeprovider"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFakeServer(t *testing.T, fake *fakeprovider.Server) (*httptest.Server, *atomic.Int32) {
+	var searches atomic.Int32
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == `/v1/search` {
+				searches.Add(1)
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Route(`/`, fake.Register)
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+	return ts, &searches
+}
+
+func TestServer_GetCurrentWeather(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name    string
+		query   string
+		steps   []fakeprovider.Step
+		res     *openmeteo.CurrentWeather
+		code    codes.Code
+		message string
+	}{
+		{
+			name:  `success`,
+			query: `Sydney`,
+			steps: []fakeprovider.Step{{Reading: &fakeprovider.Reading{TemperatureDegrees: 21.46, WindSpeed: 11.04}}},
+			res:   &openmeteo.CurrentWeather{Temperature: 21.5, WindSpeed: 11},
+		},
+		{
+			name:    `not found`,
+			query:   `atlantis`,
+			code:    codes.NotFound,
+			message: `openmeteo: no location found for query`,
+		},
+		{
+			name:    `malformed`,
+			query:   `sydney`,
+			steps:   []fakeprovider.Step{{Fault: fakeprovider.FaultMalformed}},
+			code:    codes.Internal,
+			message: `openmeteo: invalid response: unexpected EOF`,
+		},
+		{
+			name:    `server error`,
+			query:   `sydney`,
+			steps:   []fakeprovider.Step{{Fault: fakeprovider.FaultError}},
+			code:    codes.Unavailable,
+			message: `openmeteo: unexpected status code 500`,
+		},
+		{
+			name:    `rate limited`,
+			query:   `sydney`,
+			steps:   []fakeprovider.Step{{Fault: fakeprovider.FaultRateLimit}},
+			code:    codes.ResourceExhausted,
+			message: `openmeteo: unexpected status code 429`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fake := fakeprovider.Server{Readings: func(provider fakeprovider.Provider, query string) (fakeprovider.Reading, bool) {
+				if query == `atlantis` {
+					return fakeprovider.Reading{}, false
+				}
+				return fakeprovider.DeterministicReading(provider, query)
+			}}
+			fake.Script(fakeprovider.OpenMeteo, tc.query, tc.steps...)
+			ts, _ := newFakeServer(t, &fake)
+
+			server := Server{
+				BaseURL:          ts.URL,
+				GeocodingBaseURL: ts.URL,
+				Client:           ts.Client(),
+				Timeout:          time.Second * 5,
+			}
+
+			res, err := server.GetCurrentWeather(context.Background(), &openmeteo.GetCurrentWeatherRequest{Query: tc.query})
+			if tc.res != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.GetTemperature() != tc.res.GetTemperature() || res.GetWindSpeed() != tc.res.GetWindSpeed() || res.GetReadTime() == nil {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+				lat, lng := fakeprovider.DeterministicPosition(tc.query)
+				if res.GetLocation().GetName() != tc.query ||
+					res.GetLocation().GetPosition().GetLatitude() != lat ||
+					res.GetLocation().GetPosition().GetLongitude() != lng {
+					t.Errorf(`unexpected location: %v`, res.GetLocation())
+				}
+			} else {
+				if res != nil {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+				if sts := status.Convert(err); sts.Code() != tc.code || sts.Message() != tc.message {
+					t.Errorf(`unexpected error: %v`, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_GetCurrentWeather_cached(t *testing.T) {
+	t.Parallel()
+
+	var fake fakeprovider.Server
+	ts, searches := newFakeServer(t, &fake)
+
+	server := Server{
+		BaseURL:          ts.URL,
+		GeocodingBaseURL: ts.URL,
+		Client:           ts.Client(),
+	}
+
+	res1, err := server.GetCurrentWeather(context.Background(), &openmeteo.GetCurrentWeatherRequest{Query: `sydney`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := server.GetCurrentWeather(context.Background(), &openmeteo.GetCurrentWeatherRequest{Query: `sydney`, MinReadTime: res1.GetReadTime()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res1 != res2 {
+		t.Error(`expected cached response`)
+	}
+
+	// geocoding results are cached independently
+	res3, err := server.GetCurrentWeather(context.Background(), &openmeteo.GetCurrentWeatherRequest{Query: `sydney`, MinReadTime: timestamppb.New(res1.GetReadTime().AsTime().Add(time.Nanosecond))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res3 == res1 || searches.Load() != 1 {
+		t.Errorf(`unexpected searches: %d`, searches.Load())
+	}
+}
+
+func TestServer_Geocode_cacheSize(t *testing.T) {
+	t.Parallel()
+
+	var fake fakeprovider.Server
+	ts, searches := newFakeServer(t, &fake)
+
+	server := Server{
+		GeocodingBaseURL:   ts.URL,
+		Client:             ts.Client(),
+		GeocodingCacheSize: 1,
+	}
+
+	for _, query := range [...]string{`sydney`, `sydney`, `perth`, `sydney`} {
+		if _, err := server.Geocode(context.Background(), query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := searches.Load(); v != 3 {
+		t.Errorf(`unexpected searches: %d`, v)
+	}
+	if v := server.locations.Len(); v != 1 {
+		t.Errorf(`unexpected locations: %d`, v)
+	}
+}
+
+func TestServer_GetCurrentWeather_position(t *testing.T) {
+	t.Parallel()
+
+	var fake fakeprovider.Server
+	ts, searches := newFakeServer(t, &fake)
+
+	server := Server{
+		BaseURL:          ts.URL,
+		GeocodingBaseURL: ts.URL,
+		Client:           ts.Client(),
+	}
+
+	for _, position := range [...]*latlngpb.LatLng{
+		{Latitude: -33.86882, Longitude: 151.20929},
+		{Latitude: -33.8701, Longitude: 151.2070},
+	} {
+		res, err := server.GetCurrentWeather(context.Background(), &openmeteo.GetCurrentWeatherRequest{Query: `sydney`, Position: position})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// note: the location is the geohash cell
+		if res.GetLocation().GetId() != `geohash:r3gx2` ||
+			res.GetLocation().GetPosition().GetLatitude() != -33.85986328125 ||
+			res.GetLocation().GetPosition().GetLongitude() != 151.19384765625 {
+			t.Errorf(`unexpected response: %v`, res)
+		}
+	}
+	// note: the geocoding api isn't used
+	if v := searches.Load(); v != 0 {
+		t.Errorf(`unexpected searches: %d`, v)
+	}
+}
@@ -0,0 +1,560 @@
+package openweather
+
+import (
+	"context"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recorder records every span, as the global tracer provider, which may only be set once (per test binary)
+var recorder = func() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}()
+
+func TestServer_GetWeather(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name    string
+		keys    []apikey.Key
+		handler func(t *testing.T, w http.ResponseWriter, r *http.Request)
+		res     *openweather.Weather
+		code    codes.Code
+		message string
+		// requests is the expected sequence of appid values
+		requests []string
+	}{
+		{
+			name: `success`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != `/data/2.5/weather` {
+					t.Errorf(`unexpected path: %s`, r.URL.Path)
+				}
+				if v := r.URL.Query().Get(`units`); v != `metric` {
+					t.Errorf(`unexpected units: %s`, v)
+				}
+				if v := r.URL.Query().Get(`q`); v != `sydney, au` {
+					t.Errorf(`unexpected q: %s`, v)
+				}
+				_, _ = w.Write([]byte(`{"coord":{"lon":151.2073,"lat":-33.8679},"main":{"temp":21.5,"humidity":60},"wind":{"speed":4.1,"deg":90},"name":"Sydney"}`))
+			},
+			res:      &openweather.Weather{Temp: 21.5, WindSpeed: 4.1},
+			requests: []string{`key1`},
+		},
+		{
+			name: `zero values`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"main":{"temp":0},"wind":{"speed":0}}`))
+			},
+			res:      &openweather.Weather{},
+			requests: []string{`key1`},
+		},
+		{
+			name: `missing temp`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"main":{},"wind":{"speed":4.1}}`))
+			},
+			code:     codes.Internal,
+			message:  `openweather: invalid response: missing "main.temp"`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `missing wind speed`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"main":{"temp":21.5}}`))
+			},
+			code:     codes.Internal,
+			message:  `openweather: invalid response: missing "wind.speed"`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `malformed json`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"main":`))
+			},
+			code:     codes.Internal,
+			message:  `openweather: invalid response: unexpected EOF`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `not found`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"cod":"404","message":"city not found"}`))
+			},
+			code:     codes.NotFound,
+			message:  `openweather: unexpected status code 404`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `server error`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			code:     codes.Unavailable,
+			message:  `openweather: unexpected status code 502`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `key rejected failover`,
+			keys: []apikey.Key{{Value: `key1`}, {Value: `key2`}},
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get(`appid`) == `key1` {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{"main":{"temp":1},"wind":{"speed":2}}`))
+			},
+			res:      &openweather.Weather{Temp: 1, WindSpeed: 2},
+			requests: []string{`key1`, `key2`},
+		},
+		{
+			name: `all keys rejected`,
+			keys: []apikey.Key{{Value: `key1`}, {Value: `key2`}},
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			code:     codes.Unavailable,
+			message:  `openweather: api key rejected`,
+			requests: []string{`key1`, `key2`},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu       sync.Mutex
+				requests []string
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.URL.Query().Get(`appid`))
+				mu.Unlock()
+				tc.handler(t, w, r)
+			}))
+			defer ts.Close()
+
+			keys := tc.keys
+			if keys == nil {
+				keys = []apikey.Key{{Value: `key1`}}
+			}
+			server := Server{
+				Keys:    apikey.NewPool(keys...),
+				BaseURL: ts.URL + `/`,
+				Client:  ts.Client(),
+				Timeout: time.Second * 5,
+			}
+
+			start := time.Now()
+			res, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `sydney, au`})
+
+			if tc.res != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if readTime := res.GetReadTime().AsTime(); readTime.Before(start) || readTime.After(time.Now()) {
+					t.Errorf(`unexpected read time: %s`, readTime)
+				}
+				if res.GetTemp() != tc.res.GetTemp() || res.GetWindSpeed() != tc.res.GetWindSpeed() {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+			} else {
+				if res != nil {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+				if sts := status.Convert(err); sts.Code() != tc.code || sts.Message() != tc.message {
+					t.Errorf(`unexpected error: %v`, err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(requests) != len(tc.requests) {
+				t.Fatalf(`unexpected requests: %q`, requests)
+			}
+			for i := range requests {
+				if requests[i] != tc.requests[i] {
+					t.Errorf(`unexpected requests: %q`, requests)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_GetWeather_cached(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"main":{"temp":21.5},"wind":{"speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL: ts.URL,
+		Client:  ts.Client(),
+	}
+
+	res1, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `sydney`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `sydney`, MinReadTime: res1.GetReadTime()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res1 != res2 || calls.Load() != 1 {
+		t.Errorf(`expected cached response: %d`, calls.Load())
+	}
+}
+
+func TestServer_GetWeather_position(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// note: the position is snapped to the centroid of the cell
+		if params := r.URL.Query(); params.Has(`q`) || params.Get(`lat`) != `-33.8599` || params.Get(`lon`) != `151.1938` {
+			t.Errorf(`unexpected query: %s`, r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(`{"main":{"temp":21.5},"wind":{"speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL: ts.URL,
+		Client:  ts.Client(),
+	}
+
+	// note: the cache is keyed by the geohash of the position, if set
+	for _, req := range [...]*openweather.GetWeatherRequest{
+		{Query: `sydney`, Position: &latlngpb.LatLng{Latitude: -33.86882, Longitude: 151.20929}},
+		{Query: `Sydney, AU`, Position: &latlngpb.LatLng{Latitude: -33.8701, Longitude: 151.2070}},
+	} {
+		res, err := server.GetWeather(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GetTemp() != 21.5 ||
+			res.GetLocation().GetId() != `geohash:r3gx2` ||
+			res.GetLocation().GetPosition().GetLatitude() != -33.85986328125 ||
+			res.GetLocation().GetPosition().GetLongitude() != 151.19384765625 {
+			t.Errorf(`unexpected response: %v`, res)
+		}
+	}
+	if v := calls.Load(); v != 1 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+}
+
+// TestServer_GetWeather_fixtures replays recorded responses, see httpclient.Recorder.
+func TestServer_GetWeather_fixtures(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		query   string
+		res     *openweather.Weather
+		code    codes.Code
+		message string
+	}{
+		{query: `sydney`, res: &openweather.Weather{Temp: 22.61, WindSpeed: 6.17}},
+		{query: `reykjavik`, res: &openweather.Weather{Temp: -3, WindSpeed: 0}},
+		{query: `calm`, code: codes.Internal, message: `openweather: invalid response: missing "wind.speed"`},
+		{query: `atlantis`, code: codes.NotFound, message: `openweather: unexpected status code 404`},
+	} {
+		tc := tc
+		t.Run(tc.query, func(t *testing.T) {
+			t.Parallel()
+			server := Server{
+				Keys:   apikey.NewPool(apikey.Key{Value: `key1`}),
+				Client: &http.Client{Transport: &httpclient.Replayer{Dir: `testdata/fixtures`}},
+			}
+			res, err := server.getWeather(context.Background(), &openweather.GetWeatherRequest{Query: tc.query})
+			if tc.res != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.GetTemp() != tc.res.GetTemp() || res.GetWindSpeed() != tc.res.GetWindSpeed() {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+			} else if sts := status.Convert(err); sts.Code() != tc.code || sts.Message() != tc.message {
+				t.Errorf(`unexpected error: %v`, err)
+			}
+		})
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL: ts.URL,
+		Client:  ts.Client(),
+		Wait:    time.Millisecond,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `sydney`})
+		errCh <- err
+	}()
+	<-started
+
+	// in-flight calls are canceled, even if the caller's context isn't
+	server.Close()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error(`expected an error`)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal(`timed out`)
+	}
+
+	if _, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `brisbane`}); err == nil {
+		t.Error(`expected an error`)
+	}
+}
+
+func TestServer_CacheAdmin(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"main":{"temp":21.5},"wind":{"speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:      apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL:   ts.URL,
+		Client:    ts.Client(),
+		Wait:      time.Millisecond,
+		RateLimit: time.Millisecond,
+	}
+	for _, req := range [...]*openweather.GetWeatherRequest{
+		{Query: `sydney`},
+		{Query: `sydney`, Position: &latlngpb.LatLng{Latitude: -33.86882, Longitude: 151.20929}},
+	} {
+		if _, err := server.GetWeather(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := server.CacheAdmin()
+	var keys []string
+	for _, e := range admin.Entries(``) {
+		keys = append(keys, e.Key)
+	}
+	if v := strings.Join(keys, `,`); v != `position:r3gx2,query:sydney` {
+		t.Errorf(`unexpected keys: %s`, v)
+	}
+
+	prev, _ := admin.Entry(`position:r3gx2`)
+	res, err := admin.Refresh(context.Background(), `position:r3gx2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := res.Value.(*openweather.Weather); calls.Load() != 3 || v == prev.Value ||
+		v.GetLocation().GetId() != `geohash:r3gx2` {
+		t.Errorf(`unexpected entry: %+v %d`, res, calls.Load())
+	}
+
+	for _, key := range [...]string{`position:r3gx`, `position:r3gx2z`, `position:R3GX2`, `query:`, `sydney`} {
+		if _, err := admin.Refresh(context.Background(), key); status.Code(err) != codes.InvalidArgument {
+			t.Errorf(`%s: unexpected error: %v`, key, err)
+		}
+	}
+	if v := calls.Load(); v != 3 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+}
+
+func TestServer_Reconfigure(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"main":{"temp":21.5},"wind":{"speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:     apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL:  ts.URL,
+		Client:   ts.Client(),
+		Wait:     time.Millisecond,
+		CacheTTL: time.Hour,
+	}
+	request := func(expected int32) {
+		t.Helper()
+		if _, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `sydney`}); err != nil {
+			t.Fatal(err)
+		}
+		if v := atomic.LoadInt32(&calls); v != expected {
+			t.Errorf(`unexpected calls: %d`, v)
+		}
+	}
+
+	request(1)
+
+	// the cache is retained
+	server.Reconfigure(Options{Wait: time.Millisecond, CacheTTL: time.Minute})
+	request(1)
+
+	// but is subject to the new bounds
+	server.Reconfigure(Options{Wait: time.Millisecond, CacheTTL: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	request(2)
+}
+
+func TestServer_GetWeather_tracing(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"main":{"temp":21.5},"wind":{"speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL: ts.URL,
+		Client:  &http.Client{Transport: &httpclient.Tracer{Transport: ts.Client().Transport}},
+		Wait:    time.Millisecond * 50,
+	}
+	// note: the first two requests are coalesced, and the third is cached
+	parents := make([]trace.Span, 3)
+	var wg sync.WaitGroup
+	for i := range parents {
+		if i == 2 {
+			wg.Wait()
+		}
+		var ctx context.Context
+		ctx, parents[i] = otel.Tracer(`test`).Start(context.Background(), `request`)
+		wg.Add(1)
+		go func(span trace.Span) {
+			defer wg.Done()
+			defer span.End()
+			if _, err := server.GetWeather(ctx, &openweather.GetWeatherRequest{Query: `sydney`}); err != nil {
+				t.Error(err)
+			}
+		}(parents[i])
+	}
+	wg.Wait()
+
+	children := func(parent trace.SpanContext) (names []string, spans []sdktrace.ReadOnlySpan) {
+		for _, span := range recorder.Ended() {
+			if span.Parent().SpanID() == parent.SpanID() {
+				names = append(names, span.Name())
+				spans = append(spans, span)
+			}
+		}
+		return
+	}
+	attr := func(span sdktrace.ReadOnlySpan, key attribute.Key) any {
+		for _, kv := range span.Attributes() {
+			if kv.Key == key {
+				return kv.Value.AsInterface()
+			}
+		}
+		return nil
+	}
+
+	var upstream int
+	for i, parent := range parents {
+		names, spans := children(parent.SpanContext())
+		if i == 2 {
+			if len(spans) != 1 || names[0] != `openweather.cache` || attr(spans[0], tracing.AttrCache) != `hit` {
+				t.Errorf(`unexpected spans: %q`, names)
+			}
+			continue
+		}
+		if len(spans) != 2 || names[0] != `openweather.cache` || names[1] != `openweather.exclusive` ||
+			attr(spans[0], tracing.AttrCache) != `miss` {
+			t.Fatalf(`unexpected spans: %q`, names)
+		}
+		coalesced := attr(spans[1], tracing.AttrCoalesced)
+		names, spans = children(spans[1].SpanContext())
+		if coalesced == false {
+			upstream++
+			if len(spans) != 1 || names[0] != `openweather.upstream` {
+				t.Fatalf(`unexpected spans: %q`, names)
+			}
+			if names, _ = children(spans[0].SpanContext()); len(names) != 1 || names[0] != `HTTP GET` {
+				t.Errorf(`unexpected spans: %q`, names)
+			}
+		} else if coalesced != true || len(spans) != 0 {
+			t.Errorf(`unexpected spans: %v %q`, coalesced, names)
+		}
+	}
+	if upstream != 1 {
+		t.Errorf(`unexpected upstream calls: %d`, upstream)
+	}
+}
+
+// note: not parallel, as it replaces the default logger
+func TestServer_GetWeather_redacted(t *testing.T) {
+	const key = `secret-api-key`
+
+	var b strings.Builder
+	logger, err := logging.Config{Level: slog.LevelDebug, Format: logging.FormatJSON, Writer: &b}.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	// note: an invalid base url, and an unreachable one, fail with errors that would otherwise include the url
+	for _, baseURL := range [...]string{"http://example.com/\x7f", ts.URL} {
+		server := Server{
+			Keys:    apikey.NewPool(apikey.Key{Value: key}),
+			BaseURL: baseURL,
+			Client:  ts.Client(),
+		}
+		_, err := server.GetWeather(context.Background(), &openweather.GetWeatherRequest{Query: `sydney`})
+		if err == nil || strings.Contains(err.Error(), key) {
+			t.Errorf(`unexpected error: %v`, err)
+		}
+	}
+
+	if s := b.String(); strings.Contains(s, key) || strings.Count(s, `"msg":"openweather request failed"`) != 2 {
+		t.Errorf("unexpected logs:\n%s", s)
+	}
+}
@@ -0,0 +1,150 @@
+// Package rpc provides the gRPC transport between the public api, and provider backends served standalone, i.e. as
+// separate processes, rather than in-process.
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"os"
+	"time"
+)
+
+type (
+	// Config models the transport security, shared by servers and clients, see Config.NewServer and Config.Dial.
+	Config struct {
+		// Insecure disables TLS, e.g. within a service mesh, which provides its own.
+		Insecure bool
+
+		// CertFile and KeyFile are a PEM certificate and private key, required by servers (unless Insecure), and
+		// optionally presented by clients, to servers that require them (mTLS).
+		CertFile string
+		KeyFile  string
+
+		// CAFile is an optional PEM file, replacing the system root CAs used to verify servers. If set for a server,
+		// clients are required to present a certificate, verified using it (mTLS).
+		CAFile string
+
+		// ServerName optionally overrides the name clients verify the server certificate against, which otherwise
+		// defaults to the host of the target.
+		ServerName string
+	}
+)
+
+// NewServer builds a new server, including the standard health service, which reports each of the given services
+// as serving. The health service should be shut down (see GracefulStop) prior to stopping the server, such that
+// clients stop sending requests to it.
+func (x Config) NewServer(services ...string) (*grpc.Server, *health.Server, error) {
+	creds, err := x.serverCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+	server := grpc.NewServer(grpc.Creds(creds))
+	healthServer := health.NewServer()
+	for _, service := range services {
+		healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+	healthpb.RegisterHealthServer(server, healthServer)
+	return server, healthServer, nil
+}
+
+// Dial connects (lazily) to the target, e.g. `dns:///openweather:9090`, load balancing across every address it
+// resolves to, i.e. each replica, skipping any that aren't serving the given service, per the health service.
+func (x Config) Dial(target, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	creds, err := x.clientCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(target, append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(
+			`{"loadBalancingConfig":[{"round_robin":{}}],"healthCheckConfig":{"serviceName":%q}}`,
+			service,
+		)),
+	}, opts...)...)
+}
+
+// GracefulStop reports the server as not serving, via the health service, then waits (up to timeout) for in-flight
+// requests, before forcibly stopping it. Returns false if the timeout was exceeded.
+func GracefulStop(server *grpc.Server, healthServer *health.Server, timeout time.Duration) bool {
+	healthServer.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.GracefulStop()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		server.Stop()
+		<-done
+		return false
+	}
+}
+
+func (x Config) serverCredentials() (credentials.TransportCredentials, error) {
+	if x.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	if x.CertFile == `` || x.KeyFile == `` {
+		return nil, errors.New(`rpc: a certificate and key are required, unless insecure`)
+	}
+	cert, err := tls.LoadX509KeyPair(x.CertFile, x.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf(`rpc: %w`, err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if x.CAFile != `` {
+		if config.ClientCAs, err = loadCertPool(x.CAFile); err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(config), nil
+}
+
+func (x Config) clientCredentials() (credentials.TransportCredentials, error) {
+	if x.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: x.ServerName,
+	}
+	if x.CertFile != `` || x.KeyFile != `` {
+		cert, err := tls.LoadX509KeyPair(x.CertFile, x.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf(`rpc: %w`, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if x.CAFile != `` {
+		var err error
+		if config.RootCAs, err = loadCertPool(x.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+func loadCertPool(name string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf(`rpc: %w`, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf(`rpc: no certificates found in %s`, name)
+	}
+	return pool, nil
+}
@@ -0,0 +1,242 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type (
+	replicaServer struct {
+		openweather.UnimplementedOpenweatherServer
+		temp float64
+	}
+
+	// testPKI is a CA, and certificates it issued, written to PEM files
+	testPKI struct {
+		dir    string
+		ca     *x509.Certificate
+		caKey  *ecdsa.PrivateKey
+		caFile string
+	}
+)
+
+func (x *replicaServer) GetWeather(ctx context.Context, req *openweather.GetWeatherRequest) (*openweather.Weather, error) {
+	return &openweather.Weather{Temp: x.temp}, nil
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	x := &testPKI{dir: t.TempDir()}
+	var err error
+	if x.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: `test ca`},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &x.caKey.PublicKey, x.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	x.caFile = x.write(t, `ca.pem`, `CERTIFICATE`, der)
+	return x
+}
+
+// issue returns the cert and key files, for a certificate valid for localhost
+func (x *testPKI) issue(t *testing.T, name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{`localhost`},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, x.ca, &key.PublicKey, x.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x.write(t, name+`.pem`, `CERTIFICATE`, der), x.write(t, name+`-key.pem`, `EC PRIVATE KEY`, keyDER)
+}
+
+func (x *testPKI) write(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(x.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// startReplica serves an openweather replica, returning the address, and a function stopping it
+func startReplica(t *testing.T, config Config, temp float64) (string, func(timeout time.Duration) bool) {
+	server, healthServer, err := config.NewServer(openweather.Openweather_ServiceDesc.ServiceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openweather.RegisterOpenweatherServer(server, &replicaServer{temp: temp})
+	lis, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String(), func(timeout time.Duration) bool { return GracefulStop(server, healthServer, timeout) }
+}
+
+func TestConfig_Dial_loadBalancing(t *testing.T) {
+	t.Parallel()
+
+	pki := newTestPKI(t)
+	certFile, keyFile := pki.issue(t, `server`)
+	serverConfig := Config{CertFile: certFile, KeyFile: keyFile}
+	addr1, stop1 := startReplica(t, serverConfig, 1)
+	addr2, _ := startReplica(t, serverConfig, 2)
+
+	r := manual.NewBuilderWithScheme(`test`)
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: addr1}, {Addr: addr2}}})
+	conn, err := Config{CAFile: pki.caFile, ServerName: `localhost`}.Dial(
+		r.Scheme()+`:///openweather`,
+		openweather.Openweather_ServiceDesc.ServiceName,
+		grpc.WithResolvers(r),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := openweather.NewOpenweatherClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	call := func() float64 {
+		res, err := client.GetWeather(ctx, &openweather.GetWeatherRequest{Query: `sydney`}, grpc.WaitForReady(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.GetTemp()
+	}
+
+	// requests are spread across both replicas (once both are connected)
+	for seen := make(map[float64]bool); len(seen) != 2; {
+		seen[call()] = true
+	}
+
+	// a replica that is shutting down is skipped
+	if !stop1(time.Second * 5) {
+		t.Error(`expected a graceful stop`)
+	}
+	for i := 0; i < 10; i++ {
+		if v := call(); v != 2 {
+			t.Errorf(`unexpected replica: %v`, v)
+		}
+	}
+}
+
+func TestConfig_Dial_tls(t *testing.T) {
+	t.Parallel()
+
+	pki := newTestPKI(t)
+	serverCert, serverKey := pki.issue(t, `server`)
+	clientCert, clientKey := pki.issue(t, `client`)
+	untrusted := newTestPKI(t)
+
+	for _, tc := range [...]struct {
+		name   string
+		server Config
+		client Config
+		ok     bool
+	}{
+		{
+			name:   `insecure`,
+			server: Config{Insecure: true},
+			client: Config{Insecure: true},
+			ok:     true,
+		},
+		{
+			name:   `tls`,
+			server: Config{CertFile: serverCert, KeyFile: serverKey},
+			client: Config{CAFile: pki.caFile},
+			ok:     true,
+		},
+		{
+			name:   `tls untrusted`,
+			server: Config{CertFile: serverCert, KeyFile: serverKey},
+			client: Config{CAFile: untrusted.caFile},
+		},
+		{
+			name:   `tls insecure client`,
+			server: Config{CertFile: serverCert, KeyFile: serverKey},
+			client: Config{Insecure: true},
+		},
+		{
+			name:   `mtls`,
+			server: Config{CertFile: serverCert, KeyFile: serverKey, CAFile: pki.caFile},
+			client: Config{CertFile: clientCert, KeyFile: clientKey, CAFile: pki.caFile},
+			ok:     true,
+		},
+		{
+			name:   `mtls no client cert`,
+			server: Config{CertFile: serverCert, KeyFile: serverKey, CAFile: pki.caFile},
+			client: Config{CAFile: pki.caFile},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			addr, _ := startReplica(t, tc.server, 1)
+			conn, err := tc.client.Dial(addr, openweather.Openweather_ServiceDesc.ServiceName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: openweather.Openweather_ServiceDesc.ServiceName})
+			if tc.ok != (err == nil) {
+				t.Errorf(`unexpected error: %v`, err)
+			}
+			if tc.ok && res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf(`unexpected status: %v`, res.GetStatus())
+			}
+		})
+	}
+}
+
+func TestConfig_NewServer_requiresCert(t *testing.T) {
+	t.Parallel()
+	if _, _, err := (Config{}).NewServer(); err == nil || err.Error() != `rpc: a certificate and key are required, unless insecure` {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
@@ -3,16 +3,31 @@ package weatherstack
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/joeycumines/go-bigbuff"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geohash"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/metrics"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
 	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,15 +35,65 @@ type (
 	Server struct {
 		unimplementedServer
 
-		APIKey string
+		// Keys are the API keys used to authenticate with the upstream API, keys that are rejected (HTTP 401 or 429)
+		// will be taken out of rotation, and the request retried using the next available key.
+		Keys *apikey.Pool
+
+		// BaseURL is the upstream API, defaults to DefaultBaseURL.
+		BaseURL string
+
+		// Client is used to make upstream requests, defaults to http.DefaultClient.
+		// See also httpclient.Config, which supports configuring transport timeouts and TLS.
+		Client *http.Client
+
+		// Timeout bounds each upstream call (including any retries), defaults to DefaultTimeout.
+		Timeout time.Duration
+
+		// Precision is the geohash precision positions are snapped to, such that nearby positions share the cache
+		// (and upstream requests), defaults to geohash.DefaultPrecision. See also geohash.Snap.
+		Precision int
+
+		// Wait is the "long poll" prior to each upstream call, merging concurrent requests (per key), defaults to
+		// DefaultWait.
+		Wait time.Duration
+
+		// RateLimit is the minimum interval between upstream calls (per key), defaults to DefaultRateLimit.
+		RateLimit time.Duration
+
+		// CacheSize bounds the number of cached responses, evicting the least recently used, unbounded if not
+		// positive.
+		CacheSize int
+
+		// CacheTTL bounds the age of cached responses, unbounded if not positive.
+		CacheTTL time.Duration
+
+		// Metrics records the rate limiting of upstream calls, optional. Cache and coalescing outcomes are attached
+		// to the context, see metrics.Metrics.UnaryServerInterceptor.
+		Metrics *metrics.Metrics
 
 		excl  bigbuff.Exclusive
-		mu    sync.RWMutex
-		cache map[cacheKey]*cacheValue
+		cache cache.Cache[cacheKey, *cacheValue]
+		// ctx bounds all upstream calls, which may outlive the request that initiated them, see Close
+		ctx    context.Context
+		cancel context.CancelFunc
+		once   sync.Once
+		// reconfigured overrides the corresponding fields, if set, see Reconfigure
+		reconfigured atomic.Pointer[Options]
 	}
 
+	// Options are the fields of Server that may be replaced at runtime, see Server.Reconfigure.
+	Options struct {
+		Timeout   time.Duration
+		Wait      time.Duration
+		RateLimit time.Duration
+		CacheSize int
+		CacheTTL  time.Duration
+	}
+
+	// cacheKey uses the position, if set, such that queries resolving to the same location share the cache
 	cacheKey struct {
-		query string
+		query    string
+		position string
 	}
 
 	cacheValue = weatherstack.CurrentWeather
@@ -36,59 +101,109 @@ type (
 	unimplementedServer = weatherstack.UnimplementedWeatherstackServer
 )
 
+const (
+	// DefaultBaseURL is the default value for Server.BaseURL.
+	// Note that the free plan only supports http.
+	DefaultBaseURL = `http://api.weatherstack.com`
+
+	// DefaultTimeout is the default value for Server.Timeout.
+	DefaultTimeout = time.Minute
+
+	// DefaultWait is the default value for Server.Wait.
+	DefaultWait = time.Millisecond * 100
+
+	// DefaultRateLimit is the default value for Server.RateLimit.
+	DefaultRateLimit = time.Millisecond * 500
+
+	provider = `weatherstack`
+
+	// cacheKeyPosition and cacheKeyQuery prefix the formatted cache keys, see cacheKey.String
+	cacheKeyPosition = `position:`
+	cacheKeyQuery    = `query:`
+)
+
 var (
+	errKeyRejected = errors.New(`weatherstack: api key rejected`)
+)
+
+var (
+	tracer = otel.Tracer(`github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/weatherstack`)
+
 	// compile time assertions
 
 	_ weatherstack.WeatherstackServer = (*Server)(nil)
 )
 
+// Close cancels any in-flight upstream calls, and causes subsequent calls to fail. Requests already waiting on an
+// upstream call will fail, unless their own context is done first.
+func (x *Server) Close() {
+	x.context()
+	x.cancel()
+}
+
+// Reconfigure atomically replaces the options, which otherwise default to the corresponding fields of the Server.
+// Cached responses are retained, though they are subject to the new bounds, and in-flight upstream calls are
+// unaffected.
+func (x *Server) Reconfigure(options Options) {
+	x.reconfigured.Store(&options)
+}
+
+// CacheAdmin exposes the cached responses, keyed by position (a geohash cell) or query, e.g. "position:r3gx2f" or
+// "query:Sydney". Refreshing an entry calls GetCurrentWeather, requiring a read after the current time.
+func (x *Server) CacheAdmin() cache.Admin {
+	return cache.NewAdmin(&x.cache, cacheKey.String, x.refresh)
+}
+
 func (x *Server) GetCurrentWeather(ctx context.Context, req *weatherstack.GetCurrentWeatherRequest) (*weatherstack.CurrentWeather, error) {
-	key := newCacheKey(req)
+	key := newCacheKey(req, x.Precision)
 
 	// fast path
-	x.mu.RLock()
-	if res := x.cache[key]; res != nil &&
-		(req.GetMinReadTime() == nil ||
-			(res.GetReadTime() != nil && !res.GetReadTime().AsTime().Before(req.GetMinReadTime().AsTime()))) {
-		x.mu.RUnlock()
+	_, span := tracer.Start(ctx, `weatherstack.cache`)
+	res, ok := x.cache.Get(key, x.options().CacheTTL)
+	fresh := ok && (req.GetMinReadTime() == nil ||
+		(res.GetReadTime() != nil && !res.GetReadTime().AsTime().Before(req.GetMinReadTime().AsTime())))
+	metrics.ObserveCache(ctx, ok, fresh)
+	span.SetAttributes(tracing.CacheOutcome(ok, fresh))
+	span.End()
+	if fresh {
 		return res, nil
 	}
-	x.mu.RUnlock()
 
 	// summary:
 	// - locked on the key
-	// - "long poll" of 100ms prior to starting
+	// - "long poll" (default 100ms) prior to starting
 	// - merge multiple concurrent calls (per key)
-	// - rate limit (per key) to 500ms
+	// - rate limit (per key, default 500ms)
 	//
 	// To do this in a distributed manner, you'd need to use something like kafka consumer groups, to schedule
 	// and debounce the work, then a broadcast of the result (proper request-response might be possible, dunno, NATS
 	// maybe).
-	// TODO use a cancelable context
-	callCtx := context.Background()
+	// note: the span includes the wait, and any upstream call, which may be shared with other requests
+	ctx, span = tracer.Start(ctx, `weatherstack.exclusive`)
+	defer span.End()
+	// note: called is only set if this call's work function was the one called, i.e. it wasn't coalesced
+	var called bool
+	callCtx := x.context()
 	ch := x.excl.CallWithOptions(
 		bigbuff.ExclusiveKey(key),
-		bigbuff.ExclusiveWait(time.Millisecond*100),
-		bigbuff.ExclusiveRateLimit(callCtx, time.Millisecond*500),
+		bigbuff.ExclusiveWait(x.wait()),
+		bigbuff.ExclusiveRateLimit(callCtx, x.rateLimit()),
+		x.Metrics.ExclusiveRateLimit(weatherstack.Weatherstack_ServiceDesc.ServiceName),
 		bigbuff.ExclusiveValue(func() (any, error) {
-			log.Printf(`weatherstack request: %v`, req)
-			res, err := x.getCurrentWeather(callCtx, req)
+			called = true
+			slog.DebugContext(ctx, `weatherstack request`, `request`, logging.Proto(req))
+			// note: the upstream call is traced as part of the request that initiated it, but isn't bound by it
+			upstreamCtx, upstreamSpan := tracer.Start(trace.ContextWithSpan(callCtx, span), `weatherstack.upstream`)
+			defer upstreamSpan.End()
+			res, err := x.getCurrentWeather(upstreamCtx, req)
+			tracing.SetError(upstreamSpan, err)
 			if err == nil {
-				x.mu.Lock()
-				if x.cache == nil {
-					x.cache = make(map[cacheKey]*cacheValue)
-				}
-				x.cache[key] = res
-				x.mu.Unlock()
+				x.cache.Put(key, res, x.options().CacheSize)
 			}
-			{
-				var v any
-				if err != nil {
-					v = err
-				} else {
-					v = res
-				}
-				log.Printf(`weatherstack response: %v`, v)
+			if err != nil {
+				slog.WarnContext(ctx, `weatherstack request failed`, `error`, err)
+			} else {
+				slog.DebugContext(ctx, `weatherstack response`, `response`, logging.Proto(res))
 			}
 			return res, err
 		}),
@@ -96,9 +211,15 @@ func (x *Server) GetCurrentWeather(ctx context.Context, req *weatherstack.GetCur
 
 	select {
 	case <-ctx.Done():
+		tracing.SetError(span, ctx.Err())
 		return nil, status.FromContextError(ctx.Err()).Err()
 
 	case v := <-ch:
+		if !called {
+			metrics.ObserveCoalesced(ctx)
+		}
+		span.SetAttributes(tracing.AttrCoalesced.Bool(!called))
+		tracing.SetError(span, v.Error)
 		if v.Error != nil {
 			return nil, v.Error
 		}
@@ -107,58 +228,205 @@ func (x *Server) GetCurrentWeather(ctx context.Context, req *weatherstack.GetCur
 }
 
 func (x *Server) getCurrentWeather(ctx context.Context, request *weatherstack.GetCurrentWeatherRequest) (*weatherstack.CurrentWeather, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, x.timeout())
 	defer cancel()
 
+	// each key will be attempted at most once
+	for attempts := x.Keys.Len(); ; attempts-- {
+		key, err := x.Keys.Next()
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, `weatherstack: no api keys available`)
+		}
+
+		res, err := x.getCurrentWeatherWithKey(ctx, request, key)
+		if err == errKeyRejected {
+			x.Keys.Reject(key)
+			if attempts > 1 {
+				continue
+			}
+			return nil, status.Error(codes.Unavailable, `weatherstack: api key rejected`)
+		}
+		if err != nil {
+			x.Keys.Fail(key)
+		}
+
+		return res, err
+	}
+}
+
+func (x *Server) getCurrentWeatherWithKey(ctx context.Context, request *weatherstack.GetCurrentWeatherRequest, key string) (*weatherstack.CurrentWeather, error) {
+	// note: the position (if resolved) takes precedence over the query, is snapped to the geohash cell, and is also
+	// supported via the query
+	var location *locationpb.Location
+	query := request.GetQuery()
+	if position := request.GetPosition(); position != nil {
+		location = geohash.Snap(position, x.Precision)
+		query = formatPosition(location.GetPosition())
+	}
 	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(
-		`http://api.weatherstack.com/current?units=m&access_key=%s&query=%s`,
-		url.QueryEscape(x.APIKey),
-		url.QueryEscape(request.GetQuery()),
+		`%s/current?units=m&access_key=%s&query=%s`,
+		x.baseURL(),
+		url.QueryEscape(key),
+		url.QueryEscape(query),
 	), nil)
 	if err != nil {
-		return nil, err
+		// note: the error isn't wrapped, as it contains the url, including the key
+		return nil, status.Errorf(codes.Internal, `%s: invalid base url`, provider)
 	}
 
 	req = req.WithContext(ctx)
 
 	readTime := time.Now()
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := x.client().Do(req)
 	if err != nil {
-		return nil, err
+		return nil, httpclient.TransportError(provider, err)
 	}
 	defer res.Body.Close()
 	defer io.Copy(io.Discard, res.Body)
 
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(`unexpected status code %d`, res.StatusCode)
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusTooManyRequests:
+		return nil, errKeyRejected
+	default:
+		return nil, httpclient.StatusError(provider, res.StatusCode)
 	}
 
+	// note: weatherstack reports errors (including auth errors) with a 200 status code
 	var body struct {
+		Error *struct {
+			Code int    `json:"code"`
+			Type string `json:"type"`
+		} `json:"error"`
 		Current struct {
 			Temperature *float64 `json:"temperature"`
 			WindSpeed   *float64 `json:"wind_speed"`
 		} `json:"current"`
 	}
 	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
-		return nil, err
+		return nil, httpclient.DecodeError(provider, err)
+	}
+	if body.Error != nil {
+		switch body.Error.Code {
+		case 101, 102, 104:
+			// invalid_access_key / missing_access_key, inactive_user, usage_limit_reached
+			return nil, errKeyRejected
+		case 615:
+			// request_failed, i.e. the query didn't match a location
+			return nil, status.Errorf(codes.NotFound, `weatherstack: error %d: %s`, body.Error.Code, body.Error.Type)
+		default:
+			return nil, status.Errorf(codes.Unknown, `weatherstack: error %d: %s`, body.Error.Code, body.Error.Type)
+		}
 	}
 	if body.Current.Temperature == nil {
-		return nil, fmt.Errorf(`missing "current.temperature"`)
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "current.temperature"`))
 	}
 	if body.Current.WindSpeed == nil {
-		return nil, fmt.Errorf(`missing "current.wind_speed"`)
+		return nil, httpclient.DecodeError(provider, errors.New(`missing "current.wind_speed"`))
 	}
 
 	return &weatherstack.CurrentWeather{
 		ReadTime:    timestamppb.New(readTime),
+		Location:    location,
 		Temperature: *body.Current.Temperature,
 		WindSpeed:   *body.Current.WindSpeed,
 	}, nil
 }
 
-func newCacheKey(req *weatherstack.GetCurrentWeatherRequest) cacheKey {
+func (x *Server) baseURL() string {
+	if x.BaseURL != `` {
+		return strings.TrimSuffix(x.BaseURL, `/`)
+	}
+	return DefaultBaseURL
+}
+
+func (x *Server) client() *http.Client {
+	if x.Client != nil {
+		return x.Client
+	}
+	return http.DefaultClient
+}
+
+func (x *Server) timeout() time.Duration {
+	if v := x.options().Timeout; v > 0 {
+		return v
+	}
+	return DefaultTimeout
+}
+
+func (x *Server) context() context.Context {
+	x.once.Do(func() { x.ctx, x.cancel = context.WithCancel(context.Background()) })
+	return x.ctx
+}
+
+// options returns the current options, see Reconfigure
+func (x *Server) options() *Options {
+	if v := x.reconfigured.Load(); v != nil {
+		return v
+	}
+	return &Options{
+		Timeout:   x.Timeout,
+		Wait:      x.Wait,
+		RateLimit: x.RateLimit,
+		CacheSize: x.CacheSize,
+		CacheTTL:  x.CacheTTL,
+	}
+}
+
+func (x *Server) wait() time.Duration {
+	if v := x.options().Wait; v > 0 {
+		return v
+	}
+	return DefaultWait
+}
+
+func (x *Server) rateLimit() time.Duration {
+	if v := x.options().RateLimit; v > 0 {
+		return v
+	}
+	return DefaultRateLimit
+}
+
+// refresh fetches the response for the (formatted) cache key, see CacheAdmin
+func (x *Server) refresh(ctx context.Context, key string) (*cacheValue, error) {
+	req := &weatherstack.GetCurrentWeatherRequest{MinReadTime: timestamppb.Now()}
+	if v, ok := strings.CutPrefix(key, cacheKeyPosition); ok {
+		cell, err := geohash.Decode(v)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, `%s: invalid cache key: %v`, provider, err)
+		}
+		latitude, longitude := cell.Center()
+		req.Position = &latlngpb.LatLng{Latitude: latitude, Longitude: longitude}
+	} else if v, ok := strings.CutPrefix(key, cacheKeyQuery); ok {
+		req.Query = v
+	}
+	// note: also rejects positions of a different precision, which wouldn't be cached under the key
+	if (req.Position == nil && req.Query == ``) || newCacheKey(req, x.Precision).String() != key {
+		return nil, status.Errorf(codes.InvalidArgument, `%s: invalid cache key: %q`, provider, key)
+	}
+	return x.GetCurrentWeather(ctx, req)
+}
+
+func newCacheKey(req *weatherstack.GetCurrentWeatherRequest, precision int) cacheKey {
+	if position := req.GetPosition(); position != nil {
+		return cacheKey{
+			position: geohash.Encode(position.GetLatitude(), position.GetLongitude(), precision),
+		}
+	}
 	return cacheKey{
 		query: req.GetQuery(),
 	}
 }
+
+// formatPosition formats the position to 4 decimal places, i.e. about 11m
+func formatPosition(position *latlngpb.LatLng) string {
+	return fmt.Sprintf(`%.4f,%.4f`, position.GetLatitude(), position.GetLongitude())
+}
+
+func (x cacheKey) String() string {
+	if x.position != `` {
+		return cacheKeyPosition + x.position
+	}
+	return cacheKeyQuery + x.query
+}
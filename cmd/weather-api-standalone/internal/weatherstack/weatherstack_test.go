@@ -0,0 +1,392 @@
+package weatherstack
+
+import (
+	"context"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_GetCurrentWeather(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		name    string
+		keys    []apikey.Key
+		handler func(t *testing.T, w http.ResponseWriter, r *http.Request)
+		res     *weatherstack.CurrentWeather
+		code    codes.Code
+		message string
+		// requests is the expected sequence of access_key values
+		requests []string
+	}{
+		{
+			name: `success`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != `/current` {
+					t.Errorf(`unexpected path: %s`, r.URL.Path)
+				}
+				if v := r.URL.Query().Get(`units`); v != `m` {
+					t.Errorf(`unexpected units: %s`, v)
+				}
+				if v := r.URL.Query().Get(`query`); v != `sydney, au` {
+					t.Errorf(`unexpected query: %s`, v)
+				}
+				_, _ = w.Write([]byte(`{"request":{"type":"City","query":"Sydney, Australia","language":"en","unit":"m"},"location":{"name":"Sydney","country":"Australia","lat":"-33.883","lon":"151.217"},"current":{"temperature":21.5,"wind_speed":4.1,"wind_dir":"E"}}`))
+			},
+			res:      &weatherstack.CurrentWeather{Temperature: 21.5, WindSpeed: 4.1},
+			requests: []string{`key1`},
+		},
+		{
+			name: `zero values`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"current":{"temperature":0,"wind_speed":0}}`))
+			},
+			res:      &weatherstack.CurrentWeather{},
+			requests: []string{`key1`},
+		},
+		{
+			name: `missing temperature`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"current":{"wind_speed":4.1}}`))
+			},
+			code:     codes.Internal,
+			message:  `weatherstack: invalid response: missing "current.temperature"`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `missing wind speed`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"current":{"temperature":21.5}}`))
+			},
+			code:     codes.Internal,
+			message:  `weatherstack: invalid response: missing "current.wind_speed"`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `malformed json`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"current":`))
+			},
+			code:     codes.Internal,
+			message:  `weatherstack: invalid response: unexpected EOF`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `not found`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"success":false,"error":{"code":615,"type":"request_failed","info":"Your API request failed. Please try again or contact support."}}`))
+			},
+			code:     codes.NotFound,
+			message:  `weatherstack: error 615: request_failed`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `server error`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			code:     codes.Unavailable,
+			message:  `weatherstack: unexpected status code 502`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `unknown error`,
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"success":false,"error":{"code":601,"type":"missing_query"}}`))
+			},
+			code:     codes.Unknown,
+			message:  `weatherstack: error 601: missing_query`,
+			requests: []string{`key1`},
+		},
+		{
+			name: `key rejected failover`,
+			keys: []apikey.Key{{Value: `key1`}, {Value: `key2`}},
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get(`access_key`) == `key1` {
+					_, _ = w.Write([]byte(`{"success":false,"error":{"code":104,"type":"usage_limit_reached"}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"current":{"temperature":1,"wind_speed":2}}`))
+			},
+			res:      &weatherstack.CurrentWeather{Temperature: 1, WindSpeed: 2},
+			requests: []string{`key1`, `key2`},
+		},
+		{
+			name: `all keys rejected`,
+			keys: []apikey.Key{{Value: `key1`}, {Value: `key2`}},
+			handler: func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			code:     codes.Unavailable,
+			message:  `weatherstack: api key rejected`,
+			requests: []string{`key1`, `key2`},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu       sync.Mutex
+				requests []string
+			)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.URL.Query().Get(`access_key`))
+				mu.Unlock()
+				tc.handler(t, w, r)
+			}))
+			defer ts.Close()
+
+			keys := tc.keys
+			if keys == nil {
+				keys = []apikey.Key{{Value: `key1`}}
+			}
+			server := Server{
+				Keys:    apikey.NewPool(keys...),
+				BaseURL: ts.URL + `/`,
+				Client:  ts.Client(),
+				Timeout: time.Second * 5,
+			}
+
+			start := time.Now()
+			res, err := server.GetCurrentWeather(context.Background(), &weatherstack.GetCurrentWeatherRequest{Query: `sydney, au`})
+
+			if tc.res != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if readTime := res.GetReadTime().AsTime(); readTime.Before(start) || readTime.After(time.Now()) {
+					t.Errorf(`unexpected read time: %s`, readTime)
+				}
+				if res.GetTemperature() != tc.res.GetTemperature() || res.GetWindSpeed() != tc.res.GetWindSpeed() {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+			} else {
+				if res != nil {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+				if sts := status.Convert(err); sts.Code() != tc.code || sts.Message() != tc.message {
+					t.Errorf(`unexpected error: %v`, err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(requests) != len(tc.requests) {
+				t.Fatalf(`unexpected requests: %q`, requests)
+			}
+			for i := range requests {
+				if requests[i] != tc.requests[i] {
+					t.Errorf(`unexpected requests: %q`, requests)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_GetCurrentWeather_cached(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"current":{"temperature":21.5,"wind_speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL: ts.URL,
+		Client:  ts.Client(),
+	}
+
+	res1, err := server.GetCurrentWeather(context.Background(), &weatherstack.GetCurrentWeatherRequest{Query: `sydney`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := server.GetCurrentWeather(context.Background(), &weatherstack.GetCurrentWeatherRequest{Query: `sydney`, MinReadTime: res1.GetReadTime()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res1 != res2 || calls.Load() != 1 {
+		t.Errorf(`expected cached response: %d`, calls.Load())
+	}
+}
+
+func TestServer_GetCurrentWeather_position(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// note: the position is snapped to the centroid of the cell
+		if v := r.URL.Query().Get(`query`); v != `-33.8599,151.1938` {
+			t.Errorf(`unexpected query: %s`, v)
+		}
+		_, _ = w.Write([]byte(`{"current":{"temperature":21,"wind_speed":15}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:    apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL: ts.URL,
+		Client:  ts.Client(),
+	}
+
+	// note: the cache is keyed by the geohash of the position, if set
+	for _, req := range [...]*weatherstack.GetCurrentWeatherRequest{
+		{Query: `sydney`, Position: &latlngpb.LatLng{Latitude: -33.86882, Longitude: 151.20929}},
+		{Query: `Sydney, AU`, Position: &latlngpb.LatLng{Latitude: -33.8701, Longitude: 151.2070}},
+	} {
+		res, err := server.GetCurrentWeather(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GetTemperature() != 21 ||
+			res.GetLocation().GetId() != `geohash:r3gx2` ||
+			res.GetLocation().GetPosition().GetLatitude() != -33.85986328125 ||
+			res.GetLocation().GetPosition().GetLongitude() != 151.19384765625 {
+			t.Errorf(`unexpected response: %v`, res)
+		}
+	}
+	if v := calls.Load(); v != 1 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+}
+
+func TestServer_CacheAdmin(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = w.Write([]byte(`{"current":{"temperature":21.5,"wind_speed":4.1}}`))
+	}))
+	defer ts.Close()
+
+	server := Server{
+		Keys:      apikey.NewPool(apikey.Key{Value: `key1`}),
+		BaseURL:   ts.URL,
+		Client:    ts.Client(),
+		Wait:      time.Millisecond,
+		RateLimit: time.Millisecond,
+	}
+	for _, req := range [...]*weatherstack.GetCurrentWeatherRequest{
+		{Query: `sydney`},
+		{Query: `sydney`, Position: &latlngpb.LatLng{Latitude: -33.86882, Longitude: 151.20929}},
+	} {
+		if _, err := server.GetCurrentWeather(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := server.CacheAdmin()
+	var keys []string
+	for _, e := range admin.Entries(``) {
+		keys = append(keys, e.Key)
+	}
+	if v := strings.Join(keys, `,`); v != `position:r3gx2,query:sydney` {
+		t.Errorf(`unexpected keys: %s`, v)
+	}
+
+	prev, _ := admin.Entry(`position:r3gx2`)
+	res, err := admin.Refresh(context.Background(), `position:r3gx2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := res.Value.(*weatherstack.CurrentWeather); calls.Load() != 3 || v == prev.Value ||
+		v.GetLocation().GetId() != `geohash:r3gx2` {
+		t.Errorf(`unexpected entry: %+v %d`, res, calls.Load())
+	}
+
+	for _, key := range [...]string{`position:r3gx`, `position:r3gx2z`, `position:R3GX2`, `query:`, `sydney`} {
+		if _, err := admin.Refresh(context.Background(), key); status.Code(err) != codes.InvalidArgument {
+			t.Errorf(`%s: unexpected error: %v`, key, err)
+		}
+	}
+	if v := calls.Load(); v != 3 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+}
+
+// TestServer_GetCurrentWeather_fixtures replays recorded responses, see httpclient.Recorder.
+func TestServer_GetCurrentWeather_fixtures(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		query   string
+		res     *weatherstack.CurrentWeather
+		code    codes.Code
+		message string
+	}{
+		{query: `sydney`, res: &weatherstack.CurrentWeather{Temperature: 23, WindSpeed: 22}},
+		{query: `calm`, code: codes.Internal, message: `weatherstack: invalid response: missing "current.wind_speed"`},
+		{query: `nowhere`, code: codes.NotFound, message: `weatherstack: error 615: request_failed`},
+		{query: `perth`, code: codes.Unavailable, message: `weatherstack: api key rejected`},
+	} {
+		tc := tc
+		t.Run(tc.query, func(t *testing.T) {
+			t.Parallel()
+			server := Server{
+				Keys:   apikey.NewPool(apikey.Key{Value: `key1`}),
+				Client: &http.Client{Transport: &httpclient.Replayer{Dir: `testdata/fixtures`}},
+			}
+			res, err := server.getCurrentWeather(context.Background(), &weatherstack.GetCurrentWeatherRequest{Query: tc.query})
+			if tc.res != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.GetTemperature() != tc.res.GetTemperature() || res.GetWindSpeed() != tc.res.GetWindSpeed() {
+					t.Errorf(`unexpected response: %v`, res)
+				}
+			} else if sts := status.Convert(err); sts.Code() != tc.code || sts.Message() != tc.message {
+				t.Errorf(`unexpected error: %v`, err)
+			}
+		})
+	}
+}
+
+// note: not parallel, as it replaces the default logger
+func TestServer_GetCurrentWeather_redacted(t *testing.T) {
+	const key = `secret-api-key`
+
+	var b strings.Builder
+	logger, err := logging.Config{Level: slog.LevelDebug, Format: logging.FormatJSON, Writer: &b}.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	// note: an invalid base url, and an unreachable one, fail with errors that would otherwise include the url
+	for _, baseURL := range [...]string{"http://example.com/\x7f", ts.URL} {
+		server := Server{
+			Keys:    apikey.NewPool(apikey.Key{Value: key}),
+			BaseURL: baseURL,
+			Client:  ts.Client(),
+		}
+		_, err := server.GetCurrentWeather(context.Background(), &weatherstack.GetCurrentWeatherRequest{Query: `sydney`})
+		if err == nil || strings.Contains(err.Error(), key) {
+			t.Errorf(`unexpected error: %v`, err)
+		}
+	}
+
+	if s := b.String(); strings.Contains(s, key) || strings.Count(s, `"msg":"weatherstack request failed"`) != 2 {
+		t.Errorf("unexpected logs:\n%s", s)
+	}
+}
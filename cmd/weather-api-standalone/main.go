@@ -1,50 +1,599 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/fullstorydev/grpchan"
 	"github.com/fullstorydev/grpchan/inprocgrpc"
 	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/cacheadmin"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	cacheadminapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cacheadmin"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/config"
+	geocodeapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/geocode"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	metnoapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/metno"
+	omapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openmeteo"
 	owapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openweather"
 	wsapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/weatherstack"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/alerts"
+	"github.com/joeycumines/mx51-weather-api/internal/clientauth"
+	"github.com/joeycumines/mx51-weather-api/internal/divergence"
+	"github.com/joeycumines/mx51-weather-api/internal/gazetteer"
+	"github.com/joeycumines/mx51-weather-api/internal/health"
+	"github.com/joeycumines/mx51-weather-api/internal/history"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/metrics"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
 	"github.com/joeycumines/mx51-weather-api/internal/weather"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
 	"github.com/joeycumines/mx51-weather-api/openweather"
 	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const (
+	// exitOK indicates a clean exit, including a graceful shutdown.
+	exitOK = 0
+
+	// exitFailure indicates the server failed to start, or to serve, e.g. it couldn't listen.
+	exitFailure = 1
+
+	// exitUsage indicates invalid flags or config.
+	exitUsage = 2
+
+	// exitShutdownTimeout indicates the shutdown deadline was exceeded, i.e. in-flight requests were dropped.
+	exitShutdownTimeout = 3
+)
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	// the provider command serves a single provider, standalone, see runProvider
+	if len(os.Args) > 1 && os.Args[1] == `provider` {
+		return runProvider(os.Args[2:])
+	}
+
+	cfg, code, ok := loadConfig(os.Args[0], os.Args[1:])
+	if !ok {
+		return code
+	}
+
+	// logs are structured, and redact every api key, including those rotated via the admin api, and those of the
+	// clients, see logging.Redactor
+	// note: the pools are only added to prior to serving, i.e. before anything else could log
+	keyPools := make(apikey.Handler)
+	clientStore := &clientauth.FileStore{Path: cfg.Clients.File}
+	redactor := &logging.Redactor{Secrets: func() []string {
+		return append(keyPools.Secrets(), clientStore.Secrets()...)
+	}}
+	logLevel, err := installLogger(cfg, redactor)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// the servers are shut down on SIGINT or SIGTERM, see shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// spans are exported via OTLP, or to stdout, if enabled, and the (W3C) trace context is propagated regardless
+	shutdownTracing, err := tracingConfig(cfg, `weather-api`).Install(ctx)
+	if err != nil {
+		slog.Error(`failed to install tracing`, `error`, err)
+		return exitFailure
+	}
+
+	// upstream requests may be recorded to, or replayed from, fixture files, see httpclient.Recorder
+	providerClient, err := httpclient.Config{
+		RecordDir: cfg.Providers.RecordDir,
+		ReplayDir: cfg.Providers.ReplayDir,
+	}.NewClient()
+	if err != nil {
+		slog.Error(`failed to init the provider client`, `error`, err)
+		return exitFailure
+	}
+
+	// metrics are served by the admin listener, at /metrics, recorded by chi middleware, and gRPC interceptors
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	appMetrics := metrics.New(registry)
+
 	// init (in-process) gRPC server implementations for the weather apis
-	// note: these would be in separate (load balanced, redundant) processes, in a real world scenario
+	// note: openweather and weatherstack may instead be served standalone (see runProvider), by any number of
+	// replicas, which are dialed by address, and load balanced across
+	// note: each api key config may contain multiple keys, see apikey.ParseKeys
+	// note: positions are snapped to geohash cells, of the configured precision, so nearby positions share the caches
+	// note: each implementation is closed on shutdown, canceling any in-flight upstream calls
 	handlers := make(grpchan.HandlerMap)
-	if key := os.Getenv(`APP_OPENWEATHER_API_KEY`); key != `` {
-		openweather.RegisterOpenweatherServer(handlers, &owapi.Server{
-			APIKey: key,
-		})
+	var (
+		conn     inprocgrpc.Channel
+		owConn   grpc.ClientConnInterface
+		wsConn   grpc.ClientConnInterface
+		owServer *owapi.Server
+		wsServer *wsapi.Server
+		remotes  []*grpc.ClientConn
+	)
+	var providers []interface{ Close() }
+	precision := cfg.Providers.GeohashPrecision
+	if address := cfg.Providers.Openweather.Address; address != `` {
+		remote, err := rpcConfig(cfg).Dial(address, openweather.Openweather_ServiceDesc.ServiceName)
+		if err != nil {
+			slog.Error(`invalid providers.openweather.address`, `error`, err)
+			return exitFailure
+		}
+		owConn, remotes = remote, append(remotes, remote)
+	} else if keys, _ := cfg.Providers.Openweather.APIKeys.Keys(); len(keys) != 0 {
+		keyPools[`openweather`] = apikey.NewPool(keys...)
+		owServer = newOpenweatherServer(cfg, keyPools[`openweather`], providerClient, appMetrics)
+		openweather.RegisterOpenweatherServer(handlers, owServer)
+		providers = append(providers, owServer)
+		owConn = &conn
 	}
-	if key := os.Getenv(`APP_WEATHERSTACK_API_KEY`); key != `` {
-		weatherstack.RegisterWeatherstackServer(handlers, &wsapi.Server{
-			APIKey: key,
-		})
+	if address := cfg.Providers.Weatherstack.Address; address != `` {
+		remote, err := rpcConfig(cfg).Dial(address, weatherstack.Weatherstack_ServiceDesc.ServiceName)
+		if err != nil {
+			slog.Error(`invalid providers.weatherstack.address`, `error`, err)
+			return exitFailure
+		}
+		wsConn, remotes = remote, append(remotes, remote)
+	} else if keys, _ := cfg.Providers.Weatherstack.APIKeys.Keys(); len(keys) != 0 {
+		keyPools[`weatherstack`] = apikey.NewPool(keys...)
+		wsServer = newWeatherstackServer(cfg, keyPools[`weatherstack`], providerClient, appMetrics)
+		weatherstack.RegisterWeatherstackServer(handlers, wsServer)
+		providers = append(providers, wsServer)
+		wsConn = &conn
+	}
+
+	// open-meteo and met.no don't require an api key, and are (by default) the lowest priority fallbacks
+	// note: met.no only supports coordinates, so the open-meteo geocoding api is used to resolve locations
+	omServer := &omapi.Server{
+		BaseURL:            cfg.Providers.OpenMeteo.BaseURL,
+		GeocodingBaseURL:   cfg.Providers.OpenMeteo.GeocodingBaseURL,
+		Client:             providerClient,
+		Timeout:            cfg.Providers.OpenMeteo.Timeout.Std(),
+		Precision:          precision,
+		Wait:               cfg.Providers.OpenMeteo.Wait.Std(),
+		RateLimit:          cfg.Providers.OpenMeteo.RateLimit.Std(),
+		CacheSize:          cfg.Providers.OpenMeteo.CacheSize,
+		CacheTTL:           cfg.Providers.OpenMeteo.CacheTTL.Std(),
+		GeocodingCacheSize: cfg.Providers.OpenMeteo.GeocodingCacheSize,
+		GeocodingCacheTTL:  cfg.Providers.OpenMeteo.GeocodingCacheTTL.Std(),
+		Metrics:            appMetrics,
+	}
+	openmeteo.RegisterOpenMeteoServer(handlers, omServer)
+	metnoServer := &metnoapi.Server{
+		Geocoder:  omServer,
+		UserAgent: cfg.Providers.Metno.UserAgent,
+		BaseURL:   cfg.Providers.Metno.BaseURL,
+		Client:    providerClient,
+		Timeout:   cfg.Providers.Metno.Timeout.Std(),
+		Precision: precision,
+		Wait:      cfg.Providers.Metno.Wait.Std(),
+		RateLimit: cfg.Providers.Metno.RateLimit.Std(),
+		CacheSize: cfg.Providers.Metno.CacheSize,
+		CacheTTL:  cfg.Providers.Metno.CacheTTL.Std(),
+		Metrics:   appMetrics,
+	}
+	metno.RegisterMetnoServer(handlers, metnoServer)
+	providers = append(providers, omServer, metnoServer)
+
+	// queries are resolved to canonical locations (by default, using the same geocoding api as open-meteo), so that
+	// all providers are queried by position
+	// note: an (offline) gazetteer may be loaded from a GeoNames dump, e.g. cities15000.txt, replacing the upstream api
+	geocodeServer := &geocodeapi.Server{
+		BaseURL:   cfg.Geocoding.BaseURL,
+		Client:    providerClient,
+		Timeout:   cfg.Geocoding.Timeout.Std(),
+		CacheSize: cfg.Geocoding.CacheSize,
+		CacheTTL:  cfg.Geocoding.CacheTTL.Std(),
+	}
+	if geocodeServer.BaseURL == `` {
+		geocodeServer.BaseURL = cfg.Providers.OpenMeteo.GeocodingBaseURL
 	}
-	if len(handlers) == 0 {
-		panic(`no api keys provided`)
+	if v := cfg.Geocoding.GazetteerFile; v != `` {
+		if geocodeServer.Gazetteer, err = gazetteer.LoadFile(v, cfg.Geocoding.GazetteerAdmin1File); err != nil {
+			slog.Error(`invalid geocoding.gazetteer_file`, `error`, err)
+			return exitFailure
+		}
 	}
+	geocode.RegisterGeocodeServer(handlers, geocodeServer)
 
 	// the actual in-process gRPC client
-	var conn inprocgrpc.Channel
-	handlers.ForEach(conn.RegisterService)
+	// note: calls are instrumented on both sides, see metrics.Metrics.UnaryServerInterceptor, and traced, with the
+	// trace context, and request id, propagated via metadata, as they would be for remote providers
+	// note: errors are redacted by the innermost interceptor, i.e. before they are recorded
+	handlers.ForEach(interceptServer(&conn,
+		appMetrics.UnaryServerInterceptor,
+		otelgrpc.UnaryServerInterceptor(),
+		redactor.UnaryServerInterceptor,
+	).RegisterService)
+	intercept := func(cc grpc.ClientConnInterface) grpc.ClientConnInterface {
+		return grpchan.InterceptClientConn(
+			grpchan.InterceptClientConn(
+				grpchan.InterceptClientConn(cc, logging.UnaryClientInterceptor, nil),
+				tracing.UnaryClientInterceptor,
+				nil,
+			),
+			appMetrics.UnaryClientInterceptor,
+			nil,
+		)
+	}
+
+	// the caches of openweather and weatherstack may be inspected, and invalidated, via the admin api, which requires
+	// a bearer token, and is disabled if none is configured
+	// note: calls for providers served standalone are forwarded, via gRPC, presenting the same token
+	cacheAdmin := &cacheadminapi.Server{
+		Token:   string(cfg.AdminToken),
+		Caches:  make(map[string]cache.Admin),
+		Remotes: make(map[string]cacheadmin.CacheAdminClient),
+	}
+	if owServer != nil {
+		cacheAdmin.Caches[`openweather`] = owServer.CacheAdmin()
+	} else if owConn != nil {
+		cacheAdmin.Remotes[`openweather`] = cacheadmin.NewCacheAdminClient(intercept(owConn))
+	}
+	if wsServer != nil {
+		cacheAdmin.Caches[`weatherstack`] = wsServer.CacheAdmin()
+	} else if wsConn != nil {
+		cacheAdmin.Remotes[`weatherstack`] = cacheadmin.NewCacheAdminClient(intercept(wsConn))
+	}
+
+	// the readiness of each provider is tracked, see /readyz
+	checker := &health.Checker{
+		Window:           cfg.Health.Window.Std(),
+		FailureThreshold: cfg.Health.FailureThreshold,
+		GracePeriod:      cfg.Health.GracePeriod.Std(),
+	}
+
+	// tracks disagreement between providers, optionally calling a webhook when a provider diverges
+	tracker := &divergence.Tracker{
+		TemperatureThreshold: cfg.Divergence.TemperatureThreshold,
+		WindSpeedThreshold:   cfg.Divergence.WindSpeedThreshold,
+		WebhookURL:           string(cfg.Divergence.WebhookURL),
+		SampleInterval:       cfg.Divergence.SampleInterval.Std(),
+		MaxLocations:         cfg.Divergence.MaxLocations,
+	}
 
 	server := weather.Server{
-		MaxAge:       time.Second * 3,
-		TimeNow:      time.Now,
-		Openweather:  openweather.NewOpenweatherClient(&conn),
-		Weatherstack: weatherstack.NewWeatherstackClient(&conn),
+		MaxAge:     cfg.MaxAge.Std(),
+		TimeNow:    time.Now,
+		Divergence: tracker,
+		Health:     checker,
+	}
+	// readings are (optionally) persisted, enabling /v1/weather/history
+	if v := cfg.History.Dir; v != `` {
+		server.History = &history.Store{
+			Dir:       v,
+			Retention: cfg.History.Retention.Std(),
+		}
+	}
+	if owConn != nil {
+		server.Openweather = openweather.NewOpenweatherClient(intercept(owConn))
+	}
+	if wsConn != nil {
+		server.Weatherstack = weatherstack.NewWeatherstackClient(intercept(wsConn))
+	}
+	server.OpenMeteo = openmeteo.NewOpenMeteoClient(intercept(&conn))
+	server.Metno = metno.NewMetnoClient(intercept(&conn))
+	server.Geocode = geocode.NewGeocodeClient(intercept(&conn))
+	for _, provider := range server.Configured() {
+		checker.Add(string(provider))
+	}
+	// if enabled, providers are ready only if they succeeded recently, so they are probed while idle, or failing
+	// note: probing is opt-in, as it costs upstream quota, see health.Checker
+	if city := cfg.Health.ProbeCity; city != `` {
+		checker.ProbeInterval = cfg.Health.ProbeInterval.Std()
+		checker.Probe = func(ctx context.Context, provider string) error {
+			return server.Probe(ctx, provider, city)
+		}
+		go checker.Run(ctx)
+	}
+	// note: replaced when the config is reloaded, see weather.Server.Reconfigure
+	settings := weatherSettings(cfg)
+	server.AmbiguityThreshold = settings.AmbiguityThreshold
+	server.Priority = settings.Priority
+	server.Consensus = settings.Consensus
+	// routing rules may be replaced at runtime, via the admin endpoints, even if no file is configured
+	server.Routing = new(weather.Routing)
+	if v := cfg.RoutingFile; v != `` {
+		if server.Routing, err = weather.NewRouting(v); err != nil {
+			slog.Error(`invalid routing_file`, `error`, err)
+			return exitFailure
+		}
+	}
+	// the public api requires client api keys, if a clients file is configured, enforcing the limits of each
+	// client's plan, and accounting for their usage, see clientauth.Authenticator
+	// note: the clients may be reloaded from the file, at runtime, via the admin api
+	var authenticator *clientauth.Authenticator
+	if clientStore.Path != `` {
+		if err := clientStore.Reload(); err != nil {
+			slog.Error(`invalid clients.file`, `error`, err)
+			return exitFailure
+		}
+		authenticator = &clientauth.Authenticator{
+			Store:          clientStore,
+			UsageRetention: cfg.Clients.UsageRetention,
+			UsagePath:      cfg.Clients.UsageFile,
+			SaveInterval:   cfg.Clients.UsageSaveInterval.Std(),
+		}
+		if authenticator.UsagePath == `` {
+			authenticator.UsagePath = clientStore.Path + `.usage.json`
+		}
+		// usage is restored on start, saved periodically, and on shutdown, once in-flight requests are done
+		if err := authenticator.Load(); err != nil {
+			slog.Error(`invalid clients.usage_file`, `error`, err)
+			return exitFailure
+		}
+		go authenticator.Run(ctx)
+	}
+	// alert subscriptions are (optionally) persisted, and the subscribed cities are refreshed periodically
+	var alertService *alerts.Service
+	alertsDone := make(chan struct{})
+	if v := cfg.Alerts.File; v != `` {
+		if alertService, err = alerts.NewService(v); err != nil {
+			slog.Error(`invalid alerts.file`, `error`, err)
+			return exitFailure
+		}
+		alertService.Interval = cfg.Alerts.Interval.Std()
+		server.Alerts = alertService
+		go func() {
+			defer close(alertsDone)
+			alertService.Run(ctx, server.Refresh)
+		}()
+	} else {
+		close(alertsDone)
+	}
+
+	// the config may be reloaded, on SIGHUP, or via the admin api, replacing the settings of the running servers,
+	// without dropping in-flight requests, or cached responses
+	// note: a config that is invalid, or changes any field that requires a restart, is rejected, see config.Reloader
+	reloader := config.NewReloader(cfg, func() (*config.Config, error) {
+		next, _, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+		return next, err
+	}, func(prev, next *config.Config) error {
+		if err := server.Reconfigure(weatherSettings(next)); err != nil {
+			return err
+		}
+		if level, err := logging.ParseLevel(next.Log.Level); err == nil {
+			logLevel.Set(level)
+		}
+		for provider, apiKeys := range map[string][2]config.APIKeys{
+			`openweather`:  {prev.Providers.Openweather.APIKeys, next.Providers.Openweather.APIKeys},
+			`weatherstack`: {prev.Providers.Weatherstack.APIKeys, next.Providers.Weatherstack.APIKeys},
+		} {
+			// note: keys rotated via the admin api are retained, unless the configured keys changed
+			if pool := keyPools[provider]; pool != nil && apiKeys[0] != apiKeys[1] {
+				keys, _ := apiKeys[1].Keys()
+				pool.Set(keys...)
+			}
+		}
+		if owServer != nil {
+			upstream := next.Providers.Openweather.Upstream
+			owServer.Reconfigure(owapi.Options{
+				Timeout:   upstream.Timeout.Std(),
+				Wait:      upstream.Wait.Std(),
+				RateLimit: upstream.RateLimit.Std(),
+				CacheSize: upstream.CacheSize,
+				CacheTTL:  upstream.CacheTTL.Std(),
+			})
+		}
+		if wsServer != nil {
+			upstream := next.Providers.Weatherstack.Upstream
+			wsServer.Reconfigure(wsapi.Options{
+				Timeout:   upstream.Timeout.Std(),
+				Wait:      upstream.Wait.Std(),
+				RateLimit: upstream.RateLimit.Std(),
+				CacheSize: upstream.CacheSize,
+				CacheTTL:  upstream.CacheTTL.Std(),
+			})
+		}
+		omServer.Reconfigure(omapi.Options{
+			Timeout:   next.Providers.OpenMeteo.Timeout.Std(),
+			Wait:      next.Providers.OpenMeteo.Wait.Std(),
+			RateLimit: next.Providers.OpenMeteo.RateLimit.Std(),
+			CacheSize: next.Providers.OpenMeteo.CacheSize,
+			CacheTTL:  next.Providers.OpenMeteo.CacheTTL.Std(),
+		})
+		metnoServer.Reconfigure(metnoapi.Options{
+			Timeout:   next.Providers.Metno.Timeout.Std(),
+			Wait:      next.Providers.Metno.Wait.Std(),
+			RateLimit: next.Providers.Metno.RateLimit.Std(),
+			CacheSize: next.Providers.Metno.CacheSize,
+			CacheTTL:  next.Providers.Metno.CacheTTL.Std(),
+		})
+		return nil
+	})
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+	go reloader.Run(ctx, reloadSignals)
+
+	// admin endpoints are served separately, and only on localhost by default
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(appMetrics.Middleware)
+	adminRouter.Group(appMetrics.Register)
+	adminRouter.Group(tracker.Register)
+	adminRouter.Group(server.Routing.Register)
+	// the admin endpoints that expose, or modify, credentials or settings require the admin token, and are disabled
+	// without it
+	if cacheAdmin.Token != `` {
+		adminRouter.Group(cacheAdmin.Register)
+		adminRouter.Group(func(r chi.Router) {
+			r.Use(cacheAdmin.Middleware)
+			r.Group(reloader.Register)
+			r.Group(keyPools.Register)
+			if authenticator != nil {
+				r.Group(authenticator.Register)
+				r.Group(clientStore.Register)
+			}
+		})
 	}
 
+	// note: the health endpoints are polled, e.g. by load balancers, so they aren't logged, traced, or measured
 	router := chi.NewRouter()
-	router.Route(`/`, server.Register)
+	router.Group(checker.Register)
+	router.Group(func(r chi.Router) {
+		r.Use(logging.Middleware, tracing.Middleware, appMetrics.Middleware)
+		if authenticator != nil {
+			r.Use(authenticator.Middleware)
+		}
+		r.Group(server.Register)
+		if alertService != nil {
+			r.Group(alertService.Register)
+		}
+	})
+
+	servers := []*http.Server{
+		{Addr: cfg.Addr, Handler: router},
+		{Addr: cfg.AdminAddr, Handler: adminRouter},
+	}
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				errCh <- fmt.Errorf(`server %s: %w`, srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	code = exitOK
+	select {
+	case <-ctx.Done():
+		slog.Info(`shutting down, draining in-flight requests`, `timeout`, cfg.ShutdownTimeout.Std())
+	case err := <-errCh:
+		slog.Error(`shutting down`, `error`, err)
+		code = exitFailure
+	}
+	// note: also stops the alerts, and restores the default signal behavior, i.e. a second signal terminates
+	stop()
+
+	if !shutdown(cfg.ShutdownTimeout.Std(), servers, func() {
+		// cancels any background samples, see divergence.Tracker.Sample
+		server.Close()
+		for _, provider := range providers {
+			provider.Close()
+		}
+		for _, remote := range remotes {
+			_ = remote.Close()
+		}
+		<-alertsDone
+		alertService.Close()
+		if authenticator != nil {
+			if err := authenticator.Save(); err != nil {
+				slog.Error(`failed to save client usage`, `error`, err)
+			}
+		}
+		tracker.Close()
+		// flushes the current file
+		if err := server.History.Close(); err != nil {
+			slog.Error(`failed to close history`, `error`, err)
+		}
+	}) && code == exitOK {
+		code = exitShutdownTimeout
+	}
+	flushTracing(cfg, shutdownTracing)
+	slog.Info(`shutdown complete`)
+	return code
+}
+
+// loadConfig loads the config from (in order of increasing precedence) defaults, a YAML file, env vars, then flags,
+// returning false, and the exit code, if the process should exit, e.g. for --help, or --print-config.
+func loadConfig(name string, args []string) (*config.Config, int, bool) {
+	cfg, options, err := config.Load(name, args, os.LookupEnv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK, false
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitUsage, false
+	}
+	if options.PrintConfig {
+		if err := cfg.Write(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, exitFailure, false
+		}
+		return nil, exitOK, false
+	}
+	return cfg, exitOK, true
+}
+
+// weatherSettings returns the settings of weather.Server, from the (validated) config
+func weatherSettings(cfg *config.Config) weather.Settings {
+	priority, _ := weather.ParsePriority(strings.Join(cfg.Priority, `,`))
+	weights, _ := weather.ParseWeights(cfg.Consensus.Weights)
+	return weather.Settings{
+		MaxAge:             cfg.MaxAge.Std(),
+		AmbiguityThreshold: cfg.AmbiguityThreshold,
+		Priority:           priority,
+		Consensus: weather.Consensus{
+			MaxTemperatureDeviation: cfg.Consensus.MaxTemperatureDeviation,
+			MaxWindSpeedDeviation:   cfg.Consensus.MaxWindSpeedDeviation,
+			Weights:                 weights,
+		},
+	}
+}
+
+// flushTracing exports any buffered spans, then stops the exporter, see tracing.Config.Install
+func flushTracing(cfg *config.Config, shutdownTracing func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Std())
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error(`failed to flush traces`, `error`, err)
+	}
+}
 
-	panic(http.ListenAndServe(`:8080`, router))
+// interceptServer returns a registry that intercepts each service registered, with the interceptors, in order, i.e.
+// the first is the outermost
+func interceptServer(reg grpchan.ServiceRegistry, interceptors ...grpc.UnaryServerInterceptor) grpchan.ServiceRegistry {
+	for _, interceptor := range interceptors {
+		reg = grpchan.WithInterceptor(reg, interceptor, nil)
+	}
+	return reg
+}
+
+// shutdown stops accepting connections, then waits (up to timeout) for in-flight requests, including the in-process
+// gRPC calls they make, before forcibly closing any remaining connections, and calling cleanup, e.g. to cancel any
+// remaining upstream calls. Returns false if the timeout was exceeded.
+func shutdown(timeout time.Duration, servers []*http.Server, cleanup func()) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		drained atomic.Bool
+	)
+	drained.Store(true)
+	wg.Add(len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				slog.Error(`failed to drain`, `server`, srv.Addr, `error`, err)
+				drained.Store(false)
+				_ = srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+	cleanup()
+	return drained.Load()
 }
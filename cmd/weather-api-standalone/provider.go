@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/cacheadmin"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/apikey"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cache"
+	cacheadminapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/cacheadmin"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/config"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/httpclient"
+	owapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/openweather"
+	"github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/rpc"
+	wsapi "github.com/joeycumines/mx51-weather-api/cmd/weather-api-standalone/internal/weatherstack"
+	"github.com/joeycumines/mx51-weather-api/internal/health"
+	"github.com/joeycumines/mx51-weather-api/internal/logging"
+	"github.com/joeycumines/mx51-weather-api/internal/metrics"
+	"github.com/joeycumines/mx51-weather-api/internal/tracing"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runProvider implements the provider command, serving a single provider (openweather or weatherstack) standalone,
+// over gRPC, on grpc.addr, including the standard health service. Any number of replicas may be run, and dialed by
+// the api, see config.KeyedProvider.Address. The provider's api keys are managed via the admin listener.
+func runProvider(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], `-`) {
+		fmt.Fprintf(os.Stderr, "usage: %s provider <openweather|weatherstack> [flags]\n", os.Args[0])
+		return exitUsage
+	}
+	provider, args := args[0], args[1:]
+
+	cfg, code, ok := loadConfig(os.Args[0]+` provider `+provider, args)
+	if !ok {
+		return code
+	}
+
+	// note: the pool is added prior to serving, see run
+	keyPools := make(apikey.Handler)
+	redactor := &logging.Redactor{Secrets: keyPools.Secrets}
+	if _, err := installLogger(cfg, redactor); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	// the servers are shut down on SIGINT or SIGTERM, see shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracingConfig(cfg, `weather-api-`+provider).Install(ctx)
+	if err != nil {
+		slog.Error(`failed to install tracing`, `error`, err)
+		return exitFailure
+	}
+
+	providerClient, err := httpclient.Config{
+		RecordDir: cfg.Providers.RecordDir,
+		ReplayDir: cfg.Providers.ReplayDir,
+	}.NewClient()
+	if err != nil {
+		slog.Error(`failed to init the provider client`, `error`, err)
+		return exitFailure
+	}
+
+	// metrics are served by the admin listener, at /metrics
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	appMetrics := metrics.New(registry)
+
+	var (
+		service  string
+		register func(r grpc.ServiceRegistrar)
+		backend  interface{ Close() }
+		caches   cache.Admin
+		probe    func(ctx context.Context, city string) error
+		apiKeys  config.APIKeys
+	)
+	switch provider {
+	case `openweather`:
+		apiKeys = cfg.Providers.Openweather.APIKeys
+	case `weatherstack`:
+		apiKeys = cfg.Providers.Weatherstack.APIKeys
+	default:
+		fmt.Fprintf(os.Stderr, "unknown provider %q\n", provider)
+		return exitUsage
+	}
+	keys, _ := apiKeys.Keys()
+	if len(keys) == 0 {
+		fmt.Fprintf(os.Stderr, "providers.%s.api_keys required\n", provider)
+		return exitUsage
+	}
+	keyPools[provider] = apikey.NewPool(keys...)
+	switch provider {
+	case `openweather`:
+		server := newOpenweatherServer(cfg, keyPools[provider], providerClient, appMetrics)
+		service, backend, caches = openweather.Openweather_ServiceDesc.ServiceName, server, server.CacheAdmin()
+		register = func(r grpc.ServiceRegistrar) { openweather.RegisterOpenweatherServer(r, server) }
+		probe = func(ctx context.Context, city string) error {
+			_, err := server.GetWeather(ctx, &openweather.GetWeatherRequest{Query: city, MinReadTime: timestamppb.New(time.Now().Add(-cfg.MaxAge.Std()))})
+			return err
+		}
+	case `weatherstack`:
+		server := newWeatherstackServer(cfg, keyPools[provider], providerClient, appMetrics)
+		service, backend, caches = weatherstack.Weatherstack_ServiceDesc.ServiceName, server, server.CacheAdmin()
+		register = func(r grpc.ServiceRegistrar) { weatherstack.RegisterWeatherstackServer(r, server) }
+		probe = func(ctx context.Context, city string) error {
+			_, err := server.GetCurrentWeather(ctx, &weatherstack.GetCurrentWeatherRequest{Query: city, MinReadTime: timestamppb.New(time.Now().Add(-cfg.MaxAge.Std()))})
+			return err
+		}
+	}
+
+	grpcServer, healthServer, err := rpcConfig(cfg).NewServer(service)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	// note: the health service isn't instrumented, and the trace context, and request id, are propagated by the api,
+	// via metadata
+	// note: the service is reported as not serving while the provider isn't ready, such that the api skips this
+	// replica, see health.Checker
+	checker := &health.Checker{
+		Window:           cfg.Health.Window.Std(),
+		FailureThreshold: cfg.Health.FailureThreshold,
+		GracePeriod:      cfg.Health.GracePeriod.Std(),
+	}
+	checker.Add(provider)
+	// note: probes call the backend directly, i.e. they aren't instrumented, and don't count towards the metrics
+	if city := cfg.Health.ProbeCity; city != `` {
+		checker.ProbeInterval = cfg.Health.ProbeInterval.Std()
+		checker.Probe = func(ctx context.Context, _ string) error { return probe(ctx, city) }
+		go checker.Run(ctx)
+	}
+	register(interceptServer(grpcServer,
+		appMetrics.UnaryServerInterceptor,
+		checker.UnaryServerInterceptor(provider),
+		otelgrpc.UnaryServerInterceptor(),
+		redactor.UnaryServerInterceptor,
+	))
+	// the cache may be inspected, and invalidated, via gRPC (e.g. forwarded by the api), or the admin listener, if a
+	// token is configured, see cacheadminapi.Server
+	// note: calls to the cache admin service don't affect the readiness of the provider
+	cacheAdmin := &cacheadminapi.Server{
+		Token:  string(cfg.AdminToken),
+		Caches: map[string]cache.Admin{provider: caches},
+	}
+	if cacheAdmin.Token != `` {
+		cacheadmin.RegisterCacheAdminServer(interceptServer(grpcServer,
+			appMetrics.UnaryServerInterceptor,
+			otelgrpc.UnaryServerInterceptor(),
+			redactor.UnaryServerInterceptor,
+			cacheAdmin.UnaryServerInterceptor,
+		), cacheAdmin)
+	}
+	go checker.Watch(ctx, func(_ string, ready bool) {
+		if ready {
+			healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		} else {
+			healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	})
+	lis, err := net.Listen(`tcp`, cfg.GRPC.Addr)
+	if err != nil {
+		slog.Error(`failed to listen`, `error`, err)
+		return exitFailure
+	}
+
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(appMetrics.Middleware)
+	adminRouter.Group(appMetrics.Register)
+	adminRouter.Group(checker.Register)
+	// the admin endpoints that expose, or modify, credentials require the admin token, and are disabled without it
+	if cacheAdmin.Token != `` {
+		adminRouter.Group(cacheAdmin.Register)
+		adminRouter.Group(func(r chi.Router) {
+			r.Use(cacheAdmin.Middleware)
+			r.Group(keyPools.Register)
+		})
+	}
+	admin := &http.Server{Addr: cfg.AdminAddr, Handler: adminRouter}
+
+	errCh := make(chan error, 2)
+	go func() {
+		if err := grpcServer.Serve(lis); err != nil {
+			errCh <- fmt.Errorf(`server %s: %w`, cfg.GRPC.Addr, err)
+		}
+	}()
+	go func() {
+		if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+			errCh <- fmt.Errorf(`server %s: %w`, admin.Addr, err)
+		}
+	}()
+	slog.Info(`serving`, `provider`, provider, `addr`, lis.Addr().String())
+
+	code = exitOK
+	select {
+	case <-ctx.Done():
+		slog.Info(`shutting down, draining in-flight requests`, `timeout`, cfg.ShutdownTimeout.Std())
+	case err := <-errCh:
+		slog.Error(`shutting down`, `error`, err)
+		code = exitFailure
+	}
+	stop()
+
+	// note: clients stop sending requests once the health service reports not serving, see rpc.GracefulStop
+	grpcDrained := make(chan bool, 1)
+	go func() { grpcDrained <- rpc.GracefulStop(grpcServer, healthServer, cfg.ShutdownTimeout.Std()) }()
+	drained := true
+	if !shutdown(cfg.ShutdownTimeout.Std(), []*http.Server{admin}, func() {
+		if !<-grpcDrained {
+			slog.Error(`failed to drain`, `server`, cfg.GRPC.Addr)
+			drained = false
+		}
+		backend.Close()
+	}) || !drained {
+		if code == exitOK {
+			code = exitShutdownTimeout
+		}
+	}
+	flushTracing(cfg, shutdownTracing)
+	slog.Info(`shutdown complete`)
+	return code
+}
+
+func newOpenweatherServer(cfg *config.Config, keys *apikey.Pool, client *http.Client, m *metrics.Metrics) *owapi.Server {
+	upstream := cfg.Providers.Openweather.Upstream
+	return &owapi.Server{
+		Keys:      keys,
+		BaseURL:   upstream.BaseURL,
+		Client:    client,
+		Timeout:   upstream.Timeout.Std(),
+		Precision: cfg.Providers.GeohashPrecision,
+		Wait:      upstream.Wait.Std(),
+		RateLimit: upstream.RateLimit.Std(),
+		CacheSize: upstream.CacheSize,
+		CacheTTL:  upstream.CacheTTL.Std(),
+		Metrics:   m,
+	}
+}
+
+func newWeatherstackServer(cfg *config.Config, keys *apikey.Pool, client *http.Client, m *metrics.Metrics) *wsapi.Server {
+	upstream := cfg.Providers.Weatherstack.Upstream
+	return &wsapi.Server{
+		Keys:      keys,
+		BaseURL:   upstream.BaseURL,
+		Client:    client,
+		Timeout:   upstream.Timeout.Std(),
+		Precision: cfg.Providers.GeohashPrecision,
+		Wait:      upstream.Wait.Std(),
+		RateLimit: upstream.RateLimit.Std(),
+		CacheSize: upstream.CacheSize,
+		CacheTTL:  upstream.CacheTTL.Std(),
+		Metrics:   m,
+	}
+}
+
+func rpcConfig(cfg *config.Config) rpc.Config {
+	return rpc.Config{
+		Insecure:   cfg.GRPC.Insecure,
+		CertFile:   cfg.GRPC.CertFile,
+		KeyFile:    cfg.GRPC.KeyFile,
+		CAFile:     cfg.GRPC.CAFile,
+		ServerName: cfg.GRPC.ServerName,
+	}
+}
+
+func tracingConfig(cfg *config.Config, serviceName string) tracing.Config {
+	return tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		ServiceName: serviceName,
+	}
+}
+
+// installLogger installs the default logger, see logging.Config, returning its level, which may be changed at runtime
+func installLogger(cfg *config.Config, redactor *logging.Redactor) (*slog.LevelVar, error) {
+	level := new(slog.LevelVar)
+	if v, err := logging.ParseLevel(cfg.Log.Level); err == nil {
+		level.Set(v)
+	}
+	logger, err := logging.Config{
+		Level:    level,
+		Format:   cfg.Log.Format,
+		Redactor: redactor,
+	}.New()
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return level, nil
+}
@@ -0,0 +1,358 @@
+// Package alerts evaluates client subscriptions (e.g. wind in Brisbane above 60 km/h) against provider readings,
+// delivering signed webhooks, once per breach. Subscriptions are persisted to a JSON file.
+package alerts
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Service manages subscriptions, safe for concurrent use, and a nil Service ignores all readings.
+	// See also NewService, Observe, Run, and Register.
+	Service struct {
+		// Path is the (JSON) file subscriptions are persisted to, if empty, subscriptions are kept in memory only.
+		Path string
+
+		// MaxSubscriptions bounds the number of subscriptions, defaults to DefaultMaxSubscriptions.
+		MaxSubscriptions int
+
+		// Interval is how often subscribed locations are refreshed, by Run, defaults to DefaultInterval.
+		Interval time.Duration
+
+		// MaxAttempts is the number of webhook delivery attempts, defaults to DefaultMaxAttempts.
+		MaxAttempts int
+
+		// Backoff is the delay before the first retry, doubling each attempt, defaults to DefaultBackoff.
+		Backoff time.Duration
+
+		// Client is used to call webhooks, defaults to http.DefaultClient.
+		Client *http.Client
+
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+
+		mu            sync.Mutex
+		subscriptions map[string]*Subscription
+		// lastReadTime is the latest evaluated reading, per subscription, to ignore cached readings
+		lastReadTime map[string]time.Time
+
+		// changed wakes Run, as subscriptions are created or updated, so their locations are resolved promptly
+		changed chan struct{}
+
+		closeOnce  sync.Once
+		closed     chan struct{}
+		deliveries sync.WaitGroup
+	}
+
+	// Subscription models a threshold alert, for a single location and metric.
+	Subscription struct {
+		ID string `json:"id"`
+		// City is refreshed by Run, which resolves it to the location readings are matched against.
+		City string `json:"city"`
+		// Location is the (normalized) location readings are matched against, e.g. the id of the resolved city, set
+		// by Run, and defaults to the City, until it is resolved.
+		Location  string    `json:"location,omitempty"`
+		Metric    Metric    `json:"metric"`
+		Condition Condition `json:"condition"`
+		Threshold float64   `json:"threshold"`
+		// WebhookURL receives a POST request, with a Delivery (as JSON), each time the threshold is breached.
+		WebhookURL string `json:"webhook_url"`
+		// Secret is used to sign deliveries, see SignatureHeader. It is generated if not provided, and only returned
+		// on creation.
+		Secret    string    `json:"secret,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+		// Breached is true while the threshold is breached, deliveries are only sent as it becomes true.
+		Breached bool `json:"breached"`
+		// BreachedAt is the time of the latest breach, if any.
+		BreachedAt *time.Time `json:"breached_at,omitempty"`
+	}
+
+	// Reading is a single normalized reading, note that wind speed is in km/h.
+	Reading struct {
+		Provider           string    `json:"provider"`
+		ReadTime           time.Time `json:"read_time"`
+		TemperatureDegrees float64   `json:"temperature_degrees"`
+		WindSpeed          float64   `json:"wind_speed"`
+	}
+
+	// Metric identifies the value of a Reading a subscription applies to.
+	Metric string
+
+	// Condition is the comparison applied to the threshold.
+	Condition string
+)
+
+const (
+	MetricTemperatureDegrees Metric = `temperature_degrees`
+	MetricWindSpeed          Metric = `wind_speed`
+)
+
+const (
+	ConditionAbove Condition = `above`
+	ConditionBelow Condition = `below`
+)
+
+const (
+	// DefaultMaxSubscriptions is the default value for Service.MaxSubscriptions.
+	DefaultMaxSubscriptions = 1000
+
+	// DefaultInterval is the default value for Service.Interval.
+	DefaultInterval = time.Minute
+
+	// DefaultMaxAttempts is the default value for Service.MaxAttempts.
+	DefaultMaxAttempts = 5
+
+	// DefaultBackoff is the default value for Service.Backoff.
+	DefaultBackoff = time.Second
+
+	maxBackoff = time.Minute
+)
+
+// Observe evaluates the subscriptions for the given location, e.g. the id of a resolved location, delivering webhooks
+// for new breaches. Readings which have already been evaluated (e.g. cached) are ignored. See also Run.
+func (x *Service) Observe(location string, reading Reading) {
+	if x == nil {
+		return
+	}
+	location = normalizeLocation(location)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var changed bool
+	for id, sub := range x.subscriptions {
+		if sub.location() != location {
+			continue
+		}
+		if last, ok := x.lastReadTime[id]; ok && !reading.ReadTime.After(last) {
+			continue
+		}
+		if x.lastReadTime == nil {
+			x.lastReadTime = make(map[string]time.Time)
+		}
+		x.lastReadTime[id] = reading.ReadTime
+
+		breached := sub.breached(reading)
+		if breached == sub.Breached {
+			continue
+		}
+		changed = true
+		sub.Breached = breached
+		if !breached {
+			continue
+		}
+		now := x.timeNow()
+		sub.BreachedAt = &now
+		x.deliver(*sub, newDelivery(*sub, reading, now))
+	}
+
+	if changed {
+		if err := x.save(); err != nil {
+			slog.Error(`alerts: failed to save subscriptions`, `error`, err)
+		}
+	}
+}
+
+// Run periodically calls refresh for each subscribed city, until the context is canceled, or the service is closed.
+// The refresh function is expected to fetch the current weather, which will in turn call Observe, returning the
+// location the readings were observed for, which is recorded as the Location of the corresponding subscriptions.
+// Cities are also refreshed as subscriptions are created or updated.
+func (x *Service) Run(ctx context.Context, refresh func(ctx context.Context, city string) (string, error)) {
+	ticker := time.NewTicker(x.interval())
+	defer ticker.Stop()
+	changed := x.changedCh()
+	for {
+		for _, city := range x.cities() {
+			location, err := refresh(ctx, city)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.WarnContext(ctx, `alerts: failed to refresh`, `city`, city, `error`, err)
+				}
+				continue
+			}
+			x.setLocation(city, location)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-x.closedCh():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+	}
+}
+
+// Close cancels any pending webhook retries, and waits for in-flight deliveries.
+func (x *Service) Close() {
+	if x == nil {
+		return
+	}
+	x.closeOnce.Do(func() { close(x.closedCh()) })
+	x.deliveries.Wait()
+}
+
+// cities returns the distinct subscribed cities
+func (x *Service) cities() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	seen := make(map[string]bool)
+	var cities []string
+	for _, sub := range x.subscriptions {
+		if !seen[sub.City] {
+			seen[sub.City] = true
+			cities = append(cities, sub.City)
+		}
+	}
+	return cities
+}
+
+// setLocation records the location of the subscriptions for the city, see Run
+func (x *Service) setLocation(city, location string) {
+	location = normalizeLocation(location)
+	if location == `` {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var changed bool
+	for id, sub := range x.subscriptions {
+		if sub.City != city || sub.Location == location {
+			continue
+		}
+		changed = true
+		sub.Location = location
+		delete(x.lastReadTime, id)
+	}
+
+	if changed {
+		if err := x.save(); err != nil {
+			slog.Error(`alerts: failed to save subscriptions`, `error`, err)
+		}
+	}
+}
+
+// notifyChanged wakes Run, if it is waiting, must be called with the mutex held
+func (x *Service) notifyChanged() {
+	select {
+	case x.changedChLocked() <- struct{}{}:
+	default:
+	}
+}
+
+func (x *Subscription) location() string {
+	if x.Location != `` {
+		return x.Location
+	}
+	return x.City
+}
+
+func (x *Subscription) breached(reading Reading) bool {
+	var value float64
+	switch x.Metric {
+	case MetricTemperatureDegrees:
+		value = reading.TemperatureDegrees
+	case MetricWindSpeed:
+		value = reading.WindSpeed
+	default:
+		return false
+	}
+	switch x.Condition {
+	case ConditionAbove:
+		return value > x.Threshold
+	case ConditionBelow:
+		return value < x.Threshold
+	default:
+		return false
+	}
+}
+
+func (x Metric) valid() bool {
+	switch x {
+	case MetricTemperatureDegrees, MetricWindSpeed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (x Condition) valid() bool {
+	switch x {
+	case ConditionAbove, ConditionBelow:
+		return true
+	default:
+		return false
+	}
+}
+
+func (x *Service) closedCh() chan struct{} {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.closedChLocked()
+}
+
+func (x *Service) changedCh() chan struct{} {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.changedChLocked()
+}
+
+func (x *Service) changedChLocked() chan struct{} {
+	if x.changed == nil {
+		x.changed = make(chan struct{}, 1)
+	}
+	return x.changed
+}
+
+func (x *Service) closedChLocked() chan struct{} {
+	if x.closed == nil {
+		x.closed = make(chan struct{})
+	}
+	return x.closed
+}
+
+func (x *Service) maxSubscriptions() int {
+	if x.MaxSubscriptions > 0 {
+		return x.MaxSubscriptions
+	}
+	return DefaultMaxSubscriptions
+}
+
+func (x *Service) interval() time.Duration {
+	if x.Interval > 0 {
+		return x.Interval
+	}
+	return DefaultInterval
+}
+
+func (x *Service) maxAttempts() int {
+	if x.MaxAttempts > 0 {
+		return x.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (x *Service) backoff() time.Duration {
+	if x.Backoff > 0 {
+		return x.Backoff
+	}
+	return DefaultBackoff
+}
+
+func (x *Service) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
+
+func normalizeLocation(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
@@ -0,0 +1,281 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestService_Observe(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu         sync.Mutex
+		deliveries []Delivery
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if !Verify(`secret`, b, r.Header.Get(SignatureHeader)) {
+			t.Errorf(`invalid signature: %s`, r.Header.Get(SignatureHeader))
+		}
+		var delivery Delivery
+		if err := json.Unmarshal(b, &delivery); err != nil {
+			t.Error(err)
+		}
+		if delivery.ID == `` || r.Header.Get(DeliveryHeader) != delivery.ID {
+			t.Errorf(`unexpected delivery id: %s`, r.Header.Get(DeliveryHeader))
+		}
+		mu.Lock()
+		deliveries = append(deliveries, delivery)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	now := time.Unix(1667179321, 0).UTC()
+	path := filepath.Join(t.TempDir(), `alerts.json`)
+	service, err := NewService(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.Client = webhook.Client()
+	service.TimeNow = func() time.Time { return now }
+	defer service.Close()
+
+	sub, err := service.Create(Subscription{
+		City:       `Brisbane`,
+		Metric:     MetricWindSpeed,
+		Condition:  ConditionAbove,
+		Threshold:  60,
+		WebhookURL: webhook.URL,
+		Secret:     `secret`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Create(Subscription{
+		City:       `sydney`,
+		Metric:     MetricTemperatureDegrees,
+		Condition:  ConditionBelow,
+		Threshold:  0,
+		WebhookURL: webhook.URL,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range [...]float64{50, 61, 70, 65, 40, 62} {
+		service.Observe(`brisbane `, Reading{Provider: `weatherstack`, ReadTime: now.Add(time.Minute * time.Duration(i)), TemperatureDegrees: -5, WindSpeed: v})
+		// cached readings are ignored
+		service.Observe(`BRISBANE`, Reading{Provider: `weatherstack`, ReadTime: now, WindSpeed: 100})
+	}
+	service.deliveries.Wait()
+
+	mu.Lock()
+	// note: deliveries are asynchronous
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].Value < deliveries[j].Value })
+	if len(deliveries) != 2 ||
+		deliveries[0].Event != EventBreached ||
+		deliveries[0].Value != 61 ||
+		deliveries[0].Subscription.ID != sub.ID ||
+		deliveries[0].Subscription.Secret != `` ||
+		deliveries[0].Reading.ReadTime != now.Add(time.Minute) ||
+		deliveries[1].Value != 62 ||
+		deliveries[0].ID == deliveries[1].ID {
+		t.Errorf(`unexpected deliveries: %+v`, deliveries)
+	}
+	mu.Unlock()
+
+	// the state is persisted, so breaches aren't delivered again, after a restart
+	service, err = NewService(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := service.Get(sub.ID); err != nil || !v.Breached || v.BreachedAt == nil || v.Secret != `` {
+		t.Errorf(`unexpected subscription: %+v %v`, v, err)
+	}
+	if v := service.List(); len(v) != 2 || v[0].Secret != `` || v[1].Secret != `` {
+		t.Errorf(`unexpected subscriptions: %+v`, v)
+	}
+}
+
+func TestService_Observe_retry(t *testing.T) {
+	t.Parallel()
+
+	var (
+		calls int32
+		ids   sync.Map
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids.Store(r.Header.Get(DeliveryHeader), true)
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 3:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer webhook.Close()
+
+	service := Service{Client: webhook.Client(), Backoff: time.Millisecond, MaxAttempts: 3}
+	defer service.Close()
+
+	for _, city := range [...]string{`brisbane`, `sydney`} {
+		if _, err := service.Create(Subscription{
+			City:       city,
+			Metric:     MetricTemperatureDegrees,
+			Condition:  ConditionAbove,
+			Threshold:  30,
+			WebhookURL: webhook.URL,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Unix(1667179321, 0)
+	service.Observe(`brisbane`, Reading{ReadTime: now, TemperatureDegrees: 35})
+	service.deliveries.Wait()
+	if v := atomic.LoadInt32(&calls); v != 3 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+	var n int
+	ids.Range(func(key, value any) bool { n++; return true })
+	if n != 1 {
+		t.Errorf(`expected a single delivery id, got %d`, n)
+	}
+
+	// client errors aren't retried
+	service.Observe(`sydney`, Reading{ReadTime: now, TemperatureDegrees: 35})
+	service.deliveries.Wait()
+	if v := atomic.LoadInt32(&calls); v != 4 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+}
+
+func TestService_Run(t *testing.T) {
+	t.Parallel()
+
+	service := Service{Interval: time.Millisecond}
+	for _, city := range [...]string{`brisbane`, `Brisbane`, `sydney`} {
+		if _, err := service.Create(Subscription{
+			City:       city,
+			Metric:     MetricTemperatureDegrees,
+			Condition:  ConditionAbove,
+			WebhookURL: `http://localhost`,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]int)
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.Run(ctx, func(ctx context.Context, city string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[city]++
+			if calls[`brisbane`] == 3 {
+				service.Close()
+			}
+			if city == `sydney` {
+				return ``, errors.New(`some error`)
+			}
+			return `Geonames:2174003`, nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal(`timed out`)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 2 || calls[`brisbane`] != 3 || calls[`sydney`] < 2 {
+		t.Errorf(`unexpected calls: %v`, calls)
+	}
+
+	// subscriptions are matched by the resolved location, if any
+	for _, sub := range service.List() {
+		if expected := map[string]string{`brisbane`: `geonames:2174003`, `sydney`: ``}[sub.City]; sub.Location != expected {
+			t.Errorf(`unexpected location: %+v`, sub)
+		}
+	}
+	service.Observe(`brisbane`, Reading{ReadTime: time.Now(), TemperatureDegrees: 1})
+	service.Observe(`geonames:2174003`, Reading{ReadTime: time.Now(), TemperatureDegrees: 1})
+	service.Observe(`sydney`, Reading{ReadTime: time.Now(), TemperatureDegrees: 1})
+	var breached int
+	for _, sub := range service.List() {
+		if sub.Breached {
+			breached++
+		}
+	}
+	if breached != 3 {
+		t.Errorf(`unexpected breached: %d`, breached)
+	}
+}
+
+func TestService_Run_changed(t *testing.T) {
+	t.Parallel()
+
+	// note: the interval is long enough that only creation triggers a refresh
+	service := Service{Interval: time.Hour}
+	defer service.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	refreshed := make(chan string, 1)
+	go service.Run(ctx, func(ctx context.Context, city string) (string, error) {
+		refreshed <- city
+		return `geonames:2147714`, nil
+	})
+
+	if _, err := service.Create(Subscription{City: `Sydney`, Metric: MetricWindSpeed, Condition: ConditionAbove, WebhookURL: `https://example.com`}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case city := <-refreshed:
+		if city != `sydney` {
+			t.Errorf(`unexpected city: %s`, city)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal(`timed out`)
+	}
+}
+
+func TestService_Create_limit(t *testing.T) {
+	t.Parallel()
+	service := Service{MaxSubscriptions: 1}
+	sub := Subscription{City: `sydney`, Metric: MetricWindSpeed, Condition: ConditionAbove, WebhookURL: `https://example.com`}
+	if _, err := service.Create(sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Create(sub); err != ErrLimitExceeded {
+		t.Error(err)
+	}
+}
+
+func TestService_nil(t *testing.T) {
+	t.Parallel()
+	var service *Service
+	service.Observe(`sydney`, Reading{ReadTime: time.Now()})
+	service.Close()
+}
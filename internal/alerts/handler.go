@@ -0,0 +1,112 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+)
+
+// Register wires up the subscription endpoints, under /v1/alerts.
+func (x *Service) Register(r chi.Router) {
+	r.Get(`/v1/alerts`, x.listSubscriptions)
+	r.Post(`/v1/alerts`, x.createSubscription)
+	r.Get(`/v1/alerts/{id}`, x.getSubscription)
+	r.Put(`/v1/alerts/{id}`, x.updateSubscription)
+	r.Delete(`/v1/alerts/{id}`, x.deleteSubscription)
+}
+
+func (x *Service) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, x.List())
+}
+
+func (x *Service) createSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, ok := decodeSubscription(w, r)
+	if !ok {
+		return
+	}
+	sub, err := x.Create(sub)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func (x *Service) getSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, err := x.Get(chi.URLParam(r, `id`))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+func (x *Service) updateSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, ok := decodeSubscription(w, r)
+	if !ok {
+		return
+	}
+	sub, err := x.Update(chi.URLParam(r, `id`), sub)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sub)
+}
+
+func (x *Service) deleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := x.Delete(chi.URLParam(r, `id`)); err != nil {
+		writeServiceError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeSubscription(w http.ResponseWriter, r *http.Request) (Subscription, bool) {
+	var sub Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		writeError(w, http.StatusBadRequest, status.Error(codes.InvalidArgument, `invalid request body`))
+		return Subscription{}, false
+	}
+	return sub, true
+}
+
+func writeServiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, status.Error(codes.NotFound, err.Error()))
+	case errors.Is(err, ErrLimitExceeded):
+		writeError(w, http.StatusTooManyRequests, status.Error(codes.ResourceExhausted, err.Error()))
+	case errors.As(err, new(validationError)):
+		writeError(w, http.StatusBadRequest, status.Error(codes.InvalidArgument, err.Error()))
+	default:
+		writeError(w, http.StatusInternalServerError, status.Error(codes.Internal, err.Error()))
+	}
+}
+
+// writeError writes a google.rpc.Status, consistent with the other /v1 endpoints
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	sts, _ := status.FromError(err)
+	b, err := protojson.Marshal(sts.Proto())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// note: compacts the (intentionally unstable) protojson output
+	writeJSON(w, statusCode, json.RawMessage(b))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
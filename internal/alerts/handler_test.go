@@ -0,0 +1,75 @@
+package alerts
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_Register(t *testing.T) {
+	t.Parallel()
+
+	service := Service{TimeNow: func() time.Time { return time.Unix(1667179321, 0) }}
+	router := chi.NewRouter()
+	router.Route(`/`, service.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(b)
+	}
+
+	status, body := do(http.MethodPost, `/v1/alerts`, `{"city":" Brisbane","metric":"wind_speed","condition":"above","threshold":60,"webhook_url":"https://example.com/hook"}`)
+	var created Subscription
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusCreated || created.ID == `` || len(created.Secret) != 64 || created.City != `brisbane` {
+		t.Fatalf(`unexpected response: %d %s`, status, body)
+	}
+
+	expected := `{"id":"` + created.ID + `","city":"brisbane","metric":"wind_speed","condition":"above","threshold":60,"webhook_url":"https://example.com/hook","created_at":"2022-10-31T01:22:01Z","breached":false}`
+	for _, tc := range [...]struct {
+		method string
+		path   string
+		body   string
+		status int
+		res    string
+	}{
+		{http.MethodGet, `/v1/alerts`, ``, http.StatusOK, `[` + expected + `]`},
+		{http.MethodGet, `/v1/alerts/` + created.ID, ``, http.StatusOK, expected},
+		{http.MethodGet, `/v1/alerts/unknown`, ``, http.StatusNotFound, `{"code":5,"message":"subscription not found"}`},
+		{http.MethodPost, `/v1/alerts`, `{`, http.StatusBadRequest, `{"code":3,"message":"invalid request body"}`},
+		{http.MethodPost, `/v1/alerts`, `{"metric":"wind_speed","condition":"above","webhook_url":"https://example.com"}`, http.StatusBadRequest, `{"code":3,"message":"city required"}`},
+		{http.MethodPost, `/v1/alerts`, `{"city":"sydney","metric":"humidity","condition":"above","webhook_url":"https://example.com"}`, http.StatusBadRequest, `{"code":3,"message":"unknown metric \"humidity\""}`},
+		{http.MethodPost, `/v1/alerts`, `{"city":"sydney","metric":"wind_speed","condition":"equals","webhook_url":"https://example.com"}`, http.StatusBadRequest, `{"code":3,"message":"unknown condition \"equals\""}`},
+		{http.MethodPost, `/v1/alerts`, `{"city":"sydney","metric":"wind_speed","condition":"above","webhook_url":"ftp://example.com"}`, http.StatusBadRequest, `{"code":3,"message":"invalid webhook url \"ftp://example.com\""}`},
+		{http.MethodPut, `/v1/alerts/` + created.ID, `{"city":"brisbane","metric":"wind_speed","condition":"above","threshold":80,"webhook_url":"https://example.com/hook"}`, http.StatusOK, strings.Replace(expected, `:60,`, `:80,`, 1)},
+		{http.MethodPut, `/v1/alerts/unknown`, `{"city":"brisbane","metric":"wind_speed","condition":"above","webhook_url":"https://example.com/hook"}`, http.StatusNotFound, `{"code":5,"message":"subscription not found"}`},
+		{http.MethodDelete, `/v1/alerts/` + created.ID, ``, http.StatusNoContent, ``},
+		{http.MethodDelete, `/v1/alerts/` + created.ID, ``, http.StatusNotFound, `{"code":5,"message":"subscription not found"}`},
+		{http.MethodGet, `/v1/alerts`, ``, http.StatusOK, `[]`},
+	} {
+		if status, body := do(tc.method, tc.path, tc.body); status != tc.status || body != tc.res {
+			t.Errorf("unexpected response to %s %s: %d %s", tc.method, tc.path, status, body)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type (
+	storeFile struct {
+		Subscriptions []*Subscription `json:"subscriptions"`
+	}
+
+	// validationError indicates an invalid subscription
+	validationError struct{ error }
+)
+
+var (
+	// ErrNotFound indicates a subscription does not exist.
+	ErrNotFound = errors.New(`subscription not found`)
+
+	// ErrLimitExceeded indicates MaxSubscriptions has been reached.
+	ErrLimitExceeded = errors.New(`subscription limit exceeded`)
+)
+
+// NewService initialises a service, loading any existing subscriptions from the given file, which will be created
+// once the first subscription is added.
+func NewService(path string) (*Service, error) {
+	x := &Service{Path: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return x, nil
+		}
+		return nil, err
+	}
+	var file storeFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf(`invalid subscriptions file %s: %w`, path, err)
+	}
+	x.subscriptions = make(map[string]*Subscription, len(file.Subscriptions))
+	for _, sub := range file.Subscriptions {
+		if sub == nil || sub.ID == `` {
+			return nil, fmt.Errorf(`invalid subscriptions file %s: missing id`, path)
+		}
+		x.subscriptions[sub.ID] = sub
+	}
+	return x, nil
+}
+
+// List returns all subscriptions, ordered by creation time, without secrets.
+func (x *Service) List() []Subscription {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	subscriptions := make([]Subscription, 0, len(x.subscriptions))
+	for _, sub := range x.subscriptions {
+		subscriptions = append(subscriptions, sub.redacted())
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+	return subscriptions
+}
+
+// Get returns the subscription with the given id, without the secret.
+func (x *Service) Get(id string) (Subscription, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	sub := x.subscriptions[id]
+	if sub == nil {
+		return Subscription{}, ErrNotFound
+	}
+	return sub.redacted(), nil
+}
+
+// Create validates and adds a new subscription, generating the id, and the secret (if not set), returning the
+// subscription, including the secret.
+func (x *Service) Create(sub Subscription) (Subscription, error) {
+	if err := sub.normalize(); err != nil {
+		return Subscription{}, err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(x.subscriptions) >= x.maxSubscriptions() {
+		return Subscription{}, ErrLimitExceeded
+	}
+
+	var err error
+	if sub.ID, err = randomHex(16); err != nil {
+		return Subscription{}, err
+	}
+	if sub.Secret == `` {
+		if sub.Secret, err = randomHex(32); err != nil {
+			return Subscription{}, err
+		}
+	}
+	sub.CreatedAt = x.timeNow().UTC()
+	sub.Breached = false
+	sub.BreachedAt = nil
+
+	// note: the location is resolved for all subscriptions to the city, by Run
+	for _, other := range x.subscriptions {
+		if other.City == sub.City {
+			sub.Location = other.Location
+			break
+		}
+	}
+
+	if x.subscriptions == nil {
+		x.subscriptions = make(map[string]*Subscription)
+	}
+	x.subscriptions[sub.ID] = &sub
+	if err := x.save(); err != nil {
+		delete(x.subscriptions, sub.ID)
+		return Subscription{}, err
+	}
+	x.notifyChanged()
+
+	return sub, nil
+}
+
+// Update validates and replaces an existing subscription, retaining the id, creation time, and (if not set) the
+// secret. The breach state is reset, if the criteria changed.
+func (x *Service) Update(id string, sub Subscription) (Subscription, error) {
+	if err := sub.normalize(); err != nil {
+		return Subscription{}, err
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	old := x.subscriptions[id]
+	if old == nil {
+		return Subscription{}, ErrNotFound
+	}
+
+	sub.ID = old.ID
+	sub.CreatedAt = old.CreatedAt
+	if sub.Secret == `` {
+		sub.Secret = old.Secret
+	}
+	if sub.City == old.City {
+		sub.Location = old.Location
+	}
+	if sub.City == old.City && sub.Metric == old.Metric && sub.Condition == old.Condition && sub.Threshold == old.Threshold {
+		sub.Breached, sub.BreachedAt = old.Breached, old.BreachedAt
+	} else {
+		sub.Breached, sub.BreachedAt = false, nil
+		delete(x.lastReadTime, id)
+	}
+
+	x.subscriptions[id] = &sub
+	if err := x.save(); err != nil {
+		x.subscriptions[id] = old
+		return Subscription{}, err
+	}
+	if sub.City != old.City {
+		x.notifyChanged()
+	}
+
+	return sub.redacted(), nil
+}
+
+// Delete removes a subscription.
+func (x *Service) Delete(id string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	old := x.subscriptions[id]
+	if old == nil {
+		return ErrNotFound
+	}
+
+	delete(x.subscriptions, id)
+	if err := x.save(); err != nil {
+		x.subscriptions[id] = old
+		return err
+	}
+	delete(x.lastReadTime, id)
+
+	return nil
+}
+
+// save writes all subscriptions to Path (if set), atomically, must be called with the mutex held
+func (x *Service) save() error {
+	if x.Path == `` {
+		return nil
+	}
+
+	file := storeFile{Subscriptions: make([]*Subscription, 0, len(x.subscriptions))}
+	for _, sub := range x.subscriptions {
+		file.Subscriptions = append(file.Subscriptions, sub)
+	}
+	sort.Slice(file.Subscriptions, func(i, j int) bool { return file.Subscriptions[i].ID < file.Subscriptions[j].ID })
+
+	b, err := json.MarshalIndent(&file, ``, `  `)
+	if err != nil {
+		return err
+	}
+
+	// note: the file contains secrets
+	tmp, err := os.CreateTemp(filepath.Dir(x.Path), filepath.Base(x.Path)+`.*.tmp`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), x.Path)
+}
+
+// normalize validates the client-provided fields
+func (x *Subscription) normalize() error {
+	x.City = normalizeLocation(x.City)
+	// note: set by Run
+	x.Location = ``
+	if x.City == `` {
+		return validationError{errors.New(`city required`)}
+	}
+	if !x.Metric.valid() {
+		return validationError{fmt.Errorf(`unknown metric %q`, x.Metric)}
+	}
+	if !x.Condition.valid() {
+		return validationError{fmt.Errorf(`unknown condition %q`, x.Condition)}
+	}
+	if u, err := url.Parse(x.WebhookURL); err != nil || (u.Scheme != `http` && u.Scheme != `https`) || u.Host == `` {
+		return validationError{fmt.Errorf(`invalid webhook url %q`, x.WebhookURL)}
+	}
+	return nil
+}
+
+func (x *Subscription) redacted() Subscription {
+	sub := *x
+	sub.Secret = ``
+	return sub
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return ``, err
+	}
+	return hex.EncodeToString(b), nil
+}
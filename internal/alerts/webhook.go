@@ -0,0 +1,143 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type (
+	// Delivery is the body of webhook requests.
+	Delivery struct {
+		// ID is unique per breach, and is retained across retries, so receivers may deduplicate.
+		ID           string       `json:"id"`
+		Event        string       `json:"event"`
+		Time         time.Time    `json:"time"`
+		Subscription Subscription `json:"subscription"`
+		Value        float64      `json:"value"`
+		Reading      Reading      `json:"reading"`
+	}
+
+	// errPermanent indicates a delivery should not be retried
+	errPermanent struct{ error }
+)
+
+const (
+	// EventBreached is the only Delivery.Event, at present.
+	EventBreached = `breached`
+
+	// SignatureHeader contains the hex encoded HMAC-SHA256 of the request body, using the subscription's secret,
+	// prefixed by `sha256=`.
+	SignatureHeader = `X-Weather-Signature-256`
+
+	// DeliveryHeader contains Delivery.ID.
+	DeliveryHeader = `X-Weather-Delivery`
+
+	webhookTimeout = time.Second * 10
+)
+
+// Sign returns the value of SignatureHeader, for the given secret and body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return `sha256=` + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if signature is the valid value of SignatureHeader, for the given secret and body.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func newDelivery(sub Subscription, reading Reading, now time.Time) Delivery {
+	delivery := Delivery{
+		Event:        EventBreached,
+		Time:         now.UTC(),
+		Subscription: sub.redacted(),
+		Reading:      reading,
+	}
+	delivery.Reading.ReadTime = delivery.Reading.ReadTime.UTC()
+	switch sub.Metric {
+	case MetricTemperatureDegrees:
+		delivery.Value = reading.TemperatureDegrees
+	case MetricWindSpeed:
+		delivery.Value = reading.WindSpeed
+	}
+	return delivery
+}
+
+// deliver calls the webhook asynchronously, retrying with exponential backoff, must be called with the mutex held
+func (x *Service) deliver(sub Subscription, delivery Delivery) {
+	var err error
+	if delivery.ID, err = randomHex(16); err != nil {
+		slog.Error(`alerts: delivery failed`, `subscription`, sub.ID, `error`, err)
+		return
+	}
+	body, err := json.Marshal(&delivery)
+	if err != nil {
+		slog.Error(`alerts: delivery failed`, `subscription`, sub.ID, `error`, err)
+		return
+	}
+	closed := x.closedChLocked()
+	x.deliveries.Add(1)
+	go func() {
+		defer x.deliveries.Done()
+		backoff := x.backoff()
+		for attempt := 1; ; attempt++ {
+			err := x.callWebhook(sub, delivery.ID, body)
+			if err == nil {
+				return
+			}
+			if _, ok := err.(errPermanent); ok || attempt >= x.maxAttempts() {
+				slog.Error(`alerts: delivery failed`, `delivery`, delivery.ID, `subscription`, sub.ID, `attempts`, attempt, `error`, err)
+				return
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-closed:
+				timer.Stop()
+				slog.Warn(`alerts: delivery canceled`, `delivery`, delivery.ID, `subscription`, sub.ID, `error`, err)
+				return
+			case <-timer.C:
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}()
+}
+
+func (x *Service) callWebhook(sub Subscription, id string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return errPermanent{err}
+	}
+	req.Header.Set(`Content-Type`, `application/json`)
+	req.Header.Set(DeliveryHeader, id)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, body))
+	client := x.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf(`unexpected status code %d`, res.StatusCode)
+	default:
+		return errPermanent{fmt.Errorf(`unexpected status code %d`, res.StatusCode)}
+	}
+}
@@ -0,0 +1,312 @@
+// Package clientauth authenticates the clients of the public api, using API keys, enforcing the request rate, and
+// daily quota, of each client's plan, and accounting for their usage, e.g. for billing. See Authenticator.
+package clientauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Client is a consumer of the public api, identified by its API key.
+	Client struct {
+		// ID identifies the client, e.g. for billing, and is reported in place of the key.
+		ID string `json:"id"`
+
+		// Key is presented by the client, via the KeyHeader, or the KeyParam query parameter.
+		Key string `json:"key"`
+
+		Plan Plan `json:"plan"`
+	}
+
+	// Plan models the limits of a client, each of which is unlimited if not positive.
+	Plan struct {
+		// Name identifies the plan, e.g. for billing, optional.
+		Name string `json:"name,omitempty"`
+
+		// RequestsPerSecond is the sustained request rate.
+		RequestsPerSecond float64 `json:"requests_per_second,omitempty"`
+
+		// Burst is the number of requests that may be made at once, defaults to RequestsPerSecond, rounded up.
+		Burst int `json:"burst,omitempty"`
+
+		// RequestsPerDay is the quota of requests per (UTC) day.
+		RequestsPerDay int64 `json:"requests_per_day,omitempty"`
+	}
+
+	// Authenticator authenticates requests, using the Store, enforcing the limits of each client's plan, and
+	// accounting for their usage, safe for concurrent use. See also Middleware, and Register.
+	//
+	// Limits are enforced per process, i.e. each replica enforces the rate, and quota, independently. Usage is
+	// tracked in memory, and may be persisted to UsagePath, restored on start, see Load, Save, and Run.
+	Authenticator struct {
+		// Store looks up clients by API key.
+		Store Store
+
+		// UsageRetention is the number of days (including the current day) that usage is reported for, defaults
+		// to DefaultUsageRetention.
+		UsageRetention int
+
+		// UsagePath is the (JSON) file usage is persisted to, by Save, and restored from, by Load, optional.
+		UsagePath string
+
+		// SaveInterval is the interval Run saves usage, defaults to DefaultSaveInterval.
+		SaveInterval time.Duration
+
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+
+		saveMu sync.Mutex
+		mu     sync.Mutex
+		// clients are keyed by id
+		clients map[string]*clientState
+		// dirty indicates usage has changed since the last save
+		dirty bool
+	}
+
+	// Usage models the requests made by a client, since the usage was first recorded, see Authenticator.UsagePath.
+	Usage struct {
+		Client string `json:"client"`
+		Plan   string `json:"plan,omitempty"`
+		// Requests is the number of requests that were allowed, i.e. that count against the quota.
+		Requests uint64 `json:"requests"`
+		// RateLimited is the number of requests rejected as they exceeded the request rate.
+		RateLimited uint64 `json:"rate_limited"`
+		// QuotaExceeded is the number of requests rejected as they exceeded the daily quota.
+		QuotaExceeded uint64 `json:"quota_exceeded"`
+		// Days is the usage of each (UTC) day, the oldest first, bounded by Authenticator.UsageRetention.
+		Days []DailyUsage `json:"days"`
+	}
+
+	// DailyUsage models the requests made by a client, on a single (UTC) day.
+	DailyUsage struct {
+		// Date is formatted as YYYY-MM-DD.
+		Date          string `json:"date"`
+		Requests      uint64 `json:"requests"`
+		RateLimited   uint64 `json:"rate_limited"`
+		QuotaExceeded uint64 `json:"quota_exceeded"`
+	}
+
+	clientState struct {
+		plan Plan
+		// limiter is nil if the request rate is unlimited
+		limiter *rate.Limiter
+		usage   Usage
+	}
+
+	contextKeyClient struct{}
+)
+
+const (
+	// KeyHeader is the header the API key is presented via, which takes precedence over KeyParam.
+	KeyHeader = `X-API-Key`
+
+	// KeyParam is the query parameter the API key may be presented via, e.g. where headers can't be set.
+	KeyParam = `api_key`
+
+	// DefaultUsageRetention is the default value for Authenticator.UsageRetention.
+	DefaultUsageRetention = 31
+
+	dateLayout = `2006-01-02`
+)
+
+// Key returns the API key presented by the request, via the KeyHeader, or the KeyParam query parameter.
+func Key(r *http.Request) string {
+	if v := r.Header.Get(KeyHeader); v != `` {
+		return v
+	}
+	return r.URL.Query().Get(KeyParam)
+}
+
+// WithClient returns a context with the authenticated client, see FromContext.
+func WithClient(ctx context.Context, client *Client) context.Context {
+	return context.WithValue(ctx, contextKeyClient{}, client)
+}
+
+// FromContext returns the client authenticated by Middleware, or nil.
+func FromContext(ctx context.Context) *Client {
+	client, _ := ctx.Value(contextKeyClient{}).(*Client)
+	return client
+}
+
+// Middleware rejects requests that don't present a valid API key (401), or that exceed the limits of the client's
+// plan (429), in which case the response includes a google.rpc.RetryInfo, and the Retry-After header. Allowed
+// requests count against the client's quota, and the client is attached to the context, see FromContext.
+func (x *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := Key(r)
+		if key == `` {
+			writeError(w, http.StatusUnauthorized, status.Error(codes.Unauthenticated, `api key required`))
+			return
+		}
+		client, err := x.Store.Client(r.Context(), key)
+		if errors.Is(err, ErrUnknownKey) {
+			writeError(w, http.StatusUnauthorized, status.Error(codes.Unauthenticated, `invalid api key`))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, status.Error(codes.Unavailable, `api key store unavailable`))
+			return
+		}
+		if retryDelay, violation := x.allow(client); violation != nil {
+			// note: Retry-After is whole seconds, rounded up, such that clients don't retry early
+			w.Header().Set(`Retry-After`, strconv.Itoa(int(math.Ceil(retryDelay.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, newLimitError(retryDelay, violation))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithClient(r.Context(), client)))
+	})
+}
+
+// Usage returns the usage of each client that has made a request, sorted by client.
+func (x *Authenticator) Usage() []Usage {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	res := make([]Usage, 0, len(x.clients))
+	for _, state := range x.clients {
+		res = append(res, state.snapshot())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Client < res[j].Client })
+	return res
+}
+
+// ClientUsage returns the usage of a single client, or false if it hasn't made a request.
+func (x *Authenticator) ClientUsage(client string) (Usage, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	state := x.clients[client]
+	if state == nil {
+		return Usage{}, false
+	}
+	return state.snapshot(), true
+}
+
+// allow records the request, returning the delay after which it may be retried, and the violated limit, if it
+// exceeded the limits of the client's plan
+func (x *Authenticator) allow(client *Client) (time.Duration, *errdetails.QuotaFailure_Violation) {
+	now := x.timeNow()
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	state := x.state(client, now)
+	day := state.day(now, x.usageRetention())
+	x.dirty = true
+
+	if limit := client.Plan.RequestsPerDay; limit > 0 && day.Requests >= uint64(limit) {
+		day.QuotaExceeded++
+		state.usage.QuotaExceeded++
+		tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return tomorrow.Sub(now), &errdetails.QuotaFailure_Violation{
+			Subject:     `client:` + client.ID,
+			Description: fmt.Sprintf(`daily quota of %d requests exceeded`, limit),
+		}
+	}
+
+	if state.limiter != nil {
+		reservation := state.limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			day.RateLimited++
+			state.usage.RateLimited++
+			return delay, &errdetails.QuotaFailure_Violation{
+				Subject:     `client:` + client.ID,
+				Description: fmt.Sprintf(`rate limit of %g requests per second exceeded`, client.Plan.RequestsPerSecond),
+			}
+		}
+	}
+
+	day.Requests++
+	state.usage.Requests++
+	return 0, nil
+}
+
+// state returns the state of the client, applying any changes to its plan, must be called with the mutex held
+func (x *Authenticator) state(client *Client, now time.Time) *clientState {
+	if x.clients == nil {
+		x.clients = make(map[string]*clientState)
+	}
+	state := x.clients[client.ID]
+	if state == nil {
+		state = &clientState{usage: Usage{Client: client.ID}}
+		x.clients[client.ID] = state
+	} else if state.plan == client.Plan {
+		return state
+	}
+	state.plan = client.Plan
+	state.usage.Plan = client.Plan.Name
+	if limit := client.Plan.RequestsPerSecond; limit <= 0 {
+		state.limiter = nil
+	} else if burst := client.Plan.burst(); state.limiter == nil {
+		state.limiter = rate.NewLimiter(rate.Limit(limit), burst)
+	} else {
+		// note: retains the tokens of the previous plan
+		state.limiter.SetLimitAt(now, rate.Limit(limit))
+		state.limiter.SetBurstAt(now, burst)
+	}
+	return state
+}
+
+func (x *Authenticator) usageRetention() int {
+	if x.UsageRetention > 0 {
+		return x.UsageRetention
+	}
+	return DefaultUsageRetention
+}
+
+func (x *Authenticator) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
+
+// day returns the usage of the current day, discarding any days beyond the retention
+func (x *clientState) day(now time.Time, retention int) *DailyUsage {
+	date := now.UTC().Format(dateLayout)
+	if n := len(x.usage.Days); n != 0 && x.usage.Days[n-1].Date == date {
+		return &x.usage.Days[n-1]
+	}
+	x.usage.Days = append(x.usage.Days, DailyUsage{Date: date})
+	oldest := now.UTC().AddDate(0, 0, 1-retention).Format(dateLayout)
+	for len(x.usage.Days) != 0 && x.usage.Days[0].Date < oldest {
+		x.usage.Days = x.usage.Days[1:]
+	}
+	return &x.usage.Days[len(x.usage.Days)-1]
+}
+
+func (x *clientState) snapshot() Usage {
+	usage := x.usage
+	usage.Days = append([]DailyUsage{}, usage.Days...)
+	return usage
+}
+
+func (x Plan) burst() int {
+	if x.Burst > 0 {
+		return x.Burst
+	}
+	return int(math.Ceil(x.RequestsPerSecond))
+}
+
+func newLimitError(retryDelay time.Duration, violation *errdetails.QuotaFailure_Violation) error {
+	sts, err := status.New(codes.ResourceExhausted, violation.GetDescription()).WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(retryDelay)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{violation}},
+	)
+	if err != nil {
+		panic(fmt.Errorf(`clientauth: limit error details: %w`, err))
+	}
+	return sts.Err()
+}
@@ -0,0 +1,231 @@
+package clientauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type storeFunc func(ctx context.Context, key string) (*Client, error)
+
+func (f storeFunc) Client(ctx context.Context, key string) (*Client, error) { return f(ctx, key) }
+
+func newClients(clients ...*Client) storeFunc {
+	return func(ctx context.Context, key string) (*Client, error) {
+		for _, client := range clients {
+			if client.Key == key {
+				return client, nil
+			}
+		}
+		return nil, ErrUnknownKey
+	}
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 10, 31, 23, 59, 58, 0, time.UTC)
+	acme := &Client{ID: `acme`, Key: `acme-key`, Plan: Plan{Name: `pro`, RequestsPerSecond: 2, RequestsPerDay: 3}}
+	free := &Client{ID: `free`, Key: `free-key`}
+	authenticator := Authenticator{
+		Store: storeFunc(func(ctx context.Context, key string) (*Client, error) {
+			if key == `broken` {
+				return nil, errors.New(`some error`)
+			}
+			return newClients(acme, free)(ctx, key)
+		}),
+		TimeNow: func() time.Time { return now },
+	}
+	handler := authenticator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := FromContext(r.Context())
+		if client == nil {
+			t.Error(`expected a client`)
+			return
+		}
+		_, _ = w.Write([]byte(client.ID))
+	}))
+
+	request := func(header, param string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, `/v1/weather?city=sydney`, nil)
+		if header != `` {
+			r.Header.Set(KeyHeader, header)
+		}
+		if param != `` {
+			r.URL.RawQuery += `&` + KeyParam + `=` + param
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	check := func(w *httptest.ResponseRecorder, code int, body string) {
+		t.Helper()
+		if w.Code != code || (body != `` && w.Body.String() != body) {
+			t.Errorf(`unexpected response: %d %s`, w.Code, w.Body.String())
+		}
+	}
+	limited := func(w *httptest.ResponseRecorder, retryAfter string, retryDelay time.Duration) {
+		t.Helper()
+		check(w, http.StatusTooManyRequests, ``)
+		if v := w.Header().Get(`Retry-After`); v != retryAfter {
+			t.Errorf(`unexpected retry after: %s`, v)
+		}
+		var sts statuspb.Status
+		if err := protojson.Unmarshal(w.Body.Bytes(), &sts); err != nil {
+			t.Fatal(err)
+		}
+		var retryInfo errdetails.RetryInfo
+		if sts.GetCode() != int32(codes.ResourceExhausted) || len(sts.GetDetails()) != 2 || sts.GetDetails()[0].UnmarshalTo(&retryInfo) != nil {
+			t.Fatalf(`unexpected status: %v`, &sts)
+		}
+		if v := retryInfo.GetRetryDelay().AsDuration(); v != retryDelay {
+			t.Errorf(`unexpected retry delay: %s`, v)
+		}
+	}
+
+	check(request(``, ``), http.StatusUnauthorized, `{"code":16,"message":"api key required"}`)
+	check(request(`nope`, ``), http.StatusUnauthorized, `{"code":16,"message":"invalid api key"}`)
+	check(request(`broken`, ``), http.StatusServiceUnavailable, `{"code":14,"message":"api key store unavailable"}`)
+
+	// the header takes precedence over the query parameter
+	check(request(`acme-key`, `nope`), http.StatusOK, `acme`)
+	check(request(``, `acme-key`), http.StatusOK, `acme`)
+	limited(request(`acme-key`, ``), `1`, time.Second/2)
+	now = now.Add(time.Second / 2)
+	check(request(`acme-key`, ``), http.StatusOK, `acme`)
+	now = now.Add(time.Second / 2)
+	limited(request(`acme-key`, ``), `1`, time.Second)
+	limited(request(`acme-key`, ``), `1`, time.Second)
+	check(request(`free-key`, ``), http.StatusOK, `free`)
+
+	// the quota resets at midnight (UTC)
+	now = now.Add(time.Second)
+	check(request(`acme-key`, ``), http.StatusOK, `acme`)
+	now = now.Add(time.Second / 2)
+	check(request(`acme-key`, ``), http.StatusOK, `acme`)
+
+	if v, ok := authenticator.ClientUsage(`acme`); !ok || v.Plan != `pro` || v.Requests != 5 || v.RateLimited != 1 || v.QuotaExceeded != 2 ||
+		len(v.Days) != 2 || v.Days[0] != (DailyUsage{Date: `2022-10-31`, Requests: 3, RateLimited: 1, QuotaExceeded: 2}) ||
+		v.Days[1] != (DailyUsage{Date: `2022-11-01`, Requests: 2}) {
+		b, _ := json.Marshal(v)
+		t.Errorf(`unexpected usage: %s`, b)
+	}
+	if v := authenticator.Usage(); len(v) != 2 || v[0].Client != `acme` || v[1].Client != `free` || v[1].Requests != 1 {
+		t.Errorf(`unexpected usage: %+v`, v)
+	}
+	if _, ok := authenticator.ClientUsage(`nope`); ok {
+		t.Error(`expected no usage`)
+	}
+}
+
+func TestAuthenticator_allow(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	client := &Client{ID: `acme`, Key: `acme-key`, Plan: Plan{RequestsPerSecond: 0.5, Burst: 2}}
+	authenticator := Authenticator{
+		Store:          newClients(client),
+		UsageRetention: 2,
+		TimeNow:        func() time.Time { return now },
+	}
+
+	for i, expected := range [...]time.Duration{0, 0, time.Second * 2} {
+		if delay, violation := authenticator.allow(client); delay != expected || (violation == nil) != (expected == 0) {
+			t.Errorf(`%d: unexpected result: %s %v`, i, delay, violation)
+		}
+	}
+	if _, violation := authenticator.allow(client); violation.GetSubject() != `client:acme` ||
+		violation.GetDescription() != `rate limit of 0.5 requests per second exceeded` {
+		t.Errorf(`unexpected violation: %v`, violation)
+	}
+
+	// plan changes apply immediately
+	client = &Client{ID: `acme`, Key: `acme-key`, Plan: Plan{RequestsPerDay: 3}}
+	if _, violation := authenticator.allow(client); violation != nil {
+		t.Errorf(`unexpected violation: %v`, violation)
+	}
+	if delay, violation := authenticator.allow(client); delay != time.Hour*12 || violation.GetDescription() != `daily quota of 3 requests exceeded` {
+		t.Errorf(`unexpected result: %s %v`, delay, violation)
+	}
+
+	// usage is retained for the configured number of days
+	for i := 0; i < 3; i++ {
+		now = now.AddDate(0, 0, 1)
+		authenticator.allow(client)
+	}
+	if v, _ := authenticator.ClientUsage(`acme`); v.Requests != 6 || len(v.Days) != 2 || v.Days[0].Date != `2022-10-03` || v.Days[1].Date != `2022-10-04` {
+		t.Errorf(`unexpected usage: %+v`, v)
+	}
+}
+
+func TestAuthenticator_Save(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), `usage.json`)
+	now := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	client := &Client{ID: `acme`, Key: `acme-key`, Plan: Plan{Name: `pro`, RequestsPerDay: 3}}
+	newAuthenticator := func() *Authenticator {
+		authenticator := &Authenticator{
+			Store:     newClients(client),
+			UsagePath: path,
+			TimeNow:   func() time.Time { return now },
+		}
+		if err := authenticator.Load(); err != nil {
+			t.Fatal(err)
+		}
+		return authenticator
+	}
+
+	// nothing is written until there is usage
+	authenticator := newAuthenticator()
+	if err := authenticator.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf(`unexpected file: %v`, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, violation := authenticator.allow(client); violation != nil {
+			t.Fatalf(`unexpected violation: %v`, violation)
+		}
+	}
+	if err := authenticator.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// usage, and the daily quota, survive a restart
+	authenticator = newAuthenticator()
+	if v, ok := authenticator.ClientUsage(`acme`); !ok || v.Plan != `pro` || v.Requests != 2 || len(v.Days) != 1 || v.Days[0] != (DailyUsage{Date: `2022-10-01`, Requests: 2}) {
+		t.Errorf(`unexpected usage: %+v`, v)
+	}
+	if _, violation := authenticator.allow(client); violation != nil {
+		t.Errorf(`unexpected violation: %v`, violation)
+	}
+	if _, violation := authenticator.allow(client); violation.GetDescription() != `daily quota of 3 requests exceeded` {
+		t.Errorf(`unexpected violation: %v`, violation)
+	}
+	if err := authenticator.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if v := newAuthenticator().Usage(); len(v) != 1 || v[0].Requests != 3 || v[0].QuotaExceeded != 1 {
+		t.Errorf(`unexpected usage: %+v`, v)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"clients":[{}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Authenticator{UsagePath: path}).Load(); err == nil {
+		t.Error(`expected an error`)
+	}
+}
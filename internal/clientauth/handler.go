@@ -0,0 +1,65 @@
+package clientauth
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+)
+
+// Register wires up the admin endpoints, exposing the usage of each client, e.g. for billing.
+func (x *Authenticator) Register(r chi.Router) {
+	r.Get(`/admin/v1/clients/usage`, x.listUsage)
+	r.Get(`/admin/v1/clients/{client}/usage`, x.getUsage)
+}
+
+// Register wires up the admin endpoint, to reload the clients.
+func (x *FileStore) Register(r chi.Router) {
+	r.Post(`/admin/v1/clients/reload`, x.reload)
+}
+
+func (x *Authenticator) listUsage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, x.Usage())
+}
+
+func (x *Authenticator) getUsage(w http.ResponseWriter, r *http.Request) {
+	usage, ok := x.ClientUsage(chi.URLParam(r, `client`))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+func (x *FileStore) reload(w http.ResponseWriter, r *http.Request) {
+	if err := x.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// note: the clients aren't returned, as they include the keys
+	writeJSON(w, http.StatusOK, map[string]int{`clients`: x.Len()})
+}
+
+// writeError writes a google.rpc.Status, consistent with the other /v1 endpoints
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	sts, _ := status.FromError(err)
+	b, err := protojson.Marshal(sts.Proto())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// note: compacts the (intentionally unstable) protojson output
+	writeJSON(w, statusCode, json.RawMessage(b))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,109 @@
+package clientauth
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFileClients(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		Name string
+		JSON string
+		Err  string
+	}{
+		{`valid`, `{"clients":[{"id":"a","key":"k1","plan":{"requests_per_second":1}},{"id":"b","key":"k2"}]}`, ``},
+		{`empty`, `{}`, ``},
+		{`malformed`, `{`, `unexpected end of JSON input`},
+		{`missing id`, `{"clients":[{"key":"k1"}]}`, `client 0: missing id`},
+		{`null client`, `{"clients":[null]}`, `client 0: missing id`},
+		{`missing key`, `{"clients":[{"id":"a"}]}`, `client "a": missing key`},
+		{`duplicate id`, `{"clients":[{"id":"a","key":"k1"},{"id":"a","key":"k2"}]}`, `client "a": duplicate id`},
+		{`duplicate key`, `{"clients":[{"id":"a","key":"k1"},{"id":"b","key":"k1"}]}`, `client "b": duplicate key`},
+		{`negative limits`, `{"clients":[{"id":"a","key":"k1","plan":{"requests_per_day":-1}}]}`, `client "a": negative plan limits`},
+	} {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseFileClients([]byte(tc.JSON))
+			if (err == nil && tc.Err != ``) || (err != nil && err.Error() != tc.Err) {
+				t.Errorf(`unexpected error: %v`, err)
+			}
+		})
+	}
+}
+
+func TestFileStore_Register(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), `clients.json`)
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"clients":[{"id":"a","key":"k1"}]}`)
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := &Authenticator{Store: store}
+	r := chi.NewRouter()
+	store.Register(r)
+	authenticator.Register(r)
+
+	request := func(method, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	if client, err := store.Client(context.Background(), `k1`); err != nil || client.ID != `a` {
+		t.Errorf(`unexpected client: %v %v`, client, err)
+	}
+
+	write(`{"clients":[{"id":"a","key":"k3"},{"id":"b","key":"k2"}]}`)
+	if w := request(http.MethodPost, `/admin/v1/clients/reload`); w.Code != http.StatusOK || w.Body.String() != `{"clients":2}` {
+		t.Errorf(`unexpected response: %d %s`, w.Code, w.Body.String())
+	}
+	if _, err := store.Client(context.Background(), `k1`); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+	if v := store.Secrets(); len(v) != 2 {
+		t.Errorf(`unexpected secrets: %v`, v)
+	}
+
+	// the current clients are retained if the file is invalid
+	write(`{"clients":[{"id":"a"}]}`)
+	if w := request(http.MethodPost, `/admin/v1/clients/reload`); w.Code != http.StatusBadRequest {
+		t.Errorf(`unexpected response: %d %s`, w.Code, w.Body.String())
+	}
+	if client, err := store.Client(context.Background(), `k2`); err != nil || client.ID != `b` {
+		t.Errorf(`unexpected client: %v %v`, client, err)
+	}
+
+	if w := request(http.MethodGet, `/admin/v1/clients/usage`); w.Code != http.StatusOK || w.Body.String() != `[]` {
+		t.Errorf(`unexpected response: %d %s`, w.Code, w.Body.String())
+	}
+	authenticator.allow(&Client{ID: `b`, Key: `k2`, Plan: Plan{Name: `free`}})
+	if w := request(http.MethodGet, `/admin/v1/clients/b/usage`); w.Code != http.StatusOK ||
+		w.Body.String() != `{"client":"b","plan":"free","requests":1,"rate_limited":0,"quota_exceeded":0,"days":[{"date":"`+authenticator.timeNow().UTC().Format(dateLayout)+`","requests":1,"rate_limited":0,"quota_exceeded":0}]}` {
+		t.Errorf(`unexpected response: %d %s`, w.Code, w.Body.String())
+	}
+	if w := request(http.MethodGet, `/admin/v1/clients/a/usage`); w.Code != http.StatusNotFound {
+		t.Errorf(`unexpected response: %d %s`, w.Code, w.Body.String())
+	}
+
+	if v := (*FileStore)(nil).Secrets(); len(v) != 0 {
+		t.Errorf(`unexpected secrets: %v`, v)
+	}
+}
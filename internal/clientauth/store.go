@@ -0,0 +1,146 @@
+package clientauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+type (
+	// Store looks up clients by API key, and must be safe for concurrent use, e.g. FileStore.
+	Store interface {
+		// Client returns the client identified by the key, or ErrUnknownKey. Other errors are treated as the
+		// store being unavailable.
+		Client(ctx context.Context, key string) (*Client, error)
+	}
+
+	// FileStore is a Store loaded from a JSON file (see FileClients), which may be reloaded at runtime, safe for
+	// concurrent use. See also NewFileStore, and Register.
+	FileStore struct {
+		// Path is the (JSON) file the clients are loaded from, by Reload.
+		Path string
+
+		// clients are keyed by API key
+		clients atomic.Pointer[map[string]*Client]
+	}
+
+	// FileClients models the file loaded by FileStore, e.g.
+	// `{"clients":[{"id":"acme","key":"...","plan":{"requests_per_second":5,"requests_per_day":10000}}]}`.
+	FileClients struct {
+		Clients []*Client `json:"clients"`
+	}
+)
+
+var (
+	// ErrUnknownKey indicates the key doesn't identify a client.
+	ErrUnknownKey = errors.New(`clientauth: unknown api key`)
+)
+
+var (
+	// compile time assertions
+
+	_ Store = (*FileStore)(nil)
+)
+
+// NewFileStore initialises a store from the given file, see also FileStore.Reload.
+func NewFileStore(path string) (*FileStore, error) {
+	x := &FileStore{Path: path}
+	if err := x.Reload(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// ParseFileClients parses and validates clients from JSON.
+func ParseFileClients(b []byte) (*FileClients, error) {
+	var clients FileClients
+	if err := json.Unmarshal(b, &clients); err != nil {
+		return nil, err
+	}
+	if err := clients.Validate(); err != nil {
+		return nil, err
+	}
+	return &clients, nil
+}
+
+// Validate returns an error if any client is malformed, or any id or key is duplicated.
+func (x *FileClients) Validate() error {
+	ids, keys := make(map[string]bool), make(map[string]bool)
+	for i, client := range x.Clients {
+		if client == nil || client.ID == `` {
+			return fmt.Errorf(`client %d: missing id`, i)
+		}
+		if ids[client.ID] {
+			return fmt.Errorf(`client %q: duplicate id`, client.ID)
+		}
+		ids[client.ID] = true
+		if client.Key == `` {
+			return fmt.Errorf(`client %q: missing key`, client.ID)
+		}
+		if keys[client.Key] {
+			return fmt.Errorf(`client %q: duplicate key`, client.ID)
+		}
+		keys[client.Key] = true
+		if client.Plan.RequestsPerSecond < 0 || client.Plan.Burst < 0 || client.Plan.RequestsPerDay < 0 {
+			return fmt.Errorf(`client %q: negative plan limits`, client.ID)
+		}
+	}
+	return nil
+}
+
+// Reload loads and validates the clients from Path, the current clients are retained if an error occurs.
+func (x *FileStore) Reload() error {
+	if x.Path == `` {
+		return errors.New(`no clients path configured`)
+	}
+	b, err := os.ReadFile(x.Path)
+	if err != nil {
+		return err
+	}
+	clients, err := ParseFileClients(b)
+	if err != nil {
+		return fmt.Errorf(`invalid clients %s: %w`, x.Path, err)
+	}
+	m := make(map[string]*Client, len(clients.Clients))
+	for _, client := range clients.Clients {
+		m[client.Key] = client
+	}
+	x.clients.Store(&m)
+	return nil
+}
+
+// Client implements Store.
+func (x *FileStore) Client(ctx context.Context, key string) (*Client, error) {
+	if clients := x.load(); clients[key] != nil {
+		return clients[key], nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Len returns the number of clients.
+func (x *FileStore) Len() int {
+	return len(x.load())
+}
+
+// Secrets returns the key of every client, see logging.Redactor.
+func (x *FileStore) Secrets() []string {
+	clients := x.load()
+	secrets := make([]string, 0, len(clients))
+	for key := range clients {
+		secrets = append(secrets, key)
+	}
+	return secrets
+}
+
+func (x *FileStore) load() map[string]*Client {
+	if x == nil {
+		return nil
+	}
+	if v := x.clients.Load(); v != nil {
+		return *v
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package clientauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type (
+	// usageFile models the file usage is persisted to, see Authenticator.UsagePath
+	usageFile struct {
+		Clients []Usage `json:"clients"`
+	}
+)
+
+const (
+	// DefaultSaveInterval is the default value for Authenticator.SaveInterval.
+	DefaultSaveInterval = time.Minute
+)
+
+// Load restores the usage persisted to UsagePath, if it exists, replacing the current usage, and must be called
+// before serving requests.
+func (x *Authenticator) Load() error {
+	if x.UsagePath == `` {
+		return errors.New(`no usage path configured`)
+	}
+	b, err := os.ReadFile(x.UsagePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var file usageFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf(`invalid usage file %s: %w`, x.UsagePath, err)
+	}
+	clients := make(map[string]*clientState, len(file.Clients))
+	for i, usage := range file.Clients {
+		if usage.Client == `` {
+			return fmt.Errorf(`invalid usage file %s: client %d: missing id`, x.UsagePath, i)
+		}
+		// note: the plan (and limiter) is applied by the client's next request
+		clients[usage.Client] = &clientState{usage: usage}
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.clients = clients
+	x.dirty = false
+	return nil
+}
+
+// Save persists the usage to UsagePath, if it has changed since the last save, replacing the file atomically.
+func (x *Authenticator) Save() error {
+	if x.UsagePath == `` {
+		return nil
+	}
+
+	// note: serializes writes, such that an older snapshot can't replace a newer one
+	x.saveMu.Lock()
+	defer x.saveMu.Unlock()
+
+	x.mu.Lock()
+	if !x.dirty {
+		x.mu.Unlock()
+		return nil
+	}
+	file := usageFile{Clients: make([]Usage, 0, len(x.clients))}
+	for _, state := range x.clients {
+		file.Clients = append(file.Clients, state.snapshot())
+	}
+	x.dirty = false
+	x.mu.Unlock()
+
+	if err := x.writeUsage(&file); err != nil {
+		x.mu.Lock()
+		x.dirty = true
+		x.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Run saves the usage every SaveInterval, until the context is done. Note that the usage should be saved once more,
+// after the last request has been served, see Save.
+func (x *Authenticator) Run(ctx context.Context) {
+	ticker := time.NewTicker(x.saveInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := x.Save(); err != nil {
+			slog.WarnContext(ctx, `clientauth: failed to save usage`, `path`, x.UsagePath, `error`, err)
+		}
+	}
+}
+
+func (x *Authenticator) writeUsage(file *usageFile) error {
+	sort.Slice(file.Clients, func(i, j int) bool { return file.Clients[i].Client < file.Clients[j].Client })
+
+	b, err := json.MarshalIndent(file, ``, `  `)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(x.UsagePath), filepath.Base(x.UsagePath)+`.*.tmp`)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), x.UsagePath)
+}
+
+func (x *Authenticator) saveInterval() time.Duration {
+	if x.SaveInterval > 0 {
+		return x.SaveInterval
+	}
+	return DefaultSaveInterval
+}
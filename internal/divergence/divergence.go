@@ -0,0 +1,443 @@
+// Package divergence tracks the disagreement between weather providers, for the same location, over time, flagging
+// providers that drift systematically, e.g. due to a stale station, or an incorrect units assumption.
+package divergence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Tracker compares each reading against the median of the recent readings of all providers, for the same location,
+	// maintaining running divergence stats per provider and location. Safe for concurrent use, and a nil Tracker
+	// ignores all readings. See also Sample, and Register.
+	Tracker struct {
+		// TemperatureThreshold is the mean temperature delta (in degrees) at which a provider is flagged, defaults to
+		// DefaultTemperatureThreshold.
+		TemperatureThreshold float64
+
+		// WindSpeedThreshold is the mean wind speed delta (in km/h) at which a provider is flagged, defaults to
+		// DefaultWindSpeedThreshold.
+		WindSpeedThreshold float64
+
+		// MinSamples is the number of comparisons required before a provider may be flagged, defaults to
+		// DefaultMinSamples.
+		MinSamples int
+
+		// Smoothing is the weight given to each new delta, in the exponentially weighted mean, defaults to
+		// DefaultSmoothing.
+		Smoothing float64
+
+		// Window is the maximum difference in read time, between readings that will be compared, defaults to
+		// DefaultWindow.
+		Window time.Duration
+
+		// MaxLocations bounds the number of tracked locations, the least recently used location is evicted, once
+		// reached, defaults to DefaultMaxLocations.
+		MaxLocations int
+
+		// SampleInterval is the minimum interval between samples of each location, see Sample, defaults to
+		// DefaultSampleInterval. Sampling is disabled if negative.
+		SampleInterval time.Duration
+
+		// WebhookURL will receive a POST request with an Alert (as JSON) each time a provider is flagged or recovers,
+		// if set.
+		WebhookURL string
+
+		// Client is used to call the webhook, defaults to http.DefaultClient.
+		Client *http.Client
+
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+
+		mu        sync.Mutex
+		locations map[string]*locationEntry
+		// used is incremented each time a location is used, to identify the least recently used
+		used uint64
+		// webhooks is used to wait for in-flight webhook calls, see Close
+		webhooks sync.WaitGroup
+	}
+
+	// Reading is a single normalized reading, note that wind speed is in km/h.
+	Reading struct {
+		Provider           string
+		ReadTime           time.Time
+		TemperatureDegrees float64
+		WindSpeed          float64
+	}
+
+	// Stats models the running divergence of a provider, at a location, from the median of all providers.
+	// Note that at least three providers are required to identify which provider is diverging.
+	Stats struct {
+		Provider string `json:"provider"`
+		Location string `json:"location"`
+		Samples  uint64 `json:"samples"`
+		// TemperatureDelta and WindSpeedDelta are the (signed) exponentially weighted means
+		TemperatureDelta     float64    `json:"temperature_delta"`
+		WindSpeedDelta       float64    `json:"wind_speed_delta"`
+		LastTemperatureDelta float64    `json:"last_temperature_delta"`
+		LastWindSpeedDelta   float64    `json:"last_wind_speed_delta"`
+		LastObserved         *time.Time `json:"last_observed,omitempty"`
+		Flagged              bool       `json:"flagged"`
+		FlaggedSince         *time.Time `json:"flagged_since,omitempty"`
+	}
+
+	// Alert is the body of webhook requests.
+	Alert struct {
+		Event                Event     `json:"event"`
+		Time                 time.Time `json:"time"`
+		TemperatureThreshold float64   `json:"temperature_threshold"`
+		WindSpeedThreshold   float64   `json:"wind_speed_threshold"`
+		Stats                Stats     `json:"stats"`
+	}
+
+	// Event indicates the type of Alert.
+	Event string
+
+	locationEntry struct {
+		// providers are keyed by provider
+		providers map[string]*entry
+		used      uint64
+		sampled   time.Time
+	}
+
+	entry struct {
+		latest *Reading
+		stats  Stats
+	}
+)
+
+const (
+	// EventDiverged indicates a provider has crossed a threshold.
+	EventDiverged Event = `diverged`
+	// EventRecovered indicates a previously flagged provider is back within the thresholds.
+	EventRecovered Event = `recovered`
+)
+
+const (
+	// DefaultTemperatureThreshold is the default value for Tracker.TemperatureThreshold.
+	DefaultTemperatureThreshold = 2
+
+	// DefaultWindSpeedThreshold is the default value for Tracker.WindSpeedThreshold.
+	DefaultWindSpeedThreshold = 8
+
+	// DefaultMinSamples is the default value for Tracker.MinSamples.
+	DefaultMinSamples = 5
+
+	// DefaultSmoothing is the default value for Tracker.Smoothing.
+	DefaultSmoothing = 0.2
+
+	// DefaultWindow is the default value for Tracker.Window.
+	DefaultWindow = time.Minute * 15
+
+	// DefaultMaxLocations is the default value for Tracker.MaxLocations.
+	DefaultMaxLocations = 1000
+
+	// DefaultSampleInterval is the default value for Tracker.SampleInterval.
+	DefaultSampleInterval = DefaultWindow
+
+	webhookTimeout = time.Second * 10
+)
+
+// NormalizeLocation converts a query to the key used to group readings, e.g. `Sydney ` becomes `sydney`.
+func NormalizeLocation(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+// Observe records a reading, for the given location (query), comparing it against the median of the latest readings of
+// all providers, that are within the window. Readings which have already been observed (e.g. cached) are ignored.
+func (x *Tracker) Observe(query string, reading Reading) {
+	if x == nil {
+		return
+	}
+
+	location := NormalizeLocation(query)
+	if location == `` || reading.Provider == `` {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	providers := x.location(location).providers
+
+	e := providers[reading.Provider]
+	if e == nil {
+		e = &entry{stats: Stats{Provider: reading.Provider, Location: location}}
+		providers[reading.Provider] = e
+	} else if e.latest != nil && !reading.ReadTime.After(e.latest.ReadTime) {
+		// already observed, or out of order
+		return
+	}
+	e.latest = &reading
+
+	// note: the median includes the reading itself, so a single outlier doesn't skew the deltas of other providers
+	temperatures, windSpeeds := []float64{reading.TemperatureDegrees}, []float64{reading.WindSpeed}
+	for provider, other := range providers {
+		if provider == reading.Provider || other.latest == nil ||
+			absDuration(other.latest.ReadTime.Sub(reading.ReadTime)) > x.window() {
+			continue
+		}
+		temperatures = append(temperatures, other.latest.TemperatureDegrees)
+		windSpeeds = append(windSpeeds, other.latest.WindSpeed)
+	}
+	if len(temperatures) == 1 {
+		return
+	}
+
+	now := x.timeNow()
+	stats := &e.stats
+	stats.LastTemperatureDelta = reading.TemperatureDegrees - median(temperatures)
+	stats.LastWindSpeedDelta = reading.WindSpeed - median(windSpeeds)
+	if stats.Samples == 0 {
+		stats.TemperatureDelta = stats.LastTemperatureDelta
+		stats.WindSpeedDelta = stats.LastWindSpeedDelta
+	} else {
+		alpha := x.smoothing()
+		stats.TemperatureDelta += alpha * (stats.LastTemperatureDelta - stats.TemperatureDelta)
+		stats.WindSpeedDelta += alpha * (stats.LastWindSpeedDelta - stats.WindSpeedDelta)
+	}
+	stats.Samples++
+	stats.LastObserved = &now
+
+	diverged := stats.Samples >= uint64(x.minSamples()) &&
+		(math.Abs(stats.TemperatureDelta) > x.temperatureThreshold() ||
+			math.Abs(stats.WindSpeedDelta) > x.windSpeedThreshold())
+	switch {
+	case diverged && !stats.Flagged:
+		stats.Flagged = true
+		stats.FlaggedSince = &now
+		x.alert(EventDiverged, now, *stats)
+	case !diverged && stats.Flagged:
+		stats.Flagged = false
+		stats.FlaggedSince = nil
+		x.alert(EventRecovered, now, *stats)
+	}
+}
+
+// Sample returns true if all providers should be sampled for the given location (query), i.e. if it hasn't been
+// sampled within the SampleInterval, recording the sample. This allows providers to be compared, even if requests are
+// ordinarily served by a single provider.
+func (x *Tracker) Sample(query string) bool {
+	if x == nil || x.SampleInterval < 0 {
+		return false
+	}
+
+	location := NormalizeLocation(query)
+	if location == `` {
+		return false
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := x.timeNow()
+	v := x.location(location)
+	if !v.sampled.IsZero() && now.Sub(v.sampled) < x.sampleInterval() {
+		return false
+	}
+	v.sampled = now
+	return true
+}
+
+// Close waits for any in-flight webhook calls, which are bounded by a timeout. It should be called once no more
+// readings will be observed.
+func (x *Tracker) Close() {
+	if x == nil {
+		return
+	}
+	x.webhooks.Wait()
+}
+
+// Stats returns the divergence stats for all providers and locations with at least one comparison, optionally
+// filtered by provider and/or location (empty matches all), ordered by location then provider.
+func (x *Tracker) Stats(provider, query string) []Stats {
+	if x == nil {
+		return nil
+	}
+	location := NormalizeLocation(query)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	var stats []Stats
+	for l, v := range x.locations {
+		if location != `` && l != location {
+			continue
+		}
+		for p, e := range v.providers {
+			if (provider != `` && p != provider) || e.stats.Samples == 0 {
+				continue
+			}
+			stats = append(stats, e.stats)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Location != stats[j].Location {
+			return stats[i].Location < stats[j].Location
+		}
+		return stats[i].Provider < stats[j].Provider
+	})
+	return stats
+}
+
+// location returns the (normalized) location, marking it as used, evicting the least recently used location if
+// necessary, must be called with the mutex held
+func (x *Tracker) location(location string) *locationEntry {
+	x.used++
+	if v := x.locations[location]; v != nil {
+		v.used = x.used
+		return v
+	}
+	if x.locations == nil {
+		x.locations = make(map[string]*locationEntry)
+	}
+	for len(x.locations) >= x.maxLocations() {
+		var lru string
+		for k, v := range x.locations {
+			if lru == `` || v.used < x.locations[lru].used {
+				lru = k
+			}
+		}
+		delete(x.locations, lru)
+	}
+	v := &locationEntry{providers: make(map[string]*entry), used: x.used}
+	x.locations[location] = v
+	return v
+}
+
+// alert calls the webhook asynchronously, must be called with the mutex held
+func (x *Tracker) alert(event Event, now time.Time, stats Stats) {
+	slog.Warn(`divergence`,
+		`event`, event,
+		`provider`, stats.Provider,
+		`location`, stats.Location,
+		`temperature_delta`, stats.TemperatureDelta,
+		`wind_speed_delta`, stats.WindSpeedDelta,
+	)
+	if x.WebhookURL == `` {
+		return
+	}
+	b, err := json.Marshal(Alert{
+		Event:                event,
+		Time:                 now,
+		TemperatureThreshold: x.temperatureThreshold(),
+		WindSpeedThreshold:   x.windSpeedThreshold(),
+		Stats:                stats,
+	})
+	if err != nil {
+		slog.Error(`divergence webhook failed`, `error`, err)
+		return
+	}
+	x.webhooks.Add(1)
+	go func() {
+		defer x.webhooks.Done()
+		if err := x.callWebhook(b); err != nil {
+			slog.Error(`divergence webhook failed`, `error`, err)
+		}
+	}()
+}
+
+func (x *Tracker) callWebhook(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(`Content-Type`, `application/json`)
+	client := x.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf(`unexpected status code %d`, res.StatusCode)
+	}
+	return nil
+}
+
+func (x *Tracker) temperatureThreshold() float64 {
+	if x.TemperatureThreshold > 0 {
+		return x.TemperatureThreshold
+	}
+	return DefaultTemperatureThreshold
+}
+
+func (x *Tracker) windSpeedThreshold() float64 {
+	if x.WindSpeedThreshold > 0 {
+		return x.WindSpeedThreshold
+	}
+	return DefaultWindSpeedThreshold
+}
+
+func (x *Tracker) minSamples() int {
+	if x.MinSamples > 0 {
+		return x.MinSamples
+	}
+	return DefaultMinSamples
+}
+
+func (x *Tracker) smoothing() float64 {
+	if x.Smoothing > 0 && x.Smoothing <= 1 {
+		return x.Smoothing
+	}
+	return DefaultSmoothing
+}
+
+func (x *Tracker) window() time.Duration {
+	if x.Window > 0 {
+		return x.Window
+	}
+	return DefaultWindow
+}
+
+func (x *Tracker) maxLocations() int {
+	if x.MaxLocations > 0 {
+		return x.MaxLocations
+	}
+	return DefaultMaxLocations
+}
+
+func (x *Tracker) sampleInterval() time.Duration {
+	if x.SampleInterval > 0 {
+		return x.SampleInterval
+	}
+	return DefaultSampleInterval
+}
+
+func (x *Tracker) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
+
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
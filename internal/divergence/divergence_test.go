@@ -0,0 +1,214 @@
+package divergence
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTracker_Observe(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		alerts []Alert
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		alerts = append(alerts, alert)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	now := time.Unix(1667179321, 0)
+	tracker := Tracker{
+		WebhookURL: webhook.URL,
+		Client:     webhook.Client(),
+		TimeNow:    func() time.Time { return now },
+	}
+
+	observe := func(i int, openweatherWindSpeed float64) {
+		readTime := now.Add(time.Minute * time.Duration(i))
+		tracker.Observe(`Sydney`, Reading{Provider: `weatherstack`, ReadTime: readTime, TemperatureDegrees: 20, WindSpeed: 10})
+		tracker.Observe(`sydney `, Reading{Provider: `openmeteo`, ReadTime: readTime, TemperatureDegrees: 21, WindSpeed: 11})
+		// e.g. treating m/s as km/h, or the other way around
+		tracker.Observe(`SYDNEY`, Reading{Provider: `openweather`, ReadTime: readTime, TemperatureDegrees: 20.5, WindSpeed: openweatherWindSpeed})
+	}
+
+	for i := 0; i < DefaultMinSamples; i++ {
+		observe(i, 36)
+	}
+	// cached readings are ignored
+	tracker.Observe(`sydney`, Reading{Provider: `openweather`, ReadTime: now, TemperatureDegrees: 100, WindSpeed: 100})
+	tracker.webhooks.Wait()
+
+	stats := tracker.Stats(``, ``)
+	if len(stats) != 3 {
+		t.Fatalf(`unexpected stats: %+v`, stats)
+	}
+	for _, v := range stats {
+		switch v.Provider {
+		case `openweather`:
+			if !v.Flagged || v.FlaggedSince == nil || v.Samples != DefaultMinSamples || v.WindSpeedDelta != 25 || v.TemperatureDelta != 0 {
+				t.Errorf(`unexpected stats: %+v`, v)
+			}
+		case `weatherstack`:
+			// note: the first reading had nothing to compare against
+			if v.Flagged || v.Samples != DefaultMinSamples-1 || v.Location != `sydney` {
+				t.Errorf(`unexpected stats: %+v`, v)
+			}
+		default:
+			if v.Flagged || v.Samples != DefaultMinSamples {
+				t.Errorf(`unexpected stats: %+v`, v)
+			}
+		}
+	}
+	mu.Lock()
+	if len(alerts) != 1 || alerts[0].Event != EventDiverged || alerts[0].Stats.Provider != `openweather` ||
+		alerts[0].WindSpeedThreshold != DefaultWindSpeedThreshold {
+		t.Errorf(`unexpected alerts: %+v`, alerts)
+	}
+	mu.Unlock()
+
+	// once fixed, the mean converges, and the provider recovers
+	for i := DefaultMinSamples; i < DefaultMinSamples*4; i++ {
+		observe(i, 10.5)
+	}
+	tracker.webhooks.Wait()
+	if v := tracker.Stats(`openweather`, `Sydney`); len(v) != 1 || v[0].Flagged {
+		t.Errorf(`unexpected stats: %+v`, v)
+	}
+	mu.Lock()
+	if len(alerts) != 2 || alerts[1].Event != EventRecovered || alerts[1].Stats.Provider != `openweather` {
+		t.Errorf(`unexpected alerts: %+v`, alerts)
+	}
+	mu.Unlock()
+}
+
+func TestTracker_Observe_window(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1667179321, 0)
+	tracker := Tracker{Window: time.Minute}
+	tracker.Observe(`sydney`, Reading{Provider: `weatherstack`, ReadTime: now, TemperatureDegrees: 20, WindSpeed: 10})
+	tracker.Observe(`sydney`, Reading{Provider: `openweather`, ReadTime: now.Add(time.Minute * 2), TemperatureDegrees: 30, WindSpeed: 10})
+	if v := tracker.Stats(``, ``); len(v) != 0 {
+		t.Errorf(`unexpected stats: %+v`, v)
+	}
+	tracker.Observe(`sydney`, Reading{Provider: `weatherstack`, ReadTime: now.Add(time.Minute), TemperatureDegrees: 20, WindSpeed: 10})
+	if v := tracker.Stats(``, ``); len(v) != 1 || v[0].Provider != `weatherstack` || v[0].TemperatureDelta != -5 {
+		t.Errorf(`unexpected stats: %+v`, v)
+	}
+}
+
+func TestTracker_Observe_maxLocations(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1667179321, 0)
+	tracker := Tracker{MaxLocations: 2}
+	observe := func(location string) {
+		now = now.Add(time.Second)
+		tracker.Observe(location, Reading{Provider: `weatherstack`, ReadTime: now, TemperatureDegrees: 20, WindSpeed: 10})
+		tracker.Observe(location, Reading{Provider: `openweather`, ReadTime: now, TemperatureDegrees: 20, WindSpeed: 10})
+	}
+	locations := func() (locations []string) {
+		for _, v := range tracker.Stats(`openweather`, ``) {
+			locations = append(locations, v.Location)
+		}
+		return
+	}
+	observe(`sydney`)
+	observe(`brisbane`)
+	// the least recently used location is evicted
+	observe(`sydney`)
+	observe(`perth`)
+	if v := locations(); len(v) != 2 || v[0] != `perth` || v[1] != `sydney` {
+		t.Errorf(`unexpected locations: %v`, v)
+	}
+	if !tracker.Sample(`melbourne`) {
+		t.Error(`expected a sample`)
+	}
+	if v := locations(); len(v) != 1 || v[0] != `perth` {
+		t.Errorf(`unexpected locations: %v`, v)
+	}
+}
+
+func TestTracker_Sample(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1667179321, 0)
+	tracker := Tracker{SampleInterval: time.Minute, TimeNow: func() time.Time { return now }}
+	for i, tc := range [...]struct {
+		location string
+		advance  time.Duration
+		sample   bool
+	}{
+		{location: `sydney`, sample: true},
+		{location: ` Sydney`, advance: time.Second * 59},
+		{location: `brisbane`, sample: true},
+		{location: `sydney`, advance: time.Second, sample: true},
+		{location: `sydney`},
+		{location: ``},
+	} {
+		now = now.Add(tc.advance)
+		if v := tracker.Sample(tc.location); v != tc.sample {
+			t.Errorf(`%d: unexpected sample: %v`, i, v)
+		}
+	}
+	if (&Tracker{SampleInterval: -1}).Sample(`sydney`) {
+		t.Error(`expected sampling to be disabled`)
+	}
+}
+
+func TestTracker_nil(t *testing.T) {
+	t.Parallel()
+	var tracker *Tracker
+	tracker.Observe(`sydney`, Reading{Provider: `weatherstack`})
+	if tracker.Sample(`sydney`) {
+		t.Error(`expected no sample`)
+	}
+	if v := tracker.Stats(``, ``); v != nil {
+		t.Error(v)
+	}
+}
+
+func TestTracker_Register(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	tracker := Tracker{TimeNow: func() time.Time { return now.UTC() }}
+	tracker.Observe(`sydney`, Reading{Provider: `weatherstack`, ReadTime: now, TemperatureDegrees: 20, WindSpeed: 10})
+	tracker.Observe(`sydney`, Reading{Provider: `openweather`, ReadTime: now, TemperatureDegrees: 21, WindSpeed: 12})
+
+	router := chi.NewRouter()
+	router.Route(`/`, tracker.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, tc := range [...]struct {
+		path string
+		body string
+	}{
+		{`/admin/v1/divergence?provider=openweather&city=Sydney`, `[{"provider":"openweather","location":"sydney","samples":1,"temperature_delta":0.5,"wind_speed_delta":1,"last_temperature_delta":0.5,"last_wind_speed_delta":1,"last_observed":"2022-10-31T01:22:01Z","flagged":false}]`},
+		{`/admin/v1/divergence?city=brisbane`, `[]`},
+	} {
+		res, err := ts.Client().Get(ts.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || string(b) != tc.body {
+			t.Errorf("unexpected response to %s: %d %s", tc.path, res.StatusCode, b)
+		}
+	}
+}
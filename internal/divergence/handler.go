@@ -0,0 +1,38 @@
+package divergence
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// Register wires up the admin endpoint, exposing the divergence stats, optionally filtered using the `provider` and
+// `location` query parameters. The location is that readings were observed for, e.g. the id of the resolved location,
+// and `city` is accepted as an alias.
+func (x *Tracker) Register(r chi.Router) {
+	r.Get(`/admin/v1/divergence`, x.listStats)
+}
+
+func (x *Tracker) listStats(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	location := params.Get(`location`)
+	if location == `` {
+		location = params.Get(`city`)
+	}
+	stats := x.Stats(params.Get(`provider`), location)
+	if stats == nil {
+		stats = []Stats{}
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
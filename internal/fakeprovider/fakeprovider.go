@@ -0,0 +1,571 @@
+// Package fakeprovider emulates the upstream weather provider HTTP APIs, for local development and testing.
+//
+// Readings are deterministic by default (derived from the query), and may be scripted per query, including injecting
+// latency, errors, rate limiting, and malformed responses.
+package fakeprovider
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Server implements fake versions of the supported providers.
+	// See also Register.
+	Server struct {
+		// Keys are the accepted API keys, if empty then any non-empty key will be accepted.
+		Keys []string
+
+		// Readings returns the reading for a query, defaults to DeterministicReading.
+		// Returning false indicates that the location was not found.
+		Readings func(provider Provider, query string) (Reading, bool)
+
+		// Latency is added to every response, in addition to any scripted latency.
+		Latency time.Duration
+
+		// ErrorRate, RateLimitRate, and MalformedRate are the probabilities of injecting the relevant fault, for
+		// requests that were not scripted.
+		ErrorRate     float64
+		RateLimitRate float64
+		MalformedRate float64
+
+		// Rand is used to inject faults, defaults to a source seeded with 1.
+		Rand *rand.Rand
+
+		mu      sync.Mutex
+		scripts map[scriptKey][]Step
+		// locations maps coordinates (as returned by the fake geocoding api) to queries
+		locations map[position]string
+		// ids maps the ids of the fake geocoding api to queries
+		ids map[int64]string
+	}
+
+	// Provider identifies an emulated upstream API.
+	Provider string
+
+	// Reading is a normalized weather reading, note that wind speed is always in km/h.
+	Reading struct {
+		TemperatureDegrees float64 `json:"temperature_degrees"`
+		WindSpeed          float64 `json:"wind_speed"`
+	}
+
+	// Step is a scripted response, see Server.Script.
+	Step struct {
+		// Latency is an additional delay, before responding.
+		Latency Duration `json:"latency,omitempty"`
+		// Fault is the fault to inject, if any.
+		Fault Fault `json:"fault,omitempty"`
+		// Reading overrides Server.Readings, if set.
+		Reading *Reading `json:"reading,omitempty"`
+	}
+
+	// Fault is a type of injected failure.
+	Fault string
+
+	// Duration is a time.Duration that (un)marshals as a string, e.g. "150ms".
+	Duration time.Duration
+
+	position struct {
+		lat, lng float64
+	}
+
+	scriptKey struct {
+		provider Provider
+		query    string
+	}
+
+	// response is the provider-agnostic outcome of a request
+	response struct {
+		fault   Fault
+		reading Reading
+	}
+)
+
+const (
+	// Openweather emulates https://api.openweathermap.org/data/2.5/weather
+	Openweather Provider = `openweather`
+	// Weatherstack emulates http://api.weatherstack.com/current
+	Weatherstack Provider = `weatherstack`
+	// OpenMeteo emulates https://api.open-meteo.com/v1/forecast, https://geocoding-api.open-meteo.com/v1/search, and
+	// https://geocoding-api.open-meteo.com/v1/get, note that it doesn't require an API key
+	OpenMeteo Provider = `openmeteo`
+	// Metno emulates https://api.met.no/weatherapi/locationforecast/2.0/compact, including the cache headers, note
+	// that it doesn't require an API key, but does require a User-Agent
+	Metno Provider = `metno`
+)
+
+const (
+	// PositionTolerance is the maximum difference (in degrees) between a requested position, and one returned by
+	// the fake geocoding api, such that requests for positions snapped to a grid still map to the original query.
+	PositionTolerance = 0.05
+)
+
+var (
+	// countries are assigned to fake geocoding results
+	countries = [...]struct{ code, timezone string }{
+		{`AU`, `Australia/Sydney`},
+		{`GB`, `Europe/London`},
+		{`NO`, `Europe/Oslo`},
+		{`NZ`, `Pacific/Auckland`},
+		{`US`, `America/New_York`},
+	}
+)
+
+const (
+	FaultNone         Fault = ``
+	FaultError        Fault = `error`
+	FaultRateLimit    Fault = `rate_limit`
+	FaultMalformed    Fault = `malformed`
+	FaultNotFound     Fault = `not_found`
+	FaultUnauthorized Fault = `unauthorized`
+)
+
+// Register wires up the server.
+func (x *Server) Register(r chi.Router) {
+	r.Get(`/data/2.5/weather`, x.getOpenweather)
+	r.Get(`/current`, x.getWeatherstack)
+	r.Get(`/v1/search`, x.getOpenMeteoSearch)
+	r.Get(`/v1/get`, x.getOpenMeteoLocation)
+	r.Get(`/v1/forecast`, x.getOpenMeteoForecast)
+	r.Get(`/weatherapi/locationforecast/2.0/compact`, x.getMetno)
+}
+
+// Script appends steps to the script for the given provider and query, which will be consumed in order, by
+// subsequent matching requests. Queries are matched case-insensitively.
+func (x *Server) Script(provider Provider, query string, steps ...Step) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.scripts == nil {
+		x.scripts = make(map[scriptKey][]Step)
+	}
+	key := scriptKey{provider: provider, query: normalizeQuery(query)}
+	x.scripts[key] = append(x.scripts[key], steps...)
+}
+
+// LoadScript parses and appends scripted steps, from a JSON document, mapping provider to query to steps, e.g.
+// `{"openweather":{"sydney":[{"latency":"2s"},{"fault":"rate_limit"}]}}`.
+func (x *Server) LoadScript(b []byte) error {
+	var script map[Provider]map[string][]Step
+	if err := json.Unmarshal(b, &script); err != nil {
+		return err
+	}
+	for provider, queries := range script {
+		for query, steps := range queries {
+			x.Script(provider, query, steps...)
+		}
+	}
+	return nil
+}
+
+// DeterministicReading derives a plausible reading from the query, such that the same query always gives the same
+// reading, regardless of provider. All non-empty queries are considered valid.
+func DeterministicReading(_ Provider, query string) (Reading, bool) {
+	query = normalizeQuery(query)
+	if query == `` {
+		return Reading{}, false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(query))
+	v := h.Sum64()
+	return Reading{
+		// -10 to 40 degrees, 1 decimal place
+		TemperatureDegrees: float64(int64(v%500)-100) / 10,
+		// 0 to 60 km/h, 1 decimal place
+		WindSpeed: float64((v>>32)%600) / 10,
+	}, true
+}
+
+func (x *Server) getOpenweather(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := params.Get(`q`)
+	if params.Has(`lat`) || params.Has(`lon`) {
+		lat, _ := strconv.ParseFloat(params.Get(`lat`), 64)
+		lng, _ := strconv.ParseFloat(params.Get(`lon`), 64)
+		query = x.resolvePosition(lat, lng)
+	}
+	res := x.respond(r, Openweather, params.Get(`appid`), query)
+	switch res.fault {
+	case FaultNone:
+		windSpeed := res.reading.WindSpeed / 3.6
+		temp := res.reading.TemperatureDegrees
+		if params.Get(`units`) != `metric` {
+			// standard units, i.e. kelvin and m/s
+			temp += 273.15
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			`main`: map[string]any{`temp`: round(temp, 2)},
+			`wind`: map[string]any{`speed`: round(windSpeed, 2)},
+			`name`: query,
+			`cod`:  200,
+		})
+	case FaultMalformed:
+		writeMalformed(w)
+	case FaultUnauthorized:
+		writeJSON(w, http.StatusUnauthorized, map[string]any{`cod`: 401, `message`: `Invalid API key. Please see https://openweathermap.org/faq#error401 for more info.`})
+	case FaultRateLimit:
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{`cod`: 429, `message`: `Your account is temporary blocked due to exceeding of requests limitation of your subscription type.`})
+	case FaultNotFound:
+		writeJSON(w, http.StatusNotFound, map[string]any{`cod`: `404`, `message`: `city not found`})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{`cod`: 500, `message`: `Internal error`})
+	}
+}
+
+func (x *Server) getWeatherstack(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := params.Get(`query`)
+	// note: weatherstack accepts coordinates as the query, e.g. `-33.8688,151.2093`
+	if lat, lng, ok := parsePosition(query); ok {
+		query = x.resolvePosition(lat, lng)
+	}
+	res := x.respond(r, Weatherstack, params.Get(`access_key`), query)
+	writeError := func(code int, typ string) {
+		// weatherstack uses 200 for (almost) all errors
+		writeJSON(w, http.StatusOK, map[string]any{`success`: false, `error`: map[string]any{`code`: code, `type`: typ}})
+	}
+	switch res.fault {
+	case FaultNone:
+		writeJSON(w, http.StatusOK, map[string]any{
+			`request`:  map[string]any{`type`: `City`, `query`: params.Get(`query`), `unit`: `m`},
+			`location`: map[string]any{`name`: query},
+			`current`: map[string]any{
+				`temperature`: round(res.reading.TemperatureDegrees, 0),
+				`wind_speed`:  round(res.reading.WindSpeed, 0),
+			},
+		})
+	case FaultMalformed:
+		writeMalformed(w)
+	case FaultUnauthorized:
+		writeError(101, `invalid_access_key`)
+	case FaultRateLimit:
+		writeError(104, `usage_limit_reached`)
+	case FaultNotFound:
+		writeError(615, `request_failed`)
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{`success`: false})
+	}
+}
+
+func (x *Server) getOpenMeteoSearch(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := params.Get(`name`)
+	if _, ok := x.readings()(OpenMeteo, query); !ok {
+		// note: no results is indicated by omitting the field
+		writeJSON(w, http.StatusOK, map[string]any{`generationtime_ms`: 0.1})
+		return
+	}
+	result := x.geocode(query)
+	if v := params.Get(`countryCode`); v != `` {
+		result[`country_code`] = strings.ToUpper(v)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		`results`:           []any{result},
+		`generationtime_ms`: 0.1,
+	})
+}
+
+func (x *Server) getOpenMeteoLocation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(r.URL.Query().Get(`id`), 10, 64)
+	x.mu.Lock()
+	query, ok := x.ids[id]
+	x.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{`error`: true, `reason`: `Not found`})
+		return
+	}
+	writeJSON(w, http.StatusOK, x.geocode(query))
+}
+
+// geocode returns a (deterministic) geocoding api result for the query, registering the id and position
+func (x *Server) geocode(query string) map[string]any {
+	id := DeterministicID(query)
+	lat, lng := DeterministicPosition(query)
+	x.mu.Lock()
+	if x.locations == nil {
+		x.locations = make(map[position]string)
+	}
+	x.locations[position{lat: lat, lng: lng}] = query
+	if x.ids == nil {
+		x.ids = make(map[int64]string)
+	}
+	x.ids[id] = query
+	x.mu.Unlock()
+	country := countries[id%int64(len(countries))]
+	return map[string]any{
+		`id`:           id,
+		`name`:         query,
+		`latitude`:     lat,
+		`longitude`:    lng,
+		`country_code`: country.code,
+		`timezone`:     country.timezone,
+	}
+}
+
+func (x *Server) getOpenMeteoForecast(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	lat, _ := strconv.ParseFloat(params.Get(`latitude`), 64)
+	lng, _ := strconv.ParseFloat(params.Get(`longitude`), 64)
+	// note: locations are resolved via the search endpoint
+	res := x.respond(r, OpenMeteo, ``, x.resolvePosition(lat, lng))
+	switch res.fault {
+	case FaultNone:
+		writeJSON(w, http.StatusOK, map[string]any{
+			`latitude`:  lat,
+			`longitude`: lng,
+			`current_weather`: map[string]any{
+				`temperature`:   round(res.reading.TemperatureDegrees, 1),
+				`windspeed`:     round(res.reading.WindSpeed, 1),
+				`winddirection`: 90,
+				`weathercode`:   3,
+				`time`:          `2022-11-01T00:00`,
+			},
+		})
+	case FaultMalformed:
+		writeMalformed(w)
+	case FaultRateLimit:
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{`error`: true, `reason`: `Too many requests`})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]any{`error`: true, `reason`: `Internal error`})
+	}
+}
+
+func (x *Server) getMetno(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(`User-Agent`) == `` {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	params := r.URL.Query()
+	lat, _ := strconv.ParseFloat(params.Get(`lat`), 64)
+	lng, _ := strconv.ParseFloat(params.Get(`lon`), 64)
+	// note: locations are resolved via the open-meteo search endpoint
+	res := x.respond(r, Metno, ``, x.resolvePosition(lat, lng))
+	switch res.fault {
+	case FaultNone:
+		// readings are (notionally) updated hourly
+		lastModified := time.Now().UTC().Truncate(time.Hour)
+		w.Header().Set(`Last-Modified`, lastModified.Format(http.TimeFormat))
+		w.Header().Set(`Expires`, lastModified.Add(time.Hour).Format(http.TimeFormat))
+		if v, err := http.ParseTime(r.Header.Get(`If-Modified-Since`)); err == nil && !lastModified.After(v) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			`type`:     `Feature`,
+			`geometry`: map[string]any{`type`: `Point`, `coordinates`: []float64{lng, lat}},
+			`properties`: map[string]any{
+				`meta`: map[string]any{`updated_at`: lastModified.Format(time.RFC3339)},
+				`timeseries`: []any{map[string]any{
+					`time`: lastModified.Format(time.RFC3339),
+					`data`: map[string]any{`instant`: map[string]any{`details`: map[string]any{
+						`air_temperature`: round(res.reading.TemperatureDegrees, 1),
+						`wind_speed`:      round(res.reading.WindSpeed/3.6, 1),
+					}}},
+				}},
+			},
+		})
+	case FaultMalformed:
+		writeMalformed(w)
+	case FaultRateLimit:
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DeterministicPosition derives a plausible position from the query.
+func DeterministicPosition(query string) (lat, lng float64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalizeQuery(query)))
+	v := h.Sum64()
+	return float64(int64(v%18000)-9000) / 100, float64(int64((v>>32)%36000)-18000) / 100
+}
+
+// DeterministicID derives a plausible (GeoNames) id from the query.
+func DeterministicID(query string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalizeQuery(query)))
+	return int64(h.Sum64()%9000000) + 1000000
+}
+
+// resolvePosition returns the query for the nearest position previously returned by the fake geocoding api, within
+// PositionTolerance (on each axis), or the formatted position, if there are none
+func (x *Server) resolvePosition(lat, lng float64) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var (
+		query string
+		best  = math.Inf(1)
+	)
+	for k, v := range x.locations {
+		dLat, dLng := math.Abs(k.lat-lat), math.Abs(k.lng-lng)
+		if dLat > PositionTolerance || dLng > PositionTolerance {
+			continue
+		}
+		// note: ties are broken by query, for determinism
+		if d := dLat*dLat + dLng*dLng; d < best || (d == best && v < query) {
+			query, best = v, d
+		}
+	}
+	if query != `` {
+		return query
+	}
+	return formatPosition(lat, lng)
+}
+
+// respond determines the outcome of a request, including applying any latency
+func (x *Server) respond(r *http.Request, provider Provider, key, query string) response {
+	// note: scripted steps are only consumed by authorized requests, and open-meteo and met.no don't use keys
+	var step Step
+	if provider == OpenMeteo || provider == Metno || x.validKey(key) {
+		step = x.nextStep(provider, query)
+	} else {
+		step.Fault = FaultUnauthorized
+	}
+
+	if latency := x.Latency + time.Duration(step.Latency); latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-r.Context().Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	if step.Fault != FaultNone {
+		return response{fault: step.Fault}
+	}
+
+	if step.Reading != nil {
+		return response{reading: *step.Reading}
+	}
+
+	reading, ok := x.readings()(provider, query)
+	if !ok {
+		return response{fault: FaultNotFound}
+	}
+	return response{reading: reading}
+}
+
+// nextStep consumes the next scripted step, or generates one using the configured fault rates
+func (x *Server) nextStep(provider Provider, query string) (step Step) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	key := scriptKey{provider: provider, query: normalizeQuery(query)}
+	if steps := x.scripts[key]; len(steps) != 0 {
+		step, x.scripts[key] = steps[0], steps[1:]
+		return
+	}
+
+	if x.ErrorRate <= 0 && x.RateLimitRate <= 0 && x.MalformedRate <= 0 {
+		return
+	}
+	if x.Rand == nil {
+		x.Rand = rand.New(rand.NewSource(1))
+	}
+	switch v := x.Rand.Float64(); {
+	case v < x.ErrorRate:
+		step.Fault = FaultError
+	case v < x.ErrorRate+x.RateLimitRate:
+		step.Fault = FaultRateLimit
+	case v < x.ErrorRate+x.RateLimitRate+x.MalformedRate:
+		step.Fault = FaultMalformed
+	}
+	return
+}
+
+func (x *Server) readings() func(provider Provider, query string) (Reading, bool) {
+	if x.Readings != nil {
+		return x.Readings
+	}
+	return DeterministicReading
+}
+
+func (x *Server) validKey(key string) bool {
+	if key == `` {
+		return false
+	}
+	if len(x.Keys) == 0 {
+		return true
+	}
+	for _, k := range x.Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON implements json.Marshaler.
+func (x Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(x).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (x *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*x = Duration(d)
+	return nil
+}
+
+func parsePosition(s string) (lat, lng float64, ok bool) {
+	a, b, ok := strings.Cut(s, `,`)
+	if !ok {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lng, err = strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lng, true
+}
+
+func formatPosition(lat, lng float64) string {
+	return strconv.FormatFloat(lat, 'f', 2, 64) + `,` + strconv.FormatFloat(lng, 'f', 2, 64)
+}
+
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow10(places)
+	return math.Round(v*p) / p
+}
+
+func writeMalformed(w http.ResponseWriter) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(`{"main":{"temp":`))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.Header().Set(`Content-Length`, strconv.Itoa(len(b)))
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
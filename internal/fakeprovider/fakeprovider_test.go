@@ -0,0 +1,134 @@
+package fakeprovider
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	server := Server{Keys: []string{`key1`}}
+	server.Script(Openweather, `Brisbane`,
+		Step{Fault: FaultRateLimit},
+		Step{Latency: Duration(time.Millisecond * 20), Reading: &Reading{TemperatureDegrees: 25, WindSpeed: 36}},
+	)
+	if err := server.LoadScript([]byte(`{"weatherstack":{"brisbane":[{"fault":"malformed"},{"fault":"not_found"},{"fault":"error"}]}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, tc := range [...]struct {
+		path   string
+		status int
+		body   string
+	}{
+		{`/data/2.5/weather?units=metric&appid=key2&q=sydney`, 401, `{"cod":401,"message":"Invalid API key. Please see https://openweathermap.org/faq#error401 for more info."}`},
+		{`/data/2.5/weather?units=metric&appid=key1&q=sydney`, 200, `{"cod":200,"main":{"temp":5.7},"name":"sydney","wind":{"speed":9}}`},
+		{`/data/2.5/weather?units=metric&appid=key1&q=Sydney`, 200, `{"cod":200,"main":{"temp":5.7},"name":"Sydney","wind":{"speed":9}}`},
+		{`/data/2.5/weather?appid=key1&q=sydney`, 200, `{"cod":200,"main":{"temp":278.85},"name":"sydney","wind":{"speed":9}}`},
+		{`/data/2.5/weather?units=metric&appid=key1&q=`, 404, `{"cod":"404","message":"city not found"}`},
+		{`/data/2.5/weather?units=metric&appid=key1&q=brisbane`, 429, `{"cod":429,"message":"Your account is temporary blocked due to exceeding of requests limitation of your subscription type."}`},
+		{`/data/2.5/weather?units=metric&appid=key1&q=brisbane`, 200, `{"cod":200,"main":{"temp":25},"name":"brisbane","wind":{"speed":10}}`},
+		{`/current?units=m&access_key=key2&query=sydney`, 200, `{"error":{"code":101,"type":"invalid_access_key"},"success":false}`},
+		{`/current?units=m&access_key=key1&query=sydney`, 200, `{"current":{"temperature":6,"wind_speed":32},"location":{"name":"sydney"},"request":{"query":"sydney","type":"City","unit":"m"}}`},
+		{`/current?units=m&access_key=key1&query=brisbane`, 200, `{"main":{"temp":`},
+		{`/current?units=m&access_key=key1&query=brisbane`, 200, `{"error":{"code":615,"type":"request_failed"},"success":false}`},
+		{`/current?units=m&access_key=key1&query=brisbane`, 500, `{"success":false}`},
+	} {
+		res, err := ts.Client().Get(ts.URL + tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status || string(b) != tc.body {
+			t.Errorf("unexpected response to %s: %d %s", tc.path, res.StatusCode, b)
+		}
+	}
+}
+
+func TestServer_faultRates(t *testing.T) {
+	t.Parallel()
+	server := Server{ErrorRate: 0.2, RateLimitRate: 0.2, MalformedRate: 0.2}
+	counts := make(map[Fault]int)
+	for i := 0; i < 1000; i++ {
+		counts[server.nextStep(Openweather, `sydney`).Fault]++
+	}
+	for _, fault := range [...]Fault{FaultNone, FaultError, FaultRateLimit, FaultMalformed} {
+		if counts[fault] < 100 {
+			t.Errorf(`unexpected counts: %v`, counts)
+		}
+	}
+}
+
+func TestServer_metno(t *testing.T) {
+	t.Parallel()
+
+	var server Server
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	if res, err := ts.Client().Get(ts.URL + `/v1/search?name=sydney`); err != nil {
+		t.Fatal(err)
+	} else {
+		_ = res.Body.Close()
+	}
+
+	lat, lng := DeterministicPosition(`sydney`)
+	u := fmt.Sprintf(`%s/weatherapi/locationforecast/2.0/compact?lat=%.4f&lon=%.4f`, ts.URL, lat, lng)
+
+	res, err := ts.Client().Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Properties struct {
+			Timeseries []struct {
+				Data struct {
+					Instant struct {
+						Details map[string]float64 `json:"details"`
+					} `json:"instant"`
+				} `json:"data"`
+			} `json:"timeseries"`
+		} `json:"properties"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(body.Properties.Timeseries) != 1 ||
+		fmt.Sprint(body.Properties.Timeseries[0].Data.Instant.Details) != `map[air_temperature:5.7 wind_speed:9]` {
+		t.Errorf(`unexpected response: %d %+v`, res.StatusCode, body)
+	}
+	if res.Header.Get(`Last-Modified`) == `` || res.Header.Get(`Expires`) == `` {
+		t.Errorf(`unexpected headers: %v`, res.Header)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(`If-Modified-Since`, res.Header.Get(`Last-Modified`))
+	if res, err := ts.Client().Do(req); err != nil {
+		t.Fatal(err)
+	} else if _ = res.Body.Close(); res.StatusCode != http.StatusNotModified {
+		t.Errorf(`unexpected status code: %d`, res.StatusCode)
+	}
+}
@@ -0,0 +1,379 @@
+// Package gazetteer implements an in-memory index of places, loaded from a GeoNames dump, e.g. `cities15000.txt`
+// from https://download.geonames.org/export/dump/, supporting offline geocoding (by name) and reverse geocoding (by
+// position).
+package gazetteer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Index is an immutable index of places, safe for concurrent use.
+	// See also Load, Search, and Nearest.
+	Index struct {
+		// places are ordered by population, descending, such that (place) indexes also rank by population
+		places []Place
+		byID   map[int64]int
+		// names are the (normalized) name, ascii name, and alternate names of each place, sorted by name
+		names []nameEntry
+		// primary are the (normalized) names and ascii names of each place, used for fuzzy matching
+		primary map[string][]int
+		tree    kdTree
+	}
+
+	// Place models a single GeoNames entry.
+	Place struct {
+		ID             int64
+		Name           string
+		ASCIIName      string
+		AlternateNames []string
+		Latitude       float64
+		Longitude      float64
+		CountryCode    string
+		// Admin1Code is the GeoNames admin1 code, e.g. `02` for New South Wales (AU).
+		Admin1Code string
+		// Admin1 is the name of the admin1 division, and is only set if admin1 codes were loaded.
+		Admin1     string
+		Population int64
+		TimeZone   string
+	}
+
+	nameEntry struct {
+		name  string
+		place int
+	}
+
+	// match is a search result, ordered by rank, then population (i.e. place)
+	match struct {
+		place int
+		rank  int
+	}
+)
+
+const (
+	// DefaultMaxResults is used if the max results passed to Search is not positive.
+	DefaultMaxResults = 10
+
+	// maxLineSize accommodates the (potentially long) alternate names column
+	maxLineSize = 1 << 20
+
+	// columns of the GeoNames "geoname" table
+	colID             = 0
+	colName           = 1
+	colASCIIName      = 2
+	colAlternateNames = 3
+	colLatitude       = 4
+	colLongitude      = 5
+	colCountryCode    = 8
+	colAdmin1Code     = 10
+	colPopulation     = 14
+	colTimeZone       = 17
+	minColumns        = colTimeZone + 1
+
+	// match ranks, lower is better
+	rankExact  = 0
+	rankPrefix = 1
+	rankFuzzy  = 2
+)
+
+// LoadFile is a convenience wrapper around Load, the admin1 codes path is optional.
+func LoadFile(citiesPath, admin1CodesPath string) (*Index, error) {
+	cities, err := os.Open(citiesPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cities.Close()
+	var admin1Codes io.Reader
+	if admin1CodesPath != `` {
+		f, err := os.Open(admin1CodesPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		admin1Codes = f
+	}
+	return Load(cities, admin1Codes)
+}
+
+// Load builds an index from a GeoNames cities dump (tab separated, in the "geoname" table format), and optionally the
+// GeoNames admin1 codes, e.g. `admin1CodesASCII.txt`, which are used to populate Place.Admin1.
+func Load(cities io.Reader, admin1Codes io.Reader) (*Index, error) {
+	var admin1 map[string]string
+	if admin1Codes != nil {
+		var err error
+		if admin1, err = readAdmin1Codes(admin1Codes); err != nil {
+			return nil, fmt.Errorf(`gazetteer: admin1 codes: %w`, err)
+		}
+	}
+
+	var places []Place
+	if err := scanTSV(cities, func(line int, fields []string) error {
+		place, err := parsePlace(fields)
+		if err != nil {
+			return fmt.Errorf(`line %d: %w`, line, err)
+		}
+		place.Admin1 = admin1[place.CountryCode+`.`+place.Admin1Code]
+		places = append(places, place)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf(`gazetteer: cities: %w`, err)
+	}
+
+	return newIndex(places)
+}
+
+func newIndex(places []Place) (*Index, error) {
+	sort.SliceStable(places, func(i, j int) bool { return places[i].Population > places[j].Population })
+
+	x := Index{
+		places:  places,
+		byID:    make(map[int64]int, len(places)),
+		primary: make(map[string][]int, len(places)),
+	}
+	points := make([]kdPoint, len(places))
+	for i := range places {
+		place := &places[i]
+		if _, ok := x.byID[place.ID]; ok {
+			return nil, fmt.Errorf(`gazetteer: duplicate id %d`, place.ID)
+		}
+		x.byID[place.ID] = i
+
+		names := make(map[string]struct{}, len(place.AlternateNames)+2)
+		for j, name := range append([]string{place.Name, place.ASCIIName}, place.AlternateNames...) {
+			name = normalize(name)
+			if _, ok := names[name]; ok || name == `` {
+				continue
+			}
+			names[name] = struct{}{}
+			x.names = append(x.names, nameEntry{name: name, place: i})
+			if j < 2 {
+				x.primary[name] = append(x.primary[name], i)
+			}
+		}
+
+		points[i] = kdPoint{v: toVector(place.Latitude, place.Longitude), place: i}
+	}
+	sort.Slice(x.names, func(i, j int) bool {
+		if x.names[i].name != x.names[j].name {
+			return x.names[i].name < x.names[j].name
+		}
+		return x.names[i].place < x.names[j].place
+	})
+	x.tree = newKDTree(points)
+
+	return &x, nil
+}
+
+// Len returns the number of places.
+func (x *Index) Len() int { return len(x.places) }
+
+// Get returns the place with the given GeoNames id.
+func (x *Index) Get(id int64) (*Place, bool) {
+	i, ok := x.byID[id]
+	if !ok {
+		return nil, false
+	}
+	return &x.places[i], true
+}
+
+// Search returns up to maxResults places matching the query, optionally restricted to a country (ISO 3166-1
+// alpha-2). Exact (case-insensitive) matches of any name, including alternate names, rank highest, followed by prefix
+// matches, then, only if there were no other matches, fuzzy matches of the primary names. Results of the same rank
+// are ordered by population, descending.
+func (x *Index) Search(query, countryCode string, maxResults int) []*Place {
+	if maxResults <= 0 {
+		maxResults = DefaultMaxResults
+	}
+	query = normalize(query)
+	if query == `` {
+		return nil
+	}
+	countryCode = strings.ToUpper(countryCode)
+	include := func(i int) bool { return countryCode == `` || x.places[i].CountryCode == countryCode }
+
+	best := make(map[int]int)
+	add := func(place, rank int) {
+		if !include(place) {
+			return
+		}
+		if v, ok := best[place]; !ok || rank < v {
+			best[place] = rank
+		}
+	}
+
+	for i := sort.Search(len(x.names), func(i int) bool { return x.names[i].name >= query }); i < len(x.names) && strings.HasPrefix(x.names[i].name, query); i++ {
+		if x.names[i].name == query {
+			add(x.names[i].place, rankExact)
+		} else {
+			add(x.names[i].place, rankPrefix)
+		}
+	}
+
+	if len(best) == 0 {
+		if maxDistance := fuzziness(query); maxDistance > 0 {
+			for name, places := range x.primary {
+				if d := levenshtein(query, name, maxDistance); d <= maxDistance {
+					for _, place := range places {
+						add(place, rankFuzzy+d)
+					}
+				}
+			}
+		}
+	}
+
+	matches := make([]match, 0, len(best))
+	for place, rank := range best {
+		matches = append(matches, match{place: place, rank: rank})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		return matches[i].place < matches[j].place
+	})
+	if len(matches) > maxResults {
+		matches = matches[:maxResults]
+	}
+
+	results := make([]*Place, len(matches))
+	for i, m := range matches {
+		results[i] = &x.places[m.place]
+	}
+	return results
+}
+
+// Nearest returns the place nearest to the given position, and the great-circle distance to it, in kilometres, or
+// false if the index is empty.
+func (x *Index) Nearest(latitude, longitude float64) (*Place, float64, bool) {
+	i, ok := x.tree.nearest(toVector(latitude, longitude))
+	if !ok {
+		return nil, 0, false
+	}
+	place := &x.places[i]
+	return place, haversine(latitude, longitude, place.Latitude, place.Longitude), true
+}
+
+func parsePlace(fields []string) (place Place, err error) {
+	if len(fields) < minColumns {
+		return Place{}, fmt.Errorf(`expected at least %d columns, got %d`, minColumns, len(fields))
+	}
+	if place.ID, err = strconv.ParseInt(fields[colID], 10, 64); err != nil || place.ID <= 0 {
+		return Place{}, fmt.Errorf(`invalid id %q`, fields[colID])
+	}
+	if place.Latitude, err = strconv.ParseFloat(fields[colLatitude], 64); err != nil || math.Abs(place.Latitude) > 90 {
+		return Place{}, fmt.Errorf(`invalid latitude %q`, fields[colLatitude])
+	}
+	if place.Longitude, err = strconv.ParseFloat(fields[colLongitude], 64); err != nil || math.Abs(place.Longitude) > 180 {
+		return Place{}, fmt.Errorf(`invalid longitude %q`, fields[colLongitude])
+	}
+	// note: population is occasionally empty
+	if v := fields[colPopulation]; v != `` {
+		if place.Population, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return Place{}, fmt.Errorf(`invalid population %q`, v)
+		}
+	}
+	place.Name = fields[colName]
+	place.ASCIIName = fields[colASCIIName]
+	if v := fields[colAlternateNames]; v != `` {
+		place.AlternateNames = strings.Split(v, `,`)
+	}
+	place.CountryCode = strings.ToUpper(fields[colCountryCode])
+	place.Admin1Code = fields[colAdmin1Code]
+	place.TimeZone = fields[colTimeZone]
+	return place, nil
+}
+
+// readAdmin1Codes parses the admin1 codes file, mapping `<country code>.<admin1 code>` to name
+func readAdmin1Codes(r io.Reader) (map[string]string, error) {
+	codes := make(map[string]string)
+	return codes, scanTSV(r, func(line int, fields []string) error {
+		if len(fields) < 2 {
+			return fmt.Errorf(`line %d: expected at least 2 columns, got %d`, line, len(fields))
+		}
+		codes[fields[0]] = fields[1]
+		return nil
+	})
+}
+
+// scanTSV calls fn for each line, ignoring empty lines and comments
+func scanTSV(r io.Reader, fn func(line int, fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == `` || strings.HasPrefix(text, `#`) {
+			continue
+		}
+		if err := fn(line, strings.Split(text, "\t")); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ` `))
+}
+
+// fuzziness returns the maximum edit distance for fuzzy matches, scaled by the query length
+func fuzziness(query string) int {
+	switch n := len([]rune(query)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// levenshtein returns the edit distance between a and b, or max+1 if it exceeds max
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	if prev[len(rb)] > max {
+		return max + 1
+	}
+	return prev[len(rb)]
+}
+
+func minInt(v int, values ...int) int {
+	for _, w := range values {
+		if w < v {
+			v = w
+		}
+	}
+	return v
+}
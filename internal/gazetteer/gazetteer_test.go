@@ -0,0 +1,165 @@
+package gazetteer
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func loadFixture(t *testing.T) *Index {
+	index, err := LoadFile(`testdata/cities.txt`, `testdata/admin1CodesASCII.txt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func ids(places []*Place) []int64 {
+	ids := make([]int64, len(places))
+	for i, place := range places {
+		ids[i] = place.ID
+	}
+	return ids
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	index := loadFixture(t)
+	if v := index.Len(); v != 18 {
+		t.Errorf(`unexpected len: %d`, v)
+	}
+
+	place, ok := index.Get(2147714)
+	if !ok {
+		t.Fatal(`expected sydney`)
+	}
+	if place.Name != `Sydney` ||
+		place.CountryCode != `AU` ||
+		place.Admin1Code != `02` ||
+		place.Admin1 != `New South Wales` ||
+		place.TimeZone != `Australia/Sydney` ||
+		place.Population != 4627345 ||
+		place.Latitude != -33.86785 ||
+		place.Longitude != 151.20732 ||
+		len(place.AlternateNames) != 6 {
+		t.Errorf(`unexpected place: %+v`, place)
+	}
+	if _, ok := index.Get(1); ok {
+		t.Error(`expected not found`)
+	}
+
+	// admin1 codes are optional
+	index, err := LoadFile(`testdata/cities.txt`, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if place, _ := index.Get(2147714); place.Admin1 != `` || place.Admin1Code != `02` {
+		t.Errorf(`unexpected place: %+v`, place)
+	}
+
+	for _, tc := range [...]struct {
+		name  string
+		input string
+		err   string
+	}{
+		{`columns`, "1\tSydney\n", `gazetteer: cities: line 1: expected at least 18 columns, got 2`},
+		{`id`, "# comment\n\nx" + strings.Repeat("\t", 18) + "\n", `gazetteer: cities: line 3: invalid id "x"`},
+		{`latitude`, "1\tA\tA\t\t91\t0" + strings.Repeat("\t", 13) + "\n", `gazetteer: cities: line 1: invalid latitude "91"`},
+		{`duplicate`, strings.Repeat("1\tA\tA\t\t0\t0"+strings.Repeat("\t", 13)+"\n", 2), `gazetteer: duplicate id 1`},
+	} {
+		if _, err := Load(strings.NewReader(tc.input), nil); err == nil || err.Error() != tc.err {
+			t.Errorf(`%s: unexpected error: %v`, tc.name, err)
+		}
+	}
+}
+
+func TestIndex_Search(t *testing.T) {
+	t.Parallel()
+
+	index := loadFixture(t)
+	for _, tc := range [...]struct {
+		name        string
+		query       string
+		countryCode string
+		maxResults  int
+		ids         []int64
+	}{
+		{`exact ranked by population`, `springfield`, ``, 0, []int64{4409896, 4951788, 4250542}},
+		{`case and whitespace`, `  SPRINGFIELD `, ``, 0, []int64{4409896, 4951788, 4250542}},
+		{`max results`, `springfield`, ``, 1, []int64{4409896}},
+		{`country`, `sydney`, `ca`, 0, []int64{6354908}},
+		{`alternate name`, `kristiania`, ``, 0, []int64{3143244}},
+		{`alternate name non-latin`, `Сидней`, ``, 0, []int64{2147714}},
+		{`ascii name`, `zurich`, ``, 0, []int64{2657896}},
+		{`name with diacritics`, `São Paulo`, ``, 0, []int64{3448439}},
+		{`exact before prefix`, `london`, ``, 0, []int64{2643743, 6058560}},
+		{`prefix`, `melb`, ``, 0, []int64{2158177, 4163971}},
+		{`prefix multiple words`, `tamaki`, ``, 0, []int64{2193733}},
+		{`fuzzy`, `brisbain`, ``, 0, []int64{2174003}},
+		{`fuzzy distance`, `sprngfeld`, ``, 0, []int64{4409896, 4951788, 4250542}},
+		{`transpositions exceed the fuzziness`, `hobrat`, ``, 0, nil},
+		{`short queries aren't fuzzy`, `syx`, ``, 0, nil},
+		{`not found`, `atlantis`, ``, 0, nil},
+		{`empty`, ` `, ``, 0, nil},
+	} {
+		if v := ids(index.Search(tc.query, tc.countryCode, tc.maxResults)); len(v) != len(tc.ids) || (len(v) != 0 && !equalIDs(v, tc.ids)) {
+			t.Errorf(`%s: unexpected results for %q: %v`, tc.name, tc.query, v)
+		}
+	}
+}
+
+func TestIndex_Nearest(t *testing.T) {
+	t.Parallel()
+
+	index := loadFixture(t)
+	for _, tc := range [...]struct {
+		name      string
+		latitude  float64
+		longitude float64
+		id        int64
+		distance  float64
+	}{
+		{`exact`, -33.86785, 151.20732, 2147714, 0},
+		{`parramatta`, -33.8150, 151.0011, 2147714, 19.9},
+		{`gold coast`, -28.0167, 153.4000, 2174003, 71.2},
+		{`antimeridian`, -17.5, -179.9, 2110227, 189.5},
+		{`north pole`, 90, 0, 3143244, 3345.6},
+	} {
+		place, distance, ok := index.Nearest(tc.latitude, tc.longitude)
+		if !ok || place.ID != tc.id || math.Abs(distance-tc.distance) > 0.1 {
+			t.Errorf(`%s: unexpected result: %+v %f`, tc.name, place, distance)
+		}
+	}
+
+	if _, _, ok := new(Index).Nearest(0, 0); ok {
+		t.Error(`expected no result`)
+	}
+}
+
+// TestIndex_Nearest_bruteForce compares the spatial index against a linear scan.
+func TestIndex_Nearest_bruteForce(t *testing.T) {
+	t.Parallel()
+
+	index := loadFixture(t)
+	for lat := -90.0; lat <= 90; lat += 7.5 {
+		for lng := -180.0; lng <= 180; lng += 7.5 {
+			place, distance, _ := index.Nearest(lat, lng)
+			for i := range index.places {
+				other := &index.places[i]
+				if d := haversine(lat, lng, other.Latitude, other.Longitude); d < distance-1e-6 {
+					t.Fatalf(`expected %s (%f) nearest to %f,%f, got %s (%f)`, other.Name, d, lat, lng, place.Name, distance)
+				}
+			}
+		}
+	}
+}
+
+func equalIDs(a, b []int64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
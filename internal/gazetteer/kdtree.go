@@ -0,0 +1,95 @@
+package gazetteer
+
+import (
+	"math"
+	"sort"
+)
+
+type (
+	// kdTree is a static 3-d tree, of positions on the unit sphere, stored implicitly, i.e. the root of each
+	// (sub)tree is the median element of its range. Positions are converted to cartesian coordinates, so that the
+	// (euclidean) chord distance is monotonic with the great-circle distance, avoiding special cases for the poles
+	// and the antimeridian.
+	kdTree []kdPoint
+
+	kdPoint struct {
+		v     [3]float64
+		place int
+	}
+
+	kdResult struct {
+		place    int
+		distance float64
+	}
+)
+
+const earthRadiusKilometres = 6371.0088
+
+func newKDTree(points []kdPoint) kdTree {
+	buildKDTree(points, 0)
+	return points
+}
+
+func buildKDTree(points []kdPoint, depth int) {
+	if len(points) <= 1 {
+		return
+	}
+	axis := depth % 3
+	sort.Slice(points, func(i, j int) bool { return points[i].v[axis] < points[j].v[axis] })
+	mid := len(points) / 2
+	buildKDTree(points[:mid], depth+1)
+	buildKDTree(points[mid+1:], depth+1)
+}
+
+// nearest returns the place nearest to v, or false if the tree is empty, ties favour the more populous place
+func (x kdTree) nearest(v [3]float64) (int, bool) {
+	if len(x) == 0 {
+		return 0, false
+	}
+	best := kdResult{place: -1, distance: math.Inf(1)}
+	x.search(v, 0, &best)
+	return best.place, true
+}
+
+func (x kdTree) search(v [3]float64, depth int, best *kdResult) {
+	if len(x) == 0 {
+		return
+	}
+	mid := len(x) / 2
+	if d := squaredDistance(x[mid].v, v); d < best.distance || (d == best.distance && x[mid].place < best.place) {
+		best.place, best.distance = x[mid].place, d
+	}
+	axis := depth % 3
+	diff := v[axis] - x[mid].v[axis]
+	near, far := x[:mid], x[mid+1:]
+	if diff > 0 {
+		near, far = far, near
+	}
+	near.search(v, depth+1, best)
+	if diff*diff <= best.distance {
+		far.search(v, depth+1, best)
+	}
+}
+
+func toVector(latitude, longitude float64) [3]float64 {
+	lat, lng := latitude*math.Pi/180, longitude*math.Pi/180
+	return [3]float64{math.Cos(lat) * math.Cos(lng), math.Cos(lat) * math.Sin(lng), math.Sin(lat)}
+}
+
+func squaredDistance(a, b [3]float64) float64 {
+	var d float64
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
+
+// haversine returns the great-circle distance between two positions, in kilometres
+func haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	const toRadians = math.Pi / 180
+	dLat := (lat2 - lat1) * toRadians
+	dLng := (lng2 - lng1) * toRadians
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRadians)*math.Cos(lat2*toRadians)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKilometres * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,51 @@
+package health
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// Register wires up the liveness (/healthz) and readiness (/readyz) endpoints, including the readiness of each
+// provider (/readyz/{provider}), which respond 503 if not ready, e.g. so load balancers may drain the process.
+func (x *Checker) Register(r chi.Router) {
+	r.Get(`/healthz`, x.getHealth)
+	r.Get(`/readyz`, x.getReadiness)
+	r.Get(`/readyz/{provider}`, x.getProviderReadiness)
+}
+
+func (x *Checker) getHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{`status`: `ok`})
+}
+
+func (x *Checker) getReadiness(w http.ResponseWriter, r *http.Request) {
+	res := x.Readiness()
+	writeJSON(w, readinessStatusCode(res.Ready), res)
+}
+
+func (x *Checker) getProviderReadiness(w http.ResponseWriter, r *http.Request) {
+	res, ok := x.Provider(chi.URLParam(r, `provider`))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, readinessStatusCode(res.Ready), res)
+}
+
+func readinessStatusCode(ready bool) int {
+	if ready {
+		return http.StatusOK
+	}
+	return http.StatusServiceUnavailable
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,341 @@
+// Package health tracks the outcome of the calls made to each weather provider, reporting the readiness of each, and
+// of the process as a whole, see Checker.
+package health
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+type (
+	// Checker tracks the outcome of the calls made to each provider, which must be added (see Add) to be tracked.
+	// Safe for concurrent use, and a nil Checker ignores all outcomes. See also Register.
+	//
+	// Each provider is StatusOK if it succeeded within the Window, StatusFailing if it has failed (at least)
+	// FailureThreshold times in a row, the last within the Window, and otherwise StatusUnknown, e.g. if it hasn't
+	// been called recently. A provider is ready if it is StatusOK, and the process is ready if any provider is.
+	// As an idle (or new) process would otherwise never become ready, providers may be probed, see Run. Probing is
+	// opt-in, as it calls the (possibly paid) upstream APIs, so if Probe isn't set, a StatusUnknown provider is also
+	// ready if its last outcome was a success (i.e. it is idle), or if it has no outcome, and was added within the
+	// GracePeriod (i.e. it is new).
+	Checker struct {
+		// Window is how recent an outcome must be for it to be considered, defaults to DefaultWindow.
+		Window time.Duration
+
+		// FailureThreshold is the number of consecutive failures at which a provider is failing, defaults to
+		// DefaultFailureThreshold.
+		FailureThreshold int
+
+		// Probe calls the provider, e.g. fetching the current weather of a well known location, required by Run.
+		Probe func(ctx context.Context, provider string) error
+
+		// ProbeInterval is how often Run probes the providers, which also bounds each probe, defaults to
+		// DefaultProbeInterval.
+		ProbeInterval time.Duration
+
+		// GracePeriod is how long a provider that isn't probed is ready, after it is added, until it has an outcome,
+		// defaults to DefaultGracePeriod.
+		GracePeriod time.Duration
+
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+
+		mu        sync.Mutex
+		providers []*entry
+	}
+
+	// ProviderStatus models the readiness of a single provider.
+	ProviderStatus struct {
+		Provider            string     `json:"provider"`
+		Status              Status     `json:"status"`
+		Ready               bool       `json:"ready"`
+		ConsecutiveFailures int        `json:"consecutive_failures"`
+		LastSuccess         *time.Time `json:"last_success,omitempty"`
+		LastFailure         *time.Time `json:"last_failure,omitempty"`
+		LastError           string     `json:"last_error,omitempty"`
+	}
+
+	// Readiness models the readiness of the process, and each of its providers.
+	Readiness struct {
+		Ready     bool             `json:"ready"`
+		Providers []ProviderStatus `json:"providers"`
+	}
+
+	// Status indicates the state of a provider, see Checker.
+	Status string
+
+	entry struct {
+		status ProviderStatus
+		added  time.Time
+	}
+)
+
+const (
+	// StatusOK indicates the provider succeeded recently.
+	StatusOK Status = `ok`
+	// StatusUnknown indicates the provider hasn't succeeded, or failed, recently.
+	StatusUnknown Status = `unknown`
+	// StatusFailing indicates the provider has failed repeatedly, and recently.
+	StatusFailing Status = `failing`
+)
+
+const (
+	// DefaultWindow is the default value for Checker.Window.
+	DefaultWindow = time.Minute * 5
+
+	// DefaultFailureThreshold is the default value for Checker.FailureThreshold.
+	DefaultFailureThreshold = 3
+
+	// DefaultWatchInterval is the interval at which Checker.Watch polls the readiness of each provider.
+	DefaultWatchInterval = time.Second
+
+	// DefaultProbeInterval is the default value for Checker.ProbeInterval.
+	DefaultProbeInterval = time.Minute
+
+	// DefaultGracePeriod is the default value for Checker.GracePeriod.
+	DefaultGracePeriod = time.Minute * 5
+)
+
+var (
+	// compile time assertions
+
+	_ grpc.UnaryServerInterceptor = (*Checker)(nil).UnaryServerInterceptor(``)
+)
+
+// Add tracks the given providers, which are otherwise ignored, e.g. each configured provider. Adding a provider
+// that is already tracked has no effect.
+func (x *Checker) Add(providers ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.timeNow()
+	for _, provider := range providers {
+		if x.find(provider) == nil {
+			x.providers = append(x.providers, &entry{status: ProviderStatus{Provider: provider}, added: now})
+		}
+	}
+}
+
+// Observe records the outcome of a call to a provider. Errors that aren't attributable to the provider, i.e.
+// cancellation, an invalid argument, or a location that wasn't found, are ignored, see Failure.
+func (x *Checker) Observe(provider string, err error) {
+	if x == nil {
+		return
+	}
+	if err != nil && !Failure(err) {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e := x.find(provider)
+	if e == nil {
+		return
+	}
+	now := x.timeNow()
+	if err == nil {
+		e.status.ConsecutiveFailures = 0
+		e.status.LastSuccess = &now
+	} else {
+		e.status.ConsecutiveFailures++
+		e.status.LastFailure = &now
+		e.status.LastError = err.Error()
+	}
+}
+
+// Readiness returns a snapshot of the readiness of each provider, in the order they were added. The process is
+// ready if any provider is.
+func (x *Checker) Readiness() Readiness {
+	res := Readiness{Providers: []ProviderStatus{}}
+	if x == nil {
+		return res
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.timeNow()
+	for _, e := range x.providers {
+		status := x.evaluate(e, now)
+		res.Ready = res.Ready || status.Ready
+		res.Providers = append(res.Providers, status)
+	}
+	return res
+}
+
+// Provider returns the readiness of a single provider, or false if it isn't tracked.
+func (x *Checker) Provider(provider string) (ProviderStatus, bool) {
+	if x == nil {
+		return ProviderStatus{}, false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	e := x.find(provider)
+	if e == nil {
+		return ProviderStatus{}, false
+	}
+	return x.evaluate(e, x.timeNow()), true
+}
+
+// Watch calls fn with the readiness of each provider, initially, then each time it changes, polling every
+// DefaultWatchInterval, until the context is done. Intended to report the readiness of each provider via the gRPC
+// health service.
+func (x *Checker) Watch(ctx context.Context, fn func(provider string, ready bool)) {
+	ticker := time.NewTicker(DefaultWatchInterval)
+	defer ticker.Stop()
+	reported := make(map[string]bool)
+	for {
+		for _, status := range x.Readiness().Providers {
+			if ready, ok := reported[status.Provider]; !ok || ready != status.Ready {
+				reported[status.Provider] = status.Ready
+				fn(status.Provider, status.Ready)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run calls Probe, initially, then every ProbeInterval, for each provider that hasn't succeeded within half the
+// Window, recording the outcome, until the context is done. Providers that have succeeded recently (e.g. serving
+// requests) aren't probed, such that probes are only made while the process is idle, or the provider is failing.
+func (x *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(x.probeInterval())
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, provider := range x.stale() {
+			wg.Add(1)
+			go func(provider string) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(ctx, x.probeInterval())
+				defer cancel()
+				err := x.Probe(ctx, provider)
+				if ctx.Err() == nil {
+					x.Observe(provider, err)
+				}
+			}(provider)
+		}
+		wg.Wait()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// UnaryServerInterceptor returns an interceptor that records the outcome of each call handled by the provider, e.g.
+// for a provider served standalone.
+func (x *Checker) UnaryServerInterceptor(provider string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		res, err := handler(ctx, req)
+		if ctx.Err() == nil {
+			x.Observe(provider, err)
+		}
+		return res, err
+	}
+}
+
+// Failure returns true if the error is attributable to the provider, i.e. it isn't the result of cancellation, an
+// invalid argument, or a location that wasn't found.
+func Failure(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.OK, codes.Canceled, codes.InvalidArgument, codes.NotFound:
+		return false
+	default:
+		return true
+	}
+}
+
+// evaluate returns the current status of the provider, must be called with the mutex held
+func (x *Checker) evaluate(e *entry, now time.Time) ProviderStatus {
+	status := e.status
+	window := x.window()
+	recent := func(t *time.Time) bool { return t != nil && now.Sub(*t) <= window }
+	switch {
+	case status.ConsecutiveFailures >= x.failureThreshold() && recent(status.LastFailure):
+		status.Status = StatusFailing
+	case recent(status.LastSuccess):
+		status.Status = StatusOK
+	default:
+		status.Status = StatusUnknown
+	}
+	status.Ready = status.Status == StatusOK
+	if status.Status == StatusUnknown && x.Probe == nil {
+		switch {
+		case status.LastSuccess != nil:
+			// idle, unless it has failed since
+			status.Ready = status.LastFailure == nil || status.LastSuccess.After(*status.LastFailure)
+		case status.LastFailure == nil:
+			// new
+			status.Ready = now.Sub(e.added) < x.gracePeriod()
+		}
+	}
+	return status
+}
+
+// stale returns the providers that haven't succeeded within half the window, see Run
+func (x *Checker) stale() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.timeNow()
+	var providers []string
+	for _, e := range x.providers {
+		if t := e.status.LastSuccess; t == nil || now.Sub(*t) > x.window()/2 {
+			providers = append(providers, e.status.Provider)
+		}
+	}
+	return providers
+}
+
+func (x *Checker) find(provider string) *entry {
+	for _, e := range x.providers {
+		if e.status.Provider == provider {
+			return e
+		}
+	}
+	return nil
+}
+
+func (x *Checker) window() time.Duration {
+	if x.Window > 0 {
+		return x.Window
+	}
+	return DefaultWindow
+}
+
+func (x *Checker) probeInterval() time.Duration {
+	if x.ProbeInterval > 0 {
+		return x.ProbeInterval
+	}
+	return DefaultProbeInterval
+}
+
+func (x *Checker) gracePeriod() time.Duration {
+	if x.GracePeriod > 0 {
+		return x.GracePeriod
+	}
+	return DefaultGracePeriod
+}
+
+func (x *Checker) failureThreshold() int {
+	if x.FailureThreshold > 0 {
+		return x.FailureThreshold
+	}
+	return DefaultFailureThreshold
+}
+
+func (x *Checker) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
@@ -0,0 +1,346 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChecker_Readiness(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	checker := Checker{
+		Window:           time.Minute,
+		FailureThreshold: 2,
+		// note: providers are only ready if they are ok, if probed (see Run, which isn't used here)
+		Probe:   func(ctx context.Context, provider string) error { return nil },
+		TimeNow: func() time.Time { return now },
+	}
+	statuses := func() (ready bool, statuses []Status) {
+		res := checker.Readiness()
+		for _, v := range res.Providers {
+			statuses = append(statuses, v.Status)
+		}
+		return res.Ready, statuses
+	}
+	check := func(name string, ready bool, expected ...Status) {
+		t.Helper()
+		if v, s := statuses(); v != ready || len(s) != len(expected) {
+			t.Errorf(`%s: unexpected readiness: %v %v`, name, v, s)
+		} else {
+			for i := range s {
+				if s[i] != expected[i] {
+					t.Errorf(`%s: unexpected readiness: %v %v`, name, v, s)
+					break
+				}
+			}
+		}
+	}
+	failure := status.Error(codes.Unavailable, `weatherstack: request failed`)
+
+	check(`no providers`, false)
+
+	checker.Add(`weatherstack`, `openmeteo`, `weatherstack`)
+	checker.Observe(`metno`, nil)
+	// note: providers aren't ready until they succeed
+	check(`added`, false, StatusUnknown, StatusUnknown)
+
+	checker.Observe(`weatherstack`, nil)
+	checker.Observe(`openmeteo`, failure)
+	check(`first failure`, true, StatusOK, StatusUnknown)
+
+	// not attributable to the provider
+	for _, err := range [...]error{context.Canceled, status.Error(codes.NotFound, `nope`), status.Error(codes.InvalidArgument, `nope`)} {
+		checker.Observe(`openmeteo`, err)
+	}
+	check(`ignored`, true, StatusOK, StatusUnknown)
+
+	checker.Observe(`openmeteo`, failure)
+	checker.Observe(`weatherstack`, failure)
+	checker.Observe(`weatherstack`, errors.New(`some error`))
+	check(`failing`, false, StatusFailing, StatusFailing)
+	if v, ok := checker.Provider(`weatherstack`); !ok || v.Ready || v.ConsecutiveFailures != 2 ||
+		v.LastError != `some error` || v.LastSuccess == nil || v.LastFailure == nil {
+		t.Errorf(`unexpected status: %+v`, v)
+	}
+	if _, ok := checker.Provider(`metno`); ok {
+		t.Error(`expected metno to be untracked`)
+	}
+
+	checker.Observe(`openmeteo`, nil)
+	check(`recovered`, true, StatusFailing, StatusOK)
+
+	// note: failures expire, such that the provider will be attempted again
+	now = now.Add(time.Minute + 1)
+	check(`expired`, false, StatusUnknown, StatusUnknown)
+}
+
+func TestChecker_Readiness_unprobed(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	checker := Checker{
+		Window:           time.Minute,
+		FailureThreshold: 2,
+		GracePeriod:      time.Minute * 2,
+		TimeNow:          func() time.Time { return now },
+	}
+	check := func(name string, expected ...bool) {
+		t.Helper()
+		res := checker.Readiness()
+		for i, v := range res.Providers {
+			if v.Ready != expected[i] || (v.Ready && v.Status == StatusFailing) {
+				t.Errorf(`%s: unexpected readiness: %+v`, name, res)
+				break
+			}
+		}
+	}
+	failure := status.Error(codes.Unavailable, `request failed`)
+
+	checker.Add(`weatherstack`, `openweather`, `openmeteo`, `metno`)
+	check(`new`, true, true, true, true)
+
+	checker.Observe(`weatherstack`, nil)
+	checker.Observe(`openweather`, failure)
+	checker.Observe(`openmeteo`, nil)
+	checker.Observe(`openmeteo`, failure)
+	check(`outcomes`, true, false, true, true)
+
+	// idle providers remain ready, if their last outcome was a success
+	now = now.Add(time.Minute * 2)
+	check(`idle`, true, false, false, false)
+	if v, _ := checker.Provider(`weatherstack`); v.Status != StatusUnknown {
+		t.Errorf(`unexpected status: %+v`, v)
+	}
+}
+
+func TestChecker_Register(t *testing.T) {
+	t.Parallel()
+
+	checker := Checker{FailureThreshold: 1}
+	router := chi.NewRouter()
+	router.Group(checker.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	get := func(path string, v any) int {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	var readiness Readiness
+	if code := get(`/readyz`, &readiness); code != http.StatusServiceUnavailable || readiness.Ready || len(readiness.Providers) != 0 {
+		t.Errorf(`unexpected readiness: %d %+v`, code, readiness)
+	}
+
+	checker.Add(`openweather`, `metno`)
+	checker.Observe(`openweather`, nil)
+	checker.Observe(`metno`, status.Error(codes.Unavailable, `metno: request failed`))
+	if code := get(`/readyz`, &readiness); code != http.StatusOK || !readiness.Ready || len(readiness.Providers) != 2 {
+		t.Errorf(`unexpected readiness: %d %+v`, code, readiness)
+	}
+	for _, tc := range [...]struct {
+		provider string
+		code     int
+		status   Status
+	}{
+		{`openweather`, http.StatusOK, StatusOK},
+		{`metno`, http.StatusServiceUnavailable, StatusFailing},
+	} {
+		var v ProviderStatus
+		if code := get(`/readyz/`+tc.provider, &v); code != tc.code || v.Provider != tc.provider || v.Status != tc.status {
+			t.Errorf(`unexpected readiness of %s: %d %+v`, tc.provider, code, v)
+		}
+	}
+	if code := get(`/readyz/weatherstack`, nil); code != http.StatusNotFound {
+		t.Errorf(`unexpected status code: %d`, code)
+	}
+
+	// liveness is independent of the providers
+	checker.Observe(`openweather`, status.Error(codes.Unavailable, `openweather: request failed`))
+	var health map[string]string
+	if code := get(`/healthz`, &health); code != http.StatusOK || health[`status`] != `ok` {
+		t.Errorf(`unexpected health: %d %v`, code, health)
+	}
+	if code := get(`/readyz`, &readiness); code != http.StatusServiceUnavailable || readiness.Ready {
+		t.Errorf(`unexpected readiness: %d %+v`, code, readiness)
+	}
+}
+
+func TestChecker_Watch(t *testing.T) {
+	t.Parallel()
+
+	checker := Checker{Probe: func(ctx context.Context, provider string) error { return nil }}
+	checker.Add(`openweather`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reports := make(chan bool, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		checker.Watch(ctx, func(provider string, ready bool) {
+			if provider != `openweather` {
+				t.Errorf(`unexpected provider: %s`, provider)
+			}
+			reports <- ready
+		})
+	}()
+	if ready := <-reports; ready {
+		t.Error(`expected the provider to not be ready, initially`)
+	}
+
+	interceptor := checker.UnaryServerInterceptor(`openweather`)
+	_, _ = interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	select {
+	case ready := <-reports:
+		if !ready {
+			t.Error(`expected the provider to be ready`)
+		}
+	case <-time.After(DefaultWatchInterval * 5):
+		t.Error(`expected a report`)
+	}
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		_, _ = interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+			return nil, status.Error(codes.Unavailable, `openweather: request failed`)
+		})
+	}
+	select {
+	case ready := <-reports:
+		if ready {
+			t.Error(`expected the provider to be failing`)
+		}
+	case <-time.After(DefaultWatchInterval * 5):
+		t.Error(`expected a report`)
+	}
+	cancel()
+	<-done
+	if len(reports) != 0 {
+		t.Errorf(`unexpected reports: %d`, len(reports))
+	}
+}
+
+func TestChecker_Run(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		now    = time.Unix(1667179321, 0)
+		probes = make(map[string]int)
+	)
+	timeNow := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	probed := make(chan struct{}, 2)
+	checker := Checker{
+		Window:           time.Minute,
+		FailureThreshold: 1,
+		TimeNow:          timeNow,
+		ProbeInterval:    time.Millisecond * 10,
+		Probe: func(ctx context.Context, provider string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			probes[provider]++
+			select {
+			case probed <- struct{}{}:
+			default:
+			}
+			if provider == `metno` {
+				return status.Error(codes.Unavailable, `metno: request failed`)
+			}
+			return nil
+		},
+	}
+	checker.Add(`openmeteo`, `metno`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		checker.Run(ctx)
+	}()
+	<-probed
+	<-probed
+
+	// the providers are probed until they succeed, i.e. only the failing provider is probed again
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		mu.Lock()
+		n := probes[`metno`]
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`timed out`)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	mu.Lock()
+	if probes[`openmeteo`] != 1 {
+		t.Errorf(`unexpected probes: %v`, probes)
+	}
+	mu.Unlock()
+	if v := checker.Readiness(); !v.Ready || !v.Providers[0].Ready || v.Providers[1].Status != StatusFailing {
+		t.Errorf(`unexpected readiness: %+v`, v)
+	}
+
+	// successes older than half the window are refreshed
+	mu.Lock()
+	now = now.Add(time.Second * 31)
+	mu.Unlock()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go checker.Run(ctx)
+	deadline = time.Now().Add(time.Second * 5)
+	for {
+		mu.Lock()
+		n := probes[`openmeteo`]
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`timed out`)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestChecker_nil(t *testing.T) {
+	t.Parallel()
+	var checker *Checker
+	checker.Observe(`openweather`, nil)
+	if v := checker.Readiness(); v.Ready || v.Providers == nil {
+		t.Errorf(`unexpected readiness: %+v`, v)
+	}
+	if _, ok := checker.Provider(`openweather`); ok {
+		t.Error(`expected no provider`)
+	}
+}
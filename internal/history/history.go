@@ -0,0 +1,339 @@
+// Package history persists the readings received from weather providers, as append-only JSON lines files, one per
+// (UTC) day, supporting time range queries, downsampling, and retention.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Store persists readings under Dir, safe for concurrent use, and a nil Store ignores all readings.
+	// See also Record, Query, and Downsample.
+	Store struct {
+		// Dir is the directory the files are written to, it will be created if necessary.
+		Dir string
+
+		// Retention is the minimum period readings are kept for, defaults to DefaultRetention. Files are removed (a
+		// whole day at a time) once the day they contain is entirely outside the retention period.
+		Retention time.Duration
+
+		// TimeNow defaults to time.Now.
+		TimeNow func() time.Time
+
+		mu     sync.Mutex
+		file   *os.File
+		day    string
+		latest map[latestKey]time.Time
+	}
+
+	// Reading is a single normalized reading, note that wind speed is in km/h.
+	Reading struct {
+		Provider           string    `json:"provider"`
+		Location           string    `json:"location"`
+		ReadTime           time.Time `json:"read_time"`
+		TemperatureDegrees float64   `json:"temperature_degrees"`
+		WindSpeed          float64   `json:"wind_speed"`
+	}
+
+	// Point is the mean of the readings within an interval, starting at Time.
+	Point struct {
+		Time               time.Time `json:"time"`
+		Samples            int       `json:"samples"`
+		TemperatureDegrees float64   `json:"temperature_degrees"`
+		WindSpeed          float64   `json:"wind_speed"`
+	}
+
+	latestKey struct {
+		provider string
+		location string
+	}
+)
+
+const (
+	// DefaultRetention is the default value for Store.Retention.
+	DefaultRetention = time.Hour * 24 * 30
+
+	dayLayout = `2006-01-02`
+	fileExt   = `.jsonl`
+)
+
+// NormalizeLocation converts a query to the key used to store readings, e.g. `Sydney ` becomes `sydney`.
+func NormalizeLocation(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+// Record appends a reading, normalizing the location. Readings which have already been recorded (e.g. cached) are
+// ignored, as are readings outside the retention period.
+func (x *Store) Record(reading Reading) error {
+	if x == nil {
+		return nil
+	}
+
+	reading.Location = NormalizeLocation(reading.Location)
+	if reading.Location == `` || reading.Provider == `` || reading.ReadTime.IsZero() {
+		return errors.New(`history: provider, location, and read time required`)
+	}
+	reading.ReadTime = reading.ReadTime.UTC()
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	now := x.timeNow()
+	if reading.ReadTime.Before(now.Add(-x.retention())) {
+		return nil
+	}
+
+	key := latestKey{provider: reading.Provider, location: reading.Location}
+	if latest, ok := x.latest[key]; ok && !reading.ReadTime.After(latest) {
+		// already recorded, or out of order
+		return nil
+	}
+
+	b, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	file, err := x.openFile(reading.ReadTime.Format(dayLayout), now)
+	if err != nil {
+		return err
+	}
+	// note: a single write per line, so readers will at most see a partial last line
+	if _, err := file.Write(b); err != nil {
+		return err
+	}
+
+	if x.latest == nil {
+		x.latest = make(map[latestKey]time.Time)
+	}
+	x.latest[key] = reading.ReadTime
+
+	return nil
+}
+
+// Query returns the readings for the given location, with a read time in the range [from, to), ordered by read time.
+// The provider is optional, and will filter the readings if set.
+//
+// The files are scanned without holding the mutex, i.e. without blocking Record, as they are append-only, and each
+// reading is a single write. Readings recorded during the scan may or may not be included.
+func (x *Store) Query(provider, query string, from, to time.Time) ([]Reading, error) {
+	if x == nil {
+		return nil, nil
+	}
+	location := NormalizeLocation(query)
+	from, to = from.UTC(), to.UTC()
+
+	x.mu.Lock()
+	days, err := x.days()
+	x.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []Reading
+	for _, day := range days {
+		if day < from.Format(dayLayout) || day > to.Format(dayLayout) {
+			continue
+		}
+		err := x.scanFile(day, func(reading Reading) {
+			if reading.Location != location ||
+				(provider != `` && reading.Provider != provider) ||
+				reading.ReadTime.Before(from) ||
+				!reading.ReadTime.Before(to) {
+				return
+			}
+			readings = append(readings, reading)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].ReadTime.Before(readings[j].ReadTime) })
+
+	return readings, nil
+}
+
+// Prune removes the files which are entirely outside the retention period. It is called automatically, as each new
+// file is created.
+func (x *Store) Prune() error {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.prune(x.timeNow())
+}
+
+// Close closes the current file, if any. The store may continue to be used.
+func (x *Store) Close() error {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.closeFile()
+}
+
+// Downsample groups the (ordered) readings into intervals, aligned to from, returning the mean of each non-empty
+// interval.
+func Downsample(readings []Reading, from time.Time, interval time.Duration) []Point {
+	if interval <= 0 {
+		panic(fmt.Errorf(`history: invalid interval: %s`, interval))
+	}
+	var points []Point
+	for _, reading := range readings {
+		if reading.ReadTime.Before(from) {
+			continue
+		}
+		start := from.Add(reading.ReadTime.Sub(from) / interval * interval).UTC()
+		if len(points) == 0 || !points[len(points)-1].Time.Equal(start) {
+			points = append(points, Point{Time: start})
+		}
+		point := &points[len(points)-1]
+		point.Samples++
+		// incremental mean
+		point.TemperatureDegrees += (reading.TemperatureDegrees - point.TemperatureDegrees) / float64(point.Samples)
+		point.WindSpeed += (reading.WindSpeed - point.WindSpeed) / float64(point.Samples)
+	}
+	return points
+}
+
+// openFile returns the file for the given day, must be called with the mutex held
+func (x *Store) openFile(day string, now time.Time) (*os.File, error) {
+	if x.file != nil && x.day == day {
+		return x.file, nil
+	}
+	if err := x.closeFile(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(x.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(x.path(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	x.file, x.day = file, day
+	if err := x.prune(now); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (x *Store) closeFile() error {
+	if x.file == nil {
+		return nil
+	}
+	err := x.file.Close()
+	x.file, x.day = nil, ``
+	return err
+}
+
+// prune must be called with the mutex held
+func (x *Store) prune(now time.Time) error {
+	cutoff := now.Add(-x.retention()).UTC()
+
+	days, err := x.days()
+	if err != nil {
+		return err
+	}
+	for _, day := range days {
+		// note: the day is removed once the following day starts before the cutoff
+		t, err := time.Parse(dayLayout, day)
+		if err != nil || !t.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+		if day == x.day {
+			if err := x.closeFile(); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(x.path(day)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	for key, readTime := range x.latest {
+		if readTime.Before(cutoff) {
+			delete(x.latest, key)
+		}
+	}
+
+	return nil
+}
+
+// days returns the (sorted) days with files, must be called with the mutex held
+func (x *Store) days() ([]string, error) {
+	entries, err := os.ReadDir(x.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var days []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		day := strings.TrimSuffix(name, fileExt)
+		if _, err := time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// scanFile may be called without the mutex held, note the file may have been pruned
+func (x *Store) scanFile(day string, fn func(reading Reading)) error {
+	file, err := os.Open(x.path(day))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var reading Reading
+		if err := json.Unmarshal(scanner.Bytes(), &reading); err != nil {
+			// e.g. a partial line, after a crash
+			continue
+		}
+		fn(reading)
+	}
+	return scanner.Err()
+}
+
+func (x *Store) path(day string) string {
+	return filepath.Join(x.Dir, day+fileExt)
+}
+
+func (x *Store) retention() time.Duration {
+	if x.Retention > 0 {
+		return x.Retention
+	}
+	return DefaultRetention
+}
+
+func (x *Store) timeNow() time.Time {
+	if x.TimeNow != nil {
+		return x.TimeNow()
+	}
+	return time.Now()
+}
@@ -0,0 +1,213 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore_Record(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 10, 31, 1, 0, 0, 0, time.UTC)
+	store := Store{
+		Dir:     t.TempDir(),
+		TimeNow: func() time.Time { return now },
+	}
+	defer store.Close()
+
+	for _, reading := range [...]Reading{
+		{Provider: `weatherstack`, Location: `Sydney `, ReadTime: now.Add(-time.Hour * 2), TemperatureDegrees: 20, WindSpeed: 10},
+		{Provider: `openweather`, Location: `sydney`, ReadTime: now.Add(-time.Hour * 2), TemperatureDegrees: 21, WindSpeed: 12},
+		// cached, ignored
+		{Provider: `weatherstack`, Location: `sydney`, ReadTime: now.Add(-time.Hour * 2), TemperatureDegrees: 100},
+		{Provider: `weatherstack`, Location: `SYDNEY`, ReadTime: now.Add(-time.Minute), TemperatureDegrees: 22, WindSpeed: 11},
+		{Provider: `weatherstack`, Location: `brisbane`, ReadTime: now.Add(-time.Minute), TemperatureDegrees: 28, WindSpeed: 5},
+	} {
+		if err := store.Record(reading); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Record(Reading{Provider: `weatherstack`, ReadTime: now}); err == nil {
+		t.Error(`expected error`)
+	}
+
+	// two files, one per day
+	if v, err := store.days(); err != nil || !reflect.DeepEqual(v, []string{`2022-10-30`, `2022-10-31`}) {
+		t.Errorf(`unexpected days: %v %v`, v, err)
+	}
+
+	for _, tc := range [...]struct {
+		provider string
+		query    string
+		from     time.Time
+		to       time.Time
+		temps    []float64
+	}{
+		{query: `sydney`, from: now.Add(-time.Hour * 3), to: now, temps: []float64{20, 21, 22}},
+		{provider: `weatherstack`, query: ` Sydney`, from: now.Add(-time.Hour * 3), to: now, temps: []float64{20, 22}},
+		{query: `sydney`, from: now.Add(-time.Hour), to: now, temps: []float64{22}},
+		{query: `sydney`, from: now.Add(-time.Hour * 3), to: now.Add(-time.Minute), temps: []float64{20, 21}},
+		{query: `brisbane`, from: now.Add(-time.Hour * 3), to: now, temps: []float64{28}},
+		{query: `perth`, from: now.Add(-time.Hour * 3), to: now},
+	} {
+		readings, err := store.Query(tc.provider, tc.query, tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var temps []float64
+		for _, reading := range readings {
+			temps = append(temps, reading.TemperatureDegrees)
+		}
+		if !reflect.DeepEqual(temps, tc.temps) {
+			t.Errorf(`unexpected readings for %q %q: %+v`, tc.provider, tc.query, readings)
+		}
+	}
+}
+
+func TestStore_Query_concurrent(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 10, 31, 23, 0, 0, 0, time.UTC)
+	store := Store{
+		Dir:     t.TempDir(),
+		TimeNow: func() time.Time { return start },
+	}
+	defer store.Close()
+
+	// readings span two days, and are recorded while querying
+	const n = 200
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := store.Record(Reading{Provider: `openmeteo`, Location: `sydney`, ReadTime: start.Add(time.Minute * time.Duration(i)), TemperatureDegrees: float64(i)}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	check := func() int {
+		readings, err := store.Query(``, `sydney`, start, start.Add(time.Minute*n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, reading := range readings {
+			if reading.TemperatureDegrees != float64(i) {
+				t.Fatalf(`unexpected reading %d: %+v`, i, reading)
+			}
+		}
+		return len(readings)
+	}
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v := check(); v != n {
+				t.Errorf(`unexpected readings: %d`, v)
+			}
+			return
+		default:
+			check()
+		}
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 10, 31, 1, 0, 0, 0, time.UTC)
+	store := Store{
+		Dir:       t.TempDir(),
+		Retention: time.Hour * 24,
+		TimeNow:   func() time.Time { return now },
+	}
+	defer store.Close()
+
+	for _, day := range [...]string{`2022-10-28`, `2022-10-29`, `2022-10-30`} {
+		if err := os.WriteFile(store.path(day), []byte(`{"provider":"weatherstack","location":"sydney","read_time":"`+day+`T12:00:00Z"}`+"\n{\"partial"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(store.Dir, `unrelated.txt`), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// outside the retention period
+	if err := store.Record(Reading{Provider: `weatherstack`, Location: `sydney`, ReadTime: now.Add(-time.Hour * 25)}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.days(); err != nil || len(v) != 3 {
+		t.Errorf(`unexpected days: %v %v`, v, err)
+	}
+
+	// creating a new file prunes
+	if err := store.Record(Reading{Provider: `weatherstack`, Location: `sydney`, ReadTime: now}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.days(); err != nil || !reflect.DeepEqual(v, []string{`2022-10-30`, `2022-10-31`}) {
+		t.Errorf(`unexpected days: %v %v`, v, err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Dir, `unrelated.txt`)); err != nil {
+		t.Error(err)
+	}
+
+	// partial lines are skipped
+	if v, err := store.Query(``, `sydney`, now.Add(-time.Hour*48), now.Add(time.Second)); err != nil || len(v) != 2 {
+		t.Errorf(`unexpected readings: %+v %v`, v, err)
+	}
+
+	now = now.Add(time.Hour * 24)
+	if err := store.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := store.days(); err != nil || !reflect.DeepEqual(v, []string{`2022-10-31`}) {
+		t.Errorf(`unexpected days: %v %v`, v, err)
+	}
+}
+
+func TestStore_nil(t *testing.T) {
+	t.Parallel()
+	var store *Store
+	if err := store.Record(Reading{Provider: `weatherstack`, Location: `sydney`, ReadTime: time.Now()}); err != nil {
+		t.Error(err)
+	}
+	if v, err := store.Query(``, `sydney`, time.Time{}, time.Now()); v != nil || err != nil {
+		t.Error(v, err)
+	}
+	if err := store.Prune(); err != nil {
+		t.Error(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	t.Parallel()
+	from := time.Date(2022, 10, 31, 1, 30, 0, 0, time.UTC)
+	var readings []Reading
+	// note: the first reading is before from
+	for i, v := range [...]float64{1, 2, 3, 4, 5, 6, 7} {
+		readings = append(readings, Reading{
+			ReadTime:           from.Add(time.Minute * time.Duration(i*20-10)),
+			TemperatureDegrees: v,
+			WindSpeed:          v * 2,
+		})
+	}
+	points := Downsample(readings, from, time.Hour)
+	if !reflect.DeepEqual(points, []Point{
+		{Time: from, Samples: 3, TemperatureDegrees: 3, WindSpeed: 6},
+		{Time: from.Add(time.Hour), Samples: 3, TemperatureDegrees: 6, WindSpeed: 12},
+	}) {
+		t.Errorf(`unexpected points: %+v`, points)
+	}
+	if v := Downsample(nil, from, time.Hour); v != nil {
+		t.Error(v)
+	}
+}
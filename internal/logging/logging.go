@@ -0,0 +1,342 @@
+// Package logging configures structured logging, using log/slog, with request IDs, and the redaction of secrets,
+// e.g. api keys, see Config.New.
+//
+// Each request to the public api is assigned a request ID (see Middleware), which is attached to every record logged
+// with the request's context, and propagated to the providers via gRPC metadata (see UnaryClientInterceptor and
+// UnaryServerInterceptor). Secrets are redacted from the message, and every attribute, of each record, including
+// errors, and the status errors returned by the providers, see Redactor.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type (
+	// Config models the logger, see Config.New.
+	Config struct {
+		// Level is the minimum level logged, defaults to slog.LevelInfo, and may be a *slog.LevelVar, allowing it to
+		// be changed at runtime.
+		Level slog.Leveler
+
+		// Format is FormatText (the default), or FormatJSON.
+		Format string
+
+		// Writer is the output, defaults to os.Stderr.
+		Writer io.Writer
+
+		// Redactor redacts secrets from each record, it is optional, as the values of SecretParams are always
+		// redacted.
+		Redactor *Redactor
+	}
+
+	// Redactor redacts secrets, i.e. the values of SecretParams (within URLs, or similar), and any values returned
+	// by Secrets, wherever they appear. The zero value (or nil) only redacts the values of SecretParams.
+	Redactor struct {
+		// Secrets returns the current secrets, e.g. the api keys of each provider, it is optional, and must be safe
+		// for concurrent use.
+		Secrets func() []string
+	}
+
+	// handler wraps another slog.Handler, redacting each record, and adding the request ID, if any
+	handler struct {
+		next     slog.Handler
+		redactor *Redactor
+		// root and ops are used to add the request ID outside any groups, ops being the WithAttrs and WithGroup
+		// calls applied to root, which is the handler passed to Config.New
+		root slog.Handler
+		ops  []func(h slog.Handler) slog.Handler
+	}
+
+	// protoMessage formats a proto message as JSON, see Proto
+	protoMessage struct{ msg proto.Message }
+
+	requestIDKey struct{}
+)
+
+const (
+	// FormatText is the logfmt style output of slog.TextHandler.
+	FormatText = `text`
+
+	// FormatJSON is the output of slog.JSONHandler, with one object per line.
+	FormatJSON = `json`
+
+	// RequestIDHeader is the HTTP header a request ID is read from (if valid), and written to, see Middleware.
+	RequestIDHeader = `X-Request-Id`
+
+	// RequestIDMetadata is the gRPC metadata key a request ID is propagated via.
+	RequestIDMetadata = `x-request-id`
+
+	// AttrRequestID is the key of the request ID attribute, added to each record logged with its context.
+	AttrRequestID = `request_id`
+
+	// maxRequestIDLength bounds the length of request IDs accepted from clients
+	maxRequestIDLength = 128
+
+	redacted = `REDACTED`
+
+	// minSecretLength guards against redacting short (e.g. empty) values, which would mangle the output
+	minSecretLength = 4
+)
+
+var (
+	// SecretParams are the query parameters (or any other `key=value` pairs, or attributes, with these keys) whose
+	// values are always redacted, e.g. the api keys in upstream urls. It must not be modified.
+	SecretParams = []string{`appid`, `access_key`, `apikey`, `api_key`, `key`}
+
+	secretParamsPattern = regexp.MustCompile(`(?i)\b(` + strings.Join(SecretParams, `|`) + `)=[^&\s"'<>,;]+`)
+
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+	// compile time assertions
+
+	_ slog.Handler                = (*handler)(nil)
+	_ slog.LogValuer              = protoMessage{}
+	_ fmt.Stringer                = protoMessage{}
+	_ grpc.UnaryClientInterceptor = UnaryClientInterceptor
+	_ grpc.UnaryServerInterceptor = (*Redactor)(nil).UnaryServerInterceptor
+)
+
+// New returns a logger, which is typically installed via slog.SetDefault, which also redirects the standard logger.
+func (x Config) New() (*slog.Logger, error) {
+	writer := x.Writer
+	if writer == nil {
+		writer = os.Stderr
+	}
+	options := &slog.HandlerOptions{Level: x.Level}
+	var next slog.Handler
+	switch x.Format {
+	case ``, FormatText:
+		next = slog.NewTextHandler(writer, options)
+	case FormatJSON:
+		next = slog.NewJSONHandler(writer, options)
+	default:
+		return nil, fmt.Errorf(`logging: unknown format %q`, x.Format)
+	}
+	return slog.New(&handler{next: next, redactor: x.Redactor, root: next}), nil
+}
+
+// ParseLevel parses a level, e.g. `debug`, `info`, `warn`, or `error`, case-insensitive, optionally with an offset,
+// e.g. `info+2`, see slog.Level.UnmarshalText.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf(`logging: %w`, err)
+	}
+	return level, nil
+}
+
+// Redact returns s, with any secrets replaced.
+func (x *Redactor) Redact(s string) string {
+	s = secretParamsPattern.ReplaceAllString(s, `${1}=`+redacted)
+	if x != nil && x.Secrets != nil {
+		for _, secret := range x.Secrets() {
+			if len(secret) >= minSecretLength {
+				s = strings.ReplaceAll(s, secret, redacted)
+			}
+		}
+	}
+	return s
+}
+
+// RedactError returns err, with its message redacted, if it contained any secrets. The gRPC status code is retained,
+// but the error will no longer wrap the original error.
+func (x *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	if v := x.Redact(msg); v != msg {
+		if s, ok := status.FromError(err); ok {
+			return status.Error(s.Code(), x.Redact(s.Message()))
+		}
+		return errors.New(v)
+	}
+	return err
+}
+
+// UnaryServerInterceptor attaches the request ID propagated by UnaryClientInterceptor (if any) to the context, and
+// redacts secrets from any error returned by the handler.
+func (x *Redactor) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(RequestIDMetadata); len(v) != 0 && validRequestID(v[0]) {
+			ctx = WithRequestID(ctx, v[0])
+		}
+	}
+	res, err := handler(ctx, req)
+	return res, x.RedactError(err)
+}
+
+// UnaryClientInterceptor propagates the request ID of the context (if any) via metadata, see
+// Redactor.UnaryServerInterceptor.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := RequestID(ctx); id != `` {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDMetadata, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// Middleware assigns each request an ID, read from the RequestIDHeader, if valid, or generated, which is attached
+// to the context (see RequestID), and set on the response. Each request is logged (at info) once handled.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithRequestID(r.Context(), id)
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+		statusCode := ww.Status()
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
+
+		// note: the query isn't logged, as it may contain anything
+		slog.InfoContext(ctx, `request`,
+			`method`, r.Method,
+			`path`, r.URL.Path,
+			`status`, statusCode,
+			`duration`, time.Since(start),
+		)
+	})
+}
+
+// WithRequestID returns a child context, with the request ID, see RequestID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of the context, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Proto returns a value that logs the message as (single line) JSON, which is only marshalled if the record is
+// actually logged.
+func Proto(msg proto.Message) slog.Value {
+	return slog.AnyValue(protoMessage{msg})
+}
+
+func (x protoMessage) LogValue() slog.Value {
+	return slog.StringValue(x.String())
+}
+
+func (x protoMessage) String() string {
+	if x.msg == nil {
+		return `null`
+	}
+	b, err := protojson.MarshalOptions{}.Marshal(x.msg)
+	if err != nil {
+		return fmt.Sprintf(`%T: %v`, x.msg, err)
+	}
+	return string(b)
+}
+
+func (x *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return x.next.Enabled(ctx, level)
+}
+
+func (x *handler) Handle(ctx context.Context, record slog.Record) error {
+	next := x.next
+	r := slog.NewRecord(record.Time, record.Level, x.redactor.Redact(record.Message), record.PC)
+	switch id := RequestID(ctx); {
+	case id == ``:
+	case len(x.ops) == 0:
+		r.AddAttrs(slog.String(AttrRequestID, id))
+	default:
+		// note: the attributes of the record belong to the group, if any, but the request ID shouldn't
+		next = x.root.WithAttrs([]slog.Attr{slog.String(AttrRequestID, id)})
+		for _, op := range x.ops {
+			next = op(next)
+		}
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		r.AddAttrs(x.redact(attr))
+		return true
+	})
+	return next.Handle(ctx, r)
+}
+
+func (x *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redactedAttrs[i] = x.redact(attr)
+	}
+	return x.with(func(h slog.Handler) slog.Handler { return h.WithAttrs(redactedAttrs) })
+}
+
+func (x *handler) WithGroup(name string) slog.Handler {
+	return x.with(func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+}
+
+func (x *handler) with(op func(h slog.Handler) slog.Handler) *handler {
+	ops := make([]func(h slog.Handler) slog.Handler, len(x.ops), len(x.ops)+1)
+	copy(ops, x.ops)
+	return &handler{
+		next:     op(x.next),
+		redactor: x.redactor,
+		root:     x.root,
+		ops:      append(ops, op),
+	}
+}
+
+// redact returns the attribute with any secrets redacted, which may require it to be formatted, as a string
+func (x *handler) redact(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	for _, param := range SecretParams {
+		if strings.EqualFold(attr.Key, param) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(x.redactor.Redact(attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		attrs := make([]slog.Attr, len(group))
+		for i, v := range group {
+			attrs[i] = x.redact(v)
+		}
+		attr.Value = slog.GroupValue(attrs...)
+	case slog.KindAny:
+		// note: errors are always formatted, as they are by the builtin handlers, other values (e.g. structs) are
+		// only formatted if they contain secrets
+		if err, ok := attr.Value.Any().(error); ok {
+			attr.Value = slog.StringValue(x.redactor.Redact(err.Error()))
+		} else if s := fmt.Sprint(attr.Value.Any()); x.redactor.Redact(s) != s {
+			attr.Value = slog.StringValue(x.redactor.Redact(s))
+		}
+	}
+	return attr
+}
+
+func validRequestID(id string) bool {
+	return len(id) <= maxRequestIDLength && requestIDPattern.MatchString(id)
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,215 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestConfig_New(t *testing.T) {
+	t.Parallel()
+	for _, format := range [...]string{FormatText, FormatJSON} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			const (
+				paramSecret  = `param-secret`
+				rotatedKey   = `rotated-key-1234`
+				requestID    = `request-1`
+				upstreamURL  = `https://api.example.com/data?appid=` + paramSecret + `&q=sydney`
+				unrelatedKey = `unrelated-value`
+			)
+			var b strings.Builder
+			logger, err := Config{
+				Level:    slog.LevelInfo,
+				Format:   format,
+				Writer:   &b,
+				Redactor: &Redactor{Secrets: func() []string { return []string{rotatedKey, ``} }},
+			}.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx := WithRequestID(context.Background(), requestID)
+			logger.DebugContext(ctx, `not logged `+upstreamURL)
+			logger.With(`url`, upstreamURL).WithGroup(`group`).InfoContext(ctx, `request `+upstreamURL,
+				`error`, &url.Error{Op: `Get`, URL: upstreamURL, Err: errors.New(`connection refused`)},
+				`api_key`, rotatedKey,
+				`KEY`, unrelatedKey,
+				`rotated`, `using `+rotatedKey,
+				slog.Group(`nested`, `url`, upstreamURL),
+				`struct`, struct{ Token string }{rotatedKey},
+				`proto`, Proto(wrapperspb.String(upstreamURL)),
+				`count`, 3,
+			)
+			logger.Warn(`no request id`)
+
+			s := b.String()
+			for _, secret := range [...]string{paramSecret, rotatedKey, unrelatedKey} {
+				if strings.Contains(s, secret) {
+					t.Errorf("expected %q to be redacted:\n%s", secret, s)
+				}
+			}
+			if strings.Contains(s, `not logged`) || strings.Count(s, requestID) != 1 || strings.Count(s, `q=sydney`) != 5 {
+				t.Errorf("unexpected output:\n%s", s)
+			}
+			lines := strings.Split(strings.TrimSpace(s), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("unexpected output:\n%s", s)
+			}
+			if format == FormatJSON {
+				var record map[string]any
+				if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+					t.Fatal(err)
+				}
+				group, _ := record[`group`].(map[string]any)
+				if record[AttrRequestID] != requestID || group[`count`] != float64(3) ||
+					group[`api_key`] != redacted ||
+					group[`error`] != `Get "https://api.example.com/data?appid=REDACTED&q=sydney": connection refused` {
+					t.Errorf(`unexpected record: %v`, record)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_New_unknownFormat(t *testing.T) {
+	t.Parallel()
+	if _, err := (Config{Format: `xml`}).New(); err == nil || err.Error() != `logging: unknown format "xml"` {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		s     string
+		level slog.Level
+		err   bool
+	}{
+		{`debug`, slog.LevelDebug, false},
+		{`INFO`, slog.LevelInfo, false},
+		{`warn`, slog.LevelWarn, false},
+		{`error`, slog.LevelError, false},
+		{`info+2`, slog.LevelInfo + 2, false},
+		{`verbose`, 0, true},
+		{``, 0, true},
+	} {
+		if level, err := ParseLevel(tc.s); level != tc.level || (err != nil) != tc.err {
+			t.Errorf(`unexpected result for %q: %v %v`, tc.s, level, err)
+		}
+	}
+}
+
+func TestRedactor_Redact(t *testing.T) {
+	t.Parallel()
+	redactor := &Redactor{Secrets: func() []string { return []string{`abcd1234`, `abc`} }}
+	for _, tc := range [...]struct {
+		s, expected string
+	}{
+		{`/current?access_key=k1&query=sydney`, `/current?access_key=REDACTED&query=sydney`},
+		{`?APPID=k1 and apikey=k2, api_key="k3"`, `?APPID=REDACTED and apikey=REDACTED, api_key="k3"`},
+		{`monkey=business&key=k1`, `monkey=business&key=REDACTED`},
+		{`rejected abcd1234, but not abc`, `rejected REDACTED, but not abc`},
+	} {
+		if v := redactor.Redact(tc.s); v != tc.expected {
+			t.Errorf(`unexpected redaction of %q: %q`, tc.s, v)
+		}
+	}
+	if v := (*Redactor)(nil).Redact(`key=k1 abcd1234`); v != `key=REDACTED abcd1234` {
+		t.Errorf(`unexpected redaction: %q`, v)
+	}
+}
+
+func TestRedactor_RedactError(t *testing.T) {
+	t.Parallel()
+	redactor := &Redactor{Secrets: func() []string { return []string{`secret`} }}
+	if err := redactor.RedactError(nil); err != nil {
+		t.Error(err)
+	}
+	unchanged := status.Error(codes.NotFound, `not found`)
+	if err := redactor.RedactError(unchanged); err != unchanged {
+		t.Errorf(`expected the error to be unchanged: %v`, err)
+	}
+	if err := redactor.RedactError(status.Error(codes.Unavailable, `secret rejected`)); status.Code(err) != codes.Unavailable ||
+		err.Error() != `rpc error: code = Unavailable desc = REDACTED rejected` {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+	if err := redactor.RedactError(errors.New(`GET /?key=k1`)); err == nil || err.Error() != `GET /?key=REDACTED` {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	var requestID string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = RequestID(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	for _, tc := range [...]struct {
+		header string
+		valid  bool
+	}{
+		{`abc-123`, true},
+		{``, false},
+		{`has spaces`, false},
+		{strings.Repeat(`a`, maxRequestIDLength+1), false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, `/v1/weather?city=sydney`, nil)
+		if tc.header != `` {
+			req.Header.Set(RequestIDHeader, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		header := rec.Header().Get(RequestIDHeader)
+		if rec.Code != http.StatusTeapot || header != requestID ||
+			(tc.valid && header != tc.header) || (!tc.valid && !generated.MatchString(header)) {
+			t.Errorf(`unexpected request id for %q: %q %q`, tc.header, header, requestID)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	t.Parallel()
+	redactor := &Redactor{Secrets: func() []string { return []string{`secret-key`} }}
+	call := func(ctx context.Context) (string, error) {
+		var requestID string
+		err := UnaryClientInterceptor(ctx, `/test.Service/Method`, nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			// note: simulates the transport
+			md, _ := metadata.FromOutgoingContext(ctx)
+			_, err := redactor.UnaryServerInterceptor(metadata.NewIncomingContext(context.Background(), md), req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+				requestID = RequestID(ctx)
+				return nil, status.Error(codes.Unavailable, `key secret-key rejected`)
+			})
+			return err
+		})
+		return requestID, err
+	}
+
+	requestID, err := call(WithRequestID(context.Background(), `request-1`))
+	if requestID != `request-1` {
+		t.Errorf(`unexpected request id: %q`, requestID)
+	}
+	if status.Code(err) != codes.Unavailable || strings.Contains(err.Error(), `secret-key`) {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+
+	if requestID, _ := call(context.Background()); requestID != `` {
+		t.Errorf(`unexpected request id: %q`, requestID)
+	}
+}
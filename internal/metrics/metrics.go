@@ -0,0 +1,266 @@
+// Package metrics implements the Prometheus metrics of the weather api, recorded by chi middleware (see
+// Metrics.Middleware) and gRPC interceptors (see Metrics.UnaryClientInterceptor and Metrics.UnaryServerInterceptor).
+//
+// Outcomes that are only known by handlers, e.g. cache hits, are attached to the context of the request or call (see
+// ObserveCache, ObserveCoalesced, and ObserveSelection), and recorded by the middleware or interceptor, once it
+// completes.
+package metrics
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joeycumines/go-bigbuff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Metrics records the metrics of the weather api, nil is a no-op.
+	// See also New, and Register.
+	Metrics struct {
+		gatherer             prometheus.Gatherer
+		httpRequests         *prometheus.CounterVec
+		httpDuration         *prometheus.HistogramVec
+		selections           *prometheus.CounterVec
+		grpcClientCalls      *prometheus.CounterVec
+		grpcClientDuration   *prometheus.HistogramVec
+		grpcServerCalls      *prometheus.CounterVec
+		grpcServerDuration   *prometheus.HistogramVec
+		providerRequests     *prometheus.CounterVec
+		providerRateLimiting *prometheus.HistogramVec
+	}
+
+	// Selection models how the response to a request was selected, from the providers attempted.
+	Selection string
+
+	// outcomes are attached to the context, by the middleware and interceptors
+	outcomes struct {
+		mu        sync.Mutex
+		cache     string
+		coalesced bool
+		selection Selection
+	}
+
+	outcomesKey struct{}
+)
+
+const (
+	// SelectionPrimary indicates the first provider attempted returned a fresh reading.
+	SelectionPrimary Selection = `primary`
+
+	// SelectionSecondary indicates a fresh reading was returned by a provider other than the first attempted.
+	SelectionSecondary Selection = `secondary`
+
+	// SelectionStale indicates no provider returned a fresh reading, and the freshest was used.
+	SelectionStale Selection = `stale`
+)
+
+// New initialises the metrics, registering them with the registry.
+func New(registry *prometheus.Registry) *Metrics {
+	x := &Metrics{
+		gatherer: registry,
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: `weather_http_requests_total`,
+			Help: `HTTP requests, by route, method, and status code.`,
+		}, []string{`route`, `method`, `code`}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    `weather_http_request_duration_seconds`,
+			Help:    `HTTP request latency, by route, method, and status code.`,
+			Buckets: prometheus.DefBuckets,
+		}, []string{`route`, `method`, `code`}),
+		selections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: `weather_http_selections_total`,
+			Help: `Responses by how they were selected, from the providers attempted, i.e. primary, secondary (fallback), or stale.`,
+		}, []string{`route`, `selection`}),
+		grpcClientCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: `weather_grpc_client_calls_total`,
+			Help: `gRPC calls made to providers, by service, method, and status code.`,
+		}, []string{`grpc_service`, `grpc_method`, `grpc_code`}),
+		grpcClientDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    `weather_grpc_client_call_duration_seconds`,
+			Help:    `gRPC call latency, of calls made to providers, by service, method, and status code.`,
+			Buckets: prometheus.DefBuckets,
+		}, []string{`grpc_service`, `grpc_method`, `grpc_code`}),
+		grpcServerCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: `weather_grpc_server_calls_total`,
+			Help: `gRPC calls handled by providers, by service, method, and status code.`,
+		}, []string{`grpc_service`, `grpc_method`, `grpc_code`}),
+		grpcServerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    `weather_grpc_server_call_duration_seconds`,
+			Help:    `gRPC call latency, of calls handled by providers, by service, method, and status code.`,
+			Buckets: prometheus.DefBuckets,
+		}, []string{`grpc_service`, `grpc_method`, `grpc_code`}),
+		providerRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: `weather_provider_requests_total`,
+			Help: `Provider requests, by service, cache outcome (hit, miss, or stale), and whether the upstream call was shared with (coalesced into) another request's.`,
+		}, []string{`grpc_service`, `cache`, `coalesced`}),
+		providerRateLimiting: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    `weather_provider_rate_limit_wait_seconds`,
+			Help:    `Time subsequent upstream calls (for the same key) were held back by the rate limit, after each upstream call, by service.`,
+			Buckets: prometheus.DefBuckets,
+		}, []string{`grpc_service`}),
+	}
+	registry.MustRegister(
+		x.httpRequests,
+		x.httpDuration,
+		x.selections,
+		x.grpcClientCalls,
+		x.grpcClientDuration,
+		x.grpcServerCalls,
+		x.grpcServerDuration,
+		x.providerRequests,
+		x.providerRateLimiting,
+	)
+	return x
+}
+
+// ObserveCache attaches the outcome of a cache lookup to the context of a provider call, recorded by
+// UnaryServerInterceptor. A stale entry is one that was cached, but was too old to use.
+func ObserveCache(ctx context.Context, cached, fresh bool) {
+	result := `miss`
+	if cached && fresh {
+		result = `hit`
+	} else if cached {
+		result = `stale`
+	}
+	if v := getOutcomes(ctx); v != nil {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.cache = result
+	}
+}
+
+// ObserveCoalesced attaches, to the context of a provider call, that the upstream call was shared with another
+// call, i.e. the result was coalesced. Recorded by UnaryServerInterceptor.
+func ObserveCoalesced(ctx context.Context) {
+	if v := getOutcomes(ctx); v != nil {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.coalesced = true
+	}
+}
+
+// ObserveSelection attaches how the response was selected to the context of an HTTP request, recorded by
+// Middleware.
+func ObserveSelection(ctx context.Context, selection Selection) {
+	if v := getOutcomes(ctx); v != nil {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		v.selection = selection
+	}
+}
+
+// Register wires up the /metrics endpoint.
+func (x *Metrics) Register(r chi.Router) {
+	if x == nil {
+		return
+	}
+	r.Handle(`/metrics`, promhttp.HandlerFor(x.gatherer, promhttp.HandlerOpts{}))
+}
+
+// Middleware records the count and latency of each request, by route pattern, e.g. `/v1/weather`.
+func (x *Metrics) Middleware(next http.Handler) http.Handler {
+	if x == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		outcomes := new(outcomes)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), outcomesKey{}, outcomes)))
+
+		route := `unmatched`
+		if ctx := chi.RouteContext(r.Context()); ctx != nil && ctx.RoutePattern() != `` {
+			route = ctx.RoutePattern()
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		labels := prometheus.Labels{`route`: route, `method`: r.Method, `code`: strconv.Itoa(code)}
+		x.httpRequests.With(labels).Inc()
+		x.httpDuration.With(labels).Observe(time.Since(start).Seconds())
+
+		outcomes.mu.Lock()
+		defer outcomes.mu.Unlock()
+		if outcomes.selection != `` {
+			x.selections.WithLabelValues(route, string(outcomes.selection)).Inc()
+		}
+	})
+}
+
+// UnaryClientInterceptor records the count and latency of each call to a provider.
+func (x *Metrics) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if x == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	labels := grpcLabels(method, err)
+	x.grpcClientCalls.With(labels).Inc()
+	x.grpcClientDuration.With(labels).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// UnaryServerInterceptor records the count and latency of each call handled by a provider, and the outcomes
+// attached to the context by the provider, see ObserveCache and ObserveCoalesced.
+func (x *Metrics) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if x == nil {
+		return handler(ctx, req)
+	}
+	start := time.Now()
+	outcomes := new(outcomes)
+	res, err := handler(context.WithValue(ctx, outcomesKey{}, outcomes), req)
+	labels := grpcLabels(info.FullMethod, err)
+	x.grpcServerCalls.With(labels).Inc()
+	x.grpcServerDuration.With(labels).Observe(time.Since(start).Seconds())
+
+	outcomes.mu.Lock()
+	defer outcomes.mu.Unlock()
+	if outcomes.cache != `` {
+		x.providerRequests.WithLabelValues(labels[`grpc_service`], outcomes.cache, strconv.FormatBool(outcomes.coalesced)).Inc()
+	}
+	return res, err
+}
+
+// ExclusiveRateLimit returns a bigbuff.ExclusiveWrapper option, which must follow bigbuff.ExclusiveRateLimit,
+// recording the time the rate limit held back subsequent calls, after each call, for the given gRPC service.
+func (x *Metrics) ExclusiveRateLimit(service string) bigbuff.ExclusiveOption {
+	return bigbuff.ExclusiveWrapper(func(value bigbuff.WorkFunc) bigbuff.WorkFunc {
+		if x == nil {
+			return value
+		}
+		return func(resolve func(result any, err error)) {
+			var resolved time.Time
+			value(func(result any, err error) {
+				resolved = time.Now()
+				resolve(result, err)
+			})
+			if resolved != (time.Time{}) {
+				x.providerRateLimiting.WithLabelValues(service).Observe(time.Since(resolved).Seconds())
+			}
+		}
+	})
+}
+
+func getOutcomes(ctx context.Context) *outcomes {
+	v, _ := ctx.Value(outcomesKey{}).(*outcomes)
+	return v
+}
+
+func grpcLabels(fullMethod string, err error) prometheus.Labels {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, `/`), `/`)
+	return prometheus.Labels{
+		`grpc_service`: service,
+		`grpc_method`:  method,
+		`grpc_code`:    status.Code(err).String(),
+	}
+}
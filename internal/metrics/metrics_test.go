@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/go-bigbuff"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Middleware(t *testing.T) {
+	t.Parallel()
+
+	m := New(prometheus.NewRegistry())
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Group(m.Register)
+	router.Get(`/v1/weather`, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get(`city`) == `` {
+			http.Error(w, `missing city`, http.StatusBadRequest)
+			return
+		}
+		ObserveSelection(r.Context(), SelectionSecondary)
+		_, _ = w.Write([]byte(`{}`))
+	})
+	router.Get(`/v1/things/{id}`, func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, path := range [...]string{`/v1/weather?city=sydney`, `/v1/weather?city=melbourne`, `/v1/weather`, `/v1/things/1`, `/nope`} {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+
+	for _, tc := range [...]struct {
+		counter prometheus.Counter
+		value   float64
+	}{
+		{m.httpRequests.WithLabelValues(`/v1/weather`, `GET`, `200`), 2},
+		{m.httpRequests.WithLabelValues(`/v1/weather`, `GET`, `400`), 1},
+		{m.httpRequests.WithLabelValues(`/v1/things/{id}`, `GET`, `200`), 1},
+		{m.httpRequests.WithLabelValues(`unmatched`, `GET`, `404`), 1},
+		{m.selections.WithLabelValues(`/v1/weather`, `secondary`), 2},
+		{m.selections.WithLabelValues(`/v1/weather`, `primary`), 0},
+	} {
+		if v := testutil.ToFloat64(tc.counter); v != tc.value {
+			t.Errorf(`unexpected value for %s: %v`, tc.counter.Desc(), v)
+		}
+	}
+
+	res, err := ts.Client().Get(ts.URL + `/metrics`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	if s := string(b); !strings.Contains(s, `weather_http_request_duration_seconds_count{code="200",method="GET",route="/v1/weather"} 2`) {
+		t.Errorf("unexpected metrics:\n%s", s)
+	}
+}
+
+func TestMetrics_interceptors(t *testing.T) {
+	t.Parallel()
+
+	m := New(prometheus.NewRegistry())
+	const method = `/weather.openweather.v1.Openweather/GetWeather`
+	for _, tc := range [...]struct {
+		cached, fresh, coalesced bool
+		err                      error
+	}{
+		{cached: true, fresh: true},
+		{cached: true},
+		{coalesced: true},
+		{coalesced: true},
+		{err: status.Error(codes.Unavailable, `nope`)},
+	} {
+		tc := tc
+		handler := func(ctx context.Context, req any) (any, error) {
+			ObserveCache(ctx, tc.cached, tc.fresh)
+			if tc.coalesced {
+				ObserveCoalesced(ctx)
+			}
+			return nil, tc.err
+		}
+		invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			_, err := m.UnaryServerInterceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+			return err
+		}
+		if err := m.UnaryClientInterceptor(context.Background(), method, nil, nil, nil, invoker); err != tc.err {
+			t.Errorf(`unexpected error: %v`, err)
+		}
+	}
+
+	const service = `weather.openweather.v1.Openweather`
+	for _, tc := range [...]struct {
+		counter prometheus.Counter
+		value   float64
+	}{
+		{m.grpcClientCalls.WithLabelValues(service, `GetWeather`, `OK`), 4},
+		{m.grpcClientCalls.WithLabelValues(service, `GetWeather`, `Unavailable`), 1},
+		{m.grpcServerCalls.WithLabelValues(service, `GetWeather`, `OK`), 4},
+		{m.grpcServerCalls.WithLabelValues(service, `GetWeather`, `Unavailable`), 1},
+		{m.providerRequests.WithLabelValues(service, `hit`, `false`), 1},
+		{m.providerRequests.WithLabelValues(service, `stale`, `false`), 1},
+		{m.providerRequests.WithLabelValues(service, `miss`, `true`), 2},
+		{m.providerRequests.WithLabelValues(service, `miss`, `false`), 1},
+	} {
+		if v := testutil.ToFloat64(tc.counter); v != tc.value {
+			t.Errorf(`unexpected value for %s: %v`, tc.counter.Desc(), v)
+		}
+	}
+}
+
+func TestMetrics_ExclusiveRateLimit(t *testing.T) {
+	t.Parallel()
+
+	m := New(prometheus.NewRegistry())
+	var excl bigbuff.Exclusive
+	for i := 0; i < 2; i++ {
+		outcome := <-excl.CallWithOptions(
+			bigbuff.ExclusiveRateLimit(context.Background(), time.Millisecond*50),
+			m.ExclusiveRateLimit(`svc`),
+			bigbuff.ExclusiveValue(func() (any, error) { return i, nil }),
+		)
+		if outcome.Result != i {
+			t.Errorf(`unexpected outcome: %v`, outcome)
+		}
+	}
+	// the outcome is resolved prior to the rate limit, which is observed once it has elapsed
+	time.Sleep(time.Millisecond * 100)
+	if v := testutil.CollectAndCount(m.providerRateLimiting); v != 1 {
+		t.Errorf(`unexpected count: %d`, v)
+	}
+}
+
+func TestMetrics_nil(t *testing.T) {
+	t.Parallel()
+
+	var m *Metrics
+	m.Register(chi.NewRouter())
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no-op, without the middleware
+		ObserveSelection(r.Context(), SelectionStale)
+		w.WriteHeader(http.StatusTeapot)
+	})
+	rec := httptest.NewRecorder()
+	m.Middleware(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/`, nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf(`unexpected code: %d`, rec.Code)
+	}
+	if _, err := m.UnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		ObserveCache(ctx, true, true)
+		ObserveCoalesced(ctx)
+		return nil, nil
+	}); err != nil {
+		t.Error(err)
+	}
+	outcome := <-new(bigbuff.Exclusive).CallWithOptions(m.ExclusiveRateLimit(`svc`), bigbuff.ExclusiveValue(func() (any, error) { return 1, nil }))
+	if outcome.Result != 1 {
+		t.Errorf(`unexpected outcome: %v`, outcome)
+	}
+}
@@ -0,0 +1,231 @@
+// Package tracing configures OpenTelemetry tracing, exported via OTLP (gRPC), or to stdout (for local runs), with
+// W3C trace context propagation, see Config.Install.
+//
+// Spans are started by chi middleware (see Middleware), gRPC interceptors (for each provider call, see
+// UnaryClientInterceptor), the provider backends (for cache lookups, and coalesced upstream calls), and
+// httpclient.Tracer (for each upstream request). Every span uses the global tracer provider, which is a no-op unless
+// installed.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type (
+	// Config models the tracer provider, see Config.Install.
+	Config struct {
+		// Exporter is one of ExporterOTLP, ExporterStdout, or ExporterNone (the default), which disables tracing,
+		// though the trace context is still propagated.
+		Exporter string
+
+		// Endpoint is the OTLP (gRPC) collector, e.g. `otel-collector:4317`, defaults to the standard
+		// OTEL_EXPORTER_OTLP_ENDPOINT env var, or `localhost:4317`.
+		Endpoint string
+
+		// Insecure disables TLS to the OTLP collector.
+		Insecure bool
+
+		// SampleRatio is the fraction of traces sampled, unless continued from a parent, in which case the parent's
+		// sampling decision is used. Defaults to 1, i.e. every trace, if not positive.
+		SampleRatio float64
+
+		// ServiceName identifies the process, e.g. `weather-api`, and may be overridden by the standard
+		// OTEL_SERVICE_NAME env var.
+		ServiceName string
+
+		// Writer is the output of ExporterStdout, defaults to os.Stdout.
+		Writer io.Writer
+	}
+
+	// metadataCarrier adapts metadata.MD to propagation.TextMapCarrier
+	metadataCarrier metadata.MD
+)
+
+const (
+	// ExporterNone disables tracing, see Config.Exporter.
+	ExporterNone = `none`
+
+	// ExporterOTLP exports spans to an OTLP (gRPC) collector, see Config.Endpoint.
+	ExporterOTLP = `otlp`
+
+	// ExporterStdout writes spans to stdout, as JSON, e.g. for local runs.
+	ExporterStdout = `stdout`
+
+	// AttrCache is the outcome of a cache lookup, i.e. hit, miss, or stale, see CacheOutcome.
+	AttrCache = attribute.Key(`weather.cache`)
+
+	// AttrCoalesced indicates whether an upstream call was shared with (coalesced into) another request's.
+	AttrCoalesced = attribute.Key(`weather.coalesced`)
+)
+
+var (
+	tracer = otel.Tracer(`github.com/joeycumines/mx51-weather-api/internal/tracing`)
+
+	// compile time assertions
+
+	_ propagation.TextMapCarrier  = metadataCarrier(nil)
+	_ grpc.UnaryClientInterceptor = UnaryClientInterceptor
+)
+
+// Install configures the global tracer provider and propagator, returning a function that flushes any buffered
+// spans, and stops the exporter, to be called on shutdown. If tracing is disabled, only the propagator is installed.
+func (x Config) Install(ctx context.Context) (shutdown func(ctx context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var option sdktrace.TracerProviderOption
+	switch x.Exporter {
+	case ``, ExporterNone:
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var options []otlptracegrpc.Option
+		if x.Endpoint != `` {
+			options = append(options, otlptracegrpc.WithEndpoint(x.Endpoint))
+		}
+		if x.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, options...)
+		if err != nil {
+			return nil, fmt.Errorf(`tracing: %w`, err)
+		}
+		option = sdktrace.WithBatcher(exporter)
+	case ExporterStdout:
+		writer := x.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(writer))
+		if err != nil {
+			return nil, fmt.Errorf(`tracing: %w`, err)
+		}
+		// note: written synchronously, as each span ends
+		option = sdktrace.WithSyncer(exporter)
+	default:
+		return nil, fmt.Errorf(`tracing: unknown exporter %q`, x.Exporter)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(x.ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil && !errors.Is(err, resource.ErrPartialResource) {
+		return nil, fmt.Errorf(`tracing: %w`, err)
+	}
+
+	sampleRatio := x.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		option,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for each request, continuing any trace propagated by the client, named by the
+// method and route pattern (once routed), e.g. `GET /v1/weather`.
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			if ctx := chi.RouteContext(r.Context()); ctx != nil && ctx.RoutePattern() != `` {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + ` ` + ctx.RoutePattern())
+				span.SetAttributes(semconv.HTTPRouteKey.String(ctx.RoutePattern()))
+			}
+		}),
+		`http.server`,
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string { return `HTTP ` + r.Method }),
+	)
+}
+
+// UnaryClientInterceptor starts a client span for each gRPC call, propagating the trace context via metadata. It is
+// used in place of otelgrpc.UnaryClientInterceptor, which doesn't support grpchan channels, e.g. inprocgrpc, as they
+// have no grpc.ClientConn. Calls are handled by otelgrpc.UnaryServerInterceptor.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, `/`), `/`)
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(method, `/`),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemGRPC,
+			semconv.RPCServiceKey.String(service),
+			semconv.RPCMethodKey.String(name),
+		),
+	)
+	defer span.End()
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+
+	err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	sts := status.Convert(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(sts.Code())))
+	if err != nil {
+		span.SetStatus(codes.Error, sts.Message())
+	}
+	return err
+}
+
+// CacheOutcome returns the AttrCache attribute, for the outcome of a cache lookup. A stale entry is one that was
+// cached, but was too old to use.
+func CacheOutcome(cached, fresh bool) attribute.KeyValue {
+	switch {
+	case cached && fresh:
+		return AttrCache.String(`hit`)
+	case cached:
+		return AttrCache.String(`stale`)
+	default:
+		return AttrCache.String(`miss`)
+	}
+}
+
+// SetError records the error, if any, on the span, and sets its status.
+func SetError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func (x metadataCarrier) Get(key string) string {
+	if v := metadata.MD(x).Get(key); len(v) != 0 {
+		return v[0]
+	}
+	return ``
+}
+
+func (x metadataCarrier) Set(key, value string) { metadata.MD(x).Set(key, value) }
+
+func (x metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(x))
+	for key := range x {
+		keys = append(keys, key)
+	}
+	return keys
+}
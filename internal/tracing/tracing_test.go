@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// provider records every span, and is the global tracer provider (until replaced by Install), which the tracer of this
+// package delegates to, as it is the first set
+var provider, recorder = func() (*sdktrace.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider, recorder
+}()
+
+// note: not parallel, as it replaces the global tracer provider
+func TestConfig_Install_stdout(t *testing.T) {
+	var b strings.Builder
+	shutdown, err := Config{Exporter: ExporterStdout, ServiceName: `test-service`, Writer: &b}.Install(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get(`/v1/weather/{city}`, func(w http.ResponseWriter, r *http.Request) {})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	const (
+		traceID  = `4bf92f3577b34da6a3ce929d0e0e4736`
+		parentID = `00f067aa0ba902b7`
+	)
+	for _, path := range [...]string{`/v1/weather/sydney`, `/nope`} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(`traceparent`, `00-`+traceID+`-`+parentID+`-01`)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// note: a subset of tracetest.SpanStub, as written by the exporter
+	type (
+		spanContext struct{ TraceID, SpanID string }
+		spanStub    struct {
+			Name        string
+			SpanContext spanContext
+			Parent      spanContext
+		}
+	)
+	var spans []spanStub
+	for decoder := json.NewDecoder(strings.NewReader(b.String())); ; {
+		var span spanStub
+		if err := decoder.Decode(&span); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, span)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("unexpected spans:\n%s", b.String())
+	}
+	for i, name := range [...]string{`GET /v1/weather/{city}`, `HTTP GET`} {
+		if spans[i].Name != name {
+			t.Errorf(`unexpected name: %s`, spans[i].Name)
+		}
+		if spans[i].SpanContext.TraceID != traceID || spans[i].Parent.SpanID != parentID {
+			t.Errorf(`expected the propagated trace: %+v`, spans[i])
+		}
+	}
+	if !strings.Contains(b.String(), `"Value":"test-service"`) {
+		t.Errorf("expected the service name:\n%s", b.String())
+	}
+}
+
+func TestConfig_Install_none(t *testing.T) {
+	for _, exporter := range [...]string{``, ExporterNone} {
+		shutdown, err := Config{Exporter: exporter}.Install(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+		if fields := strings.Join(otel.GetTextMapPropagator().Fields(), `,`); !strings.Contains(fields, `traceparent`) {
+			t.Errorf(`unexpected propagator fields: %q`, fields)
+		}
+	}
+	if _, err := (Config{Exporter: `jaeger`}).Install(context.Background()); err == nil || err.Error() != `tracing: unknown exporter "jaeger"` {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	t.Parallel()
+
+	ctx, parent := provider.Tracer(`test`).Start(context.Background(), `TestUnaryClientInterceptor`)
+	ctx = metadata.AppendToOutgoingContext(ctx, `key`, `value`)
+	err := UnaryClientInterceptor(ctx, `/weather.geocode.v1.Geocode/GetLocation`, nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if v := md.Get(`key`); len(v) != 1 || v[0] != `value` {
+			t.Errorf(`unexpected metadata: %v`, md)
+		}
+		if v := md.Get(`traceparent`); len(v) != 1 || !strings.HasPrefix(v[0], `00-`+parent.SpanContext().TraceID().String()+`-`) {
+			t.Errorf(`expected the trace context to be propagated: %v`, md)
+		}
+		return status.Error(grpccodes.NotFound, `nope`)
+	})
+	parent.End()
+	if status.Code(err) != grpccodes.NotFound {
+		t.Errorf(`unexpected error: %v`, err)
+	}
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(`traceparent`)) != 0 {
+		t.Error(`expected the metadata to be unmodified`)
+	}
+
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		if span.Name() != `weather.geocode.v1.Geocode/GetLocation` ||
+			span.SpanKind() != trace.SpanKindClient ||
+			span.Status() != (sdktrace.Status{Code: codes.Error, Description: `nope`}) ||
+			!reflect.DeepEqual(span.Attributes(), []attribute.KeyValue{
+				semconv.RPCSystemGRPC,
+				semconv.RPCServiceKey.String(`weather.geocode.v1.Geocode`),
+				semconv.RPCMethodKey.String(`GetLocation`),
+				semconv.RPCGRPCStatusCodeKey.Int(int(grpccodes.NotFound)),
+			}) {
+			t.Errorf(`unexpected span: %s %v %v`, span.Name(), span.Status(), span.Attributes())
+		}
+		return
+	}
+	t.Error(`expected a span`)
+}
+
+func TestCacheOutcome(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		cached, fresh bool
+		value         string
+	}{
+		{true, true, `hit`},
+		{true, false, `stale`},
+		{false, false, `miss`},
+	} {
+		if v := CacheOutcome(tc.cached, tc.fresh); v != AttrCache.String(tc.value) {
+			t.Errorf(`unexpected outcome for %v %v: %v`, tc.cached, tc.fresh, v.Value.Emit())
+		}
+	}
+}
+
+func TestSetError(t *testing.T) {
+	t.Parallel()
+	spans := tracetest.NewSpanRecorder()
+	_, span := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)).Tracer(`test`).Start(context.Background(), `test`)
+	SetError(span, nil)
+	SetError(span, errors.New(`some error`))
+	span.End()
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Status().Description != `some error` || len(ended[0].Events()) != 1 ||
+		ended[0].Events()[0].Attributes[0] != attribute.String(`exception.type`, `*errors.errorString`) {
+		t.Errorf(`unexpected spans: %+v`, ended)
+	}
+}
@@ -0,0 +1,74 @@
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/joeycumines/mx51-weather-api/internal/alerts"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_Refresh(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu         sync.Mutex
+		deliveries []alerts.Delivery
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var delivery alerts.Delivery
+		if err := json.NewDecoder(r.Body).Decode(&delivery); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		deliveries = append(deliveries, delivery)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	service := alerts.Service{Client: webhook.Client()}
+	defer service.Close()
+	if _, err := service.Create(alerts.Subscription{
+		City:       `brisbane`,
+		Metric:     alerts.MetricWindSpeed,
+		Condition:  alerts.ConditionAbove,
+		Threshold:  60,
+		WebhookURL: webhook.URL,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	timeNow, setTime := mockTime()
+	setTime(time.Unix(1667179321, 0))
+	server := Server{
+		MaxAge:  time.Second,
+		TimeNow: timeNow,
+		Alerts:  &service,
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			if in.GetQuery() != `brisbane` {
+				t.Errorf(`unexpected query: %s`, in.GetQuery())
+			}
+			return &weatherstack.CurrentWeather{ReadTime: timestamppb.New(timeNow()), WindSpeed: 65}, nil
+		}},
+	}
+
+	for i := 0; i < 3; i++ {
+		setTime(timeNow().Add(time.Minute))
+		if location, err := server.Refresh(context.Background(), `brisbane`); err != nil || location != `brisbane` {
+			t.Fatal(location, err)
+		}
+	}
+	service.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 1 || deliveries[0].Value != 65 || deliveries[0].Reading.Provider != `weatherstack` {
+		t.Errorf(`unexpected deliveries: %+v`, deliveries)
+	}
+}
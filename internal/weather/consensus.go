@@ -0,0 +1,217 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Consensus configures the aggregation mode, which may be requested using the `mode=consensus` query parameter.
+	//
+	// In consensus mode, fresh readings are collected from all configured providers, readings that deviate from the
+	// median by more than the configured maximum are dropped (given at least three readings), and the weighted mean of
+	// the remaining readings is returned, along with the contributing providers and the spread.
+	Consensus struct {
+		// MaxTemperatureDeviation is the maximum difference from the median temperature (in degrees), defaults to
+		// DefaultMaxTemperatureDeviation.
+		MaxTemperatureDeviation float64
+
+		// MaxWindSpeedDeviation is the maximum difference from the median wind speed (in km/h), defaults to
+		// DefaultMaxWindSpeedDeviation.
+		MaxWindSpeedDeviation float64
+
+		// Weights are the relative weights of each provider, defaulting to 1 for any provider not present, a weight
+		// of 0 excludes the provider. See also ParseWeights.
+		Weights map[Provider]float64
+	}
+
+	// spread is the range (max - min) of the contributing readings
+	spread struct {
+		WindSpeed          float64 `json:"wind_speed"`
+		TemperatureDegrees float64 `json:"temperature_degrees"`
+	}
+
+	weightedReading struct {
+		provider Provider
+		weight   float64
+		*reading
+	}
+)
+
+const (
+	// DefaultMaxTemperatureDeviation is the default value for Consensus.MaxTemperatureDeviation.
+	DefaultMaxTemperatureDeviation = 3
+
+	// DefaultMaxWindSpeedDeviation is the default value for Consensus.MaxWindSpeedDeviation.
+	DefaultMaxWindSpeedDeviation = 10
+
+	modePriority  = `priority`
+	modeConsensus = `consensus`
+)
+
+// ParseWeights parses a comma separated list of provider weights, e.g. `weatherstack:2,openmeteo:0.5`.
+func ParseWeights(s string) (map[Provider]float64, error) {
+	weights := make(map[Provider]float64)
+	for _, v := range strings.Split(s, `,`) {
+		v = strings.TrimSpace(v)
+		if v == `` {
+			continue
+		}
+		name, value, ok := strings.Cut(v, `:`)
+		if !ok {
+			return nil, fmt.Errorf(`missing weight for %q`, v)
+		}
+		provider := Provider(strings.TrimSpace(name))
+		if !provider.valid() {
+			return nil, fmt.Errorf(`unknown provider %q`, provider)
+		}
+		if _, ok := weights[provider]; ok {
+			return nil, fmt.Errorf(`duplicate provider %q`, provider)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+			return nil, fmt.Errorf(`invalid weight for %q: %s`, provider, value)
+		}
+		weights[provider] = weight
+	}
+	return weights, nil
+}
+
+func (x *Server) buildConsensusResponse(ctx context.Context, target target, providers []Provider) (*weatherResponse, error) {
+	// TODO reconsider this, also consider independent timeouts for each sub-request
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*3)
+	defer cancel()
+
+	settings := x.settings()
+	// a factory function accepting config would make this handling nicer
+	if settings.MaxAge <= 0 {
+		panic(settings.MaxAge)
+	}
+	now := x.TimeNow()
+	minReadTime := now.Add(-settings.MaxAge)
+
+	// all providers are requested concurrently, as all readings are required
+	readings := make([]*reading, len(providers))
+	{
+		var wg sync.WaitGroup
+		wg.Add(len(providers))
+		for i, provider := range providers {
+			go func(i int, provider Provider) {
+				defer wg.Done()
+				if res, err := x.getReading(ctx, provider, target, minReadTime); err == nil && res.fresh(now, minReadTime) {
+					readings[i] = res
+				}
+			}(i, provider)
+		}
+		wg.Wait()
+	}
+
+	var candidates []weightedReading
+	for i, res := range readings {
+		if res == nil {
+			continue
+		}
+		if weight := settings.Consensus.weight(providers[i]); weight > 0 {
+			candidates = append(candidates, weightedReading{provider: providers[i], weight: weight, reading: res})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, status.Error(codes.Unavailable, `no fresh weather readings available`)
+	}
+
+	candidates = settings.Consensus.dropOutliers(candidates)
+	if len(candidates) == 0 {
+		return nil, status.Error(codes.Unavailable, `no consensus between weather providers`)
+	}
+
+	return aggregate(candidates), nil
+}
+
+// dropOutliers filters readings that deviate too far from the median, if there are at least three readings
+func (x *Consensus) dropOutliers(readings []weightedReading) []weightedReading {
+	// note: an outlier can't be identified with fewer than three readings (the median is between them), so they are
+	// retained, with any disagreement reflected by the spread
+	if len(readings) < 3 {
+		return readings
+	}
+
+	temperatures := make([]float64, len(readings))
+	windSpeeds := make([]float64, len(readings))
+	for i, v := range readings {
+		temperatures[i] = v.TemperatureDegrees
+		windSpeeds[i] = v.WindSpeed
+	}
+	medianTemperature, medianWindSpeed := median(temperatures), median(windSpeeds)
+	maxTemperatureDeviation, maxWindSpeedDeviation := x.maxTemperatureDeviation(), x.maxWindSpeedDeviation()
+
+	filtered := readings[:0:0]
+	for _, v := range readings {
+		if math.Abs(v.TemperatureDegrees-medianTemperature) > maxTemperatureDeviation ||
+			math.Abs(v.WindSpeed-medianWindSpeed) > maxWindSpeedDeviation {
+			continue
+		}
+		filtered = append(filtered, v)
+	}
+	return filtered
+}
+
+func (x *Consensus) weight(provider Provider) float64 {
+	if weight, ok := x.Weights[provider]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (x *Consensus) maxTemperatureDeviation() float64 {
+	if x.MaxTemperatureDeviation > 0 {
+		return x.MaxTemperatureDeviation
+	}
+	return DefaultMaxTemperatureDeviation
+}
+
+func (x *Consensus) maxWindSpeedDeviation() float64 {
+	if x.MaxWindSpeedDeviation > 0 {
+		return x.MaxWindSpeedDeviation
+	}
+	return DefaultMaxWindSpeedDeviation
+}
+
+// aggregate calculates the weighted mean and spread, readings must be non-empty
+func aggregate(readings []weightedReading) *weatherResponse {
+	var (
+		res         = weatherResponse{Spread: new(spread)}
+		totalWeight float64
+		min, max    = readings[0].weatherResponse, readings[0].weatherResponse
+	)
+	for _, v := range readings {
+		totalWeight += v.weight
+		res.WindSpeed += v.WindSpeed * v.weight
+		res.TemperatureDegrees += v.TemperatureDegrees * v.weight
+		res.Providers = append(res.Providers, v.provider)
+		min.WindSpeed, max.WindSpeed = math.Min(min.WindSpeed, v.WindSpeed), math.Max(max.WindSpeed, v.WindSpeed)
+		min.TemperatureDegrees, max.TemperatureDegrees = math.Min(min.TemperatureDegrees, v.TemperatureDegrees), math.Max(max.TemperatureDegrees, v.TemperatureDegrees)
+	}
+	res.WindSpeed /= totalWeight
+	res.TemperatureDegrees /= totalWeight
+	res.Spread.WindSpeed = max.WindSpeed - min.WindSpeed
+	res.Spread.TemperatureDegrees = max.TemperatureDegrees - min.TemperatureDegrees
+	return &res
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,348 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/internal/divergence"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_consensus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	fresh := timestamppb.New(now)
+	stale := timestamppb.New(now.Add(-time.Minute))
+
+	type Responses struct {
+		weatherstack *weatherstack.CurrentWeather
+		openweather  *openweather.Weather
+		openmeteo    *openmeteo.CurrentWeather
+		metno        *metno.CurrentWeather
+	}
+
+	for _, tc := range [...]struct {
+		name      string
+		path      string
+		priority  []Provider
+		consensus Consensus
+		responses Responses
+		status    int
+		body      string
+	}{
+		{
+			name: `all agree`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 20, WindSpeed: 10},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 21, WindSpeed: 2.5},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 22, WindSpeed: 12},
+				metno:        &metno.CurrentWeather{ReadTime: fresh, AirTemperature: 21, WindSpeed: 2.5},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":10,"temperature_degrees":21,"providers":["weatherstack","openweather","openmeteo","metno"],"spread":{"wind_speed":3,"temperature_degrees":2}}`,
+		},
+		{
+			name: `outlier dropped`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 20, WindSpeed: 9},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 22, WindSpeed: 2.5},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 30, WindSpeed: 12},
+				metno:        &metno.CurrentWeather{ReadTime: fresh, AirTemperature: 21, WindSpeed: 2.5},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":9,"temperature_degrees":21,"providers":["weatherstack","openweather","metno"],"spread":{"wind_speed":0,"temperature_degrees":2}}`,
+		},
+		{
+			name:      `custom deviation`,
+			consensus: Consensus{MaxTemperatureDeviation: 10},
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 20, WindSpeed: 9},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 22, WindSpeed: 2.5},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 30, WindSpeed: 9},
+				metno:        &metno.CurrentWeather{ReadTime: fresh, AirTemperature: 24, WindSpeed: 2.5},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":9,"temperature_degrees":24,"providers":["weatherstack","openweather","openmeteo","metno"],"spread":{"wind_speed":0,"temperature_degrees":10}}`,
+		},
+		{
+			name:      `weighted`,
+			consensus: Consensus{Weights: map[Provider]float64{ProviderWeatherstack: 3, ProviderOpenMeteo: 0}},
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 20, WindSpeed: 10},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 24, WindSpeed: 2.5},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 100, WindSpeed: 100},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":9.75,"temperature_degrees":21,"providers":["weatherstack","openweather"],"spread":{"wind_speed":1,"temperature_degrees":4}}`,
+		},
+		{
+			name: `stale excluded`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: stale, Temperature: 30, WindSpeed: 30},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 22, WindSpeed: 2.5},
+				metno:        &metno.CurrentWeather{ReadTime: stale, ExpireTime: timestamppb.New(now.Add(time.Minute)), AirTemperature: 20, WindSpeed: 2.5},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":9,"temperature_degrees":21,"providers":["openweather","metno"],"spread":{"wind_speed":0,"temperature_degrees":2}}`,
+		},
+		{
+			name:     `single provider`,
+			path:     `/v1/weather?city=sydney&mode=consensus`,
+			priority: []Provider{ProviderOpenMeteo},
+			responses: Responses{
+				openmeteo: &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 3, WindSpeed: 4},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":4,"temperature_degrees":3,"providers":["openmeteo"],"spread":{"wind_speed":0,"temperature_degrees":0}}`,
+		},
+		{
+			name: `no fresh readings`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: stale, Temperature: 20, WindSpeed: 10},
+			},
+			status: http.StatusServiceUnavailable,
+			body:   `{"code":14,"message":"no fresh weather readings available"}`,
+		},
+		{
+			name:     `two providers disagree`,
+			priority: []Provider{ProviderWeatherstack, ProviderOpenweather},
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 10, WindSpeed: 10},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 20, WindSpeed: 2.5},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":9.5,"temperature_degrees":15,"providers":["weatherstack","openweather"],"spread":{"wind_speed":1,"temperature_degrees":10}}`,
+		},
+		{
+			name: `no consensus`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 10, WindSpeed: 10},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 10, WindSpeed: 2.5},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 20, WindSpeed: 9},
+				metno:        &metno.CurrentWeather{ReadTime: fresh, AirTemperature: 20, WindSpeed: 2.5},
+			},
+			status: http.StatusServiceUnavailable,
+			body:   `{"code":14,"message":"no consensus between weather providers"}`,
+		},
+		{
+			name: `priority mode`,
+			path: `/v1/weather?city=sydney&mode=priority`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 20, WindSpeed: 10},
+				openweather:  &openweather.Weather{ReadTime: fresh, Temp: 24, WindSpeed: 2.5},
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":10,"temperature_degrees":20}`,
+		},
+		{
+			name:   `unknown mode`,
+			path:   `/v1/weather?city=sydney&mode=average`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"unknown mode \"average\""}`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			errUnavailable := errors.New(`unavailable`)
+
+			server := Server{
+				MaxAge:    time.Second * 3,
+				TimeNow:   func() time.Time { return now },
+				Priority:  tc.priority,
+				Consensus: tc.consensus,
+				Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+					if tc.responses.weatherstack == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.weatherstack, nil
+				}},
+				Openweather: &mockOpenweatherClient{getWeather: func(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
+					if tc.responses.openweather == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.openweather, nil
+				}},
+				OpenMeteo: &mockOpenMeteoClient{getCurrentWeather: func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+					if tc.responses.openmeteo == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.openmeteo, nil
+				}},
+				Metno: &mockMetnoClient{getCurrentWeather: func(ctx context.Context, in *metno.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*metno.CurrentWeather, error) {
+					if tc.responses.metno == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.metno, nil
+				}},
+			}
+
+			router := chi.NewRouter()
+			router.Route(`/`, server.Register)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			path := tc.path
+			if path == `` {
+				path = `/v1/weather?city=sydney&mode=consensus`
+			}
+			res, body := testRequest(t, ts, http.MethodGet, path, nil)
+			if res.StatusCode != tc.status {
+				t.Errorf(`unexpected status code: %d`, res.StatusCode)
+			}
+			if body != tc.body {
+				t.Errorf("unexpected body: %q\n%s", body, body)
+			}
+		})
+	}
+}
+
+func TestServer_divergence(t *testing.T) {
+	t.Parallel()
+
+	getTime, setTime := mockTime()
+	setTime(time.Unix(1667179321, 0))
+	tracker := divergence.Tracker{MinSamples: 1}
+	server := Server{
+		MaxAge:     time.Second * 3,
+		TimeNow:    getTime,
+		Divergence: &tracker,
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			return &weatherstack.CurrentWeather{ReadTime: timestamppb.New(getTime()), Temperature: 20, WindSpeed: 10}, nil
+		}},
+		Openweather: &mockOpenweatherClient{getWeather: func(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
+			return &openweather.Weather{ReadTime: timestamppb.New(getTime()), Temp: 21, WindSpeed: 2.5}, nil
+		}},
+		OpenMeteo: &mockOpenMeteoClient{getCurrentWeather: func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+			return &openmeteo.CurrentWeather{ReadTime: timestamppb.New(getTime()), Temperature: 35, WindSpeed: 12}, nil
+		}},
+	}
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// note: providers are requested concurrently, so the second request guarantees a comparison
+	for i := 0; i < 2; i++ {
+		if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=Sydney&mode=consensus`, nil); res.StatusCode != http.StatusOK {
+			t.Fatal(res.StatusCode, body)
+		}
+		setTime(getTime().Add(time.Second))
+	}
+
+	stats := tracker.Stats(string(ProviderOpenMeteo), `sydney`)
+	if len(stats) != 1 || !stats[0].Flagged || stats[0].LastTemperatureDelta != 14 {
+		t.Errorf(`unexpected stats: %+v`, stats)
+	}
+}
+
+func TestServer_divergence_sample(t *testing.T) {
+	t.Parallel()
+
+	getTime, setTime := mockTime()
+	setTime(time.Unix(1667179321, 0))
+	tracker := divergence.Tracker{MinSamples: 1, SampleInterval: time.Minute, TimeNow: getTime}
+	var calls sync.Map
+	call := func(provider Provider) {
+		v, _ := calls.LoadOrStore(provider, new(atomic.Int64))
+		v.(*atomic.Int64).Add(1)
+	}
+	count := func(provider Provider) int64 {
+		if v, ok := calls.Load(provider); ok {
+			return v.(*atomic.Int64).Load()
+		}
+		return 0
+	}
+	server := Server{
+		MaxAge:     time.Second * 3,
+		TimeNow:    getTime,
+		Divergence: &tracker,
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			call(ProviderWeatherstack)
+			return &weatherstack.CurrentWeather{ReadTime: timestamppb.New(getTime()), Temperature: 20, WindSpeed: 10}, nil
+		}},
+		Openweather: &mockOpenweatherClient{getWeather: func(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
+			call(ProviderOpenweather)
+			return &openweather.Weather{ReadTime: timestamppb.New(getTime()), Temp: 21, WindSpeed: 2.5}, nil
+		}},
+		OpenMeteo: &mockOpenMeteoClient{getCurrentWeather: func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+			call(ProviderOpenMeteo)
+			return &openmeteo.CurrentWeather{ReadTime: timestamppb.New(getTime()), Temperature: 35, WindSpeed: 12}, nil
+		}},
+	}
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// note: only the first request samples the other providers, as the second is within the interval
+	for i := 0; i < 2; i++ {
+		if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=Sydney`, nil); res.StatusCode != http.StatusOK {
+			t.Fatal(res.StatusCode, body)
+		}
+		setTime(getTime().Add(time.Second))
+	}
+	// waits for the samples
+	server.Close()
+
+	if v := [...]int64{count(ProviderWeatherstack), count(ProviderOpenweather), count(ProviderOpenMeteo)}; v != [...]int64{2, 1, 1} {
+		t.Errorf(`unexpected calls: %v`, v)
+	}
+	for _, provider := range [...]Provider{ProviderOpenweather, ProviderOpenMeteo} {
+		if stats := tracker.Stats(string(provider), `sydney`); len(stats) != 1 || stats[0].Samples != 1 {
+			t.Errorf(`unexpected stats for %s: %+v`, provider, stats)
+		}
+	}
+
+	// no samples are started, once closed
+	setTime(getTime().Add(time.Minute))
+	if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=Sydney`, nil); res.StatusCode != http.StatusOK {
+		t.Fatal(res.StatusCode, body)
+	}
+	server.Close()
+	if v := count(ProviderOpenweather); v != 1 {
+		t.Errorf(`unexpected calls: %d`, v)
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		in  string
+		out map[Provider]float64
+		err string
+	}{
+		{in: ``, out: map[Provider]float64{}},
+		{in: `weatherstack:2, openmeteo:0.5,metno:0`, out: map[Provider]float64{ProviderWeatherstack: 2, ProviderOpenMeteo: 0.5, ProviderMetno: 0}},
+		{in: `weatherstack`, err: `missing weight for "weatherstack"`},
+		{in: `accuweather:1`, err: `unknown provider "accuweather"`},
+		{in: `metno:1,metno:2`, err: `duplicate provider "metno"`},
+		{in: `metno:-1`, err: `invalid weight for "metno": -1`},
+		{in: `metno:NaN`, err: `invalid weight for "metno": NaN`},
+	} {
+		out, err := ParseWeights(tc.in)
+		if tc.err != `` {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf(`unexpected error for %q: %v`, tc.in, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(out, tc.out) {
+			t.Errorf(`unexpected output for %q: %v %v`, tc.in, out, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package weather
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// sampling tracks the in-flight samples, see Server.sample, and Server.Close
+	sampling struct {
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		closed bool
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+)
+
+const (
+	// sampleTimeout bounds each sample, which is independent of the request that triggered it
+	sampleTimeout = time.Second * 30
+)
+
+// Close cancels, and waits for, any in-flight samples of the providers that didn't serve a request, see
+// divergence.Tracker.Sample. It should be called once no more requests will be served.
+func (x *Server) Close() {
+	x.sampling.mu.Lock()
+	x.sampling.closed = true
+	if x.sampling.cancel != nil {
+		x.sampling.cancel()
+	}
+	x.sampling.mu.Unlock()
+	x.sampling.wg.Wait()
+}
+
+// sample requests the current weather from each of the other configured providers, in the background, if the location
+// is due to be sampled, such that Divergence can compare the providers, even though requests are (ordinarily) served
+// by a single provider
+func (x *Server) sample(ctx context.Context, target target, served Provider) {
+	if !x.Divergence.Sample(target.key()) {
+		return
+	}
+
+	var providers []Provider
+	for _, provider := range x.Configured() {
+		if provider != served {
+			providers = append(providers, provider)
+		}
+	}
+	if len(providers) == 0 {
+		return
+	}
+
+	x.sampling.mu.Lock()
+	defer x.sampling.mu.Unlock()
+	if x.sampling.closed {
+		return
+	}
+	if x.sampling.ctx == nil {
+		x.sampling.ctx, x.sampling.cancel = context.WithCancel(context.Background())
+	}
+	x.sampling.wg.Add(len(providers))
+
+	// note: retains the values of the request context, e.g. for tracing, but not its cancellation
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sampleTimeout)
+	stop := context.AfterFunc(x.sampling.ctx, cancel)
+	var wg sync.WaitGroup
+	wg.Add(len(providers))
+	go func() {
+		wg.Wait()
+		stop()
+		cancel()
+	}()
+
+	minReadTime := x.TimeNow().Add(-x.settings().MaxAge)
+	for _, provider := range providers {
+		go func(provider Provider) {
+			defer x.sampling.wg.Done()
+			defer wg.Done()
+			// note: the reading is observed by getReading, like any other
+			_, _ = x.getReading(ctx, provider, target, minReadTime)
+		}(provider)
+	}
+}
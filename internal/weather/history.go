@@ -0,0 +1,127 @@
+package weather
+
+import (
+	"github.com/joeycumines/mx51-weather-api/internal/history"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"time"
+)
+
+type (
+	historyResponse struct {
+		Location string          `json:"location"`
+		Provider Provider        `json:"provider,omitempty"`
+		From     time.Time       `json:"from"`
+		To       time.Time       `json:"to"`
+		Interval string          `json:"interval"`
+		Points   []history.Point `json:"points"`
+	}
+)
+
+const (
+	// DefaultHistoryRange is the range used if the `from` query parameter is not provided.
+	DefaultHistoryRange = time.Hour * 24
+
+	// DefaultHistoryInterval is the interval used if the `interval` query parameter is not provided.
+	DefaultHistoryInterval = time.Hour
+
+	// maxHistoryPoints bounds the number of intervals in a single request
+	maxHistoryPoints = 10000
+)
+
+func (x *Server) getHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	// note: resolved the same way as /v1/weather, as readings are tracked by the resolved location, if any
+	query := locationQuery{
+		city:    params.Get(`city`),
+		country: params.Get(`country`),
+		state:   params.Get(`state`),
+		id:      params.Get(`location_id`),
+	}
+	if query.city == `` && query.id == `` {
+		_ = writeError(w, http.StatusBadRequest, status.Error(codes.InvalidArgument,
+			`at least one query parameter required`))
+		return
+	}
+	if query.country != `` && !validCountry(query.country) {
+		_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+			`invalid country %q`, query.country))
+		return
+	}
+
+	provider := Provider(params.Get(`provider`))
+	if provider != `` && !provider.valid() {
+		_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+			`unknown provider %q`, provider))
+		return
+	}
+
+	to := x.TimeNow()
+	if v := params.Get(`to`); v != `` {
+		var err error
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+				`invalid to: %v`, err))
+			return
+		}
+	}
+
+	from := to.Add(-DefaultHistoryRange)
+	if v := params.Get(`from`); v != `` {
+		var err error
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+				`invalid from: %v`, err))
+			return
+		}
+	}
+	if !from.Before(to) {
+		_ = writeError(w, http.StatusBadRequest, status.Error(codes.InvalidArgument,
+			`from must be before to`))
+		return
+	}
+
+	interval := DefaultHistoryInterval
+	if v := params.Get(`interval`); v != `` {
+		var err error
+		if interval, err = time.ParseDuration(v); err != nil || interval <= 0 {
+			_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+				`invalid interval %q`, v))
+			return
+		}
+	}
+	if to.Sub(from)/interval >= maxHistoryPoints {
+		_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+			`interval too small, at most %d points supported`, maxHistoryPoints))
+		return
+	}
+
+	target, err := x.resolve(r.Context(), query)
+	if err != nil {
+		_ = writeError(w, httpStatusCode(err), err)
+		return
+	}
+
+	readings, err := x.History.Query(string(provider), target.key(), from, to)
+	if err != nil {
+		_ = writeError(w, http.StatusInternalServerError, status.Errorf(codes.Internal,
+			`failed to query history: %v`, err))
+		return
+	}
+
+	points := history.Downsample(readings, from, interval)
+	if points == nil {
+		points = []history.Point{}
+	}
+
+	_ = writeJSON(w, http.StatusOK, &historyResponse{
+		Location: history.NormalizeLocation(target.key()),
+		Provider: provider,
+		From:     from.UTC(),
+		To:       to.UTC(),
+		Interval: interval.String(),
+		Points:   points,
+	})
+}
@@ -0,0 +1,188 @@
+package weather
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/history"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_history(t *testing.T) {
+	t.Parallel()
+
+	timeNow, setTime := mockTime()
+	start := time.Date(2022, 10, 31, 1, 0, 0, 0, time.UTC)
+	setTime(start)
+
+	var temperature float64
+	server := Server{
+		MaxAge:  time.Minute,
+		TimeNow: timeNow,
+		History: &history.Store{Dir: t.TempDir(), TimeNow: timeNow},
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			return &weatherstack.CurrentWeather{
+				ReadTime:    timestamppb.New(timeNow()),
+				Temperature: temperature,
+				WindSpeed:   10,
+			}, nil
+		}},
+	}
+	defer server.History.Close()
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for i, v := range [...]float64{20, 22, 24, 26} {
+		setTime(start.Add(time.Minute * 20 * time.Duration(i)))
+		temperature = v
+		if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=Sydney`, nil); res.StatusCode != http.StatusOK {
+			t.Fatalf(`unexpected response: %d %s`, res.StatusCode, body)
+		}
+		// cached readings aren't recorded twice
+		if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=Sydney`, nil); res.StatusCode != http.StatusOK {
+			t.Fatalf(`unexpected response: %d %s`, res.StatusCode, body)
+		}
+	}
+	setTime(start.Add(time.Hour * 2))
+
+	for _, tc := range [...]struct {
+		path   string
+		status int
+		body   string
+	}{
+		{
+			path:   `/v1/weather/history?city=sydney&from=2022-10-31T01:00:00Z&to=2022-10-31T03:00:00Z`,
+			status: http.StatusOK,
+			body:   `{"location":"sydney","from":"2022-10-31T01:00:00Z","to":"2022-10-31T03:00:00Z","interval":"1h0m0s","points":[{"time":"2022-10-31T01:00:00Z","samples":3,"temperature_degrees":22,"wind_speed":10},{"time":"2022-10-31T02:00:00Z","samples":1,"temperature_degrees":26,"wind_speed":10}]}`,
+		},
+		{
+			path:   `/v1/weather/history?city=SYDNEY&provider=weatherstack&interval=30m`,
+			status: http.StatusOK,
+			body:   `{"location":"sydney","provider":"weatherstack","from":"2022-10-30T03:00:00Z","to":"2022-10-31T03:00:00Z","interval":"30m0s","points":[{"time":"2022-10-31T01:00:00Z","samples":2,"temperature_degrees":21,"wind_speed":10},{"time":"2022-10-31T01:30:00Z","samples":1,"temperature_degrees":24,"wind_speed":10},{"time":"2022-10-31T02:00:00Z","samples":1,"temperature_degrees":26,"wind_speed":10}]}`,
+		},
+		{
+			path:   `/v1/weather/history?city=sydney&provider=openweather`,
+			status: http.StatusOK,
+			body:   `{"location":"sydney","provider":"openweather","from":"2022-10-30T03:00:00Z","to":"2022-10-31T03:00:00Z","interval":"1h0m0s","points":[]}`,
+		},
+		{
+			path:   `/v1/weather/history`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"at least one query parameter required"}`,
+		},
+		{
+			path:   `/v1/weather/history?city=sydney&provider=accuweather`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"unknown provider \"accuweather\""}`,
+		},
+		{
+			path:   `/v1/weather/history?city=sydney&from=yesterday`,
+			status: http.StatusBadRequest,
+		},
+		{
+			path:   `/v1/weather/history?city=sydney&from=2022-10-31T03:00:00Z&to=2022-10-31T01:00:00Z`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"from must be before to"}`,
+		},
+		{
+			path:   `/v1/weather/history?city=sydney&interval=-1h`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"invalid interval \"-1h\""}`,
+		},
+		{
+			path:   `/v1/weather/history?city=sydney&interval=1s`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"interval too small, at most 10000 points supported"}`,
+		},
+	} {
+		res, body := testRequest(t, ts, http.MethodGet, tc.path, nil)
+		if res.StatusCode != tc.status || (tc.body != `` && body != tc.body) {
+			t.Errorf("unexpected response to %s: %d %s", tc.path, res.StatusCode, body)
+		}
+	}
+}
+
+func TestServer_history_resolved(t *testing.T) {
+	t.Parallel()
+
+	timeNow, setTime := mockTime()
+	start := time.Date(2022, 10, 31, 1, 0, 0, 0, time.UTC)
+	setTime(start)
+
+	sydney := &locationpb.Location{Id: `geonames:2147714`, Name: `Sydney`, CountryCode: `AU`, Position: &latlngpb.LatLng{Latitude: -33.86785, Longitude: 151.20732}}
+	server := Server{
+		MaxAge:  time.Minute,
+		TimeNow: timeNow,
+		History: &history.Store{Dir: t.TempDir(), TimeNow: timeNow},
+		Geocode: &mockGeocodeClient{
+			searchLocations: func(ctx context.Context, in *geocode.SearchLocationsRequest, opts ...grpc.CallOption) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{sydney}}, nil
+			},
+			getLocation: func(ctx context.Context, in *geocode.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error) {
+				if in.GetId() != sydney.GetId() {
+					return nil, status.Error(codes.NotFound, `geocode: not found`)
+				}
+				return sydney, nil
+			},
+		},
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			return &weatherstack.CurrentWeather{ReadTime: timestamppb.New(timeNow()), Temperature: 20, WindSpeed: 10}, nil
+		}},
+	}
+	defer server.History.Close()
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// equivalent queries share a single series
+	for i, path := range [...]string{
+		`/v1/weather?city=Sydney`,
+		`/v1/weather?city=sydney&country=au`,
+		`/v1/weather?location_id=geonames:2147714`,
+	} {
+		setTime(start.Add(time.Minute * 5 * time.Duration(i)))
+		if res, body := testRequest(t, ts, http.MethodGet, path, nil); res.StatusCode != http.StatusOK {
+			t.Fatalf(`unexpected response: %d %s`, res.StatusCode, body)
+		}
+	}
+	setTime(start.Add(time.Hour))
+
+	for _, path := range [...]string{
+		`/v1/weather/history?city=SYDNEY`,
+		`/v1/weather/history?location_id=geonames:2147714`,
+	} {
+		if res, body := testRequest(t, ts, http.MethodGet, path, nil); res.StatusCode != http.StatusOK ||
+			body != `{"location":"geonames:2147714","from":"2022-10-30T02:00:00Z","to":"2022-10-31T02:00:00Z","interval":"1h0m0s","points":[{"time":"2022-10-31T01:00:00Z","samples":3,"temperature_degrees":20,"wind_speed":10}]}` {
+			t.Errorf("unexpected response to %s: %d %s", path, res.StatusCode, body)
+		}
+	}
+	if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather/history?location_id=geonames:1`, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf(`unexpected response: %d %s`, res.StatusCode, body)
+	}
+}
+
+func TestServer_history_disabled(t *testing.T) {
+	t.Parallel()
+	router := chi.NewRouter()
+	router.Route(`/`, new(Server).Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	if res, _ := testRequest(t, ts, http.MethodGet, `/v1/weather/history?city=sydney`, nil); res.StatusCode != http.StatusNotFound {
+		t.Errorf(`unexpected status code: %d`, res.StatusCode)
+	}
+}
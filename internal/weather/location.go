@@ -0,0 +1,236 @@
+package weather
+
+import (
+	"context"
+	"fmt"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+type (
+	// locationQuery models the location related query parameters
+	locationQuery struct {
+		city    string
+		country string
+		state   string
+		id      string
+	}
+
+	// target is the subject of a request, the location is set if the query was resolved, and takes precedence
+	target struct {
+		query    string
+		location *locationpb.Location
+	}
+
+	locationResponse struct {
+		ID          string  `json:"id"`
+		Name        string  `json:"name"`
+		Admin1      string  `json:"admin1,omitempty"`
+		CountryCode string  `json:"country_code,omitempty"`
+		TimeZone    string  `json:"time_zone,omitempty"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+	}
+
+	// ambiguousLocationError indicates the query matched multiple plausible locations, the candidates are included
+	// as details of the status, along with an ErrorInfo
+	ambiguousLocationError struct {
+		query      string
+		candidates []*locationpb.Location
+	}
+)
+
+const (
+	// DefaultAmbiguityThreshold is the default value for Server.AmbiguityThreshold.
+	DefaultAmbiguityThreshold = 0.5
+
+	// ReasonAmbiguousLocation is the google.rpc.ErrorInfo reason, for ambiguous location queries.
+	ReasonAmbiguousLocation = `AMBIGUOUS_LOCATION`
+
+	// ErrorDomain is the google.rpc.ErrorInfo domain.
+	ErrorDomain = `weather`
+
+	// maxCandidates is the number of matches considered, when resolving a query
+	maxCandidates = 20
+)
+
+// resolve geocodes the query, if Geocode is configured, falling back to the (free-text) query if it is unavailable
+func (x *Server) resolve(ctx context.Context, query locationQuery) (target, error) {
+	target := target{query: query.city}
+	if target.query == `` {
+		target.query = query.id
+	}
+
+	if x.Geocode == nil {
+		if query.id != `` {
+			return target, status.Error(codes.InvalidArgument, `location_id not supported`)
+		}
+		return target, nil
+	}
+
+	if query.id != `` {
+		location, err := x.Geocode.GetLocation(ctx, &geocode.GetLocationRequest{Id: query.id})
+		switch status.Code(err) {
+		case codes.OK:
+			target.location = location
+			return target, nil
+		case codes.NotFound:
+			return target, status.Error(codes.NotFound, `location not found`)
+		case codes.InvalidArgument:
+			return target, status.Errorf(codes.InvalidArgument, `invalid location_id %q`, query.id)
+		default:
+			return target, err
+		}
+	}
+
+	res, err := x.Geocode.SearchLocations(ctx, &geocode.SearchLocationsRequest{
+		Query:       query.city,
+		CountryCode: query.country,
+		MaxResults:  maxCandidates,
+	})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return target, status.Error(codes.NotFound, `location not found`)
+		}
+		slog.WarnContext(ctx, `failed to resolve location`, `error`, err)
+		return target, nil
+	}
+
+	candidates := res.GetLocations()
+	if query.state != `` {
+		filtered := make([]*locationpb.Location, 0, len(candidates))
+		for _, location := range candidates {
+			if strings.EqualFold(strings.TrimSpace(query.state), location.GetAdmin1()) {
+				filtered = append(filtered, location)
+			}
+		}
+		candidates = filtered
+	}
+	if len(candidates) == 0 {
+		return target, status.Error(codes.NotFound, `location not found`)
+	}
+
+	if candidates = x.plausible(candidates); len(candidates) > 1 {
+		return target, &ambiguousLocationError{query: query.city, candidates: candidates}
+	}
+
+	target.location = candidates[0]
+	return target, nil
+}
+
+// key identifies the location readings are tracked by, i.e. the id of the resolved location, falling back to the
+// query, e.g. for divergence, history, and alerts
+func (x target) key() string {
+	if id := x.location.GetId(); id != `` {
+		return id
+	}
+	return x.query
+}
+
+// route returns the attributes used for routing, preferring the country of the resolved location, and falling back
+// to its name, if the query was by location id
+func (x target) route(query locationQuery) route {
+	route := route{country: query.country, city: query.city}
+	if x.location != nil {
+		if v := x.location.GetCountryCode(); v != `` {
+			route.country = v
+		}
+		if route.city == `` {
+			route.city = x.location.GetName()
+		}
+	}
+	return route
+}
+
+// plausible filters candidates by population, relative to the most populous, preserving order, falling back to the
+// most relevant match, i.e. the first, if there is no population data
+func (x *Server) plausible(candidates []*locationpb.Location) []*locationpb.Location {
+	threshold := x.ambiguityThreshold()
+	if threshold > 1 {
+		return candidates[:1]
+	}
+	var max int64
+	for _, location := range candidates {
+		if location.GetPopulation() > max {
+			max = location.GetPopulation()
+		}
+	}
+	if max == 0 {
+		return candidates[:1]
+	}
+	plausible := make([]*locationpb.Location, 0, len(candidates))
+	for _, location := range candidates {
+		if float64(location.GetPopulation()) >= threshold*float64(max) {
+			plausible = append(plausible, location)
+		}
+	}
+	return plausible
+}
+
+func (x *Server) ambiguityThreshold() float64 {
+	if v := x.settings().AmbiguityThreshold; v > 0 {
+		return v
+	}
+	return DefaultAmbiguityThreshold
+}
+
+func (x *ambiguousLocationError) Error() string {
+	return x.GRPCStatus().Message()
+}
+
+// GRPCStatus implements the interface used by status.FromError.
+func (x *ambiguousLocationError) GRPCStatus() *status.Status {
+	sts := status.Newf(codes.InvalidArgument,
+		`ambiguous location %q, disambiguate using location_id, country, or state`, x.query)
+	details := []protoiface.MessageV1{&errdetails.ErrorInfo{
+		Reason: ReasonAmbiguousLocation,
+		Domain: ErrorDomain,
+		Metadata: map[string]string{
+			`query`:      x.query,
+			`candidates`: strconv.Itoa(len(x.candidates)),
+		},
+	}}
+	for _, location := range x.candidates {
+		details = append(details, location)
+	}
+	v, err := sts.WithDetails(details...)
+	if err != nil {
+		panic(fmt.Errorf(`weather: ambiguous location details: %w`, err))
+	}
+	return v
+}
+
+func newLocationResponse(location *locationpb.Location) *locationResponse {
+	if location == nil {
+		return nil
+	}
+	return &locationResponse{
+		ID:          location.GetId(),
+		Name:        location.GetName(),
+		Admin1:      location.GetAdmin1(),
+		CountryCode: location.GetCountryCode(),
+		TimeZone:    location.GetTimeZone(),
+		Latitude:    location.GetPosition().GetLatitude(),
+		Longitude:   location.GetPosition().GetLongitude(),
+	}
+}
+
+// validCountry returns true if the country is (syntactically) an ISO 3166-1 alpha-2 code
+func validCountry(country string) bool {
+	if len(country) != 2 {
+		return false
+	}
+	for _, r := range country {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,282 @@
+package weather
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	latlngpb "google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_resolve(t *testing.T) {
+	t.Parallel()
+
+	var (
+		sydney = &locationpb.Location{
+			Id:          `geonames:2147714`,
+			Name:        `Sydney`,
+			Position:    &latlngpb.LatLng{Latitude: -33.86785, Longitude: 151.20732},
+			CountryCode: `AU`,
+			TimeZone:    `Australia/Sydney`,
+			Admin1:      `New South Wales`,
+			Population:  4627345,
+		}
+		sydneyCA = &locationpb.Location{
+			Id:          `geonames:6354908`,
+			Name:        `Sydney`,
+			Position:    &latlngpb.LatLng{Latitude: 46.1351, Longitude: -60.1831},
+			CountryCode: `CA`,
+			Admin1:      `Nova Scotia`,
+			Population:  105968,
+		}
+		springfieldMO = &locationpb.Location{
+			Id:          `geonames:4409896`,
+			Name:        `Springfield`,
+			Position:    &latlngpb.LatLng{Latitude: 37.21533, Longitude: -93.29824},
+			CountryCode: `US`,
+			Admin1:      `Missouri`,
+			Population:  169176,
+		}
+		springfieldIL = &locationpb.Location{
+			Id:          `geonames:4250542`,
+			Name:        `Springfield`,
+			Position:    &latlngpb.LatLng{Latitude: 39.80172, Longitude: -89.64371},
+			CountryCode: `US`,
+			Admin1:      `Illinois`,
+			Population:  116565,
+		}
+		springfieldOR = &locationpb.Location{
+			Id:          `geonames:5754005`,
+			Name:        `Springfield`,
+			Position:    &latlngpb.LatLng{Latitude: 44.04624, Longitude: -123.02203},
+			CountryCode: `US`,
+			Admin1:      `Oregon`,
+			Population:  59403,
+		}
+	)
+
+	// e.g. the gazetteer, or open-meteo, may not have population data, in which case the first match is used
+	var unpopulated []*locationpb.Location
+	for _, location := range [...]*locationpb.Location{springfieldIL, springfieldMO, springfieldOR} {
+		location = proto.Clone(location).(*locationpb.Location)
+		location.Population = 0
+		unpopulated = append(unpopulated, location)
+	}
+
+	for _, tc := range [...]struct {
+		name      string
+		path      string
+		threshold float64
+		search    func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error)
+		get       func(in *geocode.GetLocationRequest) (*locationpb.Location, error)
+		position  *latlngpb.LatLng
+		status    int
+		body      string
+	}{
+		{
+			name: `resolved`,
+			path: `/v1/weather?city=sydney`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				if in.GetQuery() != `sydney` || in.GetCountryCode() != `` || in.GetMaxResults() != maxCandidates {
+					t.Errorf(`unexpected request: %v`, in)
+				}
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{sydney, sydneyCA}}, nil
+			},
+			position: sydney.GetPosition(),
+			status:   http.StatusOK,
+			body:     `{"wind_speed":11,"temperature_degrees":21,"location":{"id":"geonames:2147714","name":"Sydney","admin1":"New South Wales","country_code":"AU","time_zone":"Australia/Sydney","latitude":-33.86785,"longitude":151.20732}}`,
+		},
+		{
+			name: `country`,
+			path: `/v1/weather?city=sydney&country=ca`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				if in.GetCountryCode() != `ca` {
+					t.Errorf(`unexpected request: %v`, in)
+				}
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{sydneyCA}}, nil
+			},
+			position: sydneyCA.GetPosition(),
+			status:   http.StatusOK,
+			body:     `{"wind_speed":11,"temperature_degrees":21,"location":{"id":"geonames:6354908","name":"Sydney","admin1":"Nova Scotia","country_code":"CA","latitude":46.1351,"longitude":-60.1831}}`,
+		},
+		{
+			name:   `invalid country`,
+			path:   `/v1/weather?city=sydney&country=AUS`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"invalid country \"AUS\""}`,
+		},
+		{
+			name: `ambiguous`,
+			path: `/v1/weather?city=springfield`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{springfieldMO, springfieldIL, springfieldOR}}, nil
+			},
+			status: http.StatusMultipleChoices,
+			body: `{"code":3,"message":"ambiguous location \"springfield\", disambiguate using location_id, country, or state","details":[` +
+				`{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"AMBIGUOUS_LOCATION","domain":"weather","metadata":{"candidates":"2","query":"springfield"}},` +
+				`{"@type":"type.googleapis.com/weather.type.Location","name":"Springfield","position":{"latitude":37.21533,"longitude":-93.29824},"id":"geonames:4409896","countryCode":"US","admin1":"Missouri","population":"169176"},` +
+				`{"@type":"type.googleapis.com/weather.type.Location","name":"Springfield","position":{"latitude":39.80172,"longitude":-89.64371},"id":"geonames:4250542","countryCode":"US","admin1":"Illinois","population":"116565"}]}`,
+		},
+		{
+			name:      `ambiguity threshold`,
+			path:      `/v1/weather?city=springfield`,
+			threshold: 0.3,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{springfieldMO, springfieldIL, springfieldOR}}, nil
+			},
+			status: http.StatusMultipleChoices,
+			body: `{"code":3,"message":"ambiguous location \"springfield\", disambiguate using location_id, country, or state","details":[` +
+				`{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"AMBIGUOUS_LOCATION","domain":"weather","metadata":{"candidates":"3","query":"springfield"}},` +
+				`{"@type":"type.googleapis.com/weather.type.Location","name":"Springfield","position":{"latitude":37.21533,"longitude":-93.29824},"id":"geonames:4409896","countryCode":"US","admin1":"Missouri","population":"169176"},` +
+				`{"@type":"type.googleapis.com/weather.type.Location","name":"Springfield","position":{"latitude":39.80172,"longitude":-89.64371},"id":"geonames:4250542","countryCode":"US","admin1":"Illinois","population":"116565"},` +
+				`{"@type":"type.googleapis.com/weather.type.Location","name":"Springfield","position":{"latitude":44.04624,"longitude":-123.02203},"id":"geonames:5754005","countryCode":"US","admin1":"Oregon","population":"59403"}]}`,
+		},
+		{
+			name:      `disambiguation disabled`,
+			path:      `/v1/weather?city=springfield`,
+			threshold: 2,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{springfieldMO, springfieldIL, springfieldOR}}, nil
+			},
+			position: springfieldMO.GetPosition(),
+			status:   http.StatusOK,
+			body:     `{"wind_speed":11,"temperature_degrees":21,"location":{"id":"geonames:4409896","name":"Springfield","admin1":"Missouri","country_code":"US","latitude":37.21533,"longitude":-93.29824}}`,
+		},
+		{
+			name: `no population data`,
+			path: `/v1/weather?city=springfield`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: unpopulated}, nil
+			},
+			position: unpopulated[0].GetPosition(),
+			status:   http.StatusOK,
+			body:     `{"wind_speed":11,"temperature_degrees":21,"location":{"id":"geonames:4250542","name":"Springfield","admin1":"Illinois","country_code":"US","latitude":39.80172,"longitude":-89.64371}}`,
+		},
+		{
+			name: `state`,
+			path: `/v1/weather?city=springfield&country=US&state=illinois`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{springfieldMO, springfieldIL, springfieldOR}}, nil
+			},
+			position: springfieldIL.GetPosition(),
+			status:   http.StatusOK,
+			body:     `{"wind_speed":11,"temperature_degrees":21,"location":{"id":"geonames:4250542","name":"Springfield","admin1":"Illinois","country_code":"US","latitude":39.80172,"longitude":-89.64371}}`,
+		},
+		{
+			name: `state not found`,
+			path: `/v1/weather?city=springfield&state=texas`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{springfieldMO, springfieldIL, springfieldOR}}, nil
+			},
+			status: http.StatusNotFound,
+			body:   `{"code":5,"message":"location not found"}`,
+		},
+		{
+			name: `location id`,
+			path: `/v1/weather?location_id=geonames:4250542`,
+			get: func(in *geocode.GetLocationRequest) (*locationpb.Location, error) {
+				if in.GetId() != `geonames:4250542` {
+					t.Errorf(`unexpected request: %v`, in)
+				}
+				return springfieldIL, nil
+			},
+			position: springfieldIL.GetPosition(),
+			status:   http.StatusOK,
+			body:     `{"wind_speed":11,"temperature_degrees":21,"location":{"id":"geonames:4250542","name":"Springfield","admin1":"Illinois","country_code":"US","latitude":39.80172,"longitude":-89.64371}}`,
+		},
+		{
+			name: `location id not found`,
+			path: `/v1/weather?city=springfield&location_id=geonames:1`,
+			get: func(in *geocode.GetLocationRequest) (*locationpb.Location, error) {
+				return nil, status.Error(codes.NotFound, `geocode: not found`)
+			},
+			status: http.StatusNotFound,
+			body:   `{"code":5,"message":"location not found"}`,
+		},
+		{
+			name: `invalid location id`,
+			path: `/v1/weather?location_id=springfield`,
+			get: func(in *geocode.GetLocationRequest) (*locationpb.Location, error) {
+				return nil, status.Error(codes.InvalidArgument, `geocode: invalid`)
+			},
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"invalid location_id \"springfield\""}`,
+		},
+		{
+			name: `no results`,
+			path: `/v1/weather?city=sydney`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return &geocode.SearchLocationsResponse{}, nil
+			},
+			status: http.StatusNotFound,
+			body:   `{"code":5,"message":"location not found"}`,
+		},
+		{
+			name: `not found`,
+			path: `/v1/weather?city=sydney`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return nil, status.Error(codes.NotFound, `geocode: not found`)
+			},
+			status: http.StatusNotFound,
+			body:   `{"code":5,"message":"location not found"}`,
+		},
+		{
+			name: `unavailable falls back to the query`,
+			path: `/v1/weather?city=sydney`,
+			search: func(in *geocode.SearchLocationsRequest) (*geocode.SearchLocationsResponse, error) {
+				return nil, status.Error(codes.Unavailable, `geocode: unavailable`)
+			},
+			status: http.StatusOK,
+			body:   `{"wind_speed":11,"temperature_degrees":21}`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := Server{
+				MaxAge:             time.Second * 3,
+				TimeNow:            time.Now,
+				AmbiguityThreshold: tc.threshold,
+				Geocode: &mockGeocodeClient{
+					searchLocations: func(ctx context.Context, in *geocode.SearchLocationsRequest, opts ...grpc.CallOption) (*geocode.SearchLocationsResponse, error) {
+						if tc.search == nil {
+							t.Fatalf(`unexpected request: %v`, in)
+						}
+						return tc.search(in)
+					},
+					getLocation: func(ctx context.Context, in *geocode.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error) {
+						if tc.get == nil {
+							t.Fatalf(`unexpected request: %v`, in)
+						}
+						return tc.get(in)
+					},
+				},
+				Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+					if in.GetPosition() != tc.position {
+						t.Errorf(`unexpected request: %v`, in)
+					}
+					return &weatherstack.CurrentWeather{ReadTime: timestamppb.Now(), Temperature: 21, WindSpeed: 11}, nil
+				}},
+			}
+			router := chi.NewRouter()
+			router.Route(`/`, server.Register)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			if res, body := testRequest(t, ts, http.MethodGet, tc.path, nil); res.StatusCode != tc.status || body != tc.body {
+				t.Errorf("unexpected response: %d\n%s", res.StatusCode, body)
+			}
+		})
+	}
+}
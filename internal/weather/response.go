@@ -2,8 +2,10 @@ package weather
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -11,6 +13,24 @@ import (
 	"strconv"
 )
 
+// httpStatusCode maps the (gRPC) status of err to an HTTP status code
+func httpStatusCode(err error) int {
+	sts, _ := status.FromError(err)
+	switch sts.Code() {
+	case codes.InvalidArgument:
+		if errors.As(err, new(*ambiguousLocationError)) {
+			return http.StatusMultipleChoices
+		}
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func writeError(w http.ResponseWriter, statusCode int, err error) error {
 	if err == nil {
 		panic(`writeError: non-nil error required`)
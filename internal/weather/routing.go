@@ -0,0 +1,239 @@
+package weather
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	// Routing holds the current RoutingRules, which may be replaced at runtime, safe for concurrent use.
+	// See also Register, and NewRouting.
+	Routing struct {
+		// Path is the (JSON) file the rules are loaded from, by Reload, optional.
+		Path string
+
+		rules atomic.Pointer[RoutingRules]
+	}
+
+	// RoutingRules configures the order in which providers are attempted, per request.
+	RoutingRules struct {
+		// Priority is used if no rule matches, defaults to Server.Priority.
+		Priority []Provider `json:"priority,omitempty"`
+
+		// Rules are evaluated in order, the first match determines the priority.
+		Rules []RoutingRule `json:"rules,omitempty"`
+	}
+
+	// RoutingRule matches requests using each of the non-empty criteria, i.e. criteria are combined using AND, and
+	// the values of each criteria are combined using OR.
+	RoutingRule struct {
+		// Name identifies the rule, for logging and debugging.
+		Name string `json:"name"`
+
+		// Countries are ISO 3166-1 alpha-2 codes, matched against the country of the resolved location, falling back
+		// to the `country` query parameter, case-insensitively.
+		Countries []string `json:"countries,omitempty"`
+
+		// Cities are matched against the `city` query parameter (or the name of the location, if queried by id),
+		// case-insensitively.
+		Cities []string `json:"cities,omitempty"`
+
+		// Clients are matched against the id of the authenticated client, see clientauth.FromContext.
+		Clients []string `json:"clients,omitempty"`
+
+		// Priority is the order in which providers will be attempted, for matching requests.
+		Priority []Provider `json:"priority"`
+	}
+
+	// route models the request attributes used for routing
+	route struct {
+		country string
+		city    string
+		client  string
+	}
+)
+
+// NewRouting initialises routing from the given file, see also Routing.Reload.
+func NewRouting(path string) (*Routing, error) {
+	x := &Routing{Path: path}
+	if err := x.Reload(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// ParseRoutingRules parses and validates rules from JSON.
+func ParseRoutingRules(b []byte) (*RoutingRules, error) {
+	var rules RoutingRules
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, err
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// Validate returns an error if any priority contains unknown or duplicate providers, or a rule is malformed.
+func (x *RoutingRules) Validate() error {
+	if x.Priority != nil {
+		if err := validatePriority(x.Priority); err != nil {
+			return fmt.Errorf(`invalid priority: %w`, err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, rule := range x.Rules {
+		if rule.Name == `` {
+			return fmt.Errorf(`rule %d: missing name`, i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf(`rule %q: duplicate name`, rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Countries) == 0 && len(rule.Cities) == 0 && len(rule.Clients) == 0 {
+			return fmt.Errorf(`rule %q: at least one of countries, cities, or clients required`, rule.Name)
+		}
+		for _, country := range rule.Countries {
+			if len(country) != 2 {
+				return fmt.Errorf(`rule %q: invalid country %q`, rule.Name, country)
+			}
+		}
+		if err := validatePriority(rule.Priority); err != nil {
+			return fmt.Errorf(`rule %q: invalid priority: %w`, rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// Reload loads and validates the rules from Path, the current rules are retained if an error occurs.
+func (x *Routing) Reload() error {
+	if x.Path == `` {
+		return errors.New(`no routing rules path configured`)
+	}
+	b, err := os.ReadFile(x.Path)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseRoutingRules(b)
+	if err != nil {
+		return fmt.Errorf(`invalid routing rules %s: %w`, x.Path, err)
+	}
+	x.rules.Store(rules)
+	return nil
+}
+
+// Set validates and replaces the current rules.
+func (x *Routing) Set(rules *RoutingRules) error {
+	if rules == nil {
+		rules = new(RoutingRules)
+	}
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	x.rules.Store(rules)
+	return nil
+}
+
+// Rules returns the current rules, which must not be modified.
+func (x *Routing) Rules() *RoutingRules {
+	if x == nil {
+		return nil
+	}
+	return x.rules.Load()
+}
+
+// Register wires up the admin endpoints, to view, replace, and reload the rules.
+func (x *Routing) Register(r chi.Router) {
+	r.Get(`/admin/v1/routing`, x.getRules)
+	r.Put(`/admin/v1/routing`, x.setRules)
+	r.Post(`/admin/v1/routing/reload`, x.reload)
+}
+
+func (x *Routing) getRules(w http.ResponseWriter, r *http.Request) {
+	rules := x.Rules()
+	if rules == nil {
+		rules = new(RoutingRules)
+	}
+	_ = writeJSON(w, http.StatusOK, rules)
+}
+
+func (x *Routing) setRules(w http.ResponseWriter, r *http.Request) {
+	var rules RoutingRules
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, `invalid request body`, http.StatusBadRequest)
+		return
+	}
+	if err := x.Set(&rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, &rules)
+}
+
+func (x *Routing) reload(w http.ResponseWriter, r *http.Request) {
+	if err := x.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, x.Rules())
+}
+
+// priority returns the priority of the first matching rule, or the default priority, or nil
+func (x *RoutingRules) priority(route route) []Provider {
+	if x == nil {
+		return nil
+	}
+	for _, rule := range x.Rules {
+		if rule.matches(route) {
+			return rule.Priority
+		}
+	}
+	return x.Priority
+}
+
+func (x *RoutingRule) matches(route route) bool {
+	return matchesAny(x.Countries, route.country, true) &&
+		matchesAny(x.Cities, route.city, true) &&
+		matchesAny(x.Clients, route.client, false)
+}
+
+// matchesAny returns true if values is empty, or any value is equal to v, ignoring surrounding whitespace
+func matchesAny(values []string, v string, caseInsensitive bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	v = strings.TrimSpace(v)
+	if v == `` {
+		return false
+	}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == v || (caseInsensitive && strings.EqualFold(value, v)) {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePriority(priority []Provider) error {
+	if len(priority) == 0 {
+		return errors.New(`at least one provider required`)
+	}
+	seen := make(map[Provider]bool)
+	for _, provider := range priority {
+		if !provider.valid() {
+			return fmt.Errorf(`unknown provider %q`, provider)
+		}
+		if seen[provider] {
+			return fmt.Errorf(`duplicate provider %q`, provider)
+		}
+		seen[provider] = true
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package weather
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/clientauth"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
+	"github.com/joeycumines/mx51-weather-api/openweather"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
+	"github.com/joeycumines/mx51-weather-api/weatherstack"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer_routing(t *testing.T) {
+	t.Parallel()
+
+	rules := &RoutingRules{
+		Priority: []Provider{ProviderOpenweather, ProviderWeatherstack},
+		Rules: []RoutingRule{
+			{Name: `australia`, Countries: []string{`AU`}, Priority: []Provider{ProviderWeatherstack, ProviderOpenMeteo}},
+			{Name: `debug client`, Clients: []string{`client-1`}, Priority: []Provider{ProviderOpenMeteo}},
+			{Name: `london`, Countries: []string{`gb`}, Cities: []string{`london`}, Priority: []Provider{ProviderMetno, ProviderOpenMeteo}},
+		},
+	}
+
+	for _, tc := range [...]struct {
+		name     string
+		path     string
+		client   string
+		rules    *RoutingRules
+		location *locationpb.Location
+		status   int
+		calls    string
+		body     string
+	}{
+		{
+			name:   `no rules`,
+			path:   `/v1/weather?city=sydney`,
+			status: http.StatusOK,
+			calls:  `weatherstack`,
+		},
+		{
+			name:   `default rules priority`,
+			path:   `/v1/weather?city=sydney`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `openweather`,
+		},
+		{
+			name:   `country`,
+			path:   `/v1/weather?city=perth&country=au`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `weatherstack`,
+		},
+		{
+			name:     `resolved country`,
+			path:     `/v1/weather?city=sydney`,
+			rules:    rules,
+			location: &locationpb.Location{Id: `geonames:2147714`, Name: `Sydney`, CountryCode: `AU`},
+			status:   http.StatusOK,
+			calls:    `weatherstack`,
+		},
+		{
+			name:     `resolved country takes precedence`,
+			path:     `/v1/weather?city=sydney&country=GB`,
+			rules:    rules,
+			location: &locationpb.Location{Id: `geonames:2147714`, Name: `Sydney`, CountryCode: `AU`},
+			status:   http.StatusOK,
+			calls:    `weatherstack`,
+		},
+		{
+			name:     `resolved by location id`,
+			path:     `/v1/weather?location_id=geonames:2643743`,
+			rules:    rules,
+			location: &locationpb.Location{Id: `geonames:2643743`, Name: `London`, CountryCode: `GB`},
+			status:   http.StatusOK,
+			calls:    `openmeteo`,
+		},
+		{
+			name:   `client`,
+			path:   `/v1/weather?city=sydney`,
+			client: `client-1`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `openmeteo`,
+		},
+		{
+			name:   `first match wins`,
+			path:   `/v1/weather?city=sydney&country=AU`,
+			client: `client-1`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `weatherstack`,
+		},
+		{
+			name:   `all criteria must match`,
+			path:   `/v1/weather?city=manchester&country=GB`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `openweather`,
+		},
+		{
+			name:   `unconfigured providers skipped`,
+			path:   `/v1/weather?city=London&country=GB`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `openmeteo`,
+		},
+		{
+			name:   `pinned`,
+			path:   `/v1/weather?city=sydney&country=AU&provider=openweather`,
+			rules:  rules,
+			status: http.StatusOK,
+			calls:  `openweather`,
+		},
+		{
+			name:   `pinned unknown`,
+			path:   `/v1/weather?city=sydney&provider=accuweather`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"unknown provider \"accuweather\""}`,
+		},
+		{
+			name:   `pinned not configured`,
+			path:   `/v1/weather?city=sydney&provider=metno`,
+			status: http.StatusBadRequest,
+			body:   `{"code":3,"message":"provider \"metno\" not configured"}`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu    sync.Mutex
+				calls []string
+			)
+			call := func(provider Provider) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, string(provider))
+			}
+			now := time.Unix(1667179321, 0)
+
+			var routing Routing
+			if err := routing.Set(tc.rules); err != nil {
+				t.Fatal(err)
+			}
+
+			server := Server{
+				MaxAge:  time.Second * 3,
+				TimeNow: func() time.Time { return now },
+				Routing: &routing,
+				Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+					call(ProviderWeatherstack)
+					return &weatherstack.CurrentWeather{ReadTime: timestamppb.New(now)}, nil
+				}},
+				Openweather: &mockOpenweatherClient{getWeather: func(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
+					call(ProviderOpenweather)
+					return &openweather.Weather{ReadTime: timestamppb.New(now)}, nil
+				}},
+				OpenMeteo: &mockOpenMeteoClient{getCurrentWeather: func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+					call(ProviderOpenMeteo)
+					return &openmeteo.CurrentWeather{ReadTime: timestamppb.New(now)}, nil
+				}},
+			}
+
+			if tc.location != nil {
+				server.Geocode = &mockGeocodeClient{
+					searchLocations: func(ctx context.Context, in *geocode.SearchLocationsRequest, opts ...grpc.CallOption) (*geocode.SearchLocationsResponse, error) {
+						return &geocode.SearchLocationsResponse{Locations: []*locationpb.Location{tc.location}}, nil
+					},
+					getLocation: func(ctx context.Context, in *geocode.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error) {
+						return tc.location, nil
+					},
+				}
+			}
+
+			router := chi.NewRouter()
+			if tc.client != `` {
+				// i.e. authenticated by clientauth.Authenticator.Middleware
+				router.Use(func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						next.ServeHTTP(w, r.WithContext(clientauth.WithClient(r.Context(), &clientauth.Client{ID: tc.client})))
+					})
+				})
+			}
+			router.Route(`/`, server.Register)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != tc.status {
+				t.Errorf(`unexpected status code: %d`, res.StatusCode)
+			}
+			if tc.body != `` && string(b) != tc.body {
+				t.Errorf(`unexpected body: %s`, string(b))
+			}
+			if v := strings.Join(calls, `,`); v != tc.calls {
+				t.Errorf(`unexpected calls: %s`, v)
+			}
+		})
+	}
+}
+
+func TestRoutingRules_Validate(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		in  string
+		err string
+	}{
+		{in: `{}`},
+		{in: `{"priority":["metno"],"rules":[{"name":"au","countries":["AU"],"priority":["weatherstack","openmeteo"]}]}`},
+		{in: `{"priority":[]}`, err: `invalid priority: at least one provider required`},
+		{in: `{"priority":["metno","metno"]}`, err: `invalid priority: duplicate provider "metno"`},
+		{in: `{"rules":[{"countries":["AU"],"priority":["metno"]}]}`, err: `rule 0: missing name`},
+		{in: `{"rules":[{"name":"a","countries":["AU"],"priority":["metno"]},{"name":"a","cities":["perth"],"priority":["metno"]}]}`, err: `rule "a": duplicate name`},
+		{in: `{"rules":[{"name":"a","priority":["metno"]}]}`, err: `rule "a": at least one of countries, cities, or clients required`},
+		{in: `{"rules":[{"name":"a","countries":["AUS"],"priority":["metno"]}]}`, err: `rule "a": invalid country "AUS"`},
+		{in: `{"rules":[{"name":"a","countries":["AU"],"priority":["accuweather"]}]}`, err: `rule "a": invalid priority: unknown provider "accuweather"`},
+		{in: `{"rules":[{"name":"a","countries":["AU"]}]}`, err: `rule "a": invalid priority: at least one provider required`},
+	} {
+		_, err := ParseRoutingRules([]byte(tc.in))
+		if (err == nil) != (tc.err == ``) || (err != nil && err.Error() != tc.err) {
+			t.Errorf(`unexpected error for %s: %v`, tc.in, err)
+		}
+	}
+}
+
+func TestRouting_Register(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), `routing.json`)
+	if err := os.WriteFile(path, []byte(`{"rules":[{"name":"au","countries":["AU"],"priority":["weatherstack"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	routing, err := NewRouting(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Route(`/`, routing.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, tc := range [...]struct {
+		method string
+		path   string
+		body   string
+		file   string
+		status int
+		res    string
+	}{
+		{
+			method: http.MethodGet,
+			path:   `/admin/v1/routing`,
+			status: http.StatusOK,
+			res:    `{"rules":[{"name":"au","countries":["AU"],"priority":["weatherstack"]}]}`,
+		},
+		{
+			method: http.MethodPut,
+			path:   `/admin/v1/routing`,
+			body:   `{"priority":["unknown"]}`,
+			status: http.StatusBadRequest,
+			res:    "invalid priority: unknown provider \"unknown\"\n",
+		},
+		{
+			method: http.MethodPut,
+			path:   `/admin/v1/routing`,
+			body:   `{"priority":["metno"]}`,
+			status: http.StatusOK,
+			res:    `{"priority":["metno"]}`,
+		},
+		{
+			method: http.MethodPut,
+			path:   `/admin/v1/routing`,
+			body:   `{"rules":[{"name":"partner","clients":["acme"],"priority":["metno"]}]}`,
+			status: http.StatusOK,
+			res:    `{"rules":[{"name":"partner","clients":["acme"],"priority":["metno"]}]}`,
+		},
+		{
+			method: http.MethodGet,
+			path:   `/admin/v1/routing`,
+			status: http.StatusOK,
+			res:    `{"rules":[{"name":"partner","clients":["acme"],"priority":["metno"]}]}`,
+		},
+		{
+			method: http.MethodPost,
+			path:   `/admin/v1/routing/reload`,
+			file:   `{"rules":[`,
+			status: http.StatusBadRequest,
+		},
+		{
+			method: http.MethodGet,
+			path:   `/admin/v1/routing`,
+			status: http.StatusOK,
+			res:    `{"rules":[{"name":"partner","clients":["acme"],"priority":["metno"]}]}`,
+		},
+		{
+			method: http.MethodPost,
+			path:   `/admin/v1/routing/reload`,
+			file:   `{"rules":[{"name":"partner","clients":["acme"],"priority":["openmeteo"]}]}`,
+			status: http.StatusOK,
+			res:    `{"rules":[{"name":"partner","clients":["acme"],"priority":["openmeteo"]}]}`,
+		},
+		{
+			method: http.MethodPost,
+			path:   `/admin/v1/routing/reload`,
+			file:   `{"priority":["openmeteo"]}`,
+			status: http.StatusOK,
+			res:    `{"priority":["openmeteo"]}`,
+		},
+	} {
+		if tc.file != `` {
+			if err := os.WriteFile(path, []byte(tc.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res, body := testRequest(t, ts, tc.method, tc.path, strings.NewReader(tc.body))
+		if res.StatusCode != tc.status || (tc.res != `` && body != tc.res) {
+			t.Errorf("unexpected response to %s %s: %d %s", tc.method, tc.path, res.StatusCode, body)
+		}
+	}
+
+	if _, err := NewRouting(filepath.Join(t.TempDir(), `missing.json`)); !errors.Is(err, os.ErrNotExist) {
+		t.Error(err)
+	}
+}
@@ -3,13 +3,26 @@ package weather
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/internal/alerts"
+	"github.com/joeycumines/mx51-weather-api/internal/clientauth"
+	"github.com/joeycumines/mx51-weather-api/internal/divergence"
+	"github.com/joeycumines/mx51-weather-api/internal/health"
+	"github.com/joeycumines/mx51-weather-api/internal/history"
+	"github.com/joeycumines/mx51-weather-api/internal/metrics"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
 	"github.com/joeycumines/mx51-weather-api/openweather"
 	"github.com/joeycumines/mx51-weather-api/weatherstack"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,109 +30,429 @@ type (
 	// Server implements /v1/weather.
 	// See also Register.
 	Server struct {
+		// MaxAge is the maximum age of readings, required. See also Reconfigure.
 		MaxAge       time.Duration
 		TimeNow      func() time.Time
 		Openweather  openweather.OpenweatherClient
 		Weatherstack weatherstack.WeatherstackClient
+		OpenMeteo    openmeteo.OpenMeteoClient
+		Metno        metno.MetnoClient
+		// Geocode resolves queries to locations, which are then used to query providers by position, optional.
+		Geocode geocode.GeocodeClient
+		// AmbiguityThreshold determines if a query matches multiple plausible locations, i.e. locations with a
+		// population of at least this fraction of the most populous match, defaults to DefaultAmbiguityThreshold.
+		// Values greater than 1 disable disambiguation, resolving to the most relevant match.
+		AmbiguityThreshold float64
+		// Priority is the order in which providers will be attempted, defaults to DefaultPriority.
+		// Providers without a client are skipped. See also ParsePriority, and Routing.
+		Priority []Provider
+		// Routing optionally overrides Priority, per request, e.g. by country or client.
+		Routing *Routing
+		// Consensus configures the (opt-in) aggregation mode.
+		Consensus Consensus
+		// Divergence is notified of all readings, to track disagreement between providers, optional. The other
+		// providers are sampled periodically, for each location, see divergence.Tracker.Sample, and Close.
+		Divergence *divergence.Tracker
+		// History persists all readings, and enables /v1/weather/history, optional.
+		History *history.Store
+		// Alerts evaluates subscriptions against all readings, optional.
+		Alerts *alerts.Service
+		// Health is notified of the outcome of each provider call, optional. See also Configured.
+		Health *health.Checker
+
+		// reconfigured overrides the corresponding fields, if set, see Reconfigure
+		reconfigured atomic.Pointer[Settings]
+
+		sampling sampling
+	}
+
+	// Settings are the fields of Server that may be replaced at runtime, see Server.Reconfigure.
+	Settings struct {
+		MaxAge             time.Duration
+		AmbiguityThreshold float64
+		Priority           []Provider
+		Consensus          Consensus
 	}
 
+	// Provider identifies a weather provider.
+	Provider string
+
 	weatherResponse struct {
 		WindSpeed          float64 `json:"wind_speed"`
 		TemperatureDegrees float64 `json:"temperature_degrees"`
+		// Providers and Spread are only set in consensus mode
+		Providers []Provider `json:"providers,omitempty"`
+		Spread    *spread    `json:"spread,omitempty"`
+		// Location is only set if the query was resolved
+		Location *locationResponse `json:"location,omitempty"`
+	}
+
+	// reading is a normalized response from a provider
+	reading struct {
+		readTime time.Time
+		// expireTime is set if the provider indicated how long the reading is fresh for, overriding MaxAge
+		expireTime time.Time
+		weatherResponse
 	}
 )
 
+const (
+	ProviderWeatherstack Provider = `weatherstack`
+	ProviderOpenweather  Provider = `openweather`
+	ProviderOpenMeteo    Provider = `openmeteo`
+	ProviderMetno        Provider = `metno`
+)
+
 var (
+	// DefaultPriority is the default value for Server.Priority, note that Open-Meteo and met.no are keyless fallbacks.
+	DefaultPriority = []Provider{ProviderWeatherstack, ProviderOpenweather, ProviderOpenMeteo, ProviderMetno}
+
 	errMissingReadTime = errors.New(`missing read time`)
 )
 
+// ParsePriority parses a comma separated list of providers, e.g. `openweather,weatherstack`.
+func ParsePriority(s string) ([]Provider, error) {
+	var priority []Provider
+	for _, v := range strings.Split(s, `,`) {
+		if provider := Provider(strings.TrimSpace(v)); provider != `` {
+			priority = append(priority, provider)
+		}
+	}
+	if err := validatePriority(priority); err != nil {
+		return nil, err
+	}
+	return priority, nil
+}
+
+// Reconfigure validates, then atomically replaces, the settings, which otherwise default to the corresponding fields
+// of the Server. In-flight requests are unaffected, and the fields are ignored from then on.
+func (x *Server) Reconfigure(settings Settings) error {
+	if settings.MaxAge <= 0 {
+		return fmt.Errorf(`invalid max age: %s`, settings.MaxAge)
+	}
+	if settings.AmbiguityThreshold < 0 {
+		return fmt.Errorf(`invalid ambiguity threshold: %v`, settings.AmbiguityThreshold)
+	}
+	if settings.Priority != nil {
+		if err := validatePriority(settings.Priority); err != nil {
+			return fmt.Errorf(`invalid priority: %w`, err)
+		}
+	}
+	for provider, weight := range settings.Consensus.Weights {
+		if !provider.valid() || weight < 0 {
+			return fmt.Errorf(`invalid consensus weight for %q: %v`, provider, weight)
+		}
+	}
+	x.reconfigured.Store(&settings)
+	return nil
+}
+
 // Register wires up the server.
 func (x *Server) Register(r chi.Router) {
 	r.Get(`/v1/weather`, x.getWeather)
+	if x.History != nil {
+		r.Get(`/v1/weather/history`, x.getHistory)
+	}
 }
 
 func (x *Server) getWeather(w http.ResponseWriter, r *http.Request) {
 	// note: ignores potentially malformed query in request path
 	params := r.URL.Query()
 
-	// query built by parsing params (this would be far more interesting with multiple params)
-	query := params.Get(`city`)
-	if query == `` {
+	// query built by parsing params, note that a location id may be used instead of the city
+	query := locationQuery{
+		city:    params.Get(`city`),
+		country: params.Get(`country`),
+		state:   params.Get(`state`),
+		id:      params.Get(`location_id`),
+	}
+	if query.city == `` && query.id == `` {
 		_ = writeError(w, http.StatusBadRequest, status.Error(codes.InvalidArgument,
 			`at least one query parameter required`))
 		return
 	}
+	if query.country != `` && !validCountry(query.country) {
+		_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+			`invalid country %q`, query.country))
+		return
+	}
 
-	res, err := x.buildWeatherResponse(r.Context(), query)
-	if err != nil {
-		var statusCode int
-		{
-			sts, _ := status.FromError(err)
-			switch sts.Code() {
-			case codes.Unavailable:
-				statusCode = http.StatusServiceUnavailable
-			default:
-				statusCode = http.StatusInternalServerError
+	// note: the provider may be pinned, e.g. for debugging
+	var providers []Provider
+	if v := params.Get(`provider`); v != `` {
+		provider := Provider(v)
+		if !provider.valid() {
+			_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+				`unknown provider %q`, provider))
+			return
+		}
+		if !x.configured(provider) {
+			_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+				`provider %q not configured`, provider))
+			return
+		}
+		providers = []Provider{provider}
+	}
+
+	var build func(ctx context.Context, target target, providers []Provider) (*weatherResponse, error)
+	switch mode := params.Get(`mode`); mode {
+	case ``, modePriority:
+		build = x.buildWeatherResponse
+	case modeConsensus:
+		build = x.buildConsensusResponse
+	default:
+		_ = writeError(w, http.StatusBadRequest, status.Errorf(codes.InvalidArgument,
+			`unknown mode %q`, mode))
+		return
+	}
+
+	// note: requests are routed using the resolved location, if any, e.g. its country
+	target, err := x.resolve(r.Context(), query)
+	var res *weatherResponse
+	if err == nil {
+		if providers == nil {
+			route := target.route(query)
+			if client := clientauth.FromContext(r.Context()); client != nil {
+				route.client = client.ID
 			}
+			providers = x.priority(route)
 		}
-		_ = writeError(w, statusCode, err)
+		res, err = build(r.Context(), target, providers)
+	}
+	if err != nil {
+		_ = writeError(w, httpStatusCode(err), err)
 		return
 	}
 
+	res.Location = newLocationResponse(target.location)
+
 	_ = writeJSON(w, http.StatusOK, res)
 }
 
-func (x *Server) buildWeatherResponse(ctx context.Context, query string) (*weatherResponse, error) {
+// Refresh fetches the current weather for a city, using the default route (for the resolved location), e.g. to keep
+// the provider caches warm for alerts.Service.Run. It returns the location the readings are keyed by, i.e. the id of
+// the resolved location, or the city, if it wasn't resolved.
+func (x *Server) Refresh(ctx context.Context, city string) (string, error) {
+	query := locationQuery{city: city}
+	target, err := x.resolve(ctx, query)
+	if err != nil {
+		return ``, err
+	}
+	_, err = x.buildWeatherResponse(ctx, target, x.priority(target.route(query)))
+	return target.key(), err
+}
+
+// Probe fetches the current weather for a city from a single provider, as-is (i.e. without geocoding), e.g. to check
+// its readiness, see health.Checker.Run. Unlike requests, the outcome isn't observed, nor are the readings tracked.
+func (x *Server) Probe(ctx context.Context, provider, city string) error {
+	if !x.configured(Provider(provider)) {
+		return fmt.Errorf(`provider %q not configured`, provider)
+	}
+	_, err := x.requestReading(ctx, Provider(provider), target{query: city}, x.TimeNow().Add(-x.settings().MaxAge))
+	return err
+}
+
+func (x *Server) buildWeatherResponse(ctx context.Context, target target, providers []Provider) (*weatherResponse, error) {
 	// TODO reconsider this, also consider independent timeouts for each sub-request
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*3)
 	defer cancel()
 
+	maxAge := x.settings().MaxAge
 	// a factory function accepting config would make this handling nicer
-	if x.MaxAge <= 0 {
-		panic(x.MaxAge)
+	if maxAge <= 0 {
+		panic(maxAge)
+	}
+	now := x.TimeNow()
+	minReadTime := now.Add(-maxAge)
+
+	// attempt providers in order of higher priority first, falling back to returning the freshest response
+	var freshest *reading
+	for i, provider := range providers {
+		res, err := x.getReading(ctx, provider, target, minReadTime)
+		if err != nil {
+			continue
+		}
+		if res.fresh(now, minReadTime) {
+			if i == 0 {
+				metrics.ObserveSelection(ctx, metrics.SelectionPrimary)
+			} else {
+				metrics.ObserveSelection(ctx, metrics.SelectionSecondary)
+			}
+			x.sample(ctx, target, provider)
+			return &res.weatherResponse, nil
+		}
+		// note: ties are resolved in favour of the higher priority provider
+		if freshest == nil || res.readTime.After(freshest.readTime) {
+			freshest = res
+		}
+	}
+
+	if freshest == nil {
+		return nil, status.Error(codes.Unavailable, `no weather providers available`)
 	}
-	minReadTime := x.TimeNow().Add(-x.MaxAge)
 
-	// attempt providers in order of higher priority first
+	metrics.ObserveSelection(ctx, metrics.SelectionStale)
+	return &freshest.weatherResponse, nil
+}
 
-	wsRes, wsErr := x.Weatherstack.GetCurrentWeather(ctx, &weatherstack.GetCurrentWeatherRequest{
-		Query:       query,
-		MinReadTime: timestamppb.New(minReadTime),
+// getReading requests the current weather from a single provider, normalizing the response
+func (x *Server) getReading(ctx context.Context, provider Provider, target target, minReadTime time.Time) (*reading, error) {
+	res, err := x.requestReading(ctx, provider, target, minReadTime)
+	// note: calls abandoned by the caller say nothing about the provider
+	if ctx.Err() == nil {
+		x.Health.Observe(string(provider), err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// note: readings are tracked by the resolved location, if any, such that equivalent queries share a series
+	location := target.key()
+	x.Divergence.Observe(location, divergence.Reading{
+		Provider:           string(provider),
+		ReadTime:           res.readTime,
+		TemperatureDegrees: res.TemperatureDegrees,
+		WindSpeed:          res.WindSpeed,
+	})
+	if err := x.History.Record(history.Reading{
+		Provider:           string(provider),
+		Location:           location,
+		ReadTime:           res.readTime,
+		TemperatureDegrees: res.TemperatureDegrees,
+		WindSpeed:          res.WindSpeed,
+	}); err != nil {
+		slog.ErrorContext(ctx, `failed to record history`, `error`, err)
+	}
+	x.Alerts.Observe(location, alerts.Reading{
+		Provider:           string(provider),
+		ReadTime:           res.readTime,
+		TemperatureDegrees: res.TemperatureDegrees,
+		WindSpeed:          res.WindSpeed,
 	})
-	if wsErr == nil {
-		if wsRes.GetReadTime() == nil {
-			wsErr = errMissingReadTime
-		} else if !wsRes.GetReadTime().AsTime().Before(minReadTime) {
-			return new(weatherResponse).fromWeatherstack(wsRes), nil
+	return res, nil
+}
+
+func (x *Server) requestReading(ctx context.Context, provider Provider, target target, minReadTime time.Time) (*reading, error) {
+	position := target.location.GetPosition()
+	switch provider {
+	case ProviderWeatherstack:
+		res, err := x.Weatherstack.GetCurrentWeather(ctx, &weatherstack.GetCurrentWeatherRequest{
+			Query:       target.query,
+			Position:    position,
+			MinReadTime: timestamppb.New(minReadTime),
+		})
+		if err != nil {
+			return nil, err
 		}
+		if res.GetReadTime() == nil {
+			return nil, errMissingReadTime
+		}
+		return &reading{readTime: res.GetReadTime().AsTime(), weatherResponse: *new(weatherResponse).fromWeatherstack(res)}, nil
+
+	case ProviderOpenweather:
+		res, err := x.Openweather.GetWeather(ctx, &openweather.GetWeatherRequest{
+			Query:       target.query,
+			Position:    position,
+			MinReadTime: timestamppb.New(minReadTime),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.GetReadTime() == nil {
+			return nil, errMissingReadTime
+		}
+		return &reading{readTime: res.GetReadTime().AsTime(), weatherResponse: *new(weatherResponse).fromOpenweather(res)}, nil
+
+	case ProviderOpenMeteo:
+		res, err := x.OpenMeteo.GetCurrentWeather(ctx, &openmeteo.GetCurrentWeatherRequest{
+			Query:       target.query,
+			Position:    position,
+			MinReadTime: timestamppb.New(minReadTime),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.GetReadTime() == nil {
+			return nil, errMissingReadTime
+		}
+		return &reading{readTime: res.GetReadTime().AsTime(), weatherResponse: *new(weatherResponse).fromOpenMeteo(res)}, nil
+
+	case ProviderMetno:
+		res, err := x.Metno.GetCurrentWeather(ctx, &metno.GetCurrentWeatherRequest{
+			Query:       target.query,
+			Position:    position,
+			MinReadTime: timestamppb.New(minReadTime),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res.GetReadTime() == nil {
+			return nil, errMissingReadTime
+		}
+		v := reading{readTime: res.GetReadTime().AsTime(), weatherResponse: *new(weatherResponse).fromMetno(res)}
+		if res.GetExpireTime() != nil {
+			v.expireTime = res.GetExpireTime().AsTime()
+		}
+		return &v, nil
+
+	default:
+		return nil, fmt.Errorf(`unknown provider %q`, provider)
 	}
+}
 
-	owRes, owErr := x.Openweather.GetWeather(ctx, &openweather.GetWeatherRequest{
-		Query:       query,
-		MinReadTime: timestamppb.New(minReadTime),
-	})
-	if owErr == nil {
-		if owRes.GetReadTime() == nil {
-			owErr = errMissingReadTime
-		} else if !owRes.GetReadTime().AsTime().Before(minReadTime) {
-			return new(weatherResponse).fromOpenweather(owRes), nil
-		}
-	}
-
-	// fallback to returning the freshest response
-	switch {
-	case wsErr == nil && owErr == nil:
-		if owRes.GetReadTime().AsTime().After(wsRes.GetReadTime().AsTime()) {
-			return new(weatherResponse).fromOpenweather(owRes), nil
-		} else {
-			return new(weatherResponse).fromWeatherstack(wsRes), nil
-		}
-	case wsErr == nil:
-		return new(weatherResponse).fromWeatherstack(wsRes), nil
-	case owErr == nil:
-		return new(weatherResponse).fromOpenweather(owRes), nil
+// settings returns the current settings, see Reconfigure
+func (x *Server) settings() *Settings {
+	if v := x.reconfigured.Load(); v != nil {
+		return v
+	}
+	return &Settings{
+		MaxAge:             x.MaxAge,
+		AmbiguityThreshold: x.AmbiguityThreshold,
+		Priority:           x.Priority,
+		Consensus:          x.Consensus,
+	}
+}
+
+// priority returns the configured providers, in order of priority, for the given route
+func (x *Server) priority(route route) []Provider {
+	priority := x.Routing.Rules().priority(route)
+	if priority == nil {
+		priority = x.settings().Priority
+	}
+	if priority == nil {
+		priority = DefaultPriority
+	}
+	providers := make([]Provider, 0, len(priority))
+	for _, provider := range priority {
+		if x.configured(provider) {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// Configured returns the providers that have a client, in the order of DefaultPriority.
+func (x *Server) Configured() []Provider {
+	var providers []Provider
+	for _, provider := range DefaultPriority {
+		if x.configured(provider) {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+func (x *Server) configured(provider Provider) bool {
+	switch provider {
+	case ProviderWeatherstack:
+		return x.Weatherstack != nil
+	case ProviderOpenweather:
+		return x.Openweather != nil
+	case ProviderOpenMeteo:
+		return x.OpenMeteo != nil
+	case ProviderMetno:
+		return x.Metno != nil
 	default:
-		return nil, status.Error(codes.Unavailable, `no weather providers available`)
+		return false
 	}
 }
 
@@ -135,6 +468,32 @@ func (x *weatherResponse) fromWeatherstack(res *weatherstack.CurrentWeather) *we
 	return x
 }
 
+func (x *weatherResponse) fromOpenMeteo(res *openmeteo.CurrentWeather) *weatherResponse {
+	x.WindSpeed = res.GetWindSpeed()
+	x.TemperatureDegrees = res.GetTemperature()
+	return x
+}
+
+func (x *weatherResponse) fromMetno(res *metno.CurrentWeather) *weatherResponse {
+	x.WindSpeed = metresPerSecondToKilometresPerHour(res.GetWindSpeed())
+	x.TemperatureDegrees = res.GetAirTemperature()
+	return x
+}
+
+// fresh returns true if the reading satisfies the min read time, or the provider indicated it hasn't yet expired
+func (x *reading) fresh(now, minReadTime time.Time) bool {
+	return !x.readTime.Before(minReadTime) || now.Before(x.expireTime)
+}
+
+func (x Provider) valid() bool {
+	for _, provider := range DefaultPriority {
+		if x == provider {
+			return true
+		}
+	}
+	return false
+}
+
 func metresPerSecondToKilometresPerHour(mps float64) float64 {
 	return mps * 3.6
 }
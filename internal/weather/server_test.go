@@ -3,17 +3,25 @@ package weather
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/joeycumines/mx51-weather-api/internal/health"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
 	"github.com/joeycumines/mx51-weather-api/openweather"
 	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
 	"github.com/joeycumines/mx51-weather-api/weatherstack"
 	latlongpb "google.golang.org/genproto/googleapis/type/latlng"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -389,3 +397,328 @@ func TestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_priority(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	fresh := timestamppb.New(now)
+	stale := timestamppb.New(now.Add(-time.Minute))
+
+	type Responses struct {
+		weatherstack *weatherstack.CurrentWeather
+		openweather  *openweather.Weather
+		openmeteo    *openmeteo.CurrentWeather
+		metno        *metno.CurrentWeather
+	}
+
+	for _, tc := range [...]struct {
+		name      string
+		priority  []Provider
+		noClients []Provider
+		responses Responses
+		calls     string
+		status    int
+		body      string
+	}{
+		{
+			name:     `openmeteo first`,
+			priority: []Provider{ProviderOpenMeteo, ProviderWeatherstack, ProviderOpenweather},
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 1, WindSpeed: 2},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 3, WindSpeed: 4},
+			},
+			calls:  `openmeteo`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":4,"temperature_degrees":3}`,
+		},
+		{
+			name: `default priority openmeteo fallback`,
+			responses: Responses{
+				openmeteo: &openmeteo.CurrentWeather{ReadTime: fresh, Temperature: 3, WindSpeed: 4},
+			},
+			calls:  `weatherstack,openweather,openmeteo`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":4,"temperature_degrees":3}`,
+		},
+		{
+			name: `default priority freshest stale`,
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: stale, Temperature: 1, WindSpeed: 2},
+				openweather:  &openweather.Weather{ReadTime: timestamppb.New(now.Add(-time.Second * 30)), Temp: 5, WindSpeed: 10},
+				openmeteo:    &openmeteo.CurrentWeather{ReadTime: stale, Temperature: 3, WindSpeed: 4},
+			},
+			calls:  `weatherstack,openweather,openmeteo,metno`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":36,"temperature_degrees":5}`,
+		},
+		{
+			name: `default priority metno fallback`,
+			responses: Responses{
+				metno: &metno.CurrentWeather{ReadTime: fresh, AirTemperature: 7, WindSpeed: 5},
+			},
+			calls:  `weatherstack,openweather,openmeteo,metno`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":18,"temperature_degrees":7}`,
+		},
+		{
+			name:     `metno stale but not expired`,
+			priority: []Provider{ProviderMetno, ProviderWeatherstack},
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 1, WindSpeed: 2},
+				metno:        &metno.CurrentWeather{ReadTime: timestamppb.New(now.Add(-time.Hour)), ExpireTime: timestamppb.New(now.Add(time.Minute)), AirTemperature: 7, WindSpeed: 5},
+			},
+			calls:  `metno`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":18,"temperature_degrees":7}`,
+		},
+		{
+			name:     `metno stale and expired`,
+			priority: []Provider{ProviderMetno, ProviderWeatherstack},
+			responses: Responses{
+				weatherstack: &weatherstack.CurrentWeather{ReadTime: fresh, Temperature: 1, WindSpeed: 2},
+				metno:        &metno.CurrentWeather{ReadTime: timestamppb.New(now.Add(-time.Hour)), ExpireTime: stale, AirTemperature: 7, WindSpeed: 5},
+			},
+			calls:  `metno,weatherstack`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":2,"temperature_degrees":1}`,
+		},
+		{
+			name:      `unconfigured providers skipped`,
+			priority:  []Provider{ProviderWeatherstack, ProviderOpenMeteo, ProviderOpenweather},
+			noClients: []Provider{ProviderWeatherstack, ProviderOpenMeteo},
+			responses: Responses{
+				openweather: &openweather.Weather{ReadTime: fresh, Temp: 5, WindSpeed: 10},
+			},
+			calls:  `openweather`,
+			status: http.StatusOK,
+			body:   `{"wind_speed":36,"temperature_degrees":5}`,
+		},
+		{
+			name:     `subset priority`,
+			priority: []Provider{ProviderOpenweather},
+			calls:    `openweather`,
+			status:   http.StatusServiceUnavailable,
+			body:     `{"code":14,"message":"no weather providers available"}`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				mu    sync.Mutex
+				calls []string
+			)
+			call := func(provider Provider) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, string(provider))
+			}
+			errUnavailable := errors.New(`unavailable`)
+
+			server := Server{
+				MaxAge:   time.Second * 3,
+				TimeNow:  func() time.Time { return now },
+				Priority: tc.priority,
+				Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+					call(ProviderWeatherstack)
+					if tc.responses.weatherstack == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.weatherstack, nil
+				}},
+				Openweather: &mockOpenweatherClient{getWeather: func(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
+					call(ProviderOpenweather)
+					if tc.responses.openweather == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.openweather, nil
+				}},
+				OpenMeteo: &mockOpenMeteoClient{getCurrentWeather: func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+					call(ProviderOpenMeteo)
+					if in.GetQuery() != `sydney` || !in.GetMinReadTime().AsTime().Equal(now.Add(-time.Second*3)) {
+						t.Errorf(`unexpected request: %v`, in)
+					}
+					if tc.responses.openmeteo == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.openmeteo, nil
+				}},
+				Metno: &mockMetnoClient{getCurrentWeather: func(ctx context.Context, in *metno.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*metno.CurrentWeather, error) {
+					call(ProviderMetno)
+					if tc.responses.metno == nil {
+						return nil, errUnavailable
+					}
+					return tc.responses.metno, nil
+				}},
+			}
+			for _, provider := range tc.noClients {
+				switch provider {
+				case ProviderWeatherstack:
+					server.Weatherstack = nil
+				case ProviderOpenweather:
+					server.Openweather = nil
+				case ProviderOpenMeteo:
+					server.OpenMeteo = nil
+				case ProviderMetno:
+					server.Metno = nil
+				}
+			}
+
+			router := chi.NewRouter()
+			router.Route(`/`, server.Register)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=sydney`, nil)
+			if res.StatusCode != tc.status {
+				t.Errorf(`unexpected status code: %d`, res.StatusCode)
+			}
+			if body != tc.body {
+				t.Errorf("unexpected body: %q\n%s", body, body)
+			}
+			if v := strings.Join(calls, `,`); v != tc.calls {
+				t.Errorf(`unexpected calls: %s`, v)
+			}
+		})
+	}
+}
+
+func TestServer_Reconfigure(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	call := func(provider Provider) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, string(provider))
+	}
+	server := Server{
+		MaxAge:   time.Second * 3,
+		TimeNow:  func() time.Time { return now },
+		Priority: []Provider{ProviderWeatherstack, ProviderOpenMeteo},
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			call(ProviderWeatherstack)
+			return &weatherstack.CurrentWeather{ReadTime: timestamppb.New(now.Add(-time.Second * 5)), Temperature: 1, WindSpeed: 2}, nil
+		}},
+		OpenMeteo: &mockOpenMeteoClient{getCurrentWeather: func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+			call(ProviderOpenMeteo)
+			return &openmeteo.CurrentWeather{ReadTime: timestamppb.New(now), Temperature: 3, WindSpeed: 4}, nil
+		}},
+	}
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	request := func(expectedCalls, expectedBody string) {
+		t.Helper()
+		mu.Lock()
+		calls = nil
+		mu.Unlock()
+		res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=sydney`, nil)
+		if res.StatusCode != http.StatusOK || body != expectedBody {
+			t.Errorf(`unexpected response: %d %s`, res.StatusCode, body)
+		}
+		if v := strings.Join(calls, `,`); v != expectedCalls {
+			t.Errorf(`unexpected calls: %s`, v)
+		}
+	}
+
+	// weatherstack is stale
+	request(`weatherstack,openmeteo`, `{"wind_speed":4,"temperature_degrees":3}`)
+
+	// weatherstack is now fresh enough
+	if err := server.Reconfigure(Settings{MaxAge: time.Second * 10, Priority: []Provider{ProviderWeatherstack}}); err != nil {
+		t.Fatal(err)
+	}
+	request(`weatherstack`, `{"wind_speed":2,"temperature_degrees":1}`)
+
+	for _, tc := range [...]struct {
+		settings Settings
+		err      string
+	}{
+		{settings: Settings{}, err: `invalid max age: 0s`},
+		{settings: Settings{MaxAge: time.Second, AmbiguityThreshold: -1}, err: `invalid ambiguity threshold: -1`},
+		{settings: Settings{MaxAge: time.Second, Priority: []Provider{}}, err: `invalid priority: at least one provider required`},
+		{settings: Settings{MaxAge: time.Second, Consensus: Consensus{Weights: map[Provider]float64{`darksky`: 1}}}, err: `invalid consensus weight for "darksky": 1`},
+	} {
+		if err := server.Reconfigure(tc.settings); err == nil || err.Error() != tc.err {
+			t.Errorf(`unexpected error: %v`, err)
+		}
+	}
+
+	// the previous settings are retained
+	request(`weatherstack`, `{"wind_speed":2,"temperature_degrees":1}`)
+}
+
+func TestServer_health(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1667179321, 0)
+	server := Server{
+		MaxAge:  time.Second * 3,
+		TimeNow: func() time.Time { return now },
+		Health:  &health.Checker{FailureThreshold: 1, TimeNow: func() time.Time { return now }},
+		Weatherstack: &mockWeatherstackClient{getCurrentWeather: func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error) {
+			return nil, status.Error(codes.Unavailable, `weatherstack: request failed`)
+		}},
+		Openweather: &mockOpenweatherClient{getWeather: func(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
+			return &openweather.Weather{ReadTime: timestamppb.New(now), Temp: 21, WindSpeed: 2.5}, nil
+		}},
+	}
+	var providers []string
+	for _, provider := range server.Configured() {
+		providers = append(providers, string(provider))
+	}
+	if v := strings.Join(providers, `,`); v != `weatherstack,openweather` {
+		t.Errorf(`unexpected providers: %s`, v)
+	}
+	server.Health.Add(providers...)
+
+	router := chi.NewRouter()
+	router.Route(`/`, server.Register)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	if res, body := testRequest(t, ts, http.MethodGet, `/v1/weather?city=sydney`, nil); res.StatusCode != http.StatusOK {
+		t.Fatal(res.StatusCode, body)
+	}
+
+	readiness := server.Health.Readiness()
+	if !readiness.Ready || len(readiness.Providers) != 2 ||
+		readiness.Providers[0].Status != health.StatusFailing ||
+		readiness.Providers[0].LastError != `rpc error: code = Unavailable desc = weatherstack: request failed` ||
+		readiness.Providers[1].Status != health.StatusOK {
+		t.Errorf(`unexpected readiness: %+v`, readiness)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	t.Parallel()
+	for _, tc := range [...]struct {
+		in  string
+		out []Provider
+		err string
+	}{
+		{in: `openmeteo, weatherstack`, out: []Provider{ProviderOpenMeteo, ProviderWeatherstack}},
+		{in: `openweather`, out: []Provider{ProviderOpenweather}},
+		{in: ` , `, err: `at least one provider required`},
+		{in: `openweather,darksky`, err: `unknown provider "darksky"`},
+		{in: `openweather,openweather`, err: `duplicate provider "openweather"`},
+	} {
+		out, err := ParsePriority(tc.in)
+		if (err == nil) != (tc.err == ``) || (err != nil && err.Error() != tc.err) {
+			t.Errorf(`unexpected error for %q: %v`, tc.in, err)
+		}
+		if fmt.Sprint(out) != fmt.Sprint(tc.out) {
+			t.Errorf(`unexpected output for %q: %v`, tc.in, out)
+		}
+	}
+}
@@ -2,7 +2,11 @@ package weather
 
 import (
 	"context"
+	"github.com/joeycumines/mx51-weather-api/geocode"
+	"github.com/joeycumines/mx51-weather-api/metno"
+	"github.com/joeycumines/mx51-weather-api/openmeteo"
 	"github.com/joeycumines/mx51-weather-api/openweather"
+	locationpb "github.com/joeycumines/mx51-weather-api/type/location"
 	"github.com/joeycumines/mx51-weather-api/weatherstack"
 	"google.golang.org/grpc"
 	"io"
@@ -21,6 +25,20 @@ type (
 	mockWeatherstackClient struct {
 		getCurrentWeather func(ctx context.Context, in *weatherstack.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*weatherstack.CurrentWeather, error)
 	}
+
+	mockOpenMeteoClient struct {
+		getCurrentWeather func(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error)
+	}
+
+	mockMetnoClient struct {
+		getCurrentWeather func(ctx context.Context, in *metno.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*metno.CurrentWeather, error)
+	}
+
+	mockGeocodeClient struct {
+		searchLocations    func(ctx context.Context, in *geocode.SearchLocationsRequest, opts ...grpc.CallOption) (*geocode.SearchLocationsResponse, error)
+		getLocation        func(ctx context.Context, in *geocode.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error)
+		getNearestLocation func(ctx context.Context, in *geocode.GetNearestLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error)
+	}
 )
 
 var (
@@ -28,6 +46,9 @@ var (
 
 	_ openweather.OpenweatherClient   = (*mockOpenweatherClient)(nil)
 	_ weatherstack.WeatherstackClient = (*mockWeatherstackClient)(nil)
+	_ openmeteo.OpenMeteoClient       = (*mockOpenMeteoClient)(nil)
+	_ metno.MetnoClient               = (*mockMetnoClient)(nil)
+	_ geocode.GeocodeClient           = (*mockGeocodeClient)(nil)
 )
 
 func (x *mockOpenweatherClient) GetWeather(ctx context.Context, in *openweather.GetWeatherRequest, opts ...grpc.CallOption) (*openweather.Weather, error) {
@@ -38,6 +59,26 @@ func (x *mockWeatherstackClient) GetCurrentWeather(ctx context.Context, in *weat
 	return x.getCurrentWeather(ctx, in, opts...)
 }
 
+func (x *mockOpenMeteoClient) GetCurrentWeather(ctx context.Context, in *openmeteo.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*openmeteo.CurrentWeather, error) {
+	return x.getCurrentWeather(ctx, in, opts...)
+}
+
+func (x *mockMetnoClient) GetCurrentWeather(ctx context.Context, in *metno.GetCurrentWeatherRequest, opts ...grpc.CallOption) (*metno.CurrentWeather, error) {
+	return x.getCurrentWeather(ctx, in, opts...)
+}
+
+func (x *mockGeocodeClient) SearchLocations(ctx context.Context, in *geocode.SearchLocationsRequest, opts ...grpc.CallOption) (*geocode.SearchLocationsResponse, error) {
+	return x.searchLocations(ctx, in, opts...)
+}
+
+func (x *mockGeocodeClient) GetLocation(ctx context.Context, in *geocode.GetLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error) {
+	return x.getLocation(ctx, in, opts...)
+}
+
+func (x *mockGeocodeClient) GetNearestLocation(ctx context.Context, in *geocode.GetNearestLocationRequest, opts ...grpc.CallOption) (*locationpb.Location, error) {
+	return x.getNearestLocation(ctx, in, opts...)
+}
+
 func mockTime() (get func() time.Time, set func(t time.Time)) {
 	var (
 		mu  sync.RWMutex